@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"cpms/internal/config"
+	"cpms/internal/db"
+	"cpms/internal/repo"
+	"cpms/internal/services"
+)
+
+// apikey creates a management API key directly in the database, e.g. the first admin key.
+func main() {
+	name := flag.String("name", "", "key name, recorded as actor apikey:<name> (required)")
+	roles := flag.String("roles", "admin", "comma separated roles: viewer, operator, finance, admin")
+	tenant := flag.String("tenant", "", "tenant id to scope the key to (default: platform-wide)")
+	flag.Parse()
+
+	if *name == "" {
+		log.Fatal("--name is required")
+	}
+	var rs []string
+	for _, r := range strings.Split(*roles, ",") {
+		r = strings.TrimSpace(r)
+		if !services.ValidRole(r) {
+			log.Fatalf("unknown role %q", r)
+		}
+		rs = append(rs, r)
+	}
+
+	cfg := config.Load()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	d, err := db.Connect(ctx, cfg.DatabaseURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer d.Close()
+
+	var tenantId *string
+	if *tenant != "" {
+		t, err := repo.NewTenantsRepo(d.Pool).Get(ctx, *tenant)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if t == nil {
+			log.Fatalf("unknown tenant %q", *tenant)
+		}
+		tenantId = &t.TenantId
+	}
+
+	id, key, err := services.NewAPIAuth(repo.NewAPIKeysRepo(d.Pool), nil).CreateKey(ctx, *name, rs, tenantId, "cli")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("keyId: %s\nroles: %s\nkey:   %s\n(the key is not shown again)\n", id, strings.Join(rs, ","), key)
+}
@@ -9,6 +9,7 @@ import (
 	"syscall"
 	"time"
 
+	"cpms/internal/alerts"
 	"cpms/internal/config"
 	"cpms/internal/db"
 	"cpms/internal/gatewayclient"
@@ -37,13 +38,91 @@ func main() {
 	sites := repo.NewSitesRepo(d.Pool)
 	tariffs := repo.NewTariffsRepo(d.Pool)
 	settlementsRepo := repo.NewSettlementsRepo(d.Pool)
+	deadLetters := repo.NewDeadLettersRepo(d.Pool)
+	orphans := repo.NewOrphansRepo(d.Pool)
 
 	gw := gatewayclient.New(cfg.GatewayBaseURL, cfg.GatewayAPIKey)
 
+	sinks, err := alerts.BuildSinks(cfg.AlertSinks, alerts.SinkConfig{
+		WebhookURL:   cfg.AlertWebhookURL,
+		SMTPAddr:     cfg.AlertSMTPAddr,
+		SMTPFrom:     cfg.AlertSMTPFrom,
+		SMTPTo:       cfg.AlertSMTPTo,
+		SMTPUser:     cfg.AlertSMTPUser,
+		SMTPPassword: cfg.AlertSMTPPassword,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	quiet, err := alerts.ParseQuietHours(cfg.AlertQuietHours, cfg.AlertTimezone)
+	if err != nil {
+		log.Fatal(err)
+	}
+	alertsRepo := repo.NewAlertsRepo(d.Pool)
+	alertEngine := alerts.NewEngine(alertsRepo, sinks, cfg.AlertDedupWindow, quiet)
+	alertEngine.MaxAttempts = cfg.AlertMaxAttempts
+	alertEngine.RetryBase = cfg.AlertRetryBase
+	alertEngine.RetryMax = cfg.AlertRetryMax
+
 	pricing := services.NewPricingService(chargers, tariffs, sessions)
 	settlementSvc := &services.SettlementService{Chargers: chargers, Sites: sites, Sessions: sessions, Settlements: settlementsRepo}
-	processor := services.NewEventsProcessor(events, chargers, state, sessions, pricing, settlementSvc, cfg.MaxEventSkew)
-	srv := httpapi.NewServer(cfg, chargers, state, sessions, commands, sites, tariffs, settlementsRepo, gw, processor)
+	processor := services.NewEventsProcessor(events, chargers, state, sessions, pricing, settlementSvc, deadLetters, orphans, cfg.MaxEventSkew)
+	processor.Alerts = alertEngine
+	processor.Commands = commands
+	processor.DB = d.Pool
+	queue := services.NewEventQueue(events, processor, cfg.EventWorkers, cfg.EventPollInterval, cfg.EventMaxAttempts, cfg.EventRetryBase, cfg.EventRetryMax)
+	srv := httpapi.NewServer(cfg, chargers, state, sessions, commands, sites, tariffs, settlementsRepo, gw, processor, events, queue)
+	srv.Replay = services.NewReplayService(d.Pool, cfg.MaxEventSkew)
+	srv.DeadLetters = services.NewDeadLetterService(deadLetters, events, processor)
+	srv.Alerts = alertsRepo
+	srv.Registrations = repo.NewRegistrationsRepo(d.Pool)
+	srv.Credentials = services.NewCredentialService(d.Pool, chargers, repo.NewCredentialsRepo(d.Pool), cfg.AuthCacheTTL)
+	srv.Credentials.Commands = commands
+	srv.Tenants = repo.NewTenantsRepo(d.Pool)
+	srv.Gateways.Tenants = srv.Tenants
+	srv.Credentials.Gateways = srv.Gateways
+	srv.Dispatcher = services.NewCommandDispatcher(commands, srv.Gateways, cfg.CommandWorkers, cfg.CommandPollInterval, cfg.CommandRetryBase, cfg.CommandRetryMax)
+	srv.Dispatcher.EffectWindow = cfg.CommandEffectWindow
+	srv.CommandJobs = repo.NewCommandJobsRepo(d.Pool)
+	srv.JobRunner = services.NewCommandJobRunner(srv.CommandJobs, chargers, commands, srv.Gateways, cfg.CommandPollInterval, cfg.CommandTTL)
+	srv.JobRunner.Dispatcher = srv.Dispatcher
+	srv.JobRunner.Limiter = services.NewGatewayLimiter(cfg.GatewayRatePerMinute)
+	srv.Dispatcher.Limiter = srv.JobRunner.Limiter
+	srv.Credentials.Limiter = srv.JobRunner.Limiter
+	srv.Credentials.CertTrust = repo.NewCertTrustRepo(d.Pool)
+	srv.Credentials.Attempts = repo.NewAuthAttemptsRepo(d.Pool)
+	srv.Credentials.Lockout = services.LockoutPolicy{
+		Threshold:   cfg.AuthLockoutThreshold,
+		IPThreshold: cfg.AuthIPLockoutThreshold,
+		Base:        cfg.AuthLockoutBase,
+		Max:         cfg.AuthLockoutMax,
+	}
+	jwt, err := services.NewJWTVerifier(cfg.JWTHS256Secret, cfg.JWTJWKSFile, cfg.JWTIssuer, cfg.JWTAudience, cfg.JWTRolesClaim, cfg.JWTTenantClaim, cfg.JWTPlatformRole)
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv.Auth = services.NewAPIAuth(repo.NewAPIKeysRepo(d.Pool), jwt)
+	srv.Audit = repo.NewAPIAuditRepo(d.Pool)
+	if !cfg.APIAuth {
+		log.Println("WARNING: management API authentication is off (CPMS_API_AUTH=off)")
+	}
+	srv.Maintenance = repo.NewMaintenanceRepo(d.Pool)
+	srv.Availability = services.NewAvailabilityService(chargers, events, state, srv.Maintenance, services.AvailabilityRules{
+		OfflineAfter:        cfg.AvailabilityHeartbeatInterval * time.Duration(cfg.AvailabilityOfflineMissed),
+		UnavailableStatuses: cfg.AvailabilityUnavailableStatuses,
+		TargetPercent:       cfg.AvailabilityTarget,
+	})
+
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	go queue.Run(workersCtx)
+	go srv.Dispatcher.Run(workersCtx)
+	go srv.JobRunner.Run(workersCtx)
+	go srv.Credentials.PruneAttempts(workersCtx, cfg.AuthAttemptRetention, time.Hour)
+	go services.NewOrphanReaper(orphans, processor, cfg.OrphanTimeout, cfg.OrphanReapInterval).Run(workersCtx)
+	go alertEngine.Run(workersCtx)
+	go services.NewConnectivityMonitor(chargers, alertEngine, cfg.HeartbeatInterval, cfg.ConnectivityDegradedMissed, cfg.ConnectivityOfflineMissed, cfg.ConnectivityCheckInterval).Run(workersCtx)
+	go services.NewStaleSessionSweeper(d.Pool, sessions, cfg.MaxEventSkew, cfg.StaleSessionAfter, cfg.StaleAvailableGrace, cfg.StaleSweepInterval).Run(workersCtx)
 
 	httpServer := &http.Server{
 		Addr:              cfg.ListenAddr,
@@ -63,5 +142,6 @@ func main() {
 	ctx2, cancel2 := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel2()
 	_ = httpServer.Shutdown(ctx2)
+	stopWorkers()
 	log.Println("CPMS shutdown complete")
 }
@@ -20,9 +20,11 @@ func main() {
 	active := flag.Bool("active", true, "mark charger active")
 	vendor := flag.String("vendor", "ABB", "vendor")
 	model := flag.String("model", "Terra54", "model")
-	ocpp := siteName := flag.String("site", "", "optional site name (will be created if missing)")
+	ocpp := flag.String("ocpp", "1.6J", "OCPP version")
+	siteName := flag.String("site", "", "optional site name (will be created if missing)")
 	pricePerKwh := flag.Float64("price_per_kwh", 0, "optional per-kWh price for active tariff (requires --site)")
 	currency := flag.String("currency", "USD", "tariff currency")
+	tenant := flag.String("tenant", repo.DefaultTenantId, "tenant id owning the charger and site")
 	flag.Parse()
 
 	cfg := config.Load()
@@ -39,30 +41,49 @@ func main() {
 	r := repo.NewChargersRepo(d.Pool)
 	sites := repo.NewSitesRepo(d.Pool)
 	tariffs := repo.NewTariffsRepo(d.Pool)
+	creds := repo.NewCredentialsRepo(d.Pool)
 
-	hash := security.HashSecretSHA256(*secret)
-	var siteId string
-	if *siteName != "" {
-		id, err := sites.Create(ctx, *siteName)
-		if err != nil { log.Fatal(err) }
-		siteId = id
-		_ = r.SetSite(ctx, *id, siteId)
-		if *pricePerKwh > 0 {
-			_, err := tariffs.UpsertActiveForSite(ctx, siteId, *pricePerKwh, *currency)
-			if err != nil { log.Fatal(err) }
-		}
+	hash, err := security.HashSecret(*secret)
+	if err != nil {
+		log.Fatal(err)
+	}
+	status := "Accepted"
+	if !*active {
+		status = "Pending"
 	}
-
 	err = r.Upsert(ctx, models.Charger{
-		ChargePointId: *id,
-		SecretHash:    hash,
-		IsActive:      *active,
-		Vendor:        *vendor,
-		Model:         *model,
-		OcppVersion:   *ocpp,
+		ChargePointId:      *id,
+		IsActive:           *active,
+		Vendor:             *vendor,
+		Model:              *model,
+		OcppVersion:        *ocpp,
+		RegistrationStatus: status,
+		TenantId:           tenant,
 	})
 	if err != nil {
 		log.Fatal(err)
 	}
+	// the seeded secret replaces all earlier credentials
+	if err := creds.RevokeAll(ctx, *id); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := creds.Add(ctx, *id, hash, time.Now().UTC()); err != nil {
+		log.Fatal(err)
+	}
+
+	if *siteName != "" {
+		siteId, err := sites.Create(ctx, *tenant, *siteName)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := r.SetSite(ctx, *id, siteId); err != nil {
+			log.Fatal(err)
+		}
+		if *pricePerKwh > 0 {
+			if _, err := tariffs.UpsertActiveForSite(ctx, siteId, *pricePerKwh, *currency); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
 	fmt.Println("Seeded charger:", *id, "active=", *active)
 }
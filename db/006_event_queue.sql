@@ -0,0 +1,17 @@
+-- Migration: durable event queue (async processing of gateway_events)
+-- Existing rows were already applied synchronously, so they start as Done.
+alter table gateway_events
+  add column if not exists status text not null default 'Done', -- Pending|Processing|Done|Failed
+  add column if not exists attempts int not null default 0,
+  add column if not exists last_error text,
+  add column if not exists next_attempt_at timestamptz not null default now(),
+  add column if not exists locked_at timestamptz,
+  add column if not exists processed_at timestamptz;
+
+alter table gateway_events alter column status set default 'Pending';
+
+create index if not exists idx_gateway_events_queue on gateway_events(next_attempt_at, id)
+  where status in ('Pending','Processing');
+create index if not exists idx_gateway_events_cp_open on gateway_events(charge_point_id, id)
+  where status in ('Pending','Processing');
+create index if not exists idx_gateway_events_status on gateway_events(status, id);
@@ -0,0 +1,13 @@
+-- Migration: idempotent ingestion keyed on gateway event IDs
+alter table gateway_events
+  add column if not exists event_key text;
+create unique index if not exists uq_gateway_events_event_key on gateway_events(event_key);
+
+-- Side effects remember the event that produced them so a retried apply is a no-op
+alter table sessions
+  add column if not exists start_event_id bigint references gateway_events(id) on delete set null;
+create unique index if not exists uq_sessions_start_event on sessions(start_event_id);
+
+alter table meter_samples
+  add column if not exists event_id bigint references gateway_events(id) on delete set null;
+create unique index if not exists uq_meter_samples_event on meter_samples(event_id);
@@ -0,0 +1,13 @@
+-- Migration: dead-letter table for events that fail validation or processing
+create table if not exists dead_letter_events (
+  id bigserial primary key,
+  gateway_event_id bigint references gateway_events(id) on delete cascade, -- set when the event was stored but could not be applied
+  charge_point_id text,
+  event_type text,
+  payload jsonb not null,
+  reason text not null,
+  status text not null default 'Open', -- Open|Retried|Discarded
+  created_at timestamptz not null default now(),
+  updated_at timestamptz not null default now()
+);
+create index if not exists idx_dead_letter_events_status_created on dead_letter_events(status, created_at);
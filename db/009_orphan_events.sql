@@ -0,0 +1,17 @@
+-- Migration: park MeterSample/TransactionEnded events that arrive before TransactionStarted
+create table if not exists orphan_events (
+  id bigserial primary key,
+  gateway_event_id bigint not null unique references gateway_events(id) on delete cascade,
+  charge_point_id text not null references chargers(charge_point_id) on delete cascade,
+  transaction_id int not null,
+  event_type text not null,
+  ts timestamptz not null,
+  created_at timestamptz not null default now(),
+  applied_at timestamptz
+);
+create index if not exists idx_orphan_events_cp_tx on orphan_events(charge_point_id, transaction_id) where applied_at is null;
+create index if not exists idx_orphan_events_created on orphan_events(created_at) where applied_at is null;
+
+-- Sessions created by the orphan reaper because TransactionStarted never arrived
+alter table sessions
+  add column if not exists is_synthesized boolean not null default false;
@@ -0,0 +1,12 @@
+-- Migration: OCPP 2.0.1 TransactionEvent support
+-- 2.0.1 transaction IDs are strings; 1.6 integer IDs are stored as their decimal text.
+alter table sessions alter column transaction_id type text using transaction_id::text;
+alter table meter_samples alter column transaction_id type text using transaction_id::text;
+alter table orphan_events alter column transaction_id type text using transaction_id::text;
+
+alter table sessions
+  add column if not exists evse_id int,
+  add column if not exists last_seq_no int;
+
+alter table meter_samples
+  add column if not exists seq_no int;
@@ -0,0 +1,24 @@
+-- Migration: transaction ID reuse across charger reboots
+-- A boot epoch is the number of ChargerBooted events of the charge point up to a given ts.
+-- Cheap chargers restart their transaction counter after a reboot, so sessions and parked
+-- orphans are matched on (charge_point_id, transaction_id, boot_epoch).
+create index if not exists idx_gateway_events_boots on gateway_events(charge_point_id, ts) where event_type='ChargerBooted';
+
+alter table sessions
+  add column if not exists boot_epoch int not null default 0;
+alter table orphan_events
+  add column if not exists boot_epoch int not null default 0;
+
+update sessions s set boot_epoch=(
+  select count(*) from gateway_events e
+  where e.charge_point_id=s.charge_point_id and e.event_type='ChargerBooted' and e.ts<=s.started_at
+);
+update orphan_events o set boot_epoch=(
+  select count(*) from gateway_events e
+  where e.charge_point_id=o.charge_point_id and e.event_type='ChargerBooted' and e.ts<=o.ts
+);
+
+drop index if exists idx_sessions_cp_tx;
+create index if not exists idx_sessions_cp_tx_epoch on sessions(charge_point_id, transaction_id, boot_epoch);
+drop index if exists idx_orphan_events_cp_tx;
+create index if not exists idx_orphan_events_cp_tx_epoch on orphan_events(charge_point_id, transaction_id, boot_epoch) where applied_at is null;
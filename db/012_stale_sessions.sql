@@ -0,0 +1,3 @@
+-- Migration: stale session sweeper
+-- Open sessions are scanned periodically; closed ones get reason 'StaleClosed'.
+create index if not exists idx_sessions_open on sessions(started_at) where ended_at is null;
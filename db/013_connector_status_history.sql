@@ -0,0 +1,30 @@
+-- Migration: connector status history
+-- One row per status/errorCode transition, started_at = ts of the ConnectorStatusChanged event.
+create table if not exists connector_status_history (
+  id bigserial primary key,
+  charge_point_id text not null references chargers(charge_point_id) on delete cascade,
+  connector_id int not null,
+  status text not null,
+  error_code text not null default 'NoError',
+  started_at timestamptz not null,
+  event_id bigint unique references gateway_events(id) on delete set null,
+  created_at timestamptz not null default now()
+);
+create index if not exists idx_connector_status_history_cp_conn_started on connector_status_history(charge_point_id, connector_id, started_at);
+
+-- Backfill from already applied ConnectorStatusChanged events.
+insert into connector_status_history (charge_point_id, connector_id, status, error_code, started_at, event_id)
+select charge_point_id, connector_id, status, error_code, ts, id
+from (
+  select e.id, e.charge_point_id, e.ts,
+    (e.payload->>'connectorId')::int as connector_id,
+    e.payload->>'status' as status,
+    coalesce(nullif(e.payload->>'errorCode',''), 'NoError') as error_code,
+    lag(e.payload->>'status') over w as prev_status,
+    lag(coalesce(nullif(e.payload->>'errorCode',''), 'NoError')) over w as prev_error_code
+  from gateway_events e
+  where e.event_type='ConnectorStatusChanged' and e.status='Done'
+  window w as (partition by e.charge_point_id, (e.payload->>'connectorId')::int order by e.ts, e.id)
+) x
+where prev_status is distinct from status or prev_error_code is distinct from error_code
+on conflict (event_id) do nothing;
@@ -0,0 +1,14 @@
+-- Migration: availability reporting
+-- Scheduled maintenance is excluded from availability. A window applies to one charger,
+-- all chargers of a site, or the whole fleet (both null).
+create table if not exists maintenance_windows (
+  maintenance_id uuid primary key default uuid_generate_v4(),
+  charge_point_id text references chargers(charge_point_id) on delete cascade,
+  site_id uuid references sites(site_id) on delete cascade,
+  starts_at timestamptz not null,
+  ends_at timestamptz not null,
+  reason text not null default '',
+  created_at timestamptz not null default now(),
+  check (ends_at > starts_at)
+);
+create index if not exists idx_maintenance_windows_range on maintenance_windows(starts_at, ends_at);
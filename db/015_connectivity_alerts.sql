@@ -0,0 +1,35 @@
+-- Migration: charger connectivity monitoring and alerting
+alter table chargers
+  add column if not exists heartbeat_interval_seconds int, -- from ChargerBooted; null = CPMS_HEARTBEAT_INTERVAL
+  add column if not exists connectivity_state text not null default 'Unknown', -- Unknown|Online|Degraded|Offline
+  add column if not exists connectivity_changed_at timestamptz;
+
+create table if not exists charger_state_events (
+  id bigserial primary key,
+  charge_point_id text not null references chargers(charge_point_id) on delete cascade,
+  from_state text not null,
+  to_state text not null,
+  last_seen_at timestamptz,
+  created_at timestamptz not null default now()
+);
+create index if not exists idx_charger_state_events_cp_created on charger_state_events(charge_point_id, created_at desc);
+
+create table if not exists alerts (
+  id bigserial primary key,
+  dedup_key text not null,
+  charge_point_id text not null references chargers(charge_point_id) on delete cascade,
+  connector_id int,
+  kind text not null, -- ChargerDegraded|ChargerOffline|ConnectorFaulted
+  severity text not null, -- Warning|Critical
+  message text not null,
+  status text not null default 'Open', -- Open|Resolved
+  occurrences int not null default 1,
+  first_at timestamptz not null default now(),
+  last_at timestamptz not null default now(),
+  resolved_at timestamptz,
+  notify_pending boolean not null default true, -- a notification waits for delivery (e.g. quiet hours)
+  notified_at timestamptz
+);
+create unique index if not exists uq_alerts_open_key on alerts(dedup_key) where status='Open';
+create index if not exists idx_alerts_pending on alerts(id) where notify_pending;
+create index if not exists idx_alerts_status_last on alerts(status, last_at desc);
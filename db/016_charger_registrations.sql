@@ -0,0 +1,22 @@
+-- Migration: onboarding approval for chargers that boot before being provisioned
+alter table chargers
+  add column if not exists registration_status text not null default 'Accepted', -- Pending|Accepted|Rejected|Blocked
+  add column if not exists registration_changed_at timestamptz;
+
+-- chargers auto-inserted by ChargerBooted were never provisioned
+update chargers set registration_status='Pending' where secret_hash='' and not is_active;
+alter table chargers alter column registration_status set default 'Pending';
+
+create index if not exists idx_chargers_registration on chargers(registration_status, created_at);
+
+create table if not exists charger_registration_audit (
+  id bigserial primary key,
+  charge_point_id text not null references chargers(charge_point_id) on delete cascade,
+  action text not null, -- Approved|Rejected|Blocked
+  from_status text not null,
+  actor text not null,
+  reason text not null default '',
+  site_id uuid references sites(site_id) on delete set null,
+  created_at timestamptz not null default now()
+);
+create index if not exists idx_charger_registration_audit_cp on charger_registration_audit(charge_point_id, created_at desc);
@@ -0,0 +1,32 @@
+-- Migration: multiple charger credentials with validity windows, and secret rotation history
+create table if not exists charger_credentials (
+  credential_id uuid primary key default uuid_generate_v4(),
+  charge_point_id text not null references chargers(charge_point_id) on delete cascade,
+  secret_hash text not null,
+  not_before timestamptz not null default now(),
+  not_after timestamptz, -- null = until a newer credential is used or it is revoked
+  first_used_at timestamptz,
+  last_used_at timestamptz,
+  revoked_at timestamptz,
+  created_at timestamptz not null default now()
+);
+create index if not exists idx_charger_credentials_cp_created on charger_credentials(charge_point_id, created_at desc);
+
+-- chargers.secret_hash is superseded by charger_credentials and no longer read
+insert into charger_credentials (charge_point_id, secret_hash, not_before, created_at)
+select charge_point_id, secret_hash, created_at, created_at from chargers c
+where secret_hash <> ''
+  and not exists (select 1 from charger_credentials k where k.charge_point_id=c.charge_point_id);
+
+create table if not exists credential_rotations (
+  rotation_id uuid primary key default uuid_generate_v4(),
+  charge_point_id text not null references chargers(charge_point_id) on delete cascade,
+  credential_id uuid not null references charger_credentials(credential_id) on delete cascade,
+  actor text not null,
+  status text not null default 'Pending', -- Pending|Completed|Superseded
+  old_valid_until timestamptz, -- hard expiry requested for the previous credentials
+  command_id uuid references commands(command_id) on delete set null,
+  created_at timestamptz not null default now(),
+  completed_at timestamptz -- first authentication with the new credential
+);
+create index if not exists idx_credential_rotations_cp_created on credential_rotations(charge_point_id, created_at desc);
@@ -0,0 +1,19 @@
+-- Migration: per-charger OCPP security profile and client certificate trust
+-- 1 = basic auth password, 2 = TLS + password, 3 = mutual TLS (client certificate)
+alter table chargers
+  add column if not exists security_profile int not null default 1 check (security_profile in (1,2,3));
+
+-- Either a pinned certificate fingerprint (SHA-256 of the DER, lowercase hex) or a trusted CA
+-- with the expected subject CN (null = the chargePointId).
+create table if not exists charger_cert_trust (
+  trust_id uuid primary key default uuid_generate_v4(),
+  charge_point_id text not null references chargers(charge_point_id) on delete cascade,
+  fingerprint text,
+  ca_pem text,
+  expected_cn text,
+  label text not null default '',
+  created_at timestamptz not null default now(),
+  check ((fingerprint is null) <> (ca_pem is null))
+);
+create index if not exists idx_charger_cert_trust_cp on charger_cert_trust(charge_point_id);
+create unique index if not exists uq_charger_cert_trust_pin on charger_cert_trust(charge_point_id, fingerprint) where fingerprint is not null;
@@ -0,0 +1,23 @@
+-- Migration: audit of charger auth attempts and lockouts against brute force
+-- No FK on charge_point_id: attempts for unknown chargers are recorded too.
+create table if not exists charger_auth_attempts (
+  id bigserial primary key,
+  charge_point_id text not null,
+  remote_addr text not null default '',
+  outcome text not null check (outcome in ('Allowed','Denied','Locked')),
+  reason text not null default '',
+  method text not null default '',
+  created_at timestamptz not null default now()
+);
+create index if not exists idx_charger_auth_attempts_cp on charger_auth_attempts(charge_point_id, created_at desc);
+create index if not exists idx_charger_auth_attempts_ip on charger_auth_attempts(remote_addr, created_at desc);
+
+-- Consecutive failures per charger / per remote IP; locked_until grows exponentially.
+create table if not exists auth_lockouts (
+  scope text not null check (scope in ('Charger','Ip')),
+  key text not null,
+  failures int not null default 0,
+  last_failure_at timestamptz not null,
+  locked_until timestamptz,
+  primary key (scope, key)
+);
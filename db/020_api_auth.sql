@@ -0,0 +1,26 @@
+-- Migration: API keys for the management API and an audit log of mutating requests
+create table if not exists api_keys (
+  key_id uuid primary key default uuid_generate_v4(),
+  name text not null,
+  key_prefix text not null,          -- first characters of the key, for display
+  key_hash text not null unique,     -- SHA-256 of the key, hex
+  roles text[] not null,
+  created_by text not null default '',
+  created_at timestamptz not null default now(),
+  last_used_at timestamptz,
+  revoked_at timestamptz
+);
+
+create table if not exists api_audit_log (
+  id bigserial primary key,
+  actor text not null default '',
+  auth_method text not null default '',   -- ApiKey|JWT ('' when API auth is off)
+  http_method text not null,
+  path text not null,
+  route text not null default '',
+  status int not null,
+  remote_addr text not null default '',
+  created_at timestamptz not null default now()
+);
+create index if not exists idx_api_audit_log_created on api_audit_log(created_at desc);
+create index if not exists idx_api_audit_log_actor on api_audit_log(actor, created_at desc);
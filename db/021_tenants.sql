@@ -0,0 +1,43 @@
+-- Migration: tenants (white-label CPOs) owning sites and chargers, with per-tenant config
+create table if not exists tenants (
+  tenant_id uuid primary key default uuid_generate_v4(),
+  name text not null unique,
+  default_currency text not null default 'USD',
+  gateway_base_url text,   -- null = CPMS default (GATEWAY_BASE_URL)
+  gateway_api_key text,    -- null = CPMS default (GATEWAY_API_KEY)
+  created_at timestamptz not null default now(),
+  updated_at timestamptz not null default now()
+);
+
+-- Existing data belongs to the default tenant.
+insert into tenants (tenant_id, name) values ('00000000-0000-0000-0000-000000000001', 'default')
+on conflict do nothing;
+
+alter table sites
+  add column if not exists tenant_id uuid references tenants(tenant_id);
+update sites set tenant_id='00000000-0000-0000-0000-000000000001' where tenant_id is null;
+alter table sites alter column tenant_id set not null;
+-- site names are unique per tenant
+alter table sites drop constraint if exists sites_name_key;
+create unique index if not exists uq_sites_tenant_name on sites(tenant_id, name);
+
+-- Chargers that booted unannounced stay unassigned (null) until a registration is approved.
+alter table chargers
+  add column if not exists tenant_id uuid references tenants(tenant_id);
+update chargers c set tenant_id=coalesce((select s.tenant_id from sites s where s.site_id=c.site_id), '00000000-0000-0000-0000-000000000001')
+where c.tenant_id is null and c.registration_status <> 'Pending';
+create index if not exists idx_chargers_tenant on chargers(tenant_id);
+
+-- null = maintenance of every tenant's fleet
+alter table maintenance_windows
+  add column if not exists tenant_id uuid references tenants(tenant_id) on delete cascade;
+update maintenance_windows m set tenant_id=coalesce(
+  (select c.tenant_id from chargers c where c.charge_point_id=m.charge_point_id),
+  (select s.tenant_id from sites s where s.site_id=m.site_id))
+where m.tenant_id is null;
+
+-- null = platform-wide key (all tenants)
+alter table api_keys
+  add column if not exists tenant_id uuid references tenants(tenant_id) on delete cascade;
+alter table api_audit_log
+  add column if not exists tenant_id uuid;
@@ -0,0 +1,18 @@
+-- Migration: commands are an outbox dispatched to the gateway by background workers
+-- Queued (waiting for the next attempt) -> Sent (attempt in flight) -> Acked|Failed|Expired
+alter table commands
+  add column if not exists attempts int not null default 0,
+  add column if not exists next_attempt_at timestamptz not null default now(),
+  add column if not exists locked_at timestamptz,
+  add column if not exists expires_at timestamptz not null default now() + interval '5 minutes',
+  add column if not exists completed_at timestamptz;
+
+-- Commands left over from synchronous dispatch are not sent late.
+update commands set status='Expired', error=coalesce(error, 'not dispatched before the command outbox was introduced'),
+  completed_at=now()
+where status in ('Queued','Sent');
+
+create index if not exists idx_commands_outbox on commands(next_attempt_at, created_at)
+  where status in ('Queued','Sent');
+create index if not exists idx_commands_cp_open on commands(charge_point_id, created_at)
+  where status in ('Queued','Sent');
@@ -0,0 +1,19 @@
+-- Migration: link commands to the charger events they cause
+-- Acked commands with an expected effect become Completed when a matching event arrives
+-- before effect_deadline, otherwise NoEffect.
+alter table commands
+  add column if not exists effect_event text,          -- TransactionStarted|TransactionEnded|ConnectorStatusChanged|ChargerBooted
+  add column if not exists effect_connector_id int,
+  add column if not exists effect_evse_id int,
+  add column if not exists effect_id_tag text,
+  add column if not exists effect_transaction_id text,
+  add column if not exists effect_statuses text[],      -- any of these connector statuses
+  add column if not exists effect_deadline timestamptz,
+  add column if not exists effect_event_id bigint references gateway_events(id) on delete set null,
+  add column if not exists effect_session_id uuid references sessions(session_id) on delete set null,
+  add column if not exists effect_at timestamptz;
+
+create index if not exists idx_commands_effect_open on commands(charge_point_id, effect_event, created_at)
+  where status in ('Sent','Acked','NoEffect') and effect_event is not null;
+create index if not exists idx_commands_effect_session on commands(effect_session_id)
+  where effect_session_id is not null;
@@ -0,0 +1,34 @@
+-- Migration: bulk command jobs fanned out to many chargers through the command outbox
+alter table chargers
+  add column if not exists tags text[] not null default '{}';
+create index if not exists idx_chargers_tags on chargers using gin (tags);
+
+-- Running -> Paused -> Running ... -> Completed | Aborted
+create table if not exists command_jobs (
+  job_id uuid primary key default uuid_generate_v4(),
+  tenant_id uuid references tenants(tenant_id) on delete cascade,  -- null = chargers of all tenants
+  type text not null,
+  payload jsonb not null,
+  selector jsonb not null,
+  status text not null default 'Running',
+  concurrency int not null,             -- commands of the job in flight at once
+  rate_per_minute int not null,         -- commands submitted per gateway and minute
+  created_by text,
+  created_at timestamptz not null default now(),
+  updated_at timestamptz not null default now(),
+  finished_at timestamptz
+);
+create index if not exists idx_command_jobs_created on command_jobs(created_at desc);
+
+-- Pending -> Running (command submitted) -> Succeeded | NoEffect | Failed; Skipped / Aborted without a command
+create table if not exists command_job_items (
+  job_id uuid not null references command_jobs(job_id) on delete cascade,
+  charge_point_id text not null references chargers(charge_point_id) on delete cascade,
+  status text not null default 'Pending',
+  command_id uuid references commands(command_id) on delete set null,
+  error text,
+  updated_at timestamptz not null default now(),
+  primary key (job_id, charge_point_id)
+);
+create index if not exists idx_command_job_items_open on command_job_items(job_id, status)
+  where status in ('Pending','Running');
@@ -0,0 +1,9 @@
+-- Migration: charger lockouts per (charger, remote IP) and retention of auth attempts
+-- A charger ID is locked out only for the address that failed, so failures from elsewhere
+-- cannot lock a working charger out. Existing charger lockouts are dropped.
+delete from auth_lockouts where scope='Charger';
+alter table auth_lockouts add column if not exists remote_addr text not null default '';
+alter table auth_lockouts drop constraint if exists auth_lockouts_pkey;
+alter table auth_lockouts add primary key (scope, key, remote_addr);
+
+create index if not exists idx_charger_auth_attempts_created on charger_auth_attempts(created_at);
@@ -0,0 +1,14 @@
+-- Migration: session ids follow a replay
+-- A replay rebuilds sessions and gives the rebuilt ones back the ids of the sessions they
+-- replace; references to sessions follow the id.
+alter table meter_samples drop constraint if exists meter_samples_session_id_fkey;
+alter table meter_samples add constraint meter_samples_session_id_fkey
+  foreign key (session_id) references sessions(session_id) on delete cascade on update cascade;
+
+alter table settlements drop constraint if exists settlements_session_id_fkey;
+alter table settlements add constraint settlements_session_id_fkey
+  foreign key (session_id) references sessions(session_id) on delete cascade on update cascade;
+
+alter table commands drop constraint if exists commands_effect_session_id_fkey;
+alter table commands add constraint commands_effect_session_id_fkey
+  foreign key (effect_session_id) references sessions(session_id) on delete set null on update cascade;
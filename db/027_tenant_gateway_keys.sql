@@ -0,0 +1,4 @@
+-- Migration: tenant gateways authenticate with their tenant's gateway API key
+-- The key identifies the tenant on /v1/gateway routes, so it must be unique.
+create unique index if not exists uq_tenants_gateway_api_key on tenants(gateway_api_key)
+  where gateway_api_key is not null;
@@ -0,0 +1,7 @@
+-- Migration: alert notifications are tracked per sink
+-- Sinks that already received the pending notification; a sink failure only retries the
+-- sinks missing here. Reset when the alert is queued for notification again.
+alter table alerts
+  add column if not exists notified_sinks text[] not null default '{}';
+
+create index if not exists idx_alerts_pending_critical on alerts(id) where notify_pending and severity='Critical';
@@ -0,0 +1,5 @@
+-- Migration: ChangeAvailability effects require a status transition
+-- Statuses the connector must leave for an event to count as the command's effect; such
+-- events only count once the command was acked.
+alter table commands
+  add column if not exists effect_from_statuses text[];
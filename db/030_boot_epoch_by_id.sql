@@ -0,0 +1,16 @@
+-- Migration: boot epochs follow the order events were received, not charger time
+-- A charger's clock may reset on reboot and MaxSkew clamps ts, so the epoch of an event is
+-- the number of ChargerBooted events of the charge point stored up to it (by id).
+create index if not exists idx_gateway_events_boots_id on gateway_events(charge_point_id, id) where event_type='ChargerBooted';
+
+update sessions s set boot_epoch=(
+  select count(*) from gateway_events e
+  where e.charge_point_id=s.charge_point_id and e.event_type='ChargerBooted'
+    and e.id <= coalesce(s.start_event_id, (select min(m.event_id) from meter_samples m where m.session_id=s.session_id))
+)
+where s.start_event_id is not null
+   or exists (select 1 from meter_samples m where m.session_id=s.session_id and m.event_id is not null);
+update orphan_events o set boot_epoch=(
+  select count(*) from gateway_events e
+  where e.charge_point_id=o.charge_point_id and e.event_type='ChargerBooted' and e.id<=o.gateway_event_id
+);
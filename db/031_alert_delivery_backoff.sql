@@ -0,0 +1,9 @@
+-- Migration: failed alert deliveries back off and give up
+-- A failed delivery is retried at notify_next_at with exponential backoff; after the last
+-- attempt the notification is dropped (notify_pending=false) and notify_failed_at is set.
+-- All four are reset when the alert is queued for notification again.
+alter table alerts
+  add column if not exists notify_attempts int not null default 0,
+  add column if not exists notify_next_at timestamptz,
+  add column if not exists notify_error text,
+  add column if not exists notify_failed_at timestamptz;
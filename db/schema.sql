@@ -125,3 +125,477 @@ create table if not exists settlements (
   unique(session_id)
 );
 create index if not exists idx_settlements_status_created on settlements(status, created_at);
+
+
+alter table gateway_events
+  add column if not exists status text not null default 'Pending', -- Pending|Processing|Done|Failed
+  add column if not exists attempts int not null default 0,
+  add column if not exists last_error text,
+  add column if not exists next_attempt_at timestamptz not null default now(),
+  add column if not exists locked_at timestamptz,
+  add column if not exists processed_at timestamptz;
+
+create index if not exists idx_gateway_events_queue on gateway_events(next_attempt_at, id)
+  where status in ('Pending','Processing');
+create index if not exists idx_gateway_events_cp_open on gateway_events(charge_point_id, id)
+  where status in ('Pending','Processing');
+create index if not exists idx_gateway_events_status on gateway_events(status, id);
+
+
+alter table gateway_events
+  add column if not exists event_key text;
+create unique index if not exists uq_gateway_events_event_key on gateway_events(event_key);
+
+alter table sessions
+  add column if not exists start_event_id bigint references gateway_events(id) on delete set null;
+create unique index if not exists uq_sessions_start_event on sessions(start_event_id);
+
+alter table meter_samples
+  add column if not exists event_id bigint references gateway_events(id) on delete set null;
+create unique index if not exists uq_meter_samples_event on meter_samples(event_id);
+
+
+create table if not exists dead_letter_events (
+  id bigserial primary key,
+  gateway_event_id bigint references gateway_events(id) on delete cascade, -- set when the event was stored but could not be applied
+  charge_point_id text,
+  event_type text,
+  payload jsonb not null,
+  reason text not null,
+  status text not null default 'Open', -- Open|Retried|Discarded
+  created_at timestamptz not null default now(),
+  updated_at timestamptz not null default now()
+);
+create index if not exists idx_dead_letter_events_status_created on dead_letter_events(status, created_at);
+
+
+create table if not exists orphan_events (
+  id bigserial primary key,
+  gateway_event_id bigint not null unique references gateway_events(id) on delete cascade,
+  charge_point_id text not null references chargers(charge_point_id) on delete cascade,
+  transaction_id int not null,
+  event_type text not null,
+  ts timestamptz not null,
+  created_at timestamptz not null default now(),
+  applied_at timestamptz
+);
+create index if not exists idx_orphan_events_cp_tx on orphan_events(charge_point_id, transaction_id) where applied_at is null;
+create index if not exists idx_orphan_events_created on orphan_events(created_at) where applied_at is null;
+
+alter table sessions
+  add column if not exists is_synthesized boolean not null default false;
+
+
+alter table sessions alter column transaction_id type text using transaction_id::text;
+alter table meter_samples alter column transaction_id type text using transaction_id::text;
+alter table orphan_events alter column transaction_id type text using transaction_id::text;
+
+alter table sessions
+  add column if not exists evse_id int,
+  add column if not exists last_seq_no int;
+
+alter table meter_samples
+  add column if not exists seq_no int;
+
+
+create index if not exists idx_gateway_events_boots on gateway_events(charge_point_id, ts) where event_type='ChargerBooted';
+
+alter table sessions
+  add column if not exists boot_epoch int not null default 0;
+alter table orphan_events
+  add column if not exists boot_epoch int not null default 0;
+
+update sessions s set boot_epoch=(
+  select count(*) from gateway_events e
+  where e.charge_point_id=s.charge_point_id and e.event_type='ChargerBooted' and e.ts<=s.started_at
+);
+update orphan_events o set boot_epoch=(
+  select count(*) from gateway_events e
+  where e.charge_point_id=o.charge_point_id and e.event_type='ChargerBooted' and e.ts<=o.ts
+);
+
+drop index if exists idx_sessions_cp_tx;
+create index if not exists idx_sessions_cp_tx_epoch on sessions(charge_point_id, transaction_id, boot_epoch);
+drop index if exists idx_orphan_events_cp_tx;
+create index if not exists idx_orphan_events_cp_tx_epoch on orphan_events(charge_point_id, transaction_id, boot_epoch) where applied_at is null;
+
+
+create index if not exists idx_sessions_open on sessions(started_at) where ended_at is null;
+
+
+create table if not exists connector_status_history (
+  id bigserial primary key,
+  charge_point_id text not null references chargers(charge_point_id) on delete cascade,
+  connector_id int not null,
+  status text not null,
+  error_code text not null default 'NoError',
+  started_at timestamptz not null,
+  event_id bigint unique references gateway_events(id) on delete set null,
+  created_at timestamptz not null default now()
+);
+create index if not exists idx_connector_status_history_cp_conn_started on connector_status_history(charge_point_id, connector_id, started_at);
+
+
+create table if not exists maintenance_windows (
+  maintenance_id uuid primary key default uuid_generate_v4(),
+  charge_point_id text references chargers(charge_point_id) on delete cascade,
+  site_id uuid references sites(site_id) on delete cascade,
+  starts_at timestamptz not null,
+  ends_at timestamptz not null,
+  reason text not null default '',
+  created_at timestamptz not null default now(),
+  check (ends_at > starts_at)
+);
+create index if not exists idx_maintenance_windows_range on maintenance_windows(starts_at, ends_at);
+
+
+alter table chargers
+  add column if not exists heartbeat_interval_seconds int, -- from ChargerBooted; null = CPMS_HEARTBEAT_INTERVAL
+  add column if not exists connectivity_state text not null default 'Unknown', -- Unknown|Online|Degraded|Offline
+  add column if not exists connectivity_changed_at timestamptz;
+
+create table if not exists charger_state_events (
+  id bigserial primary key,
+  charge_point_id text not null references chargers(charge_point_id) on delete cascade,
+  from_state text not null,
+  to_state text not null,
+  last_seen_at timestamptz,
+  created_at timestamptz not null default now()
+);
+create index if not exists idx_charger_state_events_cp_created on charger_state_events(charge_point_id, created_at desc);
+
+create table if not exists alerts (
+  id bigserial primary key,
+  dedup_key text not null,
+  charge_point_id text not null references chargers(charge_point_id) on delete cascade,
+  connector_id int,
+  kind text not null, -- ChargerDegraded|ChargerOffline|ConnectorFaulted
+  severity text not null, -- Warning|Critical
+  message text not null,
+  status text not null default 'Open', -- Open|Resolved
+  occurrences int not null default 1,
+  first_at timestamptz not null default now(),
+  last_at timestamptz not null default now(),
+  resolved_at timestamptz,
+  notify_pending boolean not null default true, -- a notification waits for delivery (e.g. quiet hours)
+  notified_at timestamptz
+);
+create unique index if not exists uq_alerts_open_key on alerts(dedup_key) where status='Open';
+create index if not exists idx_alerts_pending on alerts(id) where notify_pending;
+create index if not exists idx_alerts_status_last on alerts(status, last_at desc);
+
+
+alter table chargers
+  add column if not exists registration_status text not null default 'Accepted', -- Pending|Accepted|Rejected|Blocked
+  add column if not exists registration_changed_at timestamptz;
+
+-- chargers auto-inserted by ChargerBooted were never provisioned
+update chargers set registration_status='Pending' where secret_hash='' and not is_active;
+alter table chargers alter column registration_status set default 'Pending';
+
+create index if not exists idx_chargers_registration on chargers(registration_status, created_at);
+
+create table if not exists charger_registration_audit (
+  id bigserial primary key,
+  charge_point_id text not null references chargers(charge_point_id) on delete cascade,
+  action text not null, -- Approved|Rejected|Blocked
+  from_status text not null,
+  actor text not null,
+  reason text not null default '',
+  site_id uuid references sites(site_id) on delete set null,
+  created_at timestamptz not null default now()
+);
+create index if not exists idx_charger_registration_audit_cp on charger_registration_audit(charge_point_id, created_at desc);
+
+
+create table if not exists charger_credentials (
+  credential_id uuid primary key default uuid_generate_v4(),
+  charge_point_id text not null references chargers(charge_point_id) on delete cascade,
+  secret_hash text not null,
+  not_before timestamptz not null default now(),
+  not_after timestamptz, -- null = until a newer credential is used or it is revoked
+  first_used_at timestamptz,
+  last_used_at timestamptz,
+  revoked_at timestamptz,
+  created_at timestamptz not null default now()
+);
+create index if not exists idx_charger_credentials_cp_created on charger_credentials(charge_point_id, created_at desc);
+
+-- chargers.secret_hash is superseded by charger_credentials and no longer read
+insert into charger_credentials (charge_point_id, secret_hash, not_before, created_at)
+select charge_point_id, secret_hash, created_at, created_at from chargers c
+where secret_hash <> ''
+  and not exists (select 1 from charger_credentials k where k.charge_point_id=c.charge_point_id);
+
+create table if not exists credential_rotations (
+  rotation_id uuid primary key default uuid_generate_v4(),
+  charge_point_id text not null references chargers(charge_point_id) on delete cascade,
+  credential_id uuid not null references charger_credentials(credential_id) on delete cascade,
+  actor text not null,
+  status text not null default 'Pending', -- Pending|Completed|Superseded
+  old_valid_until timestamptz, -- hard expiry requested for the previous credentials
+  command_id uuid references commands(command_id) on delete set null,
+  created_at timestamptz not null default now(),
+  completed_at timestamptz -- first authentication with the new credential
+);
+create index if not exists idx_credential_rotations_cp_created on credential_rotations(charge_point_id, created_at desc);
+
+
+-- 1 = basic auth password, 2 = TLS + password, 3 = mutual TLS (client certificate)
+alter table chargers
+  add column if not exists security_profile int not null default 1 check (security_profile in (1,2,3));
+
+-- Either a pinned certificate fingerprint (SHA-256 of the DER, lowercase hex) or a trusted CA
+-- with the expected subject CN (null = the chargePointId).
+create table if not exists charger_cert_trust (
+  trust_id uuid primary key default uuid_generate_v4(),
+  charge_point_id text not null references chargers(charge_point_id) on delete cascade,
+  fingerprint text,
+  ca_pem text,
+  expected_cn text,
+  label text not null default '',
+  created_at timestamptz not null default now(),
+  check ((fingerprint is null) <> (ca_pem is null))
+);
+create index if not exists idx_charger_cert_trust_cp on charger_cert_trust(charge_point_id);
+create unique index if not exists uq_charger_cert_trust_pin on charger_cert_trust(charge_point_id, fingerprint) where fingerprint is not null;
+
+
+-- No FK on charge_point_id: attempts for unknown chargers are recorded too.
+create table if not exists charger_auth_attempts (
+  id bigserial primary key,
+  charge_point_id text not null,
+  remote_addr text not null default '',
+  outcome text not null check (outcome in ('Allowed','Denied','Locked')),
+  reason text not null default '',
+  method text not null default '',
+  created_at timestamptz not null default now()
+);
+create index if not exists idx_charger_auth_attempts_cp on charger_auth_attempts(charge_point_id, created_at desc);
+create index if not exists idx_charger_auth_attempts_ip on charger_auth_attempts(remote_addr, created_at desc);
+
+-- Consecutive failures per charger / per remote IP; locked_until grows exponentially.
+create table if not exists auth_lockouts (
+  scope text not null check (scope in ('Charger','Ip')),
+  key text not null,
+  failures int not null default 0,
+  last_failure_at timestamptz not null,
+  locked_until timestamptz,
+  primary key (scope, key)
+);
+
+
+create table if not exists api_keys (
+  key_id uuid primary key default uuid_generate_v4(),
+  name text not null,
+  key_prefix text not null,          -- first characters of the key, for display
+  key_hash text not null unique,     -- SHA-256 of the key, hex
+  roles text[] not null,
+  created_by text not null default '',
+  created_at timestamptz not null default now(),
+  last_used_at timestamptz,
+  revoked_at timestamptz
+);
+
+create table if not exists api_audit_log (
+  id bigserial primary key,
+  actor text not null default '',
+  auth_method text not null default '',   -- ApiKey|JWT ('' when API auth is off)
+  http_method text not null,
+  path text not null,
+  route text not null default '',
+  status int not null,
+  remote_addr text not null default '',
+  created_at timestamptz not null default now()
+);
+create index if not exists idx_api_audit_log_created on api_audit_log(created_at desc);
+create index if not exists idx_api_audit_log_actor on api_audit_log(actor, created_at desc);
+
+
+create table if not exists tenants (
+  tenant_id uuid primary key default uuid_generate_v4(),
+  name text not null unique,
+  default_currency text not null default 'USD',
+  gateway_base_url text,   -- null = CPMS default (GATEWAY_BASE_URL)
+  gateway_api_key text,    -- null = CPMS default (GATEWAY_API_KEY)
+  created_at timestamptz not null default now(),
+  updated_at timestamptz not null default now()
+);
+
+-- Existing data belongs to the default tenant.
+insert into tenants (tenant_id, name) values ('00000000-0000-0000-0000-000000000001', 'default')
+on conflict do nothing;
+
+alter table sites
+  add column if not exists tenant_id uuid references tenants(tenant_id);
+update sites set tenant_id='00000000-0000-0000-0000-000000000001' where tenant_id is null;
+alter table sites alter column tenant_id set not null;
+-- site names are unique per tenant
+alter table sites drop constraint if exists sites_name_key;
+create unique index if not exists uq_sites_tenant_name on sites(tenant_id, name);
+
+-- Chargers that booted unannounced stay unassigned (null) until a registration is approved.
+alter table chargers
+  add column if not exists tenant_id uuid references tenants(tenant_id);
+update chargers c set tenant_id=coalesce((select s.tenant_id from sites s where s.site_id=c.site_id), '00000000-0000-0000-0000-000000000001')
+where c.tenant_id is null and c.registration_status <> 'Pending';
+create index if not exists idx_chargers_tenant on chargers(tenant_id);
+
+-- null = maintenance of every tenant's fleet
+alter table maintenance_windows
+  add column if not exists tenant_id uuid references tenants(tenant_id) on delete cascade;
+update maintenance_windows m set tenant_id=coalesce(
+  (select c.tenant_id from chargers c where c.charge_point_id=m.charge_point_id),
+  (select s.tenant_id from sites s where s.site_id=m.site_id))
+where m.tenant_id is null;
+
+-- null = platform-wide key (all tenants)
+alter table api_keys
+  add column if not exists tenant_id uuid references tenants(tenant_id) on delete cascade;
+alter table api_audit_log
+  add column if not exists tenant_id uuid;
+
+
+-- Queued (waiting for the next attempt) -> Sent (attempt in flight) -> Acked|Failed|Expired
+alter table commands
+  add column if not exists attempts int not null default 0,
+  add column if not exists next_attempt_at timestamptz not null default now(),
+  add column if not exists locked_at timestamptz,
+  add column if not exists expires_at timestamptz not null default now() + interval '5 minutes',
+  add column if not exists completed_at timestamptz;
+
+-- Commands left over from synchronous dispatch are not sent late.
+update commands set status='Expired', error=coalesce(error, 'not dispatched before the command outbox was introduced'),
+  completed_at=now()
+where status in ('Queued','Sent');
+
+create index if not exists idx_commands_outbox on commands(next_attempt_at, created_at)
+  where status in ('Queued','Sent');
+create index if not exists idx_commands_cp_open on commands(charge_point_id, created_at)
+  where status in ('Queued','Sent');
+
+
+-- Acked commands with an expected effect become Completed when a matching event arrives
+-- before effect_deadline, otherwise NoEffect.
+alter table commands
+  add column if not exists effect_event text,          -- TransactionStarted|TransactionEnded|ConnectorStatusChanged|ChargerBooted
+  add column if not exists effect_connector_id int,
+  add column if not exists effect_evse_id int,
+  add column if not exists effect_id_tag text,
+  add column if not exists effect_transaction_id text,
+  add column if not exists effect_statuses text[],      -- any of these connector statuses
+  add column if not exists effect_deadline timestamptz,
+  add column if not exists effect_event_id bigint references gateway_events(id) on delete set null,
+  add column if not exists effect_session_id uuid references sessions(session_id) on delete set null,
+  add column if not exists effect_at timestamptz;
+
+create index if not exists idx_commands_effect_open on commands(charge_point_id, effect_event, created_at)
+  where status in ('Sent','Acked','NoEffect') and effect_event is not null;
+create index if not exists idx_commands_effect_session on commands(effect_session_id)
+  where effect_session_id is not null;
+
+
+alter table chargers
+  add column if not exists tags text[] not null default '{}';
+create index if not exists idx_chargers_tags on chargers using gin (tags);
+
+-- Running -> Paused -> Running ... -> Completed | Aborted
+create table if not exists command_jobs (
+  job_id uuid primary key default uuid_generate_v4(),
+  tenant_id uuid references tenants(tenant_id) on delete cascade,  -- null = chargers of all tenants
+  type text not null,
+  payload jsonb not null,
+  selector jsonb not null,
+  status text not null default 'Running',
+  concurrency int not null,             -- commands of the job in flight at once
+  rate_per_minute int not null,         -- commands submitted per gateway and minute
+  created_by text,
+  created_at timestamptz not null default now(),
+  updated_at timestamptz not null default now(),
+  finished_at timestamptz
+);
+create index if not exists idx_command_jobs_created on command_jobs(created_at desc);
+
+-- Pending -> Running (command submitted) -> Succeeded | NoEffect | Failed; Skipped / Aborted without a command
+create table if not exists command_job_items (
+  job_id uuid not null references command_jobs(job_id) on delete cascade,
+  charge_point_id text not null references chargers(charge_point_id) on delete cascade,
+  status text not null default 'Pending',
+  command_id uuid references commands(command_id) on delete set null,
+  error text,
+  updated_at timestamptz not null default now(),
+  primary key (job_id, charge_point_id)
+);
+create index if not exists idx_command_job_items_open on command_job_items(job_id, status)
+  where status in ('Pending','Running');
+
+
+-- A charger ID is locked out only for the address that failed, so failures from elsewhere
+-- cannot lock a working charger out. Existing charger lockouts are dropped.
+delete from auth_lockouts where scope='Charger';
+alter table auth_lockouts add column if not exists remote_addr text not null default '';
+alter table auth_lockouts drop constraint if exists auth_lockouts_pkey;
+alter table auth_lockouts add primary key (scope, key, remote_addr);
+
+create index if not exists idx_charger_auth_attempts_created on charger_auth_attempts(created_at);
+
+
+-- A replay rebuilds sessions and gives the rebuilt ones back the ids of the sessions they
+-- replace; references to sessions follow the id.
+alter table meter_samples drop constraint if exists meter_samples_session_id_fkey;
+alter table meter_samples add constraint meter_samples_session_id_fkey
+  foreign key (session_id) references sessions(session_id) on delete cascade on update cascade;
+
+alter table settlements drop constraint if exists settlements_session_id_fkey;
+alter table settlements add constraint settlements_session_id_fkey
+  foreign key (session_id) references sessions(session_id) on delete cascade on update cascade;
+
+alter table commands drop constraint if exists commands_effect_session_id_fkey;
+alter table commands add constraint commands_effect_session_id_fkey
+  foreign key (effect_session_id) references sessions(session_id) on delete set null on update cascade;
+
+
+-- The key identifies the tenant on /v1/gateway routes, so it must be unique.
+create unique index if not exists uq_tenants_gateway_api_key on tenants(gateway_api_key)
+  where gateway_api_key is not null;
+
+
+-- Sinks that already received the pending notification; a sink failure only retries the
+-- sinks missing here. Reset when the alert is queued for notification again.
+alter table alerts
+  add column if not exists notified_sinks text[] not null default '{}';
+
+create index if not exists idx_alerts_pending_critical on alerts(id) where notify_pending and severity='Critical';
+
+
+-- Statuses the connector must leave for an event to count as the command's effect; such
+-- events only count once the command was acked.
+alter table commands
+  add column if not exists effect_from_statuses text[];
+
+
+-- A charger's clock may reset on reboot and MaxSkew clamps ts, so the epoch of an event is
+-- the number of ChargerBooted events of the charge point stored up to it (by id).
+create index if not exists idx_gateway_events_boots_id on gateway_events(charge_point_id, id) where event_type='ChargerBooted';
+
+update sessions s set boot_epoch=(
+  select count(*) from gateway_events e
+  where e.charge_point_id=s.charge_point_id and e.event_type='ChargerBooted'
+    and e.id <= coalesce(s.start_event_id, (select min(m.event_id) from meter_samples m where m.session_id=s.session_id))
+)
+where s.start_event_id is not null
+   or exists (select 1 from meter_samples m where m.session_id=s.session_id and m.event_id is not null);
+update orphan_events o set boot_epoch=(
+  select count(*) from gateway_events e
+  where e.charge_point_id=o.charge_point_id and e.event_type='ChargerBooted' and e.id<=o.gateway_event_id
+);
+
+
+-- A failed delivery is retried at notify_next_at with exponential backoff; after the last
+-- attempt the notification is dropped (notify_pending=false) and notify_failed_at is set.
+-- All four are reset when the alert is queued for notification again.
+alter table alerts
+  add column if not exists notify_attempts int not null default 0,
+  add column if not exists notify_next_at timestamptz,
+  add column if not exists notify_error text,
+  add column if not exists notify_failed_at timestamptz;
@@ -0,0 +1,149 @@
+// Package alerts stores operational alerts (offline chargers, faulted connectors)
+// and delivers them through pluggable sinks with de-duplication and quiet hours.
+package alerts
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"cpms/internal/models"
+	"cpms/internal/repo"
+)
+
+const (
+	SeverityWarning  = "Warning"
+	SeverityCritical = "Critical"
+)
+
+// Engine persists alerts on Fire/Resolve and delivers pending notifications from Run.
+// During quiet hours only Critical notifications are delivered; the rest wait until
+// quiet hours end.
+type Engine struct {
+	Alerts       *repo.AlertsRepo
+	Sinks        []Sink
+	DedupWindow  time.Duration
+	Quiet        QuietHours
+	PollInterval time.Duration
+	// A notification a sink fails to take is retried after RetryBase, doubling up to
+	// RetryMax, and dropped after MaxAttempts failed deliveries.
+	MaxAttempts int
+	RetryBase   time.Duration
+	RetryMax    time.Duration
+
+	wake chan struct{}
+}
+
+func NewEngine(a *repo.AlertsRepo, sinks []Sink, dedupWindow time.Duration, quiet QuietHours) *Engine {
+	return &Engine{
+		Alerts: a, Sinks: sinks, DedupWindow: dedupWindow, Quiet: quiet, PollInterval: 10 * time.Second,
+		MaxAttempts: 10, RetryBase: 30 * time.Second, RetryMax: time.Hour,
+		wake: make(chan struct{}, 1),
+	}
+}
+
+// Fire opens (or repeats) the alert identified by a.DedupKey. A repeat is notified
+// again only after DedupWindow.
+func (e *Engine) Fire(ctx context.Context, a models.Alert) error {
+	if _, err := e.Alerts.Fire(ctx, a, time.Now().Add(-e.DedupWindow)); err != nil {
+		return err
+	}
+	e.notify()
+	return nil
+}
+
+// Resolve closes the open alert with the key, if any.
+func (e *Engine) Resolve(ctx context.Context, dedupKey string) error {
+	resolved, err := e.Alerts.Resolve(ctx, dedupKey)
+	if err != nil {
+		return err
+	}
+	if resolved {
+		e.notify()
+	}
+	return nil
+}
+
+func (e *Engine) notify() {
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers pending notifications until ctx is cancelled.
+func (e *Engine) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.PollInterval)
+	defer ticker.Stop()
+	for {
+		e.deliver(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-e.wake:
+		}
+	}
+}
+
+// deliver sends due notifications to the sinks that did not receive them yet. A failing
+// sink is retried with backoff without resending to the others (see MarkDeliveryFailed).
+func (e *Engine) deliver(ctx context.Context) {
+	pending, err := e.Alerts.ListPendingNotifications(ctx, e.Quiet.Active(time.Now()), 100)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Println("alerts:", err)
+		}
+		return
+	}
+	for _, a := range pending {
+		var failed error
+		for _, s := range e.Sinks {
+			if slices.Contains(a.NotifiedSinks, s.Name()) {
+				continue
+			}
+			if err := s.Send(ctx, a); err != nil {
+				log.Printf("alerts: sink %s: alert %d (attempt %d): %v", s.Name(), a.Id, a.NotifyAttempts+1, err)
+				failed = fmt.Errorf("sink %s: %w", s.Name(), err)
+				continue
+			}
+			if err := e.Alerts.MarkSinkNotified(ctx, a.Id, s.Name()); err != nil {
+				log.Println("alerts:", err)
+				failed = err
+			}
+		}
+		if failed != nil {
+			e.failed(ctx, a, failed)
+			continue
+		}
+		if err := e.Alerts.MarkNotified(ctx, a.Id); err != nil {
+			log.Println("alerts:", err)
+		}
+	}
+}
+
+// failed schedules the next delivery of a notification, or drops it after MaxAttempts.
+func (e *Engine) failed(ctx context.Context, a models.Alert, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+	attempt := a.NotifyAttempts + 1
+	if attempt >= e.MaxAttempts {
+		log.Printf("alerts: alert %d: giving up after %d attempts", a.Id, attempt)
+	}
+	next := time.Now().Add(retryDelay(attempt, e.RetryBase, e.RetryMax))
+	if err := e.Alerts.MarkDeliveryFailed(ctx, a.Id, err.Error(), e.MaxAttempts, next); err != nil {
+		log.Println("alerts:", err)
+	}
+}
+
+// retryDelay returns base * 2^(attempt-1), capped at max.
+func retryDelay(attempt int, base, max time.Duration) time.Duration {
+	d := base
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	return min(d, max)
+}
@@ -0,0 +1,46 @@
+package alerts
+
+import (
+	"fmt"
+	"time"
+)
+
+// QuietHours is a daily time range in Loc, e.g. 22:00-07:00. The zero value is never active.
+type QuietHours struct {
+	Enabled bool
+	Start   int // minutes after midnight
+	End     int
+	Loc     *time.Location
+}
+
+// ParseQuietHours parses "HH:MM-HH:MM" in the IANA time zone tz. An empty spec disables quiet hours.
+func ParseQuietHours(spec, tz string) (QuietHours, error) {
+	if spec == "" {
+		return QuietHours{}, nil
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return QuietHours{}, err
+	}
+	var sh, sm, eh, em int
+	if _, err := fmt.Sscanf(spec, "%d:%d-%d:%d", &sh, &sm, &eh, &em); err != nil {
+		return QuietHours{}, fmt.Errorf("invalid quiet hours %q (want HH:MM-HH:MM)", spec)
+	}
+	if sh > 23 || eh > 23 || sm > 59 || em > 59 || sh < 0 || eh < 0 || sm < 0 || em < 0 {
+		return QuietHours{}, fmt.Errorf("invalid quiet hours %q", spec)
+	}
+	return QuietHours{Enabled: true, Start: sh*60 + sm, End: eh*60 + em, Loc: loc}, nil
+}
+
+// Active reports whether t falls into the quiet hours. Ranges may wrap midnight.
+func (q QuietHours) Active(t time.Time) bool {
+	if !q.Enabled || q.Start == q.End {
+		return false
+	}
+	lt := t.In(q.Loc)
+	m := lt.Hour()*60 + lt.Minute()
+	if q.Start < q.End {
+		return m >= q.Start && m < q.End
+	}
+	return m >= q.Start || m < q.End
+}
@@ -0,0 +1,198 @@
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"cpms/internal/models"
+)
+
+// Sink delivers alert notifications (firing or resolved, see Alert.Status).
+type Sink interface {
+	Name() string
+	Send(ctx context.Context, a models.Alert) error
+}
+
+type SinkConfig struct {
+	WebhookURL   string
+	SMTPAddr     string // host:port
+	SMTPFrom     string
+	SMTPTo       []string
+	SMTPUser     string
+	SMTPPassword string
+}
+
+// BuildSinks creates the sinks named in names (log, webhook, smtp).
+func BuildSinks(names []string, cfg SinkConfig) ([]Sink, error) {
+	var sinks []Sink
+	for _, n := range names {
+		switch n {
+		case "log":
+			sinks = append(sinks, LogSink{})
+		case "webhook":
+			if cfg.WebhookURL == "" {
+				return nil, fmt.Errorf("alert sink webhook: missing URL")
+			}
+			sinks = append(sinks, &WebhookSink{URL: cfg.WebhookURL, Client: &http.Client{Timeout: 10 * time.Second}})
+		case "smtp":
+			if cfg.SMTPAddr == "" || cfg.SMTPFrom == "" || len(cfg.SMTPTo) == 0 {
+				return nil, fmt.Errorf("alert sink smtp: missing addr/from/to")
+			}
+			sinks = append(sinks, &SMTPSink{Addr: cfg.SMTPAddr, From: cfg.SMTPFrom, To: cfg.SMTPTo, User: cfg.SMTPUser, Password: cfg.SMTPPassword})
+		default:
+			return nil, fmt.Errorf("unknown alert sink %q", n)
+		}
+	}
+	return sinks, nil
+}
+
+func subject(a models.Alert) string {
+	if a.Status == "Resolved" {
+		return fmt.Sprintf("[RESOLVED] %s %s", a.Kind, a.ChargePointId)
+	}
+	return fmt.Sprintf("[%s] %s %s", strings.ToUpper(a.Severity), a.Kind, a.ChargePointId)
+}
+
+type LogSink struct{}
+
+func (LogSink) Name() string { return "log" }
+
+func (LogSink) Send(ctx context.Context, a models.Alert) error {
+	log.Printf("alert: %s: %s (occurrences=%d)", subject(a), a.Message, a.Occurrences)
+	return nil
+}
+
+// WebhookSink POSTs the alert as JSON; any non-2xx response is an error.
+type WebhookSink struct {
+	URL    string
+	Client *http.Client
+}
+
+func (s *WebhookSink) Name() string { return "webhook" }
+
+func (s *WebhookSink) Send(ctx context.Context, a models.Alert) error {
+	body, err := json.Marshal(map[string]any{
+		"alertId":       a.Id,
+		"dedupKey":      a.DedupKey,
+		"chargePointId": a.ChargePointId,
+		"connectorId":   a.ConnectorId,
+		"kind":          a.Kind,
+		"severity":      a.Severity,
+		"status":        a.Status,
+		"message":       a.Message,
+		"occurrences":   a.Occurrences,
+		"firstAt":       a.FirstAt,
+		"lastAt":        a.LastAt,
+		"resolvedAt":    a.ResolvedAt,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// SMTPSink sends a plain text mail per notification. User/Password enable PLAIN auth.
+// The whole exchange is bounded by Timeout (default 30s) and by ctx.
+type SMTPSink struct {
+	Addr     string
+	From     string
+	To       []string
+	User     string
+	Password string
+	Timeout  time.Duration
+}
+
+func (s *SMTPSink) Name() string { return "smtp" }
+
+func (s *SMTPSink) Send(ctx context.Context, a models.Alert) error {
+	host := s.Addr
+	if i := strings.LastIndex(host, ":"); i >= 0 {
+		host = host[:i]
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", s.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(s.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject(a))
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&msg, "%s\r\n\r\nCharge point: %s\r\nSeverity: %s\r\nStatus: %s\r\nFirst seen: %s\r\nLast seen: %s\r\nOccurrences: %d\r\n",
+		a.Message, a.ChargePointId, a.Severity, a.Status, a.FirstAt.Format(time.RFC3339), a.LastAt.Format(time.RFC3339), a.Occurrences)
+
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	// smtp.Client has no context: the deadline bounds every read and write, and a
+	// cancelled ctx closes the connection.
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if s.User != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.User, s.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(s.From); err != nil {
+		return err
+	}
+	for _, to := range s.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	wc, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := wc.Write(msg.Bytes()); err != nil {
+		return err
+	}
+	if err := wc.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
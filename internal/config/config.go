@@ -2,6 +2,8 @@ package config
 
 import (
 	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -13,8 +15,90 @@ type Config struct {
 	GatewayBaseURL string
 	GatewayAPIKey  string
 
+	// Command outbox: workers deliver queued commands until their deadline (TTL by default)
+	CommandWorkers      int
+	CommandPollInterval time.Duration
+	CommandTTL          time.Duration
+	CommandRetryBase    time.Duration
+	CommandRetryMax     time.Duration
+	// CommandEffectWindow is how long an acked command waits for the event showing its
+	// effect (e.g. TransactionStarted after RemoteStartTransaction) before it is NoEffect.
+	CommandEffectWindow time.Duration
+
+	// Bulk command jobs: defaults for jobs that do not set their own limits
+	CommandJobConcurrency   int // commands of a job in flight at once
+	CommandJobRatePerMinute int // commands of a job per gateway and minute
+	// Commands per gateway and minute for all jobs together; other commands count against it (0 = off)
+	GatewayRatePerMinute int
+
 	// Ingestion hardening
 	MaxEventSkew time.Duration
+
+	// Async event processing (gateway_events queue)
+	EventWorkers      int
+	EventPollInterval time.Duration
+	EventMaxAttempts  int
+	EventRetryBase    time.Duration
+	EventRetryMax     time.Duration
+
+	// Events arriving before their TransactionStarted
+	OrphanTimeout      time.Duration
+	OrphanReapInterval time.Duration
+
+	// Sessions whose TransactionEnded never arrives
+	StaleSessionAfter   time.Duration
+	StaleAvailableGrace time.Duration
+	StaleSweepInterval  time.Duration
+
+	// Availability reporting: offline = no event for HeartbeatInterval*OfflineMissed
+	AvailabilityHeartbeatInterval   time.Duration
+	AvailabilityOfflineMissed       int
+	AvailabilityUnavailableStatuses []string
+	AvailabilityTarget              float64
+
+	// Connectivity monitor: Degraded/Offline after N missed heartbeats
+	HeartbeatInterval          time.Duration
+	ConnectivityDegradedMissed int
+	ConnectivityOfflineMissed  int
+	ConnectivityCheckInterval  time.Duration
+
+	// Charger authentication: successful secret verifications are cached this long
+	AuthCacheTTL time.Duration
+	// Lockout after N consecutive failed auth attempts per charger / per remote IP (0 = off),
+	// starting at AuthLockoutBase and doubling up to AuthLockoutMax
+	AuthLockoutThreshold   int
+	AuthIPLockoutThreshold int
+	AuthLockoutBase        time.Duration
+	AuthLockoutMax         time.Duration
+	// Auth attempts older than this are deleted (0 = kept forever)
+	AuthAttemptRetention time.Duration
+
+	// Management API auth: API keys and/or JWT (HS256 secret, RS256 keys from a JWKS file)
+	APIAuth        bool // CPMS_API_AUTH=off disables it (local development only)
+	JWTHS256Secret string
+	JWTJWKSFile    string
+	JWTIssuer      string
+	JWTAudience    string
+	JWTRolesClaim  string
+	JWTTenantClaim string
+	// JWTPlatformRole is the role that makes a token without a tenant claim platform-wide;
+	// other tokens without the claim are rejected.
+	JWTPlatformRole string
+
+	// Alert delivery
+	AlertSinks        []string // log, webhook, smtp
+	AlertWebhookURL   string
+	AlertSMTPAddr     string
+	AlertSMTPFrom     string
+	AlertSMTPTo       []string
+	AlertSMTPUser     string
+	AlertSMTPPassword string
+	AlertDedupWindow  time.Duration
+	AlertQuietHours   string // e.g. 22:00-07:00
+	AlertTimezone     string
+	AlertMaxAttempts  int
+	AlertRetryBase    time.Duration
+	AlertRetryMax     time.Duration
 }
 
 func Load() Config {
@@ -24,6 +108,70 @@ func Load() Config {
 		GatewayBaseURL: getenv("GATEWAY_BASE_URL", "http://localhost:8080"),
 		GatewayAPIKey:  getenv("GATEWAY_API_KEY", ""),
 		MaxEventSkew:   parseDuration(getenv("CPMS_MAX_EVENT_SKEW", "0s")),
+
+		CommandWorkers:      parseInt(getenv("CPMS_COMMAND_WORKERS", "2")),
+		CommandPollInterval: parseDuration(getenv("CPMS_COMMAND_POLL_INTERVAL", "1s")),
+		CommandTTL:          parseDuration(getenv("CPMS_COMMAND_TTL", "5m")),
+		CommandRetryBase:    parseDuration(getenv("CPMS_COMMAND_RETRY_BASE", "2s")),
+		CommandRetryMax:     parseDuration(getenv("CPMS_COMMAND_RETRY_MAX", "1m")),
+		CommandEffectWindow: parseDuration(getenv("CPMS_COMMAND_EFFECT_WINDOW", "2m")),
+
+		CommandJobConcurrency:   parseInt(getenv("CPMS_COMMAND_JOB_CONCURRENCY", "10")),
+		CommandJobRatePerMinute: parseInt(getenv("CPMS_COMMAND_JOB_RATE_PER_MINUTE", "60")),
+		GatewayRatePerMinute:    parseInt(getenv("CPMS_GATEWAY_RATE_PER_MINUTE", "60")),
+
+		EventWorkers:      parseInt(getenv("CPMS_EVENT_WORKERS", "4")),
+		EventPollInterval: parseDuration(getenv("CPMS_EVENT_POLL_INTERVAL", "1s")),
+		EventMaxAttempts:  parseInt(getenv("CPMS_EVENT_MAX_ATTEMPTS", "10")),
+		EventRetryBase:    parseDuration(getenv("CPMS_EVENT_RETRY_BASE", "2s")),
+		EventRetryMax:     parseDuration(getenv("CPMS_EVENT_RETRY_MAX", "5m")),
+
+		OrphanTimeout:      parseDuration(getenv("CPMS_ORPHAN_TIMEOUT", "15m")),
+		OrphanReapInterval: parseDuration(getenv("CPMS_ORPHAN_REAP_INTERVAL", "1m")),
+
+		StaleSessionAfter:   parseDuration(getenv("CPMS_STALE_SESSION_AFTER", "12h")),
+		StaleAvailableGrace: parseDuration(getenv("CPMS_STALE_AVAILABLE_GRACE", "10m")),
+		StaleSweepInterval:  parseDuration(getenv("CPMS_STALE_SWEEP_INTERVAL", "5m")),
+
+		AvailabilityHeartbeatInterval:   parseDuration(getenv("CPMS_AVAILABILITY_HEARTBEAT_INTERVAL", "5m")),
+		AvailabilityOfflineMissed:       parseInt(getenv("CPMS_AVAILABILITY_OFFLINE_MISSED", "3")),
+		AvailabilityUnavailableStatuses: parseList(getenv("CPMS_AVAILABILITY_UNAVAILABLE_STATUSES", "Faulted,Unavailable")),
+		AvailabilityTarget:              parseFloat(getenv("CPMS_AVAILABILITY_TARGET", "97")),
+
+		HeartbeatInterval:          parseDuration(getenv("CPMS_HEARTBEAT_INTERVAL", "5m")),
+		ConnectivityDegradedMissed: parseInt(getenv("CPMS_CONNECTIVITY_DEGRADED_MISSED", "2")),
+		ConnectivityOfflineMissed:  parseInt(getenv("CPMS_CONNECTIVITY_OFFLINE_MISSED", "3")),
+		ConnectivityCheckInterval:  parseDuration(getenv("CPMS_CONNECTIVITY_CHECK_INTERVAL", "30s")),
+
+		AuthCacheTTL:           parseDuration(getenv("CPMS_AUTH_CACHE_TTL", "60s")),
+		AuthLockoutThreshold:   parseInt(getenv("CPMS_AUTH_LOCKOUT_THRESHOLD", "5")),
+		AuthIPLockoutThreshold: parseInt(getenv("CPMS_AUTH_IP_LOCKOUT_THRESHOLD", "20")),
+		AuthLockoutBase:        parseDuration(getenv("CPMS_AUTH_LOCKOUT_BASE", "30s")),
+		AuthLockoutMax:         parseDuration(getenv("CPMS_AUTH_LOCKOUT_MAX", "1h")),
+		AuthAttemptRetention:   parseDuration(getenv("CPMS_AUTH_ATTEMPT_RETENTION", "720h")),
+
+		APIAuth:         getenv("CPMS_API_AUTH", "on") != "off",
+		JWTHS256Secret:  getenv("CPMS_JWT_HS256_SECRET", ""),
+		JWTJWKSFile:     getenv("CPMS_JWT_JWKS_FILE", ""),
+		JWTIssuer:       getenv("CPMS_JWT_ISSUER", ""),
+		JWTAudience:     getenv("CPMS_JWT_AUDIENCE", ""),
+		JWTRolesClaim:   getenv("CPMS_JWT_ROLES_CLAIM", "roles"),
+		JWTTenantClaim:  getenv("CPMS_JWT_TENANT_CLAIM", "tenant"),
+		JWTPlatformRole: getenv("CPMS_JWT_PLATFORM_ROLE", "platform"),
+
+		AlertSinks:        parseList(getenv("CPMS_ALERT_SINKS", "log")),
+		AlertWebhookURL:   getenv("CPMS_ALERT_WEBHOOK_URL", ""),
+		AlertSMTPAddr:     getenv("CPMS_ALERT_SMTP_ADDR", ""),
+		AlertSMTPFrom:     getenv("CPMS_ALERT_SMTP_FROM", ""),
+		AlertSMTPTo:       parseList(getenv("CPMS_ALERT_SMTP_TO", "")),
+		AlertSMTPUser:     getenv("CPMS_ALERT_SMTP_USER", ""),
+		AlertSMTPPassword: getenv("CPMS_ALERT_SMTP_PASSWORD", ""),
+		AlertDedupWindow:  parseDuration(getenv("CPMS_ALERT_DEDUP_WINDOW", "1h")),
+		AlertQuietHours:   getenv("CPMS_ALERT_QUIET_HOURS", ""),
+		AlertTimezone:     getenv("CPMS_ALERT_TIMEZONE", "UTC"),
+		AlertMaxAttempts:  parseInt(getenv("CPMS_ALERT_MAX_ATTEMPTS", "10")),
+		AlertRetryBase:    parseDuration(getenv("CPMS_ALERT_RETRY_BASE", "30s")),
+		AlertRetryMax:     parseDuration(getenv("CPMS_ALERT_RETRY_MAX", "1h")),
 	}
 }
 
@@ -41,3 +189,29 @@ func parseDuration(s string) time.Duration {
 	}
 	return d
 }
+
+func parseFloat(s string) float64 {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0
+	}
+	return f
+}
+
+func parseList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func parseInt(s string) int {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0
+	}
+	return n
+}
@@ -0,0 +1,86 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"cpms/internal/models"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func alertView(a models.Alert) map[string]any {
+	return map[string]any{
+		"alertId":        a.Id,
+		"dedupKey":       a.DedupKey,
+		"chargePointId":  a.ChargePointId,
+		"connectorId":    a.ConnectorId,
+		"kind":           a.Kind,
+		"severity":       a.Severity,
+		"message":        a.Message,
+		"status":         a.Status,
+		"occurrences":    a.Occurrences,
+		"firstAt":        a.FirstAt,
+		"lastAt":         a.LastAt,
+		"resolvedAt":     a.ResolvedAt,
+		"notifyPending":  a.NotifyPending,
+		"notifiedAt":     a.NotifiedAt,
+		"notifiedSinks":  a.NotifiedSinks,
+		"notifyAttempts": a.NotifyAttempts,
+		"notifyNextAt":   a.NotifyNextAt,
+		"notifyError":    a.NotifyError,
+		"notifyFailedAt": a.NotifyFailedAt,
+	}
+}
+
+// GET /v1/alerts?status=Open&chargePointId=CP-123&limit=50
+func (s *Server) ListAlerts(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit := 50
+	if v := q.Get("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			limit = n
+		}
+	}
+	items, err := s.Alerts.List(r.Context(), tenantOf(r), q.Get("status"), q.Get("chargePointId"), limit)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	out := make([]map[string]any, 0, len(items))
+	for _, a := range items {
+		out = append(out, alertView(a))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"items": out})
+}
+
+// GET /v1/chargers/{chargePointId}/connectivity-events?limit=50
+// Online/Degraded/Offline transitions, newest first.
+func (s *Server) ListConnectivityEvents(w http.ResponseWriter, r *http.Request) {
+	cp := chi.URLParam(r, "chargePointId")
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			limit = n
+		}
+	}
+	items, err := s.Chargers.ListStateEvents(r.Context(), cp, limit)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	out := make([]map[string]any, 0, len(items))
+	for _, e := range items {
+		out = append(out, map[string]any{
+			"id":         e.Id,
+			"fromState":  e.FromState,
+			"toState":    e.ToState,
+			"lastSeenAt": e.LastSeenAt,
+			"createdAt":  e.CreatedAt,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"items": out})
+}
@@ -0,0 +1,135 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"cpms/internal/services"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// GET /v1/api-keys
+// Management API keys, newest first (keys are never returned, only their prefix).
+func (s *Server) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	items, err := s.Auth.Keys.List(r.Context(), tenantOf(r))
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	out := make([]map[string]any, 0, len(items))
+	for _, k := range items {
+		out = append(out, map[string]any{
+			"keyId":      k.KeyId,
+			"tenantId":   k.TenantId,
+			"name":       k.Name,
+			"keyPrefix":  k.KeyPrefix,
+			"roles":      k.Roles,
+			"createdBy":  k.CreatedBy,
+			"createdAt":  k.CreatedAt,
+			"lastUsedAt": k.LastUsedAt,
+			"revokedAt":  k.RevokedAt,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"items": out})
+}
+
+type createAPIKeyReq struct {
+	Name  string   `json:"name"`
+	Roles []string `json:"roles"` // viewer, operator, finance, admin
+	// TenantId scopes the key to a tenant; platform clients may omit it for a
+	// platform-wide key, tenant clients only create keys of their own tenant.
+	TenantId string `json:"tenantId,omitempty"`
+}
+
+// POST /v1/api-keys
+// The key is returned only in this response.
+func (s *Server) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req createAPIKeyReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" || len(req.Roles) == 0 {
+		http.Error(w, "invalid json/name/roles", http.StatusBadRequest)
+		return
+	}
+	for _, role := range req.Roles {
+		if !services.ValidRole(role) {
+			http.Error(w, "unknown role "+role, http.StatusBadRequest)
+			return
+		}
+	}
+	var tenantId *string
+	if req.TenantId != "" || tenantOf(r) != "" {
+		t, ok := s.targetTenant(w, r, req.TenantId)
+		if !ok {
+			return
+		}
+		tenantId = &t
+	}
+	id, key, err := s.Auth.CreateKey(r.Context(), req.Name, req.Roles, tenantId, actorOf(r))
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(map[string]any{"keyId": id, "tenantId": tenantId, "name": req.Name, "roles": req.Roles, "key": key})
+}
+
+// POST /v1/api-keys/{keyId}/revoke
+func (s *Server) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	done, err := s.Auth.Keys.Revoke(r.Context(), chi.URLParam(r, "keyId"))
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if !done {
+		http.NotFound(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET /v1/audit-log?actor=&before=RFC3339&limit=100
+// Mutating management API requests, newest first.
+func (s *Server) ListAuditLog(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var before *time.Time
+	if v := q.Get("before"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "invalid before", http.StatusBadRequest)
+			return
+		}
+		before = &t
+	}
+	limit := 100
+	if v := q.Get("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			limit = n
+		}
+	}
+	items, err := s.Audit.List(r.Context(), tenantOf(r), q.Get("actor"), before, limit)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	out := make([]map[string]any, 0, len(items))
+	for _, e := range items {
+		out = append(out, map[string]any{
+			"id":         e.Id,
+			"tenantId":   e.TenantId,
+			"actor":      e.Actor,
+			"authMethod": e.AuthMethod,
+			"method":     e.HTTPMethod,
+			"path":       e.Path,
+			"route":      e.Route,
+			"status":     e.Status,
+			"remoteAddr": e.RemoteAddr,
+			"createdAt":  e.CreatedAt,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"items": out})
+}
@@ -0,0 +1,229 @@
+package httpapi
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"cpms/internal/models"
+	"cpms/internal/repo"
+	"cpms/internal/services"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Permissions required by management API routes.
+type permission string
+
+const (
+	permRead    permission = "read"    // all GET endpoints
+	permOperate permission = "operate" // chargers, commands, registrations, sessions, maintenance
+	permFinance permission = "finance" // tariffs, wallets, settlement transitions
+	permAdmin   permission = "admin"   // replay, API keys, audit log
+)
+
+// rolePermissions maps roles to permissions; admin has all of them.
+var rolePermissions = map[string][]permission{
+	services.RoleViewer:   {permRead},
+	services.RoleOperator: {permRead, permOperate},
+	services.RoleFinance:  {permRead, permFinance},
+	services.RoleAdmin:    {permRead, permOperate, permFinance, permAdmin},
+}
+
+func hasPermission(id *services.Identity, p permission) bool {
+	for _, role := range id.Roles {
+		for _, rp := range rolePermissions[role] {
+			if rp == p {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type identityKey struct{}
+
+// identityOf returns the authenticated client, or nil when API auth is off.
+func identityOf(r *http.Request) *services.Identity {
+	id, _ := r.Context().Value(identityKey{}).(*services.Identity)
+	return id
+}
+
+// tenantOf returns the caller's tenant; "" for platform-wide clients and when API auth is off.
+func tenantOf(r *http.Request) string {
+	if id := identityOf(r); id != nil {
+		return id.TenantId
+	}
+	return ""
+}
+
+// actorOf returns who performs an action, for audit trails: the authenticated identity,
+// or the X-Actor header when API auth is off.
+func actorOf(r *http.Request) string {
+	if id := identityOf(r); id != nil {
+		return id.Subject
+	}
+	return strings.TrimSpace(r.Header.Get("X-Actor"))
+}
+
+// bearerToken reads "Authorization: Bearer <token>" or "X-API-Key: <key>".
+func bearerToken(r *http.Request) string {
+	if k := r.Header.Get("X-API-Key"); k != "" {
+		return strings.TrimSpace(k)
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	return ""
+}
+
+// authenticate resolves the client identity; requests without a valid token get 401.
+// With API auth off (CPMS_API_AUTH=off) requests pass without identity.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.Auth == nil || !s.Cfg.APIAuth {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token := bearerToken(r)
+		if token == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="cpms"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		id, err := s.Auth.Authenticate(r.Context(), token)
+		if err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		if id == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="cpms", error="invalid_token"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, id)))
+	})
+}
+
+// require rejects clients whose roles lack permission p (403).
+func (s *Server) require(p permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if id := identityOf(r); id != nil && !hasPermission(id, p) {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requirePlatform rejects tenant-scoped clients (403).
+func (s *Server) requirePlatform(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tenantOf(r) != "" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// scopedParams maps route and query parameters to the resource kinds they name.
+var scopedParams = map[string]string{
+	"chargePointId": repo.ResCharger,
+	"siteId":        repo.ResSite,
+	"sessionId":     repo.ResSession,
+	"settlementId":  repo.ResSettlement,
+	"eventId":       repo.ResEvent,
+	"deadLetterId":  repo.ResDeadLetter,
+	"maintenanceId": repo.ResMaintenance,
+	"keyId":         repo.ResAPIKey,
+	"tenantId":      repo.ResTenant,
+	"commandId":     repo.ResCommand,
+	"jobId":         repo.ResCommandJob,
+}
+
+// scopeTenant answers 404 when a tenant-scoped client names a resource of another tenant,
+// in the route or in the chargePointId/siteId query parameters. Request bodies are
+// checked by the handlers (see owns).
+func (s *Server) scopeTenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tenantOf(r) == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if rc := chi.RouteContext(r.Context()); rc != nil {
+			for i, key := range rc.URLParams.Keys {
+				if kind, ok := scopedParams[key]; ok && !s.owns(w, r, kind, rc.URLParams.Values[i]) {
+					return
+				}
+			}
+		}
+		q := r.URL.Query()
+		for _, key := range []string{"chargePointId", "siteId"} {
+			if v := q.Get(key); v != "" && !s.owns(w, r, scopedParams[key], v) {
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// owns reports whether the caller may access the resource: always for platform clients,
+// otherwise only resources of the caller's tenant. It writes 404 (or 500) itself.
+func (s *Server) owns(w http.ResponseWriter, r *http.Request, kind, id string) bool {
+	tenant := tenantOf(r)
+	if tenant == "" {
+		return true
+	}
+	ok, err := s.Tenants.Owns(r.Context(), kind, id, tenant)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return false
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return false
+	}
+	return true
+}
+
+// auditRequests records mutating requests (anything but GET/HEAD/OPTIONS) with the acting identity.
+func (s *Server) auditRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.Audit == nil || r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		e := models.APIAuditEntry{
+			Actor:      actorOf(r),
+			HTTPMethod: r.Method,
+			Path:       r.URL.Path,
+			Status:     ww.Status(),
+			RemoteAddr: r.RemoteAddr,
+			CreatedAt:  time.Now().UTC(),
+		}
+		if id := identityOf(r); id != nil {
+			e.AuthMethod = id.Method
+			if id.TenantId != "" {
+				e.TenantId = &id.TenantId
+			}
+		}
+		if rc := chi.RouteContext(r.Context()); rc != nil {
+			e.Route = rc.RoutePattern()
+		}
+		if e.Status == 0 {
+			e.Status = http.StatusOK
+		}
+		if err := s.Audit.Record(context.WithoutCancel(r.Context()), e); err != nil {
+			log.Printf("api audit: %v", err)
+		}
+	})
+}
@@ -0,0 +1,236 @@
+package httpapi
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"cpms/internal/models"
+	"cpms/internal/repo"
+	"cpms/internal/services"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// GET /v1/reports/availability?from=&to=&siteId=&chargePointId=&format=csv
+// Optional rule overrides: offlineAfter (duration, e.g. 15m), unavailableStatuses (comma separated), target (percent).
+func (s *Server) AvailabilityReport(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	from, to, ok := queryWindow(r)
+	if !ok {
+		http.Error(w, "invalid from/to", http.StatusBadRequest)
+		return
+	}
+	rules := s.Availability.Rules
+	if v := q.Get("offlineAfter"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			http.Error(w, "invalid offlineAfter", http.StatusBadRequest)
+			return
+		}
+		rules.OfflineAfter = d
+	}
+	if v := q.Get("unavailableStatuses"); v != "" {
+		rules.UnavailableStatuses = strings.Split(v, ",")
+	}
+	if v := q.Get("target"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			http.Error(w, "invalid target", http.StatusBadRequest)
+			return
+		}
+		rules.TargetPercent = f
+	}
+
+	report, err := s.Availability.Report(r.Context(), services.AvailabilityRequest{
+		From:          from,
+		To:            to,
+		SiteId:        q.Get("siteId"),
+		ChargePointId: q.Get("chargePointId"),
+		TenantId:      tenantOf(r),
+	}, rules)
+	if err != nil {
+		http.Error(w, "report error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if q.Get("format") == "csv" || strings.Contains(r.Header.Get("Accept"), "text/csv") {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="availability_%s_%s.csv"`, from.Format("20060102"), to.Format("20060102")))
+		writeAvailabilityCSV(w, report)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(report)
+}
+
+// writeAvailabilityCSV writes one row per charger, per site and for the fleet.
+func writeAvailabilityCSV(w http.ResponseWriter, report *services.AvailabilityReport) {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"scope", "id", "siteId", "from", "to", "eligibleSeconds", "availableSeconds", "offlineSeconds", "unavailableSeconds", "maintenanceSeconds", "availabilityPct", "targetPct", "meetsTarget"})
+	row := func(scope, id, siteId string, f services.AvailabilityFigures) {
+		_ = cw.Write([]string{
+			scope, id, siteId,
+			report.From.Format(time.RFC3339), report.To.Format(time.RFC3339),
+			strconv.FormatInt(f.EligibleSeconds, 10),
+			strconv.FormatInt(f.AvailableSeconds, 10),
+			strconv.FormatInt(f.OfflineSeconds, 10),
+			strconv.FormatInt(f.UnavailableSeconds, 10),
+			strconv.FormatInt(f.MaintenanceSeconds, 10),
+			strconv.FormatFloat(f.AvailabilityPct, 'f', 3, 64),
+			strconv.FormatFloat(report.TargetPercent, 'f', 2, 64),
+			strconv.FormatBool(f.MeetsTarget),
+		})
+	}
+	for _, c := range report.Chargers {
+		site := ""
+		if c.SiteId != nil {
+			site = *c.SiteId
+		}
+		row("charger", c.ChargePointId, site, c.AvailabilityFigures)
+	}
+	for _, st := range report.Sites {
+		row("site", st.SiteId, st.SiteId, st.AvailabilityFigures)
+	}
+	row("fleet", "", "", report.Fleet)
+	cw.Flush()
+}
+
+type maintenanceReq struct {
+	ChargePointId *string   `json:"chargePointId"`
+	SiteId        *string   `json:"siteId"`
+	StartsAt      time.Time `json:"startsAt"`
+	EndsAt        time.Time `json:"endsAt"`
+	Reason        string    `json:"reason"`
+}
+
+func maintenanceView(m models.MaintenanceWindow) map[string]any {
+	return map[string]any{
+		"maintenanceId": m.MaintenanceId,
+		"chargePointId": m.ChargePointId,
+		"siteId":        m.SiteId,
+		"tenantId":      m.TenantId,
+		"startsAt":      m.StartsAt,
+		"endsAt":        m.EndsAt,
+		"reason":        m.Reason,
+		"createdAt":     m.CreatedAt,
+	}
+}
+
+// POST /v1/maintenance-windows
+// Scope: chargePointId, siteId, or neither for the whole fleet. A window belongs to the
+// tenant of its charger or site; a fleet-wide window covers the caller's tenant, or all
+// tenants when created by a platform client.
+func (s *Server) CreateMaintenanceWindow(w http.ResponseWriter, r *http.Request) {
+	var req maintenanceReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if req.StartsAt.IsZero() || !req.EndsAt.After(req.StartsAt) {
+		http.Error(w, "startsAt/endsAt required, endsAt after startsAt", http.StatusBadRequest)
+		return
+	}
+	if req.ChargePointId != nil && *req.ChargePointId == "" {
+		req.ChargePointId = nil
+	}
+	if req.SiteId != nil && *req.SiteId == "" {
+		req.SiteId = nil
+	}
+	var tenantId *string
+	if t := tenantOf(r); t != "" {
+		tenantId = &t
+	}
+	if req.ChargePointId != nil {
+		if !s.owns(w, r, repo.ResCharger, *req.ChargePointId) {
+			return
+		}
+		t, err := s.Tenants.ForCharger(r.Context(), *req.ChargePointId)
+		if err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		if t != nil {
+			tenantId = &t.TenantId
+		}
+	} else if req.SiteId != nil {
+		if !s.owns(w, r, repo.ResSite, *req.SiteId) {
+			return
+		}
+		t, err := s.Tenants.ForSite(r.Context(), *req.SiteId)
+		if err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		if t != nil {
+			tenantId = &t.TenantId
+		}
+	}
+	id, err := s.Maintenance.Create(r.Context(), models.MaintenanceWindow{
+		TenantId:      tenantId,
+		ChargePointId: req.ChargePointId,
+		SiteId:        req.SiteId,
+		StartsAt:      req.StartsAt.UTC(),
+		EndsAt:        req.EndsAt.UTC(),
+		Reason:        req.Reason,
+	})
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"maintenanceId": id})
+}
+
+// GET /v1/maintenance-windows?chargePointId=&siteId=&from=&to=
+// Windows overlapping [from, to) (default: last 30 days).
+func (s *Server) ListMaintenanceWindows(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	from, err := queryTime(r, "from")
+	if err != nil {
+		http.Error(w, "invalid from", http.StatusBadRequest)
+		return
+	}
+	to, err := queryTime(r, "to")
+	if err != nil {
+		http.Error(w, "invalid to", http.StatusBadRequest)
+		return
+	}
+	if from == nil {
+		f := time.Now().UTC().AddDate(0, 0, -30)
+		from = &f
+	}
+	if to == nil {
+		t := time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+		to = &t
+	}
+	items, err := s.Maintenance.ListOverlapping(r.Context(), tenantOf(r), q.Get("chargePointId"), q.Get("siteId"), *from, *to)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	out := make([]map[string]any, 0, len(items))
+	for _, m := range items {
+		out = append(out, maintenanceView(m))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"items": out})
+}
+
+// DELETE /v1/maintenance-windows/{maintenanceId}
+func (s *Server) DeleteMaintenanceWindow(w http.ResponseWriter, r *http.Request) {
+	ok, err := s.Maintenance.Delete(r.Context(), chi.URLParam(r, "maintenanceId"))
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,113 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"cpms/internal/models"
+	"cpms/internal/security"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func validSecurityProfile(p int) bool { return p >= 1 && p <= 3 }
+
+// GET /v1/chargers/{chargePointId}/certificates
+// Client certificates trusted for the charger (security profile 3).
+func (s *Server) ListCertTrust(w http.ResponseWriter, r *http.Request) {
+	cp := chi.URLParam(r, "chargePointId")
+	items, err := s.Credentials.CertTrust.List(r.Context(), cp)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	out := make([]map[string]any, 0, len(items))
+	for _, t := range items {
+		out = append(out, map[string]any{
+			"trustId":     t.TrustId,
+			"fingerprint": t.Fingerprint,
+			"caPem":       t.CAPEM,
+			"expectedCn":  t.ExpectedCN,
+			"label":       t.Label,
+			"createdAt":   t.CreatedAt,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"items": out})
+}
+
+type certTrustReq struct {
+	Fingerprint string `json:"fingerprint,omitempty"` // SHA-256 of the certificate, hex
+	CAPEM       string `json:"caPem,omitempty"`
+	ExpectedCN  string `json:"expectedCn,omitempty"` // default: the chargePointId
+	Label       string `json:"label,omitempty"`
+}
+
+// POST /v1/chargers/{chargePointId}/certificates
+// Pins a certificate fingerprint, or trusts certificates issued by caPem whose subject CN
+// is expectedCn. Exactly one of fingerprint and caPem is required.
+func (s *Server) AddCertTrust(w http.ResponseWriter, r *http.Request) {
+	cp := chi.URLParam(r, "chargePointId")
+	var req certTrustReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if (req.Fingerprint == "") == (req.CAPEM == "") {
+		http.Error(w, "fingerprint or caPem required", http.StatusBadRequest)
+		return
+	}
+
+	t := models.CertTrust{ChargePointId: cp, Label: req.Label}
+	if req.Fingerprint != "" {
+		fp, ok := security.NormalizeFingerprint(req.Fingerprint)
+		if !ok {
+			http.Error(w, "invalid fingerprint (SHA-256 hex expected)", http.StatusBadRequest)
+			return
+		}
+		t.Fingerprint = &fp
+	} else {
+		if _, err := security.ParseCertificatesPEM(req.CAPEM); err != nil {
+			http.Error(w, "invalid caPem", http.StatusBadRequest)
+			return
+		}
+		t.CAPEM = &req.CAPEM
+		if req.ExpectedCN != "" {
+			t.ExpectedCN = &req.ExpectedCN
+		}
+	}
+
+	ch, err := s.Chargers.Get(r.Context(), cp)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if ch == nil {
+		http.NotFound(w, r)
+		return
+	}
+	id, err := s.Credentials.CertTrust.Add(r.Context(), t)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(map[string]any{"trustId": id})
+}
+
+// DELETE /v1/chargers/{chargePointId}/certificates/{trustId}
+func (s *Server) DeleteCertTrust(w http.ResponseWriter, r *http.Request) {
+	cp := chi.URLParam(r, "chargePointId")
+	id := chi.URLParam(r, "trustId")
+	done, err := s.Credentials.CertTrust.Delete(r.Context(), cp, id)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if !done {
+		http.NotFound(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
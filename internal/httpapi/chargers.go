@@ -0,0 +1,334 @@
+package httpapi
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"cpms/internal/models"
+	"cpms/internal/repo"
+	"cpms/internal/security"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func chargerView(ch models.Charger) map[string]any {
+	return map[string]any{
+		"chargePointId": ch.ChargePointId,
+		"isActive":      ch.IsActive,
+		"vendor":        ch.Vendor,
+		"model":         ch.Model,
+		"ocppVersion":   ch.OcppVersion,
+		"siteId":        ch.SiteId,
+		"tenantId":      ch.TenantId,
+		"lastSeenAt":    ch.LastSeenAt,
+		"createdAt":     ch.CreatedAt,
+		"updatedAt":     ch.UpdatedAt,
+
+		"heartbeatIntervalSeconds": ch.HeartbeatIntervalSeconds,
+		"connectivityState":        ch.ConnectivityState,
+		"connectivityChangedAt":    ch.ConnectivityChangedAt,
+		"registrationStatus":       ch.RegistrationStatus,
+		"registrationChangedAt":    ch.RegistrationChangedAt,
+		"securityProfile":          ch.SecurityProfile,
+		"tags":                     ch.Tags,
+	}
+}
+
+// GET /v1/chargers?siteId=&status=&active=&vendor=&connectivity=&tag=&q=&limit=50&cursor=
+// Ordered by chargePointId; pass nextCursor back as cursor for the next page. tag may be
+// repeated; chargers must have all given tags.
+func (s *Server) ListChargers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := repo.ChargerFilter{
+		TenantId:           tenantOf(r),
+		SiteId:             q.Get("siteId"),
+		RegistrationStatus: q.Get("status"),
+		Vendor:             q.Get("vendor"),
+		ConnectivityState:  q.Get("connectivity"),
+		Search:             q.Get("q"),
+		Tags:               q["tag"],
+	}
+	if v := q.Get("active"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			http.Error(w, "invalid active", http.StatusBadRequest)
+			return
+		}
+		f.Active = &b
+	}
+	limit := 50
+	if v := q.Get("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 && n <= 500 {
+			limit = n
+		}
+	}
+	var after string
+	if v := q.Get("cursor"); v != "" {
+		b, err := base64.RawURLEncoding.DecodeString(v)
+		if err != nil {
+			http.Error(w, "invalid cursor", http.StatusBadRequest)
+			return
+		}
+		after = string(b)
+	}
+
+	items, err := s.Chargers.List(r.Context(), f, after, limit)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	out := make([]map[string]any, 0, len(items))
+	for _, ch := range items {
+		out = append(out, chargerView(ch))
+	}
+	var next *string
+	if len(items) == limit {
+		c := base64.RawURLEncoding.EncodeToString([]byte(items[len(items)-1].ChargePointId))
+		next = &c
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"items": out, "nextCursor": next})
+}
+
+type createChargerReq struct {
+	ChargePointId string  `json:"chargePointId"`
+	Secret        string  `json:"secret,omitempty"`
+	Vendor        string  `json:"vendor"`
+	Model         string  `json:"model"`
+	OcppVersion   string  `json:"ocppVersion"`
+	SiteId        *string `json:"siteId,omitempty"`
+	IsActive      *bool   `json:"isActive,omitempty"`
+	// TenantId is only accepted from platform clients; default: the caller's tenant.
+	TenantId string `json:"tenantId,omitempty"`
+	// SecurityProfile is 1 (password, default), 2 (TLS + password) or 3 (mTLS).
+	SecurityProfile int `json:"securityProfile,omitempty"`
+}
+
+// POST /v1/chargers
+// Creates a provisioned (Accepted) charger, active unless isActive=false. Without secret
+// one is generated; it is returned only in this response.
+func (s *Server) CreateCharger(w http.ResponseWriter, r *http.Request) {
+	var req createChargerReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ChargePointId == "" {
+		http.Error(w, "invalid json/chargePointId", http.StatusBadRequest)
+		return
+	}
+	if req.SecurityProfile != 0 && !validSecurityProfile(req.SecurityProfile) {
+		http.Error(w, "invalid securityProfile", http.StatusBadRequest)
+		return
+	}
+	if req.SiteId != nil && *req.SiteId == "" {
+		req.SiteId = nil
+	}
+	tenantId, ok := s.targetTenant(w, r, req.TenantId)
+	if !ok {
+		return
+	}
+	if req.SiteId != nil && !s.siteExists(w, r, *req.SiteId, tenantId) {
+		return
+	}
+
+	secret := req.Secret
+	generated := secret == ""
+	if generated {
+		var err error
+		if secret, err = security.GenerateSecret(); err != nil {
+			http.Error(w, "secret generation failed", http.StatusInternalServerError)
+			return
+		}
+	}
+	hash, err := security.HashSecret(secret)
+	if err != nil {
+		http.Error(w, "secret hashing failed", http.StatusInternalServerError)
+		return
+	}
+	active := true
+	if req.IsActive != nil {
+		active = *req.IsActive
+	}
+
+	created, err := s.Chargers.Create(r.Context(), models.Charger{
+		ChargePointId: req.ChargePointId,
+		IsActive:      active,
+		Vendor:        req.Vendor,
+		Model:         req.Model,
+		OcppVersion:   req.OcppVersion,
+		SiteId:        req.SiteId,
+		TenantId:      &tenantId,
+
+		SecurityProfile: req.SecurityProfile,
+	}, hash)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if !created {
+		http.Error(w, "charger exists", http.StatusConflict)
+		return
+	}
+	ch, err := s.Chargers.Get(r.Context(), req.ChargePointId)
+	if err != nil || ch == nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	view := chargerView(*ch)
+	if generated {
+		view["secret"] = secret
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(view)
+}
+
+type updateChargerReq struct {
+	Vendor      *string `json:"vendor"`
+	Model       *string `json:"model"`
+	OcppVersion *string `json:"ocppVersion"`
+	SiteId      *string `json:"siteId"` // "" removes the charger from its site
+
+	SecurityProfile *int     `json:"securityProfile"`
+	Tags            []string `json:"tags"` // replaces all tags; [] removes them
+}
+
+// PATCH /v1/chargers/{chargePointId}
+func (s *Server) UpdateCharger(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "chargePointId")
+	var req updateChargerReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if req.OcppVersion != nil && *req.OcppVersion == "" {
+		http.Error(w, "invalid ocppVersion", http.StatusBadRequest)
+		return
+	}
+	if req.SecurityProfile != nil && !validSecurityProfile(*req.SecurityProfile) {
+		http.Error(w, "invalid securityProfile", http.StatusBadRequest)
+		return
+	}
+	if req.SiteId != nil && *req.SiteId != "" {
+		ch, err := s.Chargers.Get(r.Context(), id)
+		if err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		if ch == nil {
+			http.NotFound(w, r)
+			return
+		}
+		if ch.TenantId == nil {
+			http.Error(w, "charger has no tenant yet; approve it with a site instead", http.StatusConflict)
+			return
+		}
+		if !s.siteExists(w, r, *req.SiteId, *ch.TenantId) {
+			return
+		}
+	}
+	tags, ok := normalizeTags(req.Tags)
+	if !ok {
+		http.Error(w, "invalid tags", http.StatusBadRequest)
+		return
+	}
+	found, err := s.Chargers.Update(r.Context(), id, repo.ChargerUpdate{
+		Vendor:      req.Vendor,
+		Model:       req.Model,
+		OcppVersion: req.OcppVersion,
+		SiteId:      req.SiteId,
+
+		SecurityProfile: req.SecurityProfile,
+		Tags:            tags,
+	})
+	s.writeCharger(w, r, id, found, err)
+}
+
+// normalizeTags trims and de-duplicates tags, keeping nil (not given) apart from an empty
+// list. Empty tags and tags longer than 64 characters are invalid.
+func normalizeTags(tags []string) ([]string, bool) {
+	if tags == nil {
+		return nil, true
+	}
+	out := make([]string, 0, len(tags))
+	seen := map[string]bool{}
+	for _, t := range tags {
+		t = strings.TrimSpace(t)
+		if t == "" || len(t) > 64 {
+			return nil, false
+		}
+		if !seen[t] {
+			seen[t] = true
+			out = append(out, t)
+		}
+	}
+	return out, true
+}
+
+// POST /v1/chargers/{chargePointId}/deactivate
+// The charger is refused by gateway auth until activated again.
+func (s *Server) DeactivateCharger(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "chargePointId")
+	found, err := s.Chargers.SetActive(r.Context(), id, false)
+	s.writeCharger(w, r, id, found, err)
+}
+
+// POST /v1/chargers/{chargePointId}/activate
+func (s *Server) ActivateCharger(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "chargePointId")
+	found, err := s.Chargers.SetActive(r.Context(), id, true)
+	s.writeCharger(w, r, id, found, err)
+}
+
+// DELETE /v1/chargers/{chargePointId}
+// Also deletes the charger's events and history. A charger with sessions answers 409:
+// sessions and settlements are kept, deactivate the charger instead.
+func (s *Server) DeleteCharger(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "chargePointId")
+	found, deleted, err := s.Chargers.Delete(r.Context(), id)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+	if !deleted {
+		http.Error(w, "charger has sessions; deactivate it instead", http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) writeCharger(w http.ResponseWriter, r *http.Request, id string, found bool, err error) {
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+	ch, err := s.Chargers.Get(r.Context(), id)
+	if err != nil || ch == nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(chargerView(*ch))
+}
+
+// siteExists reports whether siteId is a site of tenantId; otherwise it writes the error response.
+func (s *Server) siteExists(w http.ResponseWriter, r *http.Request, siteId, tenantId string) bool {
+	site, err := s.Sites.GetByID(r.Context(), siteId)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return false
+	}
+	if site == nil || site.TenantId != tenantId {
+		http.Error(w, "unknown siteId", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,379 @@
+package httpapi
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"cpms/internal/models"
+	"cpms/internal/repo"
+	"cpms/internal/services"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxJobChargers bounds the chargers a single bulk command job may target.
+const maxJobChargers = 10000
+
+// jobSelector picks the chargers of a bulk command job; set fields combine with AND.
+type jobSelector struct {
+	TenantId       string   `json:"tenantId,omitempty"` // platform clients: only this tenant's chargers
+	SiteId         string   `json:"siteId,omitempty"`
+	Vendor         string   `json:"vendor,omitempty"`
+	Model          string   `json:"model,omitempty"`
+	Tags           []string `json:"tags,omitempty"` // chargers having all of these tags
+	ChargePointIds []string `json:"chargePointIds,omitempty"`
+	// All selects every charger (of the tenant); required when no other field is set.
+	All bool `json:"all,omitempty"`
+}
+
+func (sel jobSelector) empty() bool {
+	return sel.TenantId == "" && sel.SiteId == "" && sel.Vendor == "" && sel.Model == "" &&
+		len(sel.Tags) == 0 && len(sel.ChargePointIds) == 0
+}
+
+type createCommandJobReq struct {
+	Type     string          `json:"type"`
+	Payload  json.RawMessage `json:"payload"`
+	Selector jobSelector     `json:"selector"`
+	// Concurrency and RatePerMinute default to CPMS_COMMAND_JOB_CONCURRENCY and
+	// CPMS_COMMAND_JOB_RATE_PER_MINUTE.
+	Concurrency   *int `json:"concurrency,omitempty"`
+	RatePerMinute *int `json:"ratePerMinute,omitempty"` // per gateway
+}
+
+func commandJobView(j models.CommandJob, counts map[string]int) map[string]any {
+	var payload, selector json.RawMessage
+	if j.PayloadJSON != nil {
+		payload = json.RawMessage(j.PayloadJSON)
+	}
+	if j.SelectorJSON != nil {
+		selector = json.RawMessage(j.SelectorJSON)
+	}
+	total := 0
+	for _, n := range counts {
+		total += n
+	}
+	finished := total - counts["Pending"] - counts["Running"]
+	percent := 100.0
+	if total > 0 {
+		percent = float64(finished*1000/total) / 10
+	}
+	return map[string]any{
+		"jobId":         j.JobId,
+		"tenantId":      j.TenantId,
+		"type":          j.Type,
+		"payload":       payload,
+		"selector":      selector,
+		"status":        j.Status,
+		"concurrency":   j.Concurrency,
+		"ratePerMinute": j.RatePerMinute,
+		"createdBy":     j.CreatedBy,
+		"createdAt":     j.CreatedAt,
+		"updatedAt":     j.UpdatedAt,
+		"finishedAt":    j.FinishedAt,
+		"progress": map[string]any{
+			"total":     total,
+			"pending":   counts["Pending"],
+			"running":   counts["Running"],
+			"succeeded": counts["Succeeded"],
+			"noEffect":  counts["NoEffect"],
+			"failed":    counts["Failed"],
+			"skipped":   counts["Skipped"],
+			"aborted":   counts["Aborted"],
+			"finished":  finished,
+			"percent":   percent,
+		},
+	}
+}
+
+func commandJobItemView(it models.CommandJobItem) map[string]any {
+	return map[string]any{
+		"chargePointId": it.ChargePointId,
+		"status":        it.Status,
+		"commandId":     it.CommandId,
+		"commandStatus": it.CommandStatus,
+		"error":         it.Error,
+		"updatedAt":     it.UpdatedAt,
+	}
+}
+
+// POST /v1/command-jobs
+// Resolves the selector to the active, accepted chargers it matches (at most 10000) and
+// validates the command against each charger's OCPP catalog; chargers whose catalog
+// rejects it are Skipped. The job then runs in the background (202); follow it with
+// GET /v1/command-jobs/{jobId}.
+func (s *Server) CreateCommandJob(w http.ResponseWriter, r *http.Request) {
+	var req createCommandJobReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if req.Type == "" {
+		http.Error(w, "missing type", http.StatusBadRequest)
+		return
+	}
+	if len(req.Payload) == 0 {
+		req.Payload = json.RawMessage(`{}`)
+	}
+	sel := req.Selector
+	if sel.empty() && !sel.All {
+		http.Error(w, "selector must name siteId, vendor, model, tags or chargePointIds, or set all", http.StatusBadRequest)
+		return
+	}
+	concurrency, rate := s.Cfg.CommandJobConcurrency, s.Cfg.CommandJobRatePerMinute
+	if req.Concurrency != nil {
+		if *req.Concurrency < 1 || *req.Concurrency > 1000 {
+			http.Error(w, "concurrency must be 1..1000", http.StatusBadRequest)
+			return
+		}
+		concurrency = *req.Concurrency
+	}
+	if req.RatePerMinute != nil {
+		if *req.RatePerMinute < 1 || *req.RatePerMinute > 6000 {
+			http.Error(w, "ratePerMinute must be 1..6000", http.StatusBadRequest)
+			return
+		}
+		rate = *req.RatePerMinute
+	}
+
+	var tenant string
+	if sel.TenantId != "" || tenantOf(r) != "" {
+		var ok bool
+		if tenant, ok = s.targetTenant(w, r, sel.TenantId); !ok {
+			return
+		}
+	}
+	if sel.SiteId != "" && !s.owns(w, r, repo.ResSite, sel.SiteId) {
+		return
+	}
+	tags, ok := normalizeTags(sel.Tags)
+	if !ok {
+		http.Error(w, "invalid selector.tags", http.StatusBadRequest)
+		return
+	}
+	if len(tags) == 0 {
+		tags = nil
+	}
+	var ids []string
+	if len(sel.ChargePointIds) > 0 {
+		ids = sel.ChargePointIds
+	}
+
+	chargers, err := s.Chargers.Select(r.Context(), repo.ChargerSelector{
+		TenantId:       tenant,
+		SiteId:         sel.SiteId,
+		Vendor:         sel.Vendor,
+		Model:          sel.Model,
+		Tags:           tags,
+		ChargePointIds: ids,
+	}, maxJobChargers+1)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if len(chargers) == 0 {
+		http.Error(w, "selector matches no active, accepted chargers", http.StatusBadRequest)
+		return
+	}
+	if len(chargers) > maxJobChargers {
+		http.Error(w, "selector matches more than "+strconv.Itoa(maxJobChargers)+" chargers", http.StatusBadRequest)
+		return
+	}
+
+	// Validate once per catalog version; chargers whose version rejects the command are skipped.
+	verdicts := map[string]error{}
+	targets := make([]repo.CommandJobTarget, 0, len(chargers))
+	var firstErr error
+	valid := 0
+	for _, ch := range chargers {
+		version := services.CommandCatalogVersion(ch.OcppVersion)
+		verr, seen := verdicts[version]
+		if !seen {
+			_, verr = services.ValidateCommand(ch.OcppVersion, req.Type, req.Payload)
+			verdicts[version] = verr
+		}
+		t := repo.CommandJobTarget{ChargePointId: ch.ChargePointId}
+		if verr != nil {
+			t.Skip = verr.Error()
+			if firstErr == nil {
+				firstErr = verr
+			}
+		} else {
+			valid++
+		}
+		targets = append(targets, t)
+	}
+	if valid == 0 {
+		http.Error(w, firstErr.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sel.Tags, sel.ChargePointIds, sel.TenantId = tags, ids, tenant
+	selectorJSON, _ := json.Marshal(sel)
+	job := models.CommandJob{
+		Type:          req.Type,
+		PayloadJSON:   req.Payload,
+		SelectorJSON:  selectorJSON,
+		Concurrency:   concurrency,
+		RatePerMinute: rate,
+	}
+	if tenant != "" {
+		job.TenantId = &tenant
+	}
+	if actor := actorOf(r); actor != "" {
+		job.CreatedBy = &actor
+	}
+	id, err := s.CommandJobs.Create(r.Context(), job, targets)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if s.JobRunner != nil {
+		s.JobRunner.Notify()
+	}
+	w.Header().Set("Location", "/v1/command-jobs/"+id)
+	s.writeCommandJob(w, r, id, http.StatusAccepted)
+}
+
+// GET /v1/command-jobs?status=&limit=50
+// Newest first; tenant-scoped clients only see their tenant's jobs.
+func (s *Server) ListCommandJobs(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit := 50
+	if v := q.Get("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 && n <= 500 {
+			limit = n
+		}
+	}
+	jobs, err := s.CommandJobs.List(r.Context(), tenantOf(r), q.Get("status"), limit)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	out := make([]map[string]any, 0, len(jobs))
+	for _, j := range jobs {
+		counts, err := s.CommandJobs.ItemCounts(r.Context(), j.JobId)
+		if err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		out = append(out, commandJobView(j, counts))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"items": out})
+}
+
+// GET /v1/command-jobs/{jobId}
+func (s *Server) GetCommandJob(w http.ResponseWriter, r *http.Request) {
+	s.writeCommandJob(w, r, chi.URLParam(r, "jobId"), http.StatusOK)
+}
+
+// GET /v1/command-jobs/{jobId}/items?status=&limit=50&cursor=
+// Per-charger outcome ordered by chargePointId; pass nextCursor back as cursor.
+func (s *Server) ListCommandJobItems(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	id := chi.URLParam(r, "jobId")
+	job, err := s.CommandJobs.Get(r.Context(), id)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if job == nil {
+		http.NotFound(w, r)
+		return
+	}
+	limit := 50
+	if v := q.Get("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 && n <= 500 {
+			limit = n
+		}
+	}
+	var after string
+	if v := q.Get("cursor"); v != "" {
+		b, err := base64.RawURLEncoding.DecodeString(v)
+		if err != nil {
+			http.Error(w, "invalid cursor", http.StatusBadRequest)
+			return
+		}
+		after = string(b)
+	}
+	items, err := s.CommandJobs.ListItems(r.Context(), id, q.Get("status"), after, limit)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	out := make([]map[string]any, 0, len(items))
+	for _, it := range items {
+		out = append(out, commandJobItemView(it))
+	}
+	var next *string
+	if len(items) == limit {
+		c := base64.RawURLEncoding.EncodeToString([]byte(items[len(items)-1].ChargePointId))
+		next = &c
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"items": out, "nextCursor": next})
+}
+
+// POST /v1/command-jobs/{jobId}/pause
+// No new commands are submitted; commands in flight finish.
+func (s *Server) PauseCommandJob(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "jobId")
+	done, err := s.CommandJobs.SetStatus(r.Context(), id, []string{"Running"}, "Paused")
+	s.writeJobTransition(w, r, id, done, err)
+}
+
+// POST /v1/command-jobs/{jobId}/resume
+func (s *Server) ResumeCommandJob(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "jobId")
+	done, err := s.CommandJobs.SetStatus(r.Context(), id, []string{"Paused"}, "Running")
+	if done && s.JobRunner != nil {
+		s.JobRunner.Notify()
+	}
+	s.writeJobTransition(w, r, id, done, err)
+}
+
+// POST /v1/command-jobs/{jobId}/abort
+// Pending chargers are Aborted and the job's Queued commands cancelled; commands already
+// sent run to their end.
+func (s *Server) AbortCommandJob(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "jobId")
+	done, err := s.CommandJobs.Abort(r.Context(), id)
+	s.writeJobTransition(w, r, id, done, err)
+}
+
+// writeJobTransition answers a pause/resume/abort: the job, with 409 when it was not in
+// a status the transition applies to.
+func (s *Server) writeJobTransition(w http.ResponseWriter, r *http.Request, id string, done bool, err error) {
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	status := http.StatusOK
+	if !done {
+		status = http.StatusConflict
+	}
+	s.writeCommandJob(w, r, id, status)
+}
+
+func (s *Server) writeCommandJob(w http.ResponseWriter, r *http.Request, id string, status int) {
+	job, err := s.CommandJobs.Get(r.Context(), id)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if job == nil {
+		http.NotFound(w, r)
+		return
+	}
+	counts, err := s.CommandJobs.ItemCounts(r.Context(), id)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(commandJobView(*job, counts))
+}
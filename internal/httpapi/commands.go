@@ -1,12 +1,19 @@
 package httpapi
 
 import (
-    "context"
+    "encoding/base64"
     "encoding/json"
     "net/http"
+    "regexp"
+    "strconv"
+    "strings"
     "time"
 
     "cpms/internal/models"
+    "cpms/internal/repo"
+    "cpms/internal/services"
+
+    "github.com/go-chi/chi/v5"
 )
 
 type createCommandReq struct {
@@ -14,8 +21,65 @@ type createCommandReq struct {
     ChargePointId  string          `json:"chargePointId"`
     IdempotencyKey string          `json:"idempotencyKey"`
     Payload        json.RawMessage `json:"payload"`
+    // ExpiresAt is the delivery deadline (default: now + CPMS_COMMAND_TTL).
+    ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+func commandView(c models.Command) map[string]any {
+    var payload map[string]json.RawMessage
+    _ = json.Unmarshal(c.PayloadJSON, &payload)
+    view := map[string]any{
+        "commandId":      c.CommandId,
+        "chargePointId":  c.ChargePointId,
+        "type":           c.Type,
+        "idempotencyKey": c.IdempotencyKey,
+        "payload":        payload["payload"],
+        "status":         c.Status,
+        "attempts":       c.Attempts,
+        "error":          c.Error,
+        "expiresAt":      c.ExpiresAt,
+        "completedAt":    c.CompletedAt,
+        "createdAt":      c.CreatedAt,
+        "updatedAt":      c.UpdatedAt,
+    }
+    if c.Status == "Queued" {
+        view["nextAttemptAt"] = c.NextAttemptAt
+    }
+    if c.ResponseJSON != nil {
+        view["response"] = json.RawMessage(c.ResponseJSON)
+    }
+    if c.Effect != nil {
+        view["effect"] = commandEffectView(c)
+    }
+    return view
+}
+
+// commandEffectView shows the expected event and, once observed, the event and session
+// that completed the command.
+func commandEffectView(c models.Command) map[string]any {
+    e := c.Effect
+    return map[string]any{
+        "event":         e.Event,
+        "connectorId":   e.ConnectorId,
+        "evseId":        e.EvseId,
+        "idTag":         e.IdTag,
+        "transactionId": e.TransactionId,
+        "statuses":      e.Statuses,
+        "fromStatuses":  e.FromStatuses,
+        "deadline":      c.EffectDeadline,
+        "eventId":       c.EffectEventId,
+        "sessionId":     c.EffectSessionId,
+        "observedAt":    c.EffectAt,
+    }
 }
 
+// POST /v1/commands
+// The type and payload are validated against the command catalog of the charger's OCPP
+// version (GET /v1/commands/catalog). Queues the command and answers 202; the dispatcher delivers it to the gateway, retrying
+// until expiresAt. Poll GET /v1/commands/{commandId} for the outcome. Repeating an
+// idempotencyKey returns the existing command with 200. Commands with a known effect
+// (starts, stops, availability changes, resets) move from Acked to Completed or NoEffect
+// once the charger's events show whether they took effect.
 func (s *Server) CreateAndSendCommand(w http.ResponseWriter, r *http.Request) {
     var req createCommandReq
     if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -29,20 +93,47 @@ func (s *Server) CreateAndSendCommand(w http.ResponseWriter, r *http.Request) {
     if len(req.Payload) == 0 {
         req.Payload = json.RawMessage(`{}`)
     }
+    now := time.Now().UTC()
+    expiresAt := now.Add(s.Cfg.CommandTTL)
+    if req.ExpiresAt != nil {
+        if !req.ExpiresAt.After(now) {
+            http.Error(w, "expiresAt must be in the future", http.StatusBadRequest)
+            return
+        }
+        expiresAt = req.ExpiresAt.UTC()
+    }
+
+    if !s.owns(w, r, repo.ResCharger, req.ChargePointId) {
+        return
+    }
 
     existing, err := s.Commands.GetByIdempotency(r.Context(), req.IdempotencyKey)
     if err != nil {
         http.Error(w, "db error", http.StatusInternalServerError)
         return
     }
+    if existing != nil && tenantOf(r) != "" && existing.ChargePointId != req.ChargePointId {
+        http.Error(w, "idempotencyKey already used", http.StatusConflict)
+        return
+    }
     if existing != nil {
         w.Header().Set("Content-Type", "application/json")
-        _ = json.NewEncoder(w).Encode(map[string]any{
-            "commandId": existing.CommandId,
-            "status":    existing.Status,
-            "response":  json.RawMessage(existing.ResponseJSON),
-            "error":     existing.Error,
-        })
+        _ = json.NewEncoder(w).Encode(commandView(*existing))
+        return
+    }
+
+    ch, err := s.Chargers.Get(r.Context(), req.ChargePointId)
+    if err != nil {
+        http.Error(w, "db error", http.StatusInternalServerError)
+        return
+    }
+    if ch == nil {
+        http.Error(w, "unknown chargePointId", http.StatusBadRequest)
+        return
+    }
+    decoded, err := services.ValidateCommand(ch.OcppVersion, req.Type, req.Payload)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
         return
     }
 
@@ -59,41 +150,156 @@ func (s *Server) CreateAndSendCommand(w http.ResponseWriter, r *http.Request) {
         IdempotencyKey: req.IdempotencyKey,
         PayloadJSON:    gwBody,
         Status:         "Queued",
+        ExpiresAt:      expiresAt,
+        Effect:         services.CommandEffect(decoded),
     })
     if err != nil {
         http.Error(w, "db error", http.StatusInternalServerError)
         return
     }
+    if s.Dispatcher != nil {
+        s.Dispatcher.Notify()
+    }
 
-    ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
-    defer cancel()
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Location", "/v1/commands/"+cmdId)
+    w.WriteHeader(http.StatusAccepted)
+    _ = json.NewEncoder(w).Encode(map[string]any{
+        "commandId": cmdId,
+        "status":    "Queued",
+        "expiresAt": expiresAt,
+    })
+}
 
-    _ = s.Commands.MarkSent(r.Context(), cmdId)
-    status, respBody, err := s.Gateway.SendCommand(ctx, gwBody)
+// GET /v1/commands/{commandId}
+func (s *Server) GetCommand(w http.ResponseWriter, r *http.Request) {
+    c, err := s.Commands.Get(r.Context(), chi.URLParam(r, "commandId"))
     if err != nil {
-        _ = s.Commands.MarkFailed(r.Context(), cmdId, err.Error())
-        http.Error(w, "gateway error: "+err.Error(), http.StatusBadGateway)
+        http.Error(w, "db error", http.StatusInternalServerError)
         return
     }
-    if status < 200 || status >= 300 {
-        _ = s.Commands.MarkFailed(r.Context(), cmdId, string(respBody))
-        w.Header().Set("Content-Type", "application/json")
-        w.WriteHeader(http.StatusBadGateway)
-        _ = json.NewEncoder(w).Encode(map[string]any{
-            "commandId":     cmdId,
-            "status":        "Failed",
-            "gatewayStatus": status,
-            "gatewayBody":   json.RawMessage(respBody),
-        })
+    if c == nil {
+        http.NotFound(w, r)
         return
     }
+    w.Header().Set("Content-Type", "application/json")
+    _ = json.NewEncoder(w).Encode(commandView(*c))
+}
 
-    _ = s.Commands.MarkAcked(r.Context(), cmdId, respBody)
+// GET /v1/chargers/{chargePointId}/commands?type=&status=&from=&to=&limit=50&cursor=
+// Newest first; pass nextCursor back as cursor for the next page.
+func (s *Server) ListChargerCommands(w http.ResponseWriter, r *http.Request) {
+    q := r.URL.Query()
+    f := repo.CommandFilter{Type: q.Get("type"), Status: q.Get("status")}
+    switch f.Status {
+    case "", "Queued", "Sent", "Acked", "Failed", "Expired", "Cancelled", "Completed", "NoEffect":
+    default:
+        http.Error(w, "invalid status", http.StatusBadRequest)
+        return
+    }
+    var err error
+    if f.From, err = queryTime(r, "from"); err != nil {
+        http.Error(w, "invalid from", http.StatusBadRequest)
+        return
+    }
+    if f.To, err = queryTime(r, "to"); err != nil {
+        http.Error(w, "invalid to", http.StatusBadRequest)
+        return
+    }
+    limit := 50
+    if v := q.Get("limit"); v != "" {
+        if n, err := strconv.Atoi(v); err == nil && n > 0 && n <= 500 {
+            limit = n
+        }
+    }
+    var after *repo.CommandCursor
+    if v := q.Get("cursor"); v != "" {
+        c, ok := decodeCommandCursor(v)
+        if !ok {
+            http.Error(w, "invalid cursor", http.StatusBadRequest)
+            return
+        }
+        after = &c
+    }
 
+    items, err := s.Commands.ListByCharger(r.Context(), chi.URLParam(r, "chargePointId"), f, after, limit)
+    if err != nil {
+        http.Error(w, "db error", http.StatusInternalServerError)
+        return
+    }
+    out := make([]map[string]any, 0, len(items))
+    for _, c := range items {
+        out = append(out, commandView(c))
+    }
+    var next *string
+    if len(items) == limit {
+        last := items[len(items)-1]
+        c := base64.RawURLEncoding.EncodeToString([]byte(last.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + last.CommandId))
+        next = &c
+    }
     w.Header().Set("Content-Type", "application/json")
-    _ = json.NewEncoder(w).Encode(map[string]any{
-        "commandId":       cmdId,
-        "status":          "Acked",
-        "gatewayResponse": json.RawMessage(respBody),
-    })
+    _ = json.NewEncoder(w).Encode(map[string]any{"items": out, "nextCursor": next})
+}
+
+// commandIdPattern matches a command id (UUID).
+var commandIdPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// decodeCommandCursor parses a nextCursor of ListChargerCommands. A cursor whose command
+// id is not a UUID is rejected here rather than failing the query.
+func decodeCommandCursor(v string) (repo.CommandCursor, bool) {
+    b, err := base64.RawURLEncoding.DecodeString(v)
+    if err != nil {
+        return repo.CommandCursor{}, false
+    }
+    at, id, ok := strings.Cut(string(b), "|")
+    if !ok {
+        return repo.CommandCursor{}, false
+    }
+    t, err := time.Parse(time.RFC3339Nano, at)
+    if err != nil || !commandIdPattern.MatchString(id) {
+        return repo.CommandCursor{}, false
+    }
+    return repo.CommandCursor{CreatedAt: t, CommandId: id}, true
+}
+
+// POST /v1/commands/{commandId}/cancel
+// Only Queued commands can be cancelled; a command already handed to the gateway (Sent)
+// or finished answers 409.
+func (s *Server) CancelCommand(w http.ResponseWriter, r *http.Request) {
+    id := chi.URLParam(r, "commandId")
+    done, err := s.Commands.Cancel(r.Context(), id)
+    if err != nil {
+        http.Error(w, "db error", http.StatusInternalServerError)
+        return
+    }
+    c, err := s.Commands.Get(r.Context(), id)
+    if err != nil {
+        http.Error(w, "db error", http.StatusInternalServerError)
+        return
+    }
+    if c == nil {
+        http.NotFound(w, r)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    if !done {
+        w.WriteHeader(http.StatusConflict)
+    }
+    _ = json.NewEncoder(w).Encode(commandView(*c))
+}
+
+// GET /v1/commands/catalog?ocppVersion=
+// Supported command types with their payload JSON schemas, per OCPP version (1.6, 2.0.1);
+// ocppVersion accepts a charger's reported version, e.g. 1.6J.
+func (s *Server) CommandCatalog(w http.ResponseWriter, r *http.Request) {
+    versions := []string{services.OCPP16, services.OCPP201}
+    if v := r.URL.Query().Get("ocppVersion"); v != "" {
+        versions = []string{services.CommandCatalogVersion(v)}
+    }
+    out := make([]map[string]any, 0, len(versions))
+    for _, v := range versions {
+        out = append(out, map[string]any{"ocppVersion": v, "commands": services.CommandCatalog(v)})
+    }
+    w.Header().Set("Content-Type", "application/json")
+    _ = json.NewEncoder(w).Encode(map[string]any{"items": out})
 }
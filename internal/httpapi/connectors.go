@@ -0,0 +1,122 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"cpms/internal/services"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// queryTime parses an optional RFC3339 query parameter.
+func queryTime(r *http.Request, name string) (*time.Time, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return nil, err
+	}
+	t = t.UTC()
+	return &t, nil
+}
+
+// queryWindow parses from/to, defaulting to the last 30 days.
+func queryWindow(r *http.Request) (time.Time, time.Time, bool) {
+	from, err := queryTime(r, "from")
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+	to, err := queryTime(r, "to")
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+	now := time.Now().UTC()
+	if to == nil || to.After(now) {
+		to = &now
+	}
+	if from == nil {
+		f := to.AddDate(0, 0, -30)
+		from = &f
+	}
+	if !from.Before(*to) {
+		return time.Time{}, time.Time{}, false
+	}
+	return *from, *to, true
+}
+
+// GET /v1/chargers/{chargePointId}/connectors/{connectorId}/history?from=&to=&limit=
+func (s *Server) ConnectorHistory(w http.ResponseWriter, r *http.Request) {
+	cp := chi.URLParam(r, "chargePointId")
+	connectorId, err := strconv.Atoi(chi.URLParam(r, "connectorId"))
+	if err != nil {
+		http.Error(w, "invalid connectorId", http.StatusBadRequest)
+		return
+	}
+	from, err := queryTime(r, "from")
+	if err != nil {
+		http.Error(w, "invalid from", http.StatusBadRequest)
+		return
+	}
+	to, err := queryTime(r, "to")
+	if err != nil {
+		http.Error(w, "invalid to", http.StatusBadRequest)
+		return
+	}
+	limit := 500
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 && n <= 5000 {
+			limit = n
+		}
+	}
+	items, err := s.State.ListStatusHistory(r.Context(), cp, connectorId, from, to, limit)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	out := make([]map[string]any, 0, len(items))
+	for _, h := range items {
+		out = append(out, map[string]any{
+			"status":    h.Status,
+			"errorCode": h.ErrorCode,
+			"startedAt": h.StartedAt,
+			"endedAt":   h.EndedAt,
+			"eventId":   h.EventId,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"items": out})
+}
+
+// GET /v1/chargers/{chargePointId}/connectors/{connectorId}/timeline?from=&to=
+// Time spent per status in the window (default: last 30 days).
+func (s *Server) ConnectorTimeline(w http.ResponseWriter, r *http.Request) {
+	cp := chi.URLParam(r, "chargePointId")
+	connectorId, err := strconv.Atoi(chi.URLParam(r, "connectorId"))
+	if err != nil {
+		http.Error(w, "invalid connectorId", http.StatusBadRequest)
+		return
+	}
+	from, to, ok := queryWindow(r)
+	if !ok {
+		http.Error(w, "invalid from/to", http.StatusBadRequest)
+		return
+	}
+	history, err := s.State.ListStatusHistory(r.Context(), cp, connectorId, &from, &to, 0)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"chargePointId": cp,
+		"connectorId":   connectorId,
+		"from":          from,
+		"to":            to,
+		"statuses":      services.ConnectorTimeline(history, from, to),
+	})
+}
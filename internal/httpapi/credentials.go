@@ -0,0 +1,203 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"cpms/internal/models"
+	"cpms/internal/services"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// credentialState is Revoked, Expired, Scheduled (not valid yet) or Valid at now.
+func credentialState(c models.ChargerCredential, now time.Time) string {
+	switch {
+	case c.RevokedAt != nil:
+		return "Revoked"
+	case c.NotAfter != nil && !c.NotAfter.After(now):
+		return "Expired"
+	case c.NotBefore.After(now):
+		return "Scheduled"
+	}
+	return "Valid"
+}
+
+// GET /v1/chargers/{chargePointId}/credentials
+// All credentials of the charger, newest first (hashes are never returned).
+func (s *Server) ListCredentials(w http.ResponseWriter, r *http.Request) {
+	cp := chi.URLParam(r, "chargePointId")
+	items, err := s.Credentials.Credentials.List(r.Context(), cp)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	now := time.Now().UTC()
+	out := make([]map[string]any, 0, len(items))
+	for _, c := range items {
+		out = append(out, map[string]any{
+			"credentialId": c.CredentialId,
+			"state":        credentialState(c, now),
+			"notBefore":    c.NotBefore,
+			"notAfter":     c.NotAfter,
+			"firstUsedAt":  c.FirstUsedAt,
+			"lastUsedAt":   c.LastUsedAt,
+			"revokedAt":    c.RevokedAt,
+			"createdAt":    c.CreatedAt,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"items": out})
+}
+
+type rotateReq struct {
+	NotBefore   *time.Time `json:"notBefore"`
+	OldValidFor string     `json:"oldValidFor"` // duration, e.g. "72h"
+	Push        *bool      `json:"push"`        // default true
+}
+
+// POST /v1/chargers/{chargePointId}/credentials/rotate
+// Generates a new secret (returned once) and by default pushes it to the charger.
+// The previous credentials stay valid until the charger authenticates with the new one.
+func (s *Server) RotateCredentials(w http.ResponseWriter, r *http.Request) {
+	cp := chi.URLParam(r, "chargePointId")
+	var req rotateReq
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+	}
+	actor := actorOf(r)
+	if actor == "" {
+		http.Error(w, "X-Actor header required", http.StatusBadRequest)
+		return
+	}
+	rr := services.RotateRequest{ChargePointId: cp, Actor: actor, Push: req.Push == nil || *req.Push}
+	if req.NotBefore != nil {
+		rr.NotBefore = req.NotBefore.UTC()
+	}
+	if req.OldValidFor != "" {
+		d, err := time.ParseDuration(req.OldValidFor)
+		if err != nil || d <= 0 {
+			http.Error(w, "invalid oldValidFor", http.StatusBadRequest)
+			return
+		}
+		rr.OldValidFor = d
+	}
+
+	res, err := s.Credentials.Rotate(r.Context(), rr)
+	if res == nil {
+		if err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		http.NotFound(w, r)
+		return
+	}
+	out := map[string]any{
+		"rotationId":   res.RotationId,
+		"credentialId": res.CredentialId,
+		"secret":       res.Secret,
+	}
+	if rr.Push {
+		out["commandId"] = res.CommandId
+		out["commandStatus"] = res.CommandStatus
+		out["commandError"] = res.CommandError
+		if err != nil {
+			// the credential exists; the secret must be pushed another way
+			out["commandStatus"] = "NotSent"
+			out["commandError"] = err.Error()
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+// POST /v1/chargers/{chargePointId}/credentials/{credentialId}/revoke
+func (s *Server) RevokeCredential(w http.ResponseWriter, r *http.Request) {
+	cp := chi.URLParam(r, "chargePointId")
+	id := chi.URLParam(r, "credentialId")
+	done, err := s.Credentials.Credentials.Revoke(r.Context(), cp, id)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if !done {
+		http.NotFound(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET /v1/chargers/{chargePointId}/credential-rotations?limit=50
+func (s *Server) ListCredentialRotations(w http.ResponseWriter, r *http.Request) {
+	cp := chi.URLParam(r, "chargePointId")
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			limit = n
+		}
+	}
+	items, err := s.Credentials.Credentials.ListRotations(r.Context(), cp, limit)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	out := make([]map[string]any, 0, len(items))
+	for _, x := range items {
+		out = append(out, map[string]any{
+			"rotationId":    x.RotationId,
+			"credentialId":  x.CredentialId,
+			"actor":         x.Actor,
+			"status":        x.Status,
+			"oldValidUntil": x.OldValidUntil,
+			"commandId":     x.CommandId,
+			"createdAt":     x.CreatedAt,
+			"completedAt":   x.CompletedAt,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"items": out})
+}
+
+// GET /v1/chargers/{chargePointId}/auth-attempts?outcome=Allowed|Denied|Locked&limit=100
+// Gateway auth attempts for the charger, newest first, and its current lockout if any.
+func (s *Server) ListAuthAttempts(w http.ResponseWriter, r *http.Request) {
+	cp := chi.URLParam(r, "chargePointId")
+	if s.Credentials.Attempts == nil {
+		http.Error(w, "auth attempts not recorded", http.StatusNotImplemented)
+		return
+	}
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			limit = n
+		}
+	}
+	items, err := s.Credentials.Attempts.List(r.Context(), cp, r.URL.Query().Get("outcome"), limit)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	lockedUntil, err := s.Credentials.Attempts.ChargerLockedUntil(r.Context(), cp, time.Now().UTC())
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	out := make([]map[string]any, 0, len(items))
+	for _, a := range items {
+		out = append(out, map[string]any{
+			"id":         a.Id,
+			"remoteAddr": a.RemoteAddr,
+			"outcome":    a.Outcome,
+			"reason":     a.Reason,
+			"method":     a.Method,
+			"createdAt":  a.CreatedAt,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"items": out, "lockedUntil": lockedUntil})
+}
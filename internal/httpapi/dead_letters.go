@@ -0,0 +1,134 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"cpms/internal/models"
+	"cpms/internal/services"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func deadLetterView(d models.DeadLetterEvent) map[string]any {
+	return map[string]any{
+		"deadLetterId":   d.Id,
+		"gatewayEventId": d.GatewayEventId,
+		"chargePointId":  d.ChargePointId,
+		"type":           d.EventType,
+		"reason":         d.Reason,
+		"status":         d.Status,
+		"payload":        json.RawMessage(d.Payload),
+		"createdAt":      d.CreatedAt,
+		"updatedAt":      d.UpdatedAt,
+	}
+}
+
+// GET /v1/dead-letters?status=Open&chargePointId=CP-123&limit=50
+func (s *Server) ListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit := 50
+	if v := q.Get("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			limit = n
+		}
+	}
+	items, err := s.DeadLetters.DeadLetters.List(r.Context(), tenantOf(r), q.Get("status"), q.Get("chargePointId"), limit)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	out := make([]map[string]any, 0, len(items))
+	for _, d := range items {
+		out = append(out, deadLetterView(d))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"items": out})
+}
+
+func (s *Server) GetDeadLetter(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "deadLetterId"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid deadLetterId", http.StatusBadRequest)
+		return
+	}
+	d, err := s.DeadLetters.DeadLetters.Get(r.Context(), id)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if d == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(deadLetterView(*d))
+}
+
+type retryDeadLetterReq struct {
+	Payload json.RawMessage `json:"payload"`
+}
+
+// POST /v1/dead-letters/{deadLetterId}/retry
+// Optional body {"payload": {...}} replaces the stored payload (operator correction).
+func (s *Server) RetryDeadLetter(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "deadLetterId"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid deadLetterId", http.StatusBadRequest)
+		return
+	}
+	var req retryDeadLetterReq
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+	}
+	var payload []byte
+	if len(req.Payload) > 0 {
+		payload = req.Payload
+	}
+
+	res, err := s.DeadLetters.Retry(r.Context(), id, payload)
+	var verr *services.ValidationError
+	switch {
+	case errors.Is(err, services.ErrDeadLetterNotFound):
+		http.NotFound(w, r)
+		return
+	case errors.Is(err, services.ErrDeadLetterNotOpen):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.As(err, &verr):
+		http.Error(w, "invalid event: "+verr.Reason, http.StatusUnprocessableEntity)
+		return
+	case err != nil:
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	s.Queue.Notify()
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"deadLetterId": id, "status": "Retried", "eventId": res.EventId, "duplicate": res.Duplicate})
+}
+
+func (s *Server) DiscardDeadLetter(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "deadLetterId"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid deadLetterId", http.StatusBadRequest)
+		return
+	}
+	err = s.DeadLetters.Discard(r.Context(), id)
+	switch {
+	case errors.Is(err, services.ErrDeadLetterNotFound):
+		http.NotFound(w, r)
+		return
+	case errors.Is(err, services.ErrDeadLetterNotOpen):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,165 @@
+package httpapi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"cpms/internal/models"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func eventView(e models.GatewayEvent) map[string]any {
+	return map[string]any{
+		"eventId":       e.Id,
+		"chargePointId": e.ChargePointId,
+		"type":          e.EventType,
+		"eventKey":      e.EventKey,
+		"ts":            e.Ts,
+		"status":        e.Status,
+		"attempts":      e.Attempts,
+		"lastError":     e.LastError,
+		"nextAttemptAt": e.NextAttemptAt,
+		"processedAt":   e.ProcessedAt,
+		"receivedAt":    e.ReceivedAt,
+		"payload":       json.RawMessage(e.Payload),
+	}
+}
+
+// GET /v1/events?status=Failed&chargePointId=CP-123&limit=50
+func (s *Server) ListEvents(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit := 50
+	if v := q.Get("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			limit = n
+		}
+	}
+	items, err := s.Events.List(r.Context(), tenantOf(r), q.Get("status"), q.Get("chargePointId"), limit)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	out := make([]map[string]any, 0, len(items))
+	for _, e := range items {
+		out = append(out, eventView(e))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"items": out})
+}
+
+func (s *Server) GetEvent(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "eventId"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid eventId", http.StatusBadRequest)
+		return
+	}
+	e, err := s.Events.Get(r.Context(), id)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if e == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(eventView(*e))
+}
+
+const maxBatchEvents = 10000
+
+// POST /v1/gateway/events:batch
+// Body is a JSON array of envelopes, or NDJSON (one envelope per line) when the
+// Content-Type contains "ndjson" or the body does not start with '['.
+func (s *Server) IngestEventsBatch(w http.ResponseWriter, r *http.Request) {
+	raw, err := readAll(r, 32<<20)
+	if err != nil {
+		http.Error(w, "bad body", http.StatusBadRequest)
+		return
+	}
+	items, err := splitBatch(raw, strings.Contains(r.Header.Get("Content-Type"), "ndjson"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(items) > maxBatchEvents {
+		http.Error(w, "too many events (max "+strconv.Itoa(maxBatchEvents)+")", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	results := s.Processor.IngestBatch(r.Context(), gatewayTenantOf(r), items)
+
+	accepted, duplicates, deadLettered, failed := 0, 0, 0, 0
+	out := make([]map[string]any, 0, len(results))
+	for _, res := range results {
+		item := map[string]any{
+			"index":         res.Index,
+			"type":          res.Type,
+			"chargePointId": res.ChargePointId,
+			"accepted":      res.Err == nil && res.DeadLetterId == 0,
+		}
+		switch {
+		case res.Err != nil:
+			failed++
+			item["error"] = res.Err.Error()
+		case res.DeadLetterId != 0:
+			deadLettered++
+			item["deadLetterId"] = res.DeadLetterId
+			item["reason"] = res.Reason
+		case res.Duplicate:
+			duplicates++
+			item["eventId"] = res.EventId
+			item["duplicate"] = true
+		default:
+			accepted++
+			item["eventId"] = res.EventId
+			item["duplicate"] = false
+		}
+		out = append(out, item)
+	}
+	if accepted > 0 {
+		s.Queue.Notify()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"accepted":     accepted,
+		"duplicates":   duplicates,
+		"deadLettered": deadLettered,
+		"failed":       failed,
+		"items":        out,
+	})
+}
+
+func splitBatch(raw []byte, ndjson bool) ([][]byte, error) {
+	trimmed := bytes.TrimSpace(raw)
+	if !ndjson && len(trimmed) > 0 && trimmed[0] == '[' {
+		var arr []json.RawMessage
+		if err := json.Unmarshal(trimmed, &arr); err != nil {
+			return nil, err
+		}
+		out := make([][]byte, len(arr))
+		for i, m := range arr {
+			out[i] = m
+		}
+		return out, nil
+	}
+
+	var out [][]byte
+	sc := bufio.NewScanner(bytes.NewReader(trimmed))
+	sc.Buffer(make([]byte, 0, 64<<10), 2<<20)
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		out = append(out, append([]byte(nil), line...))
+	}
+	return out, sc.Err()
+}
@@ -1,20 +1,45 @@
 package httpapi
 
 import (
+	"context"
+	"crypto/subtle"
 	"net/http"
-	"strings"
 )
 
-func RequireBearer(token string, next http.Handler) http.Handler {
-	if token == "" {
-		return next
-	}
+type gatewayTenantKey struct{}
+
+// gatewayTenantOf returns the tenant of the gateway calling a /v1/gateway route; "" for
+// the CPMS gateway (GATEWAY_API_KEY), which acts for every charger.
+func gatewayTenantOf(r *http.Request) string {
+	t, _ := r.Context().Value(gatewayTenantKey{}).(string)
+	return t
+}
+
+// authenticateGateway admits the CPMS gateway with GATEWAY_API_KEY and a tenant's own
+// gateway with the tenant's gateway API key. Without GATEWAY_API_KEY, requests that
+// present no tenant key pass as the CPMS gateway (local development).
+func (s *Server) authenticateGateway(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		auth := r.Header.Get("Authorization")
-		if !strings.HasPrefix(auth, "Bearer ") || strings.TrimPrefix(auth, "Bearer ") != token {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		token := bearerToken(r)
+		if token != "" && s.Cfg.GatewayAPIKey != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.Cfg.GatewayAPIKey)) == 1 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if token != "" && s.Tenants != nil {
+			t, err := s.Tenants.GetByGatewayKey(r.Context(), token)
+			if err != nil {
+				http.Error(w, "db error", http.StatusInternalServerError)
+				return
+			}
+			if t != nil {
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), gatewayTenantKey{}, t.TenantId)))
+				return
+			}
+		}
+		if s.Cfg.GatewayAPIKey == "" {
+			next.ServeHTTP(w, r)
 			return
 		}
-		next.ServeHTTP(w, r)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
 	})
 }
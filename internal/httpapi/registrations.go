@@ -0,0 +1,255 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"cpms/internal/models"
+	"cpms/internal/security"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func registrationView(c models.Charger) map[string]any {
+	return map[string]any{
+		"chargePointId":         c.ChargePointId,
+		"registrationStatus":    c.RegistrationStatus,
+		"registrationChangedAt": c.RegistrationChangedAt,
+		"vendor":                c.Vendor,
+		"model":                 c.Model,
+		"ocppVersion":           c.OcppVersion,
+		"firstSeenAt":           c.CreatedAt,
+		"lastSeenAt":            c.LastSeenAt,
+	}
+}
+
+// GET /v1/registrations?status=Pending&limit=50
+// Chargers that booted but are not provisioned (or were rejected/blocked), oldest first.
+func (s *Server) ListRegistrations(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	status := q.Get("status")
+	if status == "" {
+		status = "Pending"
+	}
+	switch status {
+	case "Pending", "Accepted", "Rejected", "Blocked":
+	default:
+		http.Error(w, "invalid status", http.StatusBadRequest)
+		return
+	}
+	limit := 50
+	if v := q.Get("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			limit = n
+		}
+	}
+	items, err := s.Registrations.List(r.Context(), tenantOf(r), status, limit)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	out := make([]map[string]any, 0, len(items))
+	for _, c := range items {
+		out = append(out, registrationView(c))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"items": out})
+}
+
+type registrationDecisionReq struct {
+	SiteId string `json:"siteId,omitempty"` // approve only
+	// TenantId assigns an unassigned charger on approve; platform clients only.
+	TenantId string `json:"tenantId,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// decodeDecision reads the optional body and the actor; it writes the error response itself.
+func decodeDecision(w http.ResponseWriter, r *http.Request) (registrationDecisionReq, string, bool) {
+	var req registrationDecisionReq
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return req, "", false
+		}
+	}
+	actor := actorOf(r)
+	if actor == "" {
+		http.Error(w, "X-Actor header required", http.StatusBadRequest)
+		return req, "", false
+	}
+	return req, actor, true
+}
+
+// POST /v1/registrations/{chargePointId}/approve  {"siteId": "...", "tenantId": "...", "reason": "..."}
+// Activates the charger and returns its new secret. The secret is stored hashed and
+// is only shown in this response. A charger without tenant joins the given tenant,
+// else the site's tenant, else the default tenant.
+func (s *Server) ApproveRegistration(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "chargePointId")
+	req, actor, ok := decodeDecision(w, r)
+	if !ok {
+		return
+	}
+
+	tenantId, ok := s.approveTenant(w, r, id, req)
+	if !ok {
+		return
+	}
+	var siteId *string
+	if req.SiteId != "" {
+		if !s.siteExists(w, r, req.SiteId, tenantId) {
+			return
+		}
+		siteId = &req.SiteId
+	}
+
+	secret, err := security.GenerateSecret()
+	if err != nil {
+		http.Error(w, "secret generation failed", http.StatusInternalServerError)
+		return
+	}
+	hash, err := security.HashSecret(secret)
+	if err != nil {
+		http.Error(w, "secret hashing failed", http.StatusInternalServerError)
+		return
+	}
+	done, err := s.Registrations.Approve(r.Context(), id, hash, siteId, tenantId, actor, req.Reason)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if !done {
+		s.registrationConflict(w, r, id)
+		return
+	}
+	ch, err := s.Chargers.Get(r.Context(), id)
+	if err != nil || ch == nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	view := registrationView(*ch)
+	view["secret"] = secret
+	if siteId != nil {
+		view["siteId"] = *siteId
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(view)
+}
+
+// approveTenant returns the tenant a charger has, or joins on approval; it writes the
+// error response itself.
+func (s *Server) approveTenant(w http.ResponseWriter, r *http.Request, id string, req registrationDecisionReq) (string, bool) {
+	t, err := s.Tenants.ForCharger(r.Context(), id)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return "", false
+	}
+	if t != nil {
+		if req.TenantId != "" && req.TenantId != t.TenantId {
+			http.Error(w, "charger belongs to another tenant", http.StatusConflict)
+			return "", false
+		}
+		return t.TenantId, true
+	}
+	if req.TenantId == "" && req.SiteId != "" {
+		st, err := s.Tenants.ForSite(r.Context(), req.SiteId)
+		if err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return "", false
+		}
+		if st == nil {
+			http.Error(w, "unknown siteId", http.StatusBadRequest)
+			return "", false
+		}
+		req.TenantId = st.TenantId
+	}
+	return s.targetTenant(w, r, req.TenantId)
+}
+
+// POST /v1/registrations/{chargePointId}/reject  {"reason": "..."}
+// Only Pending chargers can be rejected; a rejected charger is Pending again after its next boot.
+func (s *Server) RejectRegistration(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "chargePointId")
+	req, actor, ok := decodeDecision(w, r)
+	if !ok {
+		return
+	}
+	done, err := s.Registrations.Reject(r.Context(), id, actor, req.Reason)
+	s.writeDecision(w, r, id, done, err)
+}
+
+// POST /v1/registrations/{chargePointId}/block  {"reason": "..."}
+// Deactivates the charger and revokes its credentials; later boots keep it Blocked.
+func (s *Server) BlockRegistration(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "chargePointId")
+	req, actor, ok := decodeDecision(w, r)
+	if !ok {
+		return
+	}
+	done, err := s.Registrations.Block(r.Context(), id, actor, req.Reason)
+	s.writeDecision(w, r, id, done, err)
+}
+
+func (s *Server) writeDecision(w http.ResponseWriter, r *http.Request, id string, done bool, err error) {
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if !done {
+		s.registrationConflict(w, r, id)
+		return
+	}
+	ch, err := s.Chargers.Get(r.Context(), id)
+	if err != nil || ch == nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(registrationView(*ch))
+}
+
+// registrationConflict answers a decision that changed nothing: 404 for unknown chargers,
+// 409 when the current status does not allow it.
+func (s *Server) registrationConflict(w http.ResponseWriter, r *http.Request, id string) {
+	ch, err := s.Chargers.Get(r.Context(), id)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if ch == nil {
+		http.NotFound(w, r)
+		return
+	}
+	http.Error(w, "charger is "+ch.RegistrationStatus, http.StatusConflict)
+}
+
+// GET /v1/registrations/{chargePointId}/audit?limit=50
+func (s *Server) ListRegistrationAudit(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "chargePointId")
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			limit = n
+		}
+	}
+	items, err := s.Registrations.ListAudit(r.Context(), id, limit)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	out := make([]map[string]any, 0, len(items))
+	for _, a := range items {
+		out = append(out, map[string]any{
+			"id":         a.Id,
+			"action":     a.Action,
+			"fromStatus": a.FromStatus,
+			"actor":      a.Actor,
+			"reason":     a.Reason,
+			"siteId":     a.SiteId,
+			"createdAt":  a.CreatedAt,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"items": out})
+}
@@ -0,0 +1,49 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"cpms/internal/repo"
+	"cpms/internal/services"
+)
+
+type replayReq struct {
+	ChargePointId string     `json:"chargePointId"`
+	From          *time.Time `json:"from"`
+	To            *time.Time `json:"to"`
+	Apply         bool       `json:"apply"`
+	Force         bool       `json:"force"`
+}
+
+// POST /v1/admin/replay
+// Dry-run by default; set "apply": true to commit the rebuilt state.
+func (s *Server) ReplayEvents(w http.ResponseWriter, r *http.Request) {
+	var req replayReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ChargePointId == "" {
+		http.Error(w, "invalid json/chargePointId", http.StatusBadRequest)
+		return
+	}
+	if !s.owns(w, r, repo.ResCharger, req.ChargePointId) {
+		return
+	}
+	report, err := s.Replay.Run(r.Context(), services.ReplayRequest{
+		ChargePointId: req.ChargePointId,
+		From:          req.From,
+		To:            req.To,
+		Apply:         req.Apply,
+		Force:         req.Force,
+	})
+	if err != nil {
+		if errors.Is(err, services.ErrReplayEventsPending) || errors.Is(err, services.ErrReplaySettled) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, "replay error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(report)
+}
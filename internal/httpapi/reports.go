@@ -0,0 +1,72 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type reusedTxSession struct {
+	SessionId     string     `json:"sessionId"`
+	ConnectorId   int        `json:"connectorId"`
+	EvseId        *int       `json:"evseId"`
+	BootEpoch     int        `json:"bootEpoch"`
+	StartedAt     time.Time  `json:"startedAt"`
+	EndedAt       *time.Time `json:"endedAt"`
+	EnergyWh      *int64     `json:"energyWh"`
+	IsSynthesized bool       `json:"isSynthesized"`
+}
+
+type reusedTx struct {
+	ChargePointId string `json:"chargePointId"`
+	TransactionId string `json:"transactionId"`
+	// SameEpoch is true when two sessions share the id within one boot epoch;
+	// events of such transactions cannot be matched and are dead-lettered.
+	SameEpoch bool              `json:"sameEpoch"`
+	Sessions  []reusedTxSession `json:"sessions"`
+}
+
+// GET /v1/reports/reused-transactions?chargePointId=...&limit=...
+// Lists transaction ids that were used by more than one session (counter reset after reboot).
+func (s *Server) ReusedTransactionsReport(w http.ResponseWriter, r *http.Request) {
+	limit := 200
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			limit = n
+		}
+	}
+	sessions, err := s.Sessions.ListReusedTransactions(r.Context(), tenantOf(r), r.URL.Query().Get("chargePointId"), limit)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	items := []reusedTx{}
+	epochs := map[int]bool{}
+	for _, sess := range sessions {
+		n := len(items)
+		if n == 0 || items[n-1].ChargePointId != sess.ChargePointId || items[n-1].TransactionId != sess.TransactionId {
+			items = append(items, reusedTx{ChargePointId: sess.ChargePointId, TransactionId: sess.TransactionId})
+			epochs = map[int]bool{}
+			n++
+		}
+		item := &items[n-1]
+		if epochs[sess.BootEpoch] {
+			item.SameEpoch = true
+		}
+		epochs[sess.BootEpoch] = true
+		item.Sessions = append(item.Sessions, reusedTxSession{
+			SessionId:     sess.SessionId,
+			ConnectorId:   sess.ConnectorId,
+			EvseId:        sess.EvseId,
+			BootEpoch:     sess.BootEpoch,
+			StartedAt:     sess.StartedAt,
+			EndedAt:       sess.EndedAt,
+			EnergyWh:      sess.EnergyWh,
+			IsSynthesized: sess.IsSynthesized,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"items": items})
+}
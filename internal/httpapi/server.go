@@ -2,61 +2,157 @@ package httpapi
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
 
 	"cpms/internal/config"
 	"cpms/internal/gatewayclient"
+	"cpms/internal/models"
 	"cpms/internal/repo"
-	"cpms/internal/security"
 	"cpms/internal/services"
 
 	"github.com/go-chi/chi/v5"
 )
 
 type Server struct {
-	Cfg         config.Config
-	Chargers    *repo.ChargersRepo
-	State       *repo.StateRepo
-	Sessions    *repo.SessionsRepo
-	Commands    *repo.CommandsRepo
-	Sites       *repo.SitesRepo
-	Tariffs     *repo.TariffsRepo
-	Settlements *repo.SettlementsRepo
-	Gateway     *gatewayclient.Client
-	Processor   *services.EventsProcessor
+	Cfg           config.Config
+	Chargers      *repo.ChargersRepo
+	State         *repo.StateRepo
+	Sessions      *repo.SessionsRepo
+	Commands      *repo.CommandsRepo
+	Sites         *repo.SitesRepo
+	Tariffs       *repo.TariffsRepo
+	Settlements   *repo.SettlementsRepo
+	Gateways      *services.Gateways
+	Processor     *services.EventsProcessor
+	Events        *repo.EventsRepo
+	Queue         *services.EventQueue
+	Replay        *services.ReplayService
+	DeadLetters   *services.DeadLetterService
+	Maintenance   *repo.MaintenanceRepo
+	Availability  *services.AvailabilityService
+	Alerts        *repo.AlertsRepo
+	Registrations *repo.RegistrationsRepo
+	Credentials   *services.CredentialService
+	Auth          *services.APIAuth
+	Audit         *repo.APIAuditRepo
+	Tenants       *repo.TenantsRepo
+	Dispatcher    *services.CommandDispatcher
+	CommandJobs   *repo.CommandJobsRepo
+	JobRunner     *services.CommandJobRunner
 }
 
-func NewServer(cfg config.Config, chargers *repo.ChargersRepo, state *repo.StateRepo, sessions *repo.SessionsRepo, commands *repo.CommandsRepo, sites *repo.SitesRepo, tariffs *repo.TariffsRepo, settlements *repo.SettlementsRepo, gw *gatewayclient.Client, processor *services.EventsProcessor) *Server {
-	return &Server{Cfg: cfg, Chargers: chargers, State: state, Sessions: sessions, Commands: commands, Sites: sites, Tariffs: tariffs, Settlements: settlements, Gateway: gw, Processor: processor}
+func NewServer(cfg config.Config, chargers *repo.ChargersRepo, state *repo.StateRepo, sessions *repo.SessionsRepo, commands *repo.CommandsRepo, sites *repo.SitesRepo, tariffs *repo.TariffsRepo, settlements *repo.SettlementsRepo, gw *gatewayclient.Client, processor *services.EventsProcessor, events *repo.EventsRepo, queue *services.EventQueue) *Server {
+	return &Server{Cfg: cfg, Chargers: chargers, State: state, Sessions: sessions, Commands: commands, Sites: sites, Tariffs: tariffs, Settlements: settlements, Gateways: services.NewGateways(nil, gw), Processor: processor, Events: events, Queue: queue}
 }
 
 func (s *Server) Routes() http.Handler {
 	r := chi.NewRouter()
 
 	r.Route("/v1/gateway", func(r chi.Router) {
-		r.Use(func(next http.Handler) http.Handler { return RequireBearer(s.Cfg.GatewayAPIKey, next) })
+		r.Use(s.authenticateGateway)
 		r.Post("/chargers/{chargePointId}/auth", s.AuthCharger)
 		r.Post("/events", s.IngestEvent)
+		r.Post("/events:batch", s.IngestEventsBatch)
 	})
 
-	r.Get("/v1/chargers/{chargePointId}", s.GetCharger)
-	r.Get("/v1/chargers/{chargePointId}/connectors", s.ListConnectors)
-	r.Get("/v1/chargers/{chargePointId}/sessions", s.ListSessionsByCharger)
-	r.Get("/v1/sessions/{sessionId}", s.GetSession)
-	r.Post("/v1/sessions/{sessionId}/finalize", s.FinalizeSession)
+	r.Group(func(r chi.Router) {
+		r.Use(s.authenticate, s.auditRequests, s.scopeTenant)
 
-	r.Post("/v1/commands", s.CreateAndSendCommand)
+		r.Group(func(r chi.Router) {
+			r.Use(s.require(permRead))
+			r.Get("/v1/chargers", s.ListChargers)
+			r.Get("/v1/chargers/{chargePointId}", s.GetCharger)
+			r.Get("/v1/chargers/{chargePointId}/credentials", s.ListCredentials)
+			r.Get("/v1/chargers/{chargePointId}/credential-rotations", s.ListCredentialRotations)
+			r.Get("/v1/chargers/{chargePointId}/auth-attempts", s.ListAuthAttempts)
+			r.Get("/v1/chargers/{chargePointId}/certificates", s.ListCertTrust)
+			r.Get("/v1/chargers/{chargePointId}/connectors", s.ListConnectors)
+			r.Get("/v1/chargers/{chargePointId}/connectivity-events", s.ListConnectivityEvents)
+			r.Get("/v1/chargers/{chargePointId}/connectors/{connectorId}/history", s.ConnectorHistory)
+			r.Get("/v1/chargers/{chargePointId}/connectors/{connectorId}/timeline", s.ConnectorTimeline)
+			r.Get("/v1/chargers/{chargePointId}/sessions", s.ListSessionsByCharger)
+			r.Get("/v1/chargers/{chargePointId}/commands", s.ListChargerCommands)
+			r.Get("/v1/sessions/{sessionId}", s.GetSession)
+			r.Get("/v1/commands/catalog", s.CommandCatalog)
+			r.Get("/v1/commands/{commandId}", s.GetCommand)
+			r.Get("/v1/command-jobs", s.ListCommandJobs)
+			r.Get("/v1/command-jobs/{jobId}", s.GetCommandJob)
+			r.Get("/v1/command-jobs/{jobId}/items", s.ListCommandJobItems)
+			r.Get("/v1/registrations", s.ListRegistrations)
+			r.Get("/v1/registrations/{chargePointId}/audit", s.ListRegistrationAudit)
+			r.Get("/v1/events", s.ListEvents)
+			r.Get("/v1/events/{eventId}", s.GetEvent)
+			r.Get("/v1/dead-letters", s.ListDeadLetters)
+			r.Get("/v1/dead-letters/{deadLetterId}", s.GetDeadLetter)
+			r.Get("/v1/reports/reused-transactions", s.ReusedTransactionsReport)
+			r.Get("/v1/reports/availability", s.AvailabilityReport)
+			r.Get("/v1/alerts", s.ListAlerts)
+			r.Get("/v1/maintenance-windows", s.ListMaintenanceWindows)
+			r.Get("/v1/settlements", s.ListSettlements)
+			r.Get("/v1/tenants", s.ListTenants)
+			r.Get("/v1/tenants/{tenantId}", s.GetTenant)
+		})
 
-	r.Post("/v1/sites", s.CreateSite)
-	r.Post("/v1/sites/{siteId}/tariffs", s.UpsertActiveTariff)
-	r.Post("/v1/sites/{siteId}/wallet", s.SetSiteWallet)
+		r.Group(func(r chi.Router) {
+			r.Use(s.require(permOperate))
+			r.Post("/v1/chargers", s.CreateCharger)
+			r.Patch("/v1/chargers/{chargePointId}", s.UpdateCharger)
+			r.Delete("/v1/chargers/{chargePointId}", s.DeleteCharger)
+			r.Post("/v1/chargers/{chargePointId}/activate", s.ActivateCharger)
+			r.Post("/v1/chargers/{chargePointId}/deactivate", s.DeactivateCharger)
+			r.Post("/v1/chargers/{chargePointId}/credentials/rotate", s.RotateCredentials)
+			r.Post("/v1/chargers/{chargePointId}/credentials/{credentialId}/revoke", s.RevokeCredential)
+			r.Post("/v1/chargers/{chargePointId}/certificates", s.AddCertTrust)
+			r.Delete("/v1/chargers/{chargePointId}/certificates/{trustId}", s.DeleteCertTrust)
+			r.Post("/v1/sessions/{sessionId}/finalize", s.FinalizeSession)
 
-	r.Get("/v1/settlements", s.ListSettlements)
-	r.Post("/v1/settlements/{settlementId}/submitted", s.MarkSettlementSubmitted)
-	r.Post("/v1/settlements/{settlementId}/confirmed", s.MarkSettlementConfirmed)
-	r.Post("/v1/settlements/{settlementId}/failed", s.MarkSettlementFailed)
+			r.Post("/v1/registrations/{chargePointId}/approve", s.ApproveRegistration)
+			r.Post("/v1/registrations/{chargePointId}/reject", s.RejectRegistration)
+			r.Post("/v1/registrations/{chargePointId}/block", s.BlockRegistration)
+
+			r.Post("/v1/commands", s.CreateAndSendCommand)
+			r.Post("/v1/commands/{commandId}/cancel", s.CancelCommand)
+			r.Post("/v1/command-jobs", s.CreateCommandJob)
+			r.Post("/v1/command-jobs/{jobId}/pause", s.PauseCommandJob)
+			r.Post("/v1/command-jobs/{jobId}/resume", s.ResumeCommandJob)
+			r.Post("/v1/command-jobs/{jobId}/abort", s.AbortCommandJob)
+
+			r.Post("/v1/dead-letters/{deadLetterId}/retry", s.RetryDeadLetter)
+			r.Post("/v1/dead-letters/{deadLetterId}/discard", s.DiscardDeadLetter)
+
+			r.Post("/v1/maintenance-windows", s.CreateMaintenanceWindow)
+			r.Delete("/v1/maintenance-windows/{maintenanceId}", s.DeleteMaintenanceWindow)
+
+			r.Post("/v1/sites", s.CreateSite)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(s.require(permFinance))
+			r.Post("/v1/sites/{siteId}/tariffs", s.UpsertActiveTariff)
+			r.Post("/v1/sites/{siteId}/wallet", s.SetSiteWallet)
+			r.Post("/v1/settlements/{settlementId}/submitted", s.MarkSettlementSubmitted)
+			r.Post("/v1/settlements/{settlementId}/confirmed", s.MarkSettlementConfirmed)
+			r.Post("/v1/settlements/{settlementId}/failed", s.MarkSettlementFailed)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(s.require(permAdmin))
+			r.Post("/v1/admin/replay", s.ReplayEvents)
+			r.Get("/v1/api-keys", s.ListAPIKeys)
+			r.Post("/v1/api-keys", s.CreateAPIKey)
+			r.Post("/v1/api-keys/{keyId}/revoke", s.RevokeAPIKey)
+			r.Get("/v1/audit-log", s.ListAuditLog)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(s.require(permAdmin), s.requirePlatform)
+			r.Post("/v1/tenants", s.CreateTenant)
+			r.Patch("/v1/tenants/{tenantId}", s.UpdateTenant)
+		})
+	})
 
 	r.Get("/healthz", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
 	return r
@@ -66,11 +162,17 @@ type authReq struct {
 	PresentedSecret string `json:"presentedSecret"`
 	RemoteAddr      string `json:"remoteAddr,omitempty"`
 	CertFingerprint string `json:"certFingerprint,omitempty"`
+	// TLS is true when the charger connected over TLS (wss://); ClientCertPEM is the
+	// client certificate (and chain) presented in the TLS handshake, if any.
+	TLS           bool   `json:"tls,omitempty"`
+	ClientCertPEM string `json:"clientCertPem,omitempty"`
 }
 
 type authResp struct {
-	Allowed     bool   `json:"allowed"`
-	OcppVersion string `json:"ocppVersion,omitempty"`
+	Allowed         bool   `json:"allowed"`
+	OcppVersion     string `json:"ocppVersion,omitempty"`
+	SecurityProfile int    `json:"securityProfile,omitempty"`
+	Reason          string `json:"reason,omitempty"`
 }
 
 func (s *Server) AuthCharger(w http.ResponseWriter, r *http.Request) {
@@ -79,29 +181,43 @@ func (s *Server) AuthCharger(w http.ResponseWriter, r *http.Request) {
 	var req authReq
 	_ = json.NewDecoder(r.Body).Decode(&req)
 
-	ch, err := s.Chargers.Get(r.Context(), id)
+	// A tenant's gateway only authenticates the tenant's chargers; others look unknown.
+	if tenant := gatewayTenantOf(r); tenant != "" {
+		ok, err := s.Tenants.Owns(r.Context(), repo.ResCharger, id, tenant)
+		if err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			_ = json.NewEncoder(w).Encode(authResp{Allowed: false, Reason: "UnknownCharger"})
+			return
+		}
+	}
+
+	res, err := s.Credentials.Authenticate(r.Context(), id, services.AuthAttempt{
+		Secret:          req.PresentedSecret,
+		TLS:             req.TLS,
+		CertFingerprint: req.CertFingerprint,
+		CertPEM:         req.ClientCertPEM,
+		RemoteAddr:      req.RemoteAddr,
+	})
 	if err != nil {
 		http.Error(w, "db error", http.StatusInternalServerError)
 		return
 	}
-	if ch == nil || !ch.IsActive || ch.SecretHash == "" {
-		w.WriteHeader(http.StatusUnauthorized)
-		_ = json.NewEncoder(w).Encode(authResp{Allowed: false})
-		return
-	}
-
-	presentedHash := security.HashSecretSHA256(req.PresentedSecret)
-	ok := security.ConstantTimeEqualHex(ch.SecretHash, presentedHash)
-
-	if !ok {
+	if !res.Allowed {
+		if res.LockedUntil != nil {
+			w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(*res.LockedUntil).Seconds())+1))
+		}
 		w.WriteHeader(http.StatusUnauthorized)
-		_ = json.NewEncoder(w).Encode(authResp{Allowed: false})
+		_ = json.NewEncoder(w).Encode(authResp{Allowed: false, Reason: res.Reason})
 		return
 	}
 
 	_ = s.Chargers.TouchLastSeen(r.Context(), id, time.Now().UTC())
 	w.WriteHeader(http.StatusOK)
-	_ = json.NewEncoder(w).Encode(authResp{Allowed: true, OcppVersion: ch.OcppVersion})
+	_ = json.NewEncoder(w).Encode(authResp{Allowed: true, OcppVersion: res.Charger.OcppVersion, SecurityProfile: res.Charger.SecurityProfile})
 }
 
 func (s *Server) IngestEvent(w http.ResponseWriter, r *http.Request) {
@@ -110,14 +226,25 @@ func (s *Server) IngestEvent(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "bad body", http.StatusBadRequest)
 		return
 	}
-	evtType, err := s.Processor.Ingest(r.Context(), raw)
+	res, err := s.Processor.Ingest(r.Context(), gatewayTenantOf(r), raw)
+	if errors.Is(err, services.ErrForeignCharger) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusAccepted)
-	_ = json.NewEncoder(w).Encode(map[string]any{"accepted": true, "type": evtType})
+	if res.DeadLetterId != 0 {
+		_ = json.NewEncoder(w).Encode(map[string]any{"accepted": false, "type": res.Type, "deadLetterId": res.DeadLetterId, "reason": res.Reason})
+		return
+	}
+	if !res.Duplicate {
+		s.Queue.Notify()
+	}
+	_ = json.NewEncoder(w).Encode(map[string]any{"accepted": true, "type": res.Type, "eventId": res.EventId, "duplicate": res.Duplicate})
 }
 
 func (s *Server) GetCharger(w http.ResponseWriter, r *http.Request) {
@@ -131,16 +258,7 @@ func (s *Server) GetCharger(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
-	_ = json.NewEncoder(w).Encode(map[string]any{
-		"chargePointId": ch.ChargePointId,
-		"isActive":      ch.IsActive,
-		"vendor":        ch.Vendor,
-		"model":         ch.Model,
-		"ocppVersion":   ch.OcppVersion,
-		"lastSeenAt":    ch.LastSeenAt,
-		"createdAt":     ch.CreatedAt,
-		"updatedAt":     ch.UpdatedAt,
-	})
+	_ = json.NewEncoder(w).Encode(chargerView(*ch))
 }
 
 func (s *Server) ListConnectors(w http.ResponseWriter, r *http.Request) {
@@ -164,7 +282,27 @@ func (s *Server) GetSession(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
-	_ = json.NewEncoder(w).Encode(sess)
+	// Commands are the remote starts/stops whose effect was observed on this session.
+	cmds, err := s.Commands.ListBySession(r.Context(), id)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	links := make([]map[string]any, 0, len(cmds))
+	for _, c := range cmds {
+		links = append(links, map[string]any{
+			"CommandId":  c.CommandId,
+			"Type":       c.Type,
+			"Status":     c.Status,
+			"Event":      c.Effect.Event,
+			"EventId":    c.EffectEventId,
+			"ObservedAt": c.EffectAt,
+		})
+	}
+	_ = json.NewEncoder(w).Encode(struct {
+		*models.Session
+		Commands []map[string]any
+	}{sess, links})
 }
 
 func (s *Server) ListSessionsByCharger(w http.ResponseWriter, r *http.Request) {
@@ -16,7 +16,7 @@ func (s *Server) ListSettlements(w http.ResponseWriter, r *http.Request) {
 			limit = n
 		}
 	}
-	items, err := s.Settlements.List(r.Context(), status, limit)
+	items, err := s.Settlements.List(r.Context(), tenantOf(r), status, limit)
 	if err != nil {
 		http.Error(w, "db error", http.StatusInternalServerError)
 		return
@@ -11,6 +11,8 @@ import (
 
 type createSiteReq struct {
 	Name string `json:"name"`
+	// TenantId is only accepted from platform clients; default: the caller's tenant.
+	TenantId string `json:"tenantId,omitempty"`
 }
 
 func (s *Server) CreateSite(w http.ResponseWriter, r *http.Request) {
@@ -19,12 +21,16 @@ func (s *Server) CreateSite(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid json/name", http.StatusBadRequest)
 		return
 	}
-	id, err := s.Sites.Create(r.Context(), req.Name)
+	tenantId, ok := s.targetTenant(w, r, req.TenantId)
+	if !ok {
+		return
+	}
+	id, err := s.Sites.Create(r.Context(), tenantId, req.Name)
 	if err != nil {
 		http.Error(w, "db error", http.StatusInternalServerError)
 		return
 	}
-	_ = json.NewEncoder(w).Encode(map[string]any{"siteId": id, "name": req.Name})
+	_ = json.NewEncoder(w).Encode(map[string]any{"siteId": id, "name": req.Name, "tenantId": tenantId})
 }
 
 type createTariffReq struct {
@@ -39,8 +45,18 @@ func (s *Server) UpsertActiveTariff(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid json/pricePerKwh", http.StatusBadRequest)
 		return
 	}
+	// Unknown sites and sites of another tenant are 404, whether or not a currency is given.
+	t, err := s.Tenants.ForSite(r.Context(), siteId)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if t == nil || (tenantOf(r) != "" && t.TenantId != tenantOf(r)) {
+		http.NotFound(w, r)
+		return
+	}
 	if req.Currency == "" {
-		req.Currency = "USD"
+		req.Currency = t.DefaultCurrency
 	}
 	id, err := s.Tariffs.UpsertActiveForSite(r.Context(), siteId, req.PricePerKwh, req.Currency)
 	if err != nil {
@@ -0,0 +1,211 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"cpms/internal/models"
+	"cpms/internal/repo"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func tenantView(t models.Tenant) map[string]any {
+	return map[string]any{
+		"tenantId":         t.TenantId,
+		"name":             t.Name,
+		"defaultCurrency":  t.DefaultCurrency,
+		"gatewayBaseUrl":   t.GatewayBaseURL,
+		"hasGatewayApiKey": t.GatewayAPIKey != nil,
+		"createdAt":        t.CreatedAt,
+		"updatedAt":        t.UpdatedAt,
+	}
+}
+
+// targetTenant resolves the tenant a new resource belongs to: the caller's tenant, or for
+// platform clients the requested tenant (default: the default tenant). It writes the
+// error response itself.
+func (s *Server) targetTenant(w http.ResponseWriter, r *http.Request, requested string) (string, bool) {
+	if own := tenantOf(r); own != "" {
+		if requested != "" && requested != own {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return "", false
+		}
+		return own, true
+	}
+	if requested == "" {
+		return repo.DefaultTenantId, true
+	}
+	t, err := s.Tenants.Get(r.Context(), requested)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return "", false
+	}
+	if t == nil {
+		http.Error(w, "unknown tenantId", http.StatusBadRequest)
+		return "", false
+	}
+	return t.TenantId, true
+}
+
+// GET /v1/tenants
+// All tenants for platform clients, otherwise only the caller's tenant.
+func (s *Server) ListTenants(w http.ResponseWriter, r *http.Request) {
+	items, err := s.Tenants.List(r.Context(), tenantOf(r))
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	out := make([]map[string]any, 0, len(items))
+	for _, t := range items {
+		out = append(out, tenantView(t))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"items": out})
+}
+
+// GET /v1/tenants/{tenantId}
+func (s *Server) GetTenant(w http.ResponseWriter, r *http.Request) {
+	t, err := s.Tenants.Get(r.Context(), chi.URLParam(r, "tenantId"))
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if t == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(tenantView(*t))
+}
+
+type tenantReq struct {
+	Name            *string `json:"name"`
+	DefaultCurrency *string `json:"defaultCurrency"` // ISO 4217, e.g. EUR
+	// GatewayBaseURL and GatewayAPIKey override the CPMS gateway for the tenant's
+	// chargers; "" falls back to the default gateway.
+	GatewayBaseURL *string `json:"gatewayBaseUrl"`
+	GatewayAPIKey  *string `json:"gatewayApiKey"`
+}
+
+// validate checks the fields that are set; it writes the error response itself.
+func (req *tenantReq) validate(w http.ResponseWriter) bool {
+	if req.Name != nil && strings.TrimSpace(*req.Name) == "" {
+		http.Error(w, "invalid name", http.StatusBadRequest)
+		return false
+	}
+	if req.DefaultCurrency != nil {
+		c := strings.ToUpper(*req.DefaultCurrency)
+		if len(c) != 3 {
+			http.Error(w, "invalid defaultCurrency", http.StatusBadRequest)
+			return false
+		}
+		req.DefaultCurrency = &c
+	}
+	if req.GatewayBaseURL != nil && *req.GatewayBaseURL != "" {
+		u, err := url.Parse(*req.GatewayBaseURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			http.Error(w, "invalid gatewayBaseUrl", http.StatusBadRequest)
+			return false
+		}
+	}
+	return true
+}
+
+// POST /v1/tenants  (platform clients only)
+func (s *Server) CreateTenant(w http.ResponseWriter, r *http.Request) {
+	var req tenantReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == nil {
+		http.Error(w, "invalid json/name", http.StatusBadRequest)
+		return
+	}
+	if !req.validate(w) {
+		return
+	}
+	t := models.Tenant{Name: *req.Name}
+	if req.DefaultCurrency != nil {
+		t.DefaultCurrency = *req.DefaultCurrency
+	}
+	if req.GatewayBaseURL != nil && *req.GatewayBaseURL != "" {
+		t.GatewayBaseURL = req.GatewayBaseURL
+	}
+	if req.GatewayAPIKey != nil && *req.GatewayAPIKey != "" {
+		if s.gatewayKeyTaken(w, r, *req.GatewayAPIKey, "") {
+			return
+		}
+		t.GatewayAPIKey = req.GatewayAPIKey
+	}
+	id, created, err := s.Tenants.Create(r.Context(), t)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if !created {
+		http.Error(w, "tenant name exists", http.StatusConflict)
+		return
+	}
+	s.writeTenant(w, r, id, http.StatusCreated)
+}
+
+// PATCH /v1/tenants/{tenantId}  (platform clients only)
+func (s *Server) UpdateTenant(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "tenantId")
+	var req tenantReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if !req.validate(w) {
+		return
+	}
+	if req.GatewayAPIKey != nil && *req.GatewayAPIKey != "" && s.gatewayKeyTaken(w, r, *req.GatewayAPIKey, id) {
+		return
+	}
+	found, err := s.Tenants.Update(r.Context(), id, repo.TenantUpdate{
+		Name:            req.Name,
+		DefaultCurrency: req.DefaultCurrency,
+		GatewayBaseURL:  req.GatewayBaseURL,
+		GatewayAPIKey:   req.GatewayAPIKey,
+	})
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+	s.writeTenant(w, r, id, http.StatusOK)
+}
+
+// gatewayKeyTaken answers 409 when key already authenticates another gateway: the CPMS
+// gateway or another tenant's (the key identifies the tenant on /v1/gateway routes).
+func (s *Server) gatewayKeyTaken(w http.ResponseWriter, r *http.Request, key, tenantId string) bool {
+	if key == s.Cfg.GatewayAPIKey {
+		http.Error(w, "gateway api key in use", http.StatusConflict)
+		return true
+	}
+	t, err := s.Tenants.GetByGatewayKey(r.Context(), key)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return true
+	}
+	if t != nil && t.TenantId != tenantId {
+		http.Error(w, "gateway api key in use", http.StatusConflict)
+		return true
+	}
+	return false
+}
+
+func (s *Server) writeTenant(w http.ResponseWriter, r *http.Request, id string, status int) {
+	t, err := s.Tenants.Get(r.Context(), id)
+	if err != nil || t == nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(tenantView(*t))
+}
@@ -4,14 +4,26 @@ import "time"
 
 type Charger struct {
 	ChargePointId string
-	SecretHash    string
 	IsActive      bool
 	Vendor        string
 	Model         string
 	OcppVersion   string
+	SiteId        *string
+	TenantId      *string // nil until an unannounced charger is approved
 	CreatedAt     time.Time
 	UpdatedAt     time.Time
 	LastSeenAt    *time.Time
+
+	HeartbeatIntervalSeconds *int
+	ConnectivityState        string
+	ConnectivityChangedAt    *time.Time
+
+	RegistrationStatus    string // Pending|Accepted|Rejected|Blocked
+	RegistrationChangedAt *time.Time
+
+	SecurityProfile int // 1 password, 2 TLS + password, 3 mTLS
+
+	Tags []string // free-form labels for selecting chargers, e.g. in bulk command jobs
 }
 
 type ConnectorState struct {
@@ -22,11 +34,24 @@ type ConnectorState struct {
 	UpdatedAt     time.Time
 }
 
+// ConnectorStatusChange is one row of connector_status_history. EndedAt is the
+// start of the next transition (nil for the current status).
+type ConnectorStatusChange struct {
+	Id            int64
+	ChargePointId string
+	ConnectorId   int
+	Status        string
+	ErrorCode     string
+	StartedAt     time.Time
+	EndedAt       *time.Time
+	EventId       *int64
+}
+
 type Session struct {
 	SessionId     string
 	ChargePointId string
 	ConnectorId   int
-	TransactionId int
+	TransactionId string
 	IdTag         string
 	StartedAt     time.Time
 	EndedAt       *time.Time
@@ -41,15 +66,22 @@ type Session struct {
 	CostAmount    *float64
 	CostCurrency  *string
 	PricedAt      *time.Time
+	StartEventId  *int64
+	IsSynthesized bool
+	EvseId        *int
+	LastSeqNo     *int
+	BootEpoch     int
 }
 
 type MeterSample struct {
 	Id            int64
 	SessionId     string
 	ChargePointId string
-	TransactionId int
+	TransactionId string
 	Ts            time.Time
 	SamplesJSON   []byte
+	EventId       *int64
+	SeqNo         *int
 }
 
 type Command struct {
@@ -58,15 +90,41 @@ type Command struct {
 	Type           string
 	IdempotencyKey string
 	PayloadJSON    []byte
-	Status         string
+	Status         string // Queued|Sent|Acked|Failed|Expired|Cancelled|Completed|NoEffect
 	ResponseJSON   []byte
-	Error          *string
+	Error          *string // last dispatch error
+	Attempts       int
+	NextAttemptAt  time.Time
+	ExpiresAt      time.Time
+	CompletedAt    *time.Time
 	CreatedAt      time.Time
 	UpdatedAt      time.Time
+
+	// Effect is the charger event expected to follow the command (nil: not tracked).
+	Effect          *CommandEffect
+	EffectDeadline  *time.Time
+	EffectEventId   *int64
+	EffectSessionId *string
+	EffectAt        *time.Time
+}
+
+// CommandEffect describes the event that shows a command took effect; nil fields match
+// any value.
+type CommandEffect struct {
+	Event         string // TransactionStarted|TransactionEnded|ConnectorStatusChanged|ChargerBooted
+	ConnectorId   *int
+	EvseId        *int
+	IdTag         *string
+	TransactionId *string
+	Statuses      []string
+	// FromStatuses, if set, is where the connector must come from: only a transition out
+	// of them after the ack counts.
+	FromStatuses []string
 }
 
 type Site struct {
 	SiteId       string
+	TenantId     string
 	Name         string
 	PayoutWallet *string
 	CreatedAt    time.Time
@@ -96,3 +154,200 @@ type Settlement struct {
 	CreatedAt    time.Time
 	UpdatedAt    time.Time
 }
+
+type GatewayEvent struct {
+	Id            int64
+	ChargePointId string
+	EventType     string
+	EventKey      *string
+	Ts            time.Time
+	Payload       []byte
+	Status        string
+	Attempts      int
+	LastError     *string
+	NextAttemptAt time.Time
+	ProcessedAt   *time.Time
+	ReceivedAt    time.Time
+}
+
+type DeadLetterEvent struct {
+	Id             int64
+	GatewayEventId *int64
+	ChargePointId  string
+	EventType      string
+	Payload        []byte
+	Reason         string
+	Status         string
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+type MaintenanceWindow struct {
+	MaintenanceId string
+	ChargePointId *string
+	SiteId        *string
+	TenantId      *string // nil = every tenant
+	StartsAt      time.Time
+	EndsAt        time.Time
+	Reason        string
+	CreatedAt     time.Time
+}
+
+type ChargerStateEvent struct {
+	Id            int64
+	ChargePointId string
+	FromState     string
+	ToState       string
+	LastSeenAt    *time.Time
+	CreatedAt     time.Time
+}
+
+// ChargerCredential is one secret a charger may authenticate with. It is valid in
+// [NotBefore, NotAfter) unless revoked.
+type ChargerCredential struct {
+	CredentialId  string
+	ChargePointId string
+	SecretHash    string
+	NotBefore     time.Time
+	NotAfter      *time.Time
+	FirstUsedAt   *time.Time
+	LastUsedAt    *time.Time
+	RevokedAt     *time.Time
+	CreatedAt     time.Time
+}
+
+// CertTrust accepts a client certificate for a charger: either a pinned SHA-256
+// fingerprint, or any certificate issued by CAPEM with subject CN ExpectedCN.
+type CertTrust struct {
+	TrustId       string
+	ChargePointId string
+	Fingerprint   *string
+	CAPEM         *string
+	ExpectedCN    *string
+	Label         string
+	CreatedAt     time.Time
+}
+
+// AuthAttemptRecord is one charger auth attempt as seen by the gateway auth endpoint.
+type AuthAttemptRecord struct {
+	Id            int64
+	ChargePointId string
+	RemoteAddr    string
+	Outcome       string // Allowed|Denied|Locked
+	Reason        string
+	Method        string // Password|Certificate
+	CreatedAt     time.Time
+}
+
+type CredentialRotation struct {
+	RotationId    string
+	ChargePointId string
+	CredentialId  string
+	Actor         string
+	Status        string // Pending|Completed|Superseded
+	OldValidUntil *time.Time
+	CommandId     *string
+	CreatedAt     time.Time
+	CompletedAt   *time.Time
+}
+
+// RegistrationAudit records who approved, rejected or blocked a charger.
+type RegistrationAudit struct {
+	Id            int64
+	ChargePointId string
+	Action        string
+	FromStatus    string
+	Actor         string
+	Reason        string
+	SiteId        *string
+	CreatedAt     time.Time
+}
+
+type Alert struct {
+	Id            int64
+	DedupKey      string
+	ChargePointId string
+	ConnectorId   *int
+	Kind          string
+	Severity      string
+	Message       string
+	Status        string
+	Occurrences   int
+	FirstAt       time.Time
+	LastAt        time.Time
+	ResolvedAt    *time.Time
+	NotifyPending bool
+	NotifiedAt    *time.Time
+	// NotifiedSinks already received the pending notification.
+	NotifiedSinks []string
+	// Failed deliveries of the pending notification; it is retried at NotifyNextAt and
+	// dropped (NotifyFailedAt) after the last attempt.
+	NotifyAttempts int
+	NotifyNextAt   *time.Time
+	NotifyError    *string
+	NotifyFailedAt *time.Time
+}
+
+// APIKey is a management API credential; only a hash of the key is stored.
+type APIKey struct {
+	KeyId      string
+	TenantId   *string // nil = platform-wide
+	Name       string
+	KeyPrefix  string
+	Roles      []string
+	CreatedBy  string
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+}
+
+// APIAuditEntry records a mutating management API request and who made it.
+type APIAuditEntry struct {
+	Id         int64
+	TenantId   *string
+	Actor      string
+	AuthMethod string // ApiKey|JWT
+	HTTPMethod string
+	Path       string
+	Route      string
+	Status     int
+	RemoteAddr string
+	CreatedAt  time.Time
+}
+
+// Tenant is a CPO (white-label customer) owning sites and chargers.
+type Tenant struct {
+	TenantId        string
+	Name            string
+	DefaultCurrency string
+	GatewayBaseURL  *string
+	GatewayAPIKey   *string
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+// CommandJob fans one command out to the chargers matched by a selector.
+type CommandJob struct {
+	JobId         string
+	TenantId      *string // nil = chargers of all tenants
+	Type          string
+	PayloadJSON   []byte
+	SelectorJSON  []byte
+	Status        string // Running|Paused|Completed|Aborted
+	Concurrency   int
+	RatePerMinute int // per gateway
+	CreatedBy     *string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	FinishedAt    *time.Time
+}
+
+type CommandJobItem struct {
+	JobId         string
+	ChargePointId string
+	Status        string // Pending|Running|Succeeded|NoEffect|Failed|Skipped|Aborted
+	CommandId     *string
+	CommandStatus *string
+	Error         *string
+	UpdatedAt     time.Time
+}
@@ -0,0 +1,131 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"cpms/internal/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// AlertsRepo stores alerts. At most one alert per dedup_key is Open; firing it again
+// only bumps occurrences/last_at.
+type AlertsRepo struct{ db DBTX }
+
+func NewAlertsRepo(db DBTX) *AlertsRepo { return &AlertsRepo{db: db} }
+
+const alertCols = `id, dedup_key, charge_point_id, connector_id, kind, severity, message, status, occurrences, first_at, last_at, resolved_at, notify_pending, notified_at, notified_sinks,
+  notify_attempts, notify_next_at, notify_error, notify_failed_at`
+
+func scanAlert(row pgx.Row) (models.Alert, error) {
+	var a models.Alert
+	err := row.Scan(&a.Id, &a.DedupKey, &a.ChargePointId, &a.ConnectorId, &a.Kind, &a.Severity, &a.Message, &a.Status, &a.Occurrences, &a.FirstAt, &a.LastAt, &a.ResolvedAt, &a.NotifyPending, &a.NotifiedAt, &a.NotifiedSinks,
+		&a.NotifyAttempts, &a.NotifyNextAt, &a.NotifyError, &a.NotifyFailedAt)
+	return a, err
+}
+
+// Fire opens an alert or bumps the open one with the same dedup key. A repeated
+// alert is only queued for notification again if the last notification is older
+// than renotifyBefore.
+func (r *AlertsRepo) Fire(ctx context.Context, a models.Alert, renotifyBefore time.Time) (created bool, err error) {
+	err = r.db.QueryRow(ctx, `
+		insert into alerts (dedup_key, charge_point_id, connector_id, kind, severity, message)
+		values ($1,$2,$3,$4,$5,$6)
+		on conflict (dedup_key) where status='Open' do update set
+		  occurrences=alerts.occurrences+1,
+		  last_at=now(),
+		  message=excluded.message,
+		  notify_pending=alerts.notify_pending or alerts.notified_at is null or alerts.notified_at < $7,
+		  notified_sinks=case when alerts.notify_pending then alerts.notified_sinks else '{}' end,
+		  notify_attempts=case when alerts.notify_pending then alerts.notify_attempts else 0 end,
+		  notify_next_at=case when alerts.notify_pending then alerts.notify_next_at end,
+		  notify_error=case when alerts.notify_pending then alerts.notify_error end,
+		  notify_failed_at=case when alerts.notify_pending then alerts.notify_failed_at end
+		returning xmax = 0
+	`, a.DedupKey, a.ChargePointId, a.ConnectorId, a.Kind, a.Severity, a.Message, renotifyBefore).Scan(&created)
+	return created, err
+}
+
+// Resolve closes the open alert with the key. A resolution is only notified if the
+// alert itself was notified (by at least one sink); an alert resolved before delivery
+// is dropped silently.
+func (r *AlertsRepo) Resolve(ctx context.Context, dedupKey string) (bool, error) {
+	tag, err := r.db.Exec(ctx, `
+		update alerts set status='Resolved', resolved_at=now(), last_at=now(),
+		  notify_pending=(notified_at is not null or cardinality(notified_sinks) > 0), notified_sinks='{}',
+		  notify_attempts=0, notify_next_at=null, notify_error=null, notify_failed_at=null
+		where dedup_key=$1 and status='Open'
+	`, dedupKey)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// ListPendingNotifications returns alerts waiting for delivery whose retry is due, oldest
+// first; criticalOnly leaves out the other severities (quiet hours).
+func (r *AlertsRepo) ListPendingNotifications(ctx context.Context, criticalOnly bool, limit int) ([]models.Alert, error) {
+	return r.list(ctx, `where notify_pending and (notify_next_at is null or notify_next_at <= now())
+		and (not $2 or severity='Critical') order by id limit $1`, limit, criticalOnly)
+}
+
+// MarkDeliveryFailed counts a failed delivery of the pending notification of an alert and
+// retries it at nextAt. The attempt that reaches maxAttempts drops the notification and
+// records notify_failed_at instead.
+func (r *AlertsRepo) MarkDeliveryFailed(ctx context.Context, id int64, errMsg string, maxAttempts int, nextAt time.Time) error {
+	_, err := r.db.Exec(ctx, `
+		update alerts set notify_attempts=notify_attempts+1, notify_error=$2,
+		  notify_pending=notify_attempts+1 < $3,
+		  notify_next_at=case when notify_attempts+1 < $3 then $4::timestamptz end,
+		  notify_failed_at=case when notify_attempts+1 >= $3 then now() end
+		where id=$1 and notify_pending
+	`, id, errMsg, maxAttempts, nextAt)
+	return err
+}
+
+// MarkSinkNotified records that sink received the pending notification of an alert.
+func (r *AlertsRepo) MarkSinkNotified(ctx context.Context, id int64, sink string) error {
+	_, err := r.db.Exec(ctx, `
+		update alerts set notified_sinks=array_append(notified_sinks, $2)
+		where id=$1 and notify_pending and not ($2 = any(notified_sinks))
+	`, id, sink)
+	return err
+}
+
+// MarkNotified ends the pending notification of an alert once every sink received it.
+func (r *AlertsRepo) MarkNotified(ctx context.Context, id int64) error {
+	_, err := r.db.Exec(ctx, `
+		update alerts set notify_pending=false, notified_at=now(), notified_sinks='{}',
+		  notify_attempts=0, notify_next_at=null, notify_error=null
+		where id=$1
+	`, id)
+	return err
+}
+
+// List returns alerts, newest first. Empty tenantId/status/cp do not filter.
+func (r *AlertsRepo) List(ctx context.Context, tenantId, status, cp string, limit int) ([]models.Alert, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+	return r.list(ctx, `where ($2 = '' or status=$2) and ($3 = '' or charge_point_id=$3) and `+chargerInTenant("charge_point_id", 4)+
+		` order by last_at desc limit $1`, limit, status, cp, tenantId)
+}
+
+func (r *AlertsRepo) list(ctx context.Context, where string, args ...any) ([]models.Alert, error) {
+	rows, err := r.db.Query(ctx, `select `+alertCols+` from alerts `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.Alert
+	for rows.Next() {
+		a, err := scanAlert(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,49 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"cpms/internal/models"
+)
+
+// APIAuditRepo stores the audit log of mutating management API requests (api_audit_log).
+type APIAuditRepo struct{ db DBTX }
+
+func NewAPIAuditRepo(db DBTX) *APIAuditRepo { return &APIAuditRepo{db: db} }
+
+func (r *APIAuditRepo) Record(ctx context.Context, e models.APIAuditEntry) error {
+	_, err := r.db.Exec(ctx, `
+		insert into api_audit_log (actor, auth_method, http_method, path, route, status, remote_addr, created_at, tenant_id)
+		values ($1,$2,$3,$4,$5,$6,$7,$8,$9::uuid)
+	`, e.Actor, e.AuthMethod, e.HTTPMethod, e.Path, e.Route, e.Status, e.RemoteAddr, e.CreatedAt, e.TenantId)
+	return err
+}
+
+// List returns entries newest first, optionally for one tenant, one actor and/or before a time.
+func (r *APIAuditRepo) List(ctx context.Context, tenantId, actor string, before *time.Time, limit int) ([]models.APIAuditEntry, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	rows, err := r.db.Query(ctx, `
+		select id, tenant_id::text, actor, auth_method, http_method, path, route, status, remote_addr, created_at
+		from api_audit_log
+		where ($1='' or actor=$1) and ($2::timestamptz is null or created_at < $2) and ($4='' or tenant_id::text=$4)
+		order by created_at desc, id desc
+		limit $3
+	`, actor, before, limit, tenantId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.APIAuditEntry
+	for rows.Next() {
+		var e models.APIAuditEntry
+		if err := rows.Scan(&e.Id, &e.TenantId, &e.Actor, &e.AuthMethod, &e.HTTPMethod, &e.Path, &e.Route, &e.Status, &e.RemoteAddr, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,86 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"cpms/internal/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// APIKeysRepo stores management API keys (api_keys).
+type APIKeysRepo struct{ db DBTX }
+
+func NewAPIKeysRepo(db DBTX) *APIKeysRepo { return &APIKeysRepo{db: db} }
+
+const apiKeyCols = `key_id, tenant_id::text, name, key_prefix, roles, created_by, created_at, last_used_at, revoked_at`
+
+func scanAPIKey(row pgx.Row) (models.APIKey, error) {
+	var k models.APIKey
+	err := row.Scan(&k.KeyId, &k.TenantId, &k.Name, &k.KeyPrefix, &k.Roles, &k.CreatedBy, &k.CreatedAt, &k.LastUsedAt, &k.RevokedAt)
+	return k, err
+}
+
+func (r *APIKeysRepo) Create(ctx context.Context, k models.APIKey, keyHash string) (string, error) {
+	row := r.db.QueryRow(ctx, `
+		insert into api_keys (name, key_prefix, key_hash, roles, created_by, tenant_id)
+		values ($1,$2,$3,$4,$5,$6::uuid)
+		returning key_id
+	`, k.Name, k.KeyPrefix, keyHash, k.Roles, k.CreatedBy, k.TenantId)
+	var id string
+	if err := row.Scan(&id); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// GetActiveByHash returns the non-revoked key with the given hash, or nil.
+func (r *APIKeysRepo) GetActiveByHash(ctx context.Context, keyHash string) (*models.APIKey, error) {
+	k, err := scanAPIKey(r.db.QueryRow(ctx, `select `+apiKeyCols+` from api_keys where key_hash=$1 and revoked_at is null`, keyHash))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &k, nil
+}
+
+// List returns keys newest first; a non-empty tenantId returns only that tenant's keys.
+func (r *APIKeysRepo) List(ctx context.Context, tenantId string) ([]models.APIKey, error) {
+	rows, err := r.db.Query(ctx, `select `+apiKeyCols+` from api_keys where ($1 = '' or tenant_id::text=$1) order by created_at desc`, tenantId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.APIKey
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, k)
+	}
+	return out, rows.Err()
+}
+
+// TouchUsed sets last_used_at, at most once a minute per key.
+func (r *APIKeysRepo) TouchUsed(ctx context.Context, id string, at time.Time) error {
+	_, err := r.db.Exec(ctx, `
+		update api_keys set last_used_at=$2
+		where key_id=$1 and (last_used_at is null or last_used_at < $2 - interval '1 minute')
+	`, id, at)
+	return err
+}
+
+// Revoke returns false if the key does not exist or was already revoked.
+func (r *APIKeysRepo) Revoke(ctx context.Context, id string) (bool, error) {
+	tag, err := r.db.Exec(ctx, `update api_keys set revoked_at=now() where key_id::text=$1 and revoked_at is null`, id)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
@@ -0,0 +1,140 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"cpms/internal/models"
+)
+
+// AuthAttemptsRepo audits charger auth attempts (charger_auth_attempts) and keeps the
+// failure counters used for lockouts (auth_lockouts).
+type AuthAttemptsRepo struct{ db DBTX }
+
+func NewAuthAttemptsRepo(db DBTX) *AuthAttemptsRepo { return &AuthAttemptsRepo{db: db} }
+
+// Lockout scopes.
+const (
+	LockoutCharger = "Charger"
+	LockoutIP      = "Ip"
+)
+
+func (r *AuthAttemptsRepo) Record(ctx context.Context, a models.AuthAttemptRecord) error {
+	_, err := r.db.Exec(ctx, `
+		insert into charger_auth_attempts (charge_point_id, remote_addr, outcome, reason, method, created_at)
+		values ($1,$2,$3,$4,$5,$6)
+	`, a.ChargePointId, a.RemoteAddr, a.Outcome, a.Reason, a.Method, a.CreatedAt)
+	return err
+}
+
+// List returns the attempts for a charger, newest first. outcome "" means all.
+func (r *AuthAttemptsRepo) List(ctx context.Context, cp, outcome string, limit int) ([]models.AuthAttemptRecord, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	rows, err := r.db.Query(ctx, `
+		select id, charge_point_id, remote_addr, outcome, reason, method, created_at
+		from charger_auth_attempts
+		where charge_point_id=$1 and ($2='' or outcome=$2)
+		order by created_at desc, id desc
+		limit $3
+	`, cp, outcome, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.AuthAttemptRecord
+	for rows.Next() {
+		var a models.AuthAttemptRecord
+		if err := rows.Scan(&a.Id, &a.ChargePointId, &a.RemoteAddr, &a.Outcome, &a.Reason, &a.Method, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+// LockedUntil returns the latest lockout still in effect at now of the charger for the IP,
+// or of the IP itself, if any.
+func (r *AuthAttemptsRepo) LockedUntil(ctx context.Context, cp, ip string, now time.Time) (*time.Time, error) {
+	row := r.db.QueryRow(ctx, `
+		select max(locked_until) from auth_lockouts
+		where ((scope='Charger' and key=$1 and remote_addr=$2) or (scope='Ip' and key=$2 and $2<>''))
+		  and locked_until > $3
+	`, cp, ip, now)
+	var until *time.Time
+	if err := row.Scan(&until); err != nil {
+		return nil, err
+	}
+	return until, nil
+}
+
+// ChargerLockedUntil returns the latest lockout of the charger for any IP still in effect at now.
+func (r *AuthAttemptsRepo) ChargerLockedUntil(ctx context.Context, cp string, now time.Time) (*time.Time, error) {
+	row := r.db.QueryRow(ctx, `
+		select max(locked_until) from auth_lockouts
+		where scope='Charger' and key=$1 and locked_until > $2
+	`, cp, now)
+	var until *time.Time
+	if err := row.Scan(&until); err != nil {
+		return nil, err
+	}
+	return until, nil
+}
+
+// RegisterFailure counts a failed attempt and returns the number of consecutive failures.
+// Charger counters are kept per remote address (addr); IP counters use addr "".
+// The count restarts when the previous failure is older than resetAfter.
+func (r *AuthAttemptsRepo) RegisterFailure(ctx context.Context, scope, key, addr string, now time.Time, resetAfter time.Duration) (int, error) {
+	row := r.db.QueryRow(ctx, `
+		insert into auth_lockouts (scope, key, remote_addr, failures, last_failure_at)
+		values ($1,$2,$3,1,$4)
+		on conflict (scope, key, remote_addr) do update set
+		  failures = case when auth_lockouts.last_failure_at < $5 then 1 else auth_lockouts.failures + 1 end,
+		  last_failure_at = $4
+		returning failures
+	`, scope, key, addr, now, now.Add(-resetAfter))
+	var n int
+	if err := row.Scan(&n); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (r *AuthAttemptsRepo) Lock(ctx context.Context, scope, key, addr string, until time.Time) error {
+	_, err := r.db.Exec(ctx, `update auth_lockouts set locked_until=$4 where scope=$1 and key=$2 and remote_addr=$3`, scope, key, addr, until)
+	return err
+}
+
+// Reset clears the failure count and lockout of a charger (for addr) or IP.
+func (r *AuthAttemptsRepo) Reset(ctx context.Context, scope, key, addr string) error {
+	_, err := r.db.Exec(ctx, `delete from auth_lockouts where scope=$1 and key=$2 and remote_addr=$3`, scope, key, addr)
+	return err
+}
+
+// Decay takes one failure off the counter of a charger (for addr) or IP; a lockout in
+// effect stays.
+func (r *AuthAttemptsRepo) Decay(ctx context.Context, scope, key, addr string) error {
+	_, err := r.db.Exec(ctx, `
+		update auth_lockouts set failures=failures-1
+		where scope=$1 and key=$2 and remote_addr=$3 and failures > 0
+	`, scope, key, addr)
+	return err
+}
+
+// Prune deletes the attempts recorded before before and the counters whose last failure
+// is older than resetAfter and that no longer lock anything. Returns the attempts deleted.
+func (r *AuthAttemptsRepo) Prune(ctx context.Context, before, now time.Time, resetAfter time.Duration) (int64, error) {
+	tag, err := r.db.Exec(ctx, `delete from charger_auth_attempts where created_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := r.db.Exec(ctx, `
+		delete from auth_lockouts
+		where last_failure_at < $2 and (locked_until is null or locked_until <= $1)
+	`, now, now.Add(-resetAfter)); err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,65 @@
+package repo
+
+import (
+	"context"
+
+	"cpms/internal/models"
+)
+
+// CertTrustRepo stores the client certificates accepted per charger (charger_cert_trust).
+type CertTrustRepo struct{ db DBTX }
+
+func NewCertTrustRepo(db DBTX) *CertTrustRepo { return &CertTrustRepo{db: db} }
+
+// Add inserts a pinned fingerprint or a CA/CN entry. Adding a pin that already exists
+// returns the existing entry.
+func (r *CertTrustRepo) Add(ctx context.Context, t models.CertTrust) (string, error) {
+	row := r.db.QueryRow(ctx, `
+		with ins as (
+		  insert into charger_cert_trust (charge_point_id, fingerprint, ca_pem, expected_cn, label)
+		  values ($1,$2,$3,$4,$5)
+		  on conflict (charge_point_id, fingerprint) where fingerprint is not null do nothing
+		  returning trust_id
+		)
+		select trust_id from ins
+		union all
+		select trust_id from charger_cert_trust where charge_point_id=$1 and fingerprint=$2
+		limit 1
+	`, t.ChargePointId, t.Fingerprint, t.CAPEM, t.ExpectedCN, t.Label)
+	var id string
+	if err := row.Scan(&id); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+func (r *CertTrustRepo) List(ctx context.Context, cp string) ([]models.CertTrust, error) {
+	rows, err := r.db.Query(ctx, `
+		select trust_id, charge_point_id, fingerprint, ca_pem, expected_cn, label, created_at
+		from charger_cert_trust where charge_point_id=$1
+		order by created_at
+	`, cp)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.CertTrust
+	for rows.Next() {
+		var t models.CertTrust
+		if err := rows.Scan(&t.TrustId, &t.ChargePointId, &t.Fingerprint, &t.CAPEM, &t.ExpectedCN, &t.Label, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+// Delete removes a trust entry. Returns false if it does not exist.
+func (r *CertTrustRepo) Delete(ctx context.Context, cp, id string) (bool, error) {
+	tag, err := r.db.Exec(ctx, `delete from charger_cert_trust where charge_point_id=$1 and trust_id::text=$2`, cp, id)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
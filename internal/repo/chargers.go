@@ -8,37 +8,75 @@ import (
 	"cpms/internal/models"
 
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-type ChargersRepo struct{ db *pgxpool.Pool }
+type ChargersRepo struct{ db DBTX }
 
-func NewChargersRepo(db *pgxpool.Pool) *ChargersRepo { return &ChargersRepo{db: db} }
+func NewChargersRepo(db DBTX) *ChargersRepo { return &ChargersRepo{db: db} }
 
 func (r *ChargersRepo) Upsert(ctx context.Context, c models.Charger) error {
 	_, err := r.db.Exec(ctx, `
-		insert into chargers (charge_point_id, secret_hash, is_active, vendor, model, ocpp_version)
-		values ($1,$2,$3,$4,$5,$6)
+		insert into chargers (charge_point_id, is_active, vendor, model, ocpp_version, registration_status, registration_changed_at, tenant_id)
+		values ($1,$2,$3,$4,$5,coalesce(nullif($6,''),'Pending'),now(),$7::uuid)
 		on conflict (charge_point_id) do update set
-		  secret_hash=excluded.secret_hash,
 		  is_active=excluded.is_active,
+		  tenant_id=coalesce(excluded.tenant_id, chargers.tenant_id),
 		  vendor=excluded.vendor,
 		  model=excluded.model,
 		  ocpp_version=excluded.ocpp_version,
+		  registration_status=excluded.registration_status,
+		  registration_changed_at=case when chargers.registration_status=excluded.registration_status
+		    then chargers.registration_changed_at else now() end,
 		  updated_at=now()
-	`, c.ChargePointId, c.SecretHash, c.IsActive, c.Vendor, c.Model, c.OcppVersion)
+	`, c.ChargePointId, c.IsActive, c.Vendor, c.Model, c.OcppVersion, c.RegistrationStatus, c.TenantId)
 	return err
 }
 
-func (r *ChargersRepo) Get(ctx context.Context, id string) (*models.Charger, error) {
-	row := r.db.QueryRow(ctx, `
-		select charge_point_id, secret_hash, is_active, coalesce(vendor,''), coalesce(model,''), coalesce(ocpp_version,'1.6J'),
-		       created_at, updated_at, last_seen_at
-		from chargers where charge_point_id=$1
-	`, id)
+// EnsureExists inserts an unprovisioned (Pending, inactive) charger unless it is already known,
+// so events of a charger that boots before being provisioned can be stored. tenantId ""
+// leaves the new charger without a tenant until it is approved.
+func (r *ChargersRepo) EnsureExists(ctx context.Context, id, tenantId string) error {
+	_, err := r.db.Exec(ctx, `
+		insert into chargers (charge_point_id, tenant_id) values ($1, nullif($2,'')::uuid)
+		on conflict (charge_point_id) do nothing
+	`, id, tenantId)
+	return err
+}
+
+// RecordBoot stores the boot details of a charger that is not provisioned yet, and puts a
+// Rejected charger booting after its rejection back to Pending. Accepted and Blocked chargers are left as they are.
+func (r *ChargersRepo) RecordBoot(ctx context.Context, id, vendor, model, ocppVersion string, ts time.Time) error {
+	_, err := r.db.Exec(ctx, `
+		update chargers set
+		  vendor=coalesce(nullif($2,''), vendor),
+		  model=coalesce(nullif($3,''), model),
+		  ocpp_version=coalesce(nullif($4,''), ocpp_version),
+		  registration_status=case when registration_status='Rejected' then 'Pending' else registration_status end,
+		  registration_changed_at=case when registration_status='Rejected' then now() else registration_changed_at end,
+		  updated_at=now()
+		where charge_point_id=$1
+		  and (registration_status='Pending' or (registration_status='Rejected' and registration_changed_at < $5))
+	`, id, vendor, model, ocppVersion, ts)
+	return err
+}
+
+const chargerCols = `charge_point_id, is_active, coalesce(vendor,''), coalesce(model,''), coalesce(ocpp_version,'1.6J'),
+	site_id::text, created_at, updated_at, last_seen_at, heartbeat_interval_seconds, connectivity_state, connectivity_changed_at,
+	registration_status, registration_changed_at, security_profile, tenant_id::text, tags`
 
+func scanCharger(row pgx.Row) (models.Charger, error) {
 	var c models.Charger
-	if err := row.Scan(&c.ChargePointId, &c.SecretHash, &c.IsActive, &c.Vendor, &c.Model, &c.OcppVersion, &c.CreatedAt, &c.UpdatedAt, &c.LastSeenAt); err != nil {
+	err := row.Scan(&c.ChargePointId, &c.IsActive, &c.Vendor, &c.Model, &c.OcppVersion, &c.SiteId, &c.CreatedAt, &c.UpdatedAt, &c.LastSeenAt,
+		&c.HeartbeatIntervalSeconds, &c.ConnectivityState, &c.ConnectivityChangedAt, &c.RegistrationStatus, &c.RegistrationChangedAt,
+		&c.SecurityProfile, &c.TenantId, &c.Tags)
+	return c, err
+}
+
+func (r *ChargersRepo) Get(ctx context.Context, id string) (*models.Charger, error) {
+	row := r.db.QueryRow(ctx, `select `+chargerCols+` from chargers where charge_point_id=$1`, id)
+
+	c, err := scanCharger(row)
+	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, nil
 		}
@@ -47,11 +85,247 @@ func (r *ChargersRepo) Get(ctx context.Context, id string) (*models.Charger, err
 	return &c, nil
 }
 
+// TouchLastSeen never moves last_seen_at backwards (late/backfilled events).
 func (r *ChargersRepo) TouchLastSeen(ctx context.Context, id string, t time.Time) error {
-	_, err := r.db.Exec(ctx, `update chargers set last_seen_at=$2, updated_at=now() where charge_point_id=$1`, id, t)
+	_, err := r.db.Exec(ctx, `update chargers set last_seen_at=greatest(last_seen_at, $2), updated_at=now() where charge_point_id=$1`, id, t)
+	return err
+}
+
+// SetHeartbeatInterval stores the heartbeat interval the charger was given at boot.
+func (r *ChargersRepo) SetHeartbeatInterval(ctx context.Context, id string, seconds int) error {
+	_, err := r.db.Exec(ctx, `update chargers set heartbeat_interval_seconds=$2, updated_at=now() where charge_point_id=$1`, id, seconds)
 	return err
 }
 
+// ListForConnectivity returns all provisioned chargers that were seen at least once.
+func (r *ChargersRepo) ListForConnectivity(ctx context.Context) ([]models.Charger, error) {
+	rows, err := r.db.Query(ctx, `select `+chargerCols+` from chargers where last_seen_at is not null and registration_status='Accepted' order by charge_point_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.Charger
+	for rows.Next() {
+		c, err := scanCharger(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// SetConnectivityState moves a charger from one connectivity state to another and records
+// the change in charger_state_events. Returns false if the charger was not in state from.
+func (r *ChargersRepo) SetConnectivityState(ctx context.Context, id, from, to string, lastSeenAt *time.Time) (bool, error) {
+	tag, err := r.db.Exec(ctx, `
+		with u as (
+		  update chargers set connectivity_state=$3, connectivity_changed_at=now()
+		  where charge_point_id=$1 and connectivity_state=$2
+		  returning charge_point_id
+		)
+		insert into charger_state_events (charge_point_id, from_state, to_state, last_seen_at)
+		select charge_point_id, $2, $3, $4 from u
+	`, id, from, to, lastSeenAt)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// ListStateEvents returns the connectivity changes of a charger, newest first.
+func (r *ChargersRepo) ListStateEvents(ctx context.Context, id string, limit int) ([]models.ChargerStateEvent, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+	rows, err := r.db.Query(ctx, `
+		select id, charge_point_id, from_state, to_state, last_seen_at, created_at
+		from charger_state_events where charge_point_id=$1
+		order by created_at desc, id desc
+		limit $2
+	`, id, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.ChargerStateEvent
+	for rows.Next() {
+		var e models.ChargerStateEvent
+		if err := rows.Scan(&e.Id, &e.ChargePointId, &e.FromState, &e.ToState, &e.LastSeenAt, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// Create inserts a provisioned charger with its first credential. Returns false if the
+// charge point already exists.
+func (r *ChargersRepo) Create(ctx context.Context, c models.Charger, secretHash string) (bool, error) {
+	tag, err := r.db.Exec(ctx, `
+		with c as (
+		  insert into chargers (charge_point_id, is_active, vendor, model, ocpp_version, site_id, security_profile, tenant_id, registration_status, registration_changed_at)
+		  values ($1,$2,$3,$4,coalesce(nullif($5,''),'1.6J'),$6::uuid,greatest($8,1),$9::uuid,'Accepted',now())
+		  on conflict (charge_point_id) do nothing
+		  returning charge_point_id
+		)
+		insert into charger_credentials (charge_point_id, secret_hash)
+		select charge_point_id, $7 from c
+	`, c.ChargePointId, c.IsActive, c.Vendor, c.Model, c.OcppVersion, c.SiteId, secretHash, c.SecurityProfile, c.TenantId)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// ChargerUpdate holds the fields to change; nil fields are left as they are.
+// An empty SiteId removes the charger from its site; Tags replaces all tags.
+type ChargerUpdate struct {
+	Vendor          *string
+	Model           *string
+	OcppVersion     *string
+	SiteId          *string
+	SecurityProfile *int
+	Tags            []string // nil: unchanged
+}
+
+// Update applies u to a charger. Returns false if the charger does not exist.
+func (r *ChargersRepo) Update(ctx context.Context, id string, u ChargerUpdate) (bool, error) {
+	tag, err := r.db.Exec(ctx, `
+		update chargers set
+		  vendor=coalesce($2, vendor),
+		  model=coalesce($3, model),
+		  ocpp_version=coalesce($4, ocpp_version),
+		  site_id=case when $5::text is null then site_id when $5='' then null else $5::uuid end,
+		  security_profile=coalesce($6, security_profile),
+		  tags=coalesce($7, tags),
+		  updated_at=now()
+		where charge_point_id=$1
+	`, id, u.Vendor, u.Model, u.OcppVersion, u.SiteId, u.SecurityProfile, u.Tags)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// SetActive activates or deactivates a charger. Returns false if the charger does not exist.
+func (r *ChargersRepo) SetActive(ctx context.Context, id string, active bool) (bool, error) {
+	tag, err := r.db.Exec(ctx, `update chargers set is_active=$2, updated_at=now() where charge_point_id=$1`, id, active)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// Delete removes a charger that has no sessions and, through the foreign keys, its events
+// and state. Returns found=false if the charger does not exist and deleted=false if it has
+// sessions: those (and their settlements) are billing records and are never deleted here.
+func (r *ChargersRepo) Delete(ctx context.Context, id string) (found, deleted bool, err error) {
+	var n, d int
+	err = r.db.QueryRow(ctx, `
+		with c as (
+		  select charge_point_id, exists (select 1 from sessions s where s.charge_point_id=$1) as has_sessions
+		  from chargers where charge_point_id=$1
+		  for update
+		), d as (
+		  delete from chargers where charge_point_id in (select charge_point_id from c where not has_sessions)
+		  returning 1
+		)
+		select (select count(*) from c), (select count(*) from d)
+	`, id).Scan(&n, &d)
+	return n == 1, d == 1, err
+}
+
+// ChargerFilter narrows List; empty fields match everything.
+type ChargerFilter struct {
+	TenantId           string
+	SiteId             string
+	RegistrationStatus string
+	Active             *bool
+	Vendor             string
+	ConnectivityState  string
+	Search             string   // substring of charge_point_id, vendor or model (case-insensitive)
+	Tags               []string // chargers having all of these tags
+}
+
+// List returns chargers matching f ordered by charge_point_id, starting after the
+// charge point after (keyset pagination).
+func (r *ChargersRepo) List(ctx context.Context, f ChargerFilter, after string, limit int) ([]models.Charger, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+	rows, err := r.db.Query(ctx, `
+		select `+chargerCols+` from chargers
+		where ($1 = '' or site_id::text=$1)
+		  and ($2 = '' or registration_status=$2)
+		  and ($3::boolean is null or is_active=$3)
+		  and ($4 = '' or lower(vendor)=lower($4))
+		  and ($5 = '' or connectivity_state=$5)
+		  and ($6 = '' or strpos(lower(charge_point_id||' '||coalesce(vendor,'')||' '||coalesce(model,'')), lower($6)) > 0)
+		  and charge_point_id > $7
+		  and ($9 = '' or tenant_id::text=$9)
+		  and ($10::text[] is null or tags @> $10)
+		order by charge_point_id
+		limit $8
+	`, f.SiteId, f.RegistrationStatus, f.Active, f.Vendor, f.ConnectivityState, f.Search, after, limit, f.TenantId, f.Tags)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.Charger
+	for rows.Next() {
+		c, err := scanCharger(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// ChargerSelector picks the chargers of a bulk command job; set fields combine with AND.
+type ChargerSelector struct {
+	TenantId       string
+	SiteId         string
+	Vendor         string
+	Model          string
+	Tags           []string // chargers having all of these tags
+	ChargePointIds []string
+}
+
+// Select returns up to limit active, accepted chargers matching sel, ordered by charge_point_id.
+func (r *ChargersRepo) Select(ctx context.Context, sel ChargerSelector, limit int) ([]models.Charger, error) {
+	rows, err := r.db.Query(ctx, `
+		select `+chargerCols+` from chargers
+		where is_active and registration_status='Accepted'
+		  and ($1 = '' or tenant_id::text=$1)
+		  and ($2 = '' or site_id::text=$2)
+		  and ($3 = '' or lower(vendor)=lower($3))
+		  and ($4 = '' or lower(model)=lower($4))
+		  and ($5::text[] is null or tags @> $5)
+		  and ($6::text[] is null or charge_point_id = any($6))
+		order by charge_point_id
+		limit $7
+	`, sel.TenantId, sel.SiteId, sel.Vendor, sel.Model, sel.Tags, sel.ChargePointIds, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.Charger
+	for rows.Next() {
+		c, err := scanCharger(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
 func (r *ChargersRepo) SetSite(ctx context.Context, chargePointId string, siteId string) error {
 	_, err := r.db.Exec(ctx, `update chargers set site_id=$2, updated_at=now() where charge_point_id=$1`, chargePointId, siteId)
 	return err
@@ -65,3 +339,35 @@ func (r *ChargersRepo) GetSiteID(ctx context.Context, chargePointId string) (str
 	}
 	return site, nil
 }
+
+// ReportCharger is the charger data needed by availability reports.
+type ReportCharger struct {
+	ChargePointId string
+	SiteId        *string
+	TenantId      *string
+	CreatedAt     time.Time
+}
+
+// ListForReport returns chargers, optionally limited to a tenant, one site and/or charge point.
+func (r *ChargersRepo) ListForReport(ctx context.Context, tenantId, siteId, cp string) ([]ReportCharger, error) {
+	rows, err := r.db.Query(ctx, `
+		select charge_point_id, site_id::text, tenant_id::text, created_at
+		from chargers
+		where ($1 = '' or site_id::text=$1) and ($2 = '' or charge_point_id=$2) and ($3 = '' or tenant_id::text=$3)
+		order by charge_point_id
+	`, siteId, cp, tenantId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []ReportCharger
+	for rows.Next() {
+		var c ReportCharger
+		if err := rows.Scan(&c.ChargePointId, &c.SiteId, &c.TenantId, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
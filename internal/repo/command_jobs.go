@@ -0,0 +1,350 @@
+package repo
+
+import (
+	"context"
+	"errors"
+
+	"cpms/internal/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type CommandJobsRepo struct{ db DBTX }
+
+func NewCommandJobsRepo(db DBTX) *CommandJobsRepo { return &CommandJobsRepo{db: db} }
+
+const commandJobCols = `job_id, tenant_id::text, type, payload, selector, status, concurrency, rate_per_minute,
+	created_by, created_at, updated_at, finished_at`
+
+func scanCommandJob(row pgx.Row) (models.CommandJob, error) {
+	var j models.CommandJob
+	err := row.Scan(&j.JobId, &j.TenantId, &j.Type, &j.PayloadJSON, &j.SelectorJSON, &j.Status, &j.Concurrency, &j.RatePerMinute,
+		&j.CreatedBy, &j.CreatedAt, &j.UpdatedAt, &j.FinishedAt)
+	return j, err
+}
+
+// CommandJobTarget is a charger selected for a job; a target with a Skip reason gets no command.
+type CommandJobTarget struct {
+	ChargePointId string
+	Skip          string
+}
+
+// Create stores a Running job with one item per target.
+func (r *CommandJobsRepo) Create(ctx context.Context, j models.CommandJob, targets []CommandJobTarget) (string, error) {
+	cps := make([]string, len(targets))
+	skips := make([]string, len(targets))
+	for i, t := range targets {
+		cps[i], skips[i] = t.ChargePointId, t.Skip
+	}
+	var id string
+	err := r.db.QueryRow(ctx, `
+		with j as (
+		  insert into command_jobs (tenant_id, type, payload, selector, concurrency, rate_per_minute, created_by)
+		  values ($1::uuid, $2, $3, $4, $5, $6, $7)
+		  returning job_id
+		), i as (
+		  insert into command_job_items (job_id, charge_point_id, status, error)
+		  select j.job_id, t.cp, case when t.skip='' then 'Pending' else 'Skipped' end, nullif(t.skip,'')
+		  from j, unnest($8::text[], $9::text[]) as t(cp, skip)
+		)
+		select job_id::text from j
+	`, j.TenantId, j.Type, j.PayloadJSON, j.SelectorJSON, j.Concurrency, j.RatePerMinute, j.CreatedBy, cps, skips).Scan(&id)
+	return id, err
+}
+
+func (r *CommandJobsRepo) Get(ctx context.Context, id string) (*models.CommandJob, error) {
+	j, err := scanCommandJob(r.db.QueryRow(ctx, `select `+commandJobCols+` from command_jobs where job_id::text=$1`, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &j, nil
+}
+
+// List returns jobs newest first; a tenant only sees its own jobs.
+func (r *CommandJobsRepo) List(ctx context.Context, tenantId, status string, limit int) ([]models.CommandJob, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+	rows, err := r.db.Query(ctx, `
+		select `+commandJobCols+` from command_jobs
+		where ($1 = '' or tenant_id::text=$1) and ($2 = '' or status=$2)
+		order by created_at desc
+		limit $3
+	`, tenantId, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.CommandJob
+	for rows.Next() {
+		j, err := scanCommandJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, j)
+	}
+	return out, rows.Err()
+}
+
+// ListRunning returns the jobs the runner submits commands for, oldest first.
+func (r *CommandJobsRepo) ListRunning(ctx context.Context) ([]models.CommandJob, error) {
+	rows, err := r.db.Query(ctx, `select `+commandJobCols+` from command_jobs where status='Running' order by created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.CommandJob
+	for rows.Next() {
+		j, err := scanCommandJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, j)
+	}
+	return out, rows.Err()
+}
+
+// ItemCounts returns the number of items of a job per item status.
+func (r *CommandJobsRepo) ItemCounts(ctx context.Context, jobId string) (map[string]int, error) {
+	rows, err := r.db.Query(ctx, `select status, count(*) from command_job_items where job_id::text=$1 group by status`, jobId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := map[string]int{}
+	for rows.Next() {
+		var status string
+		var n int
+		if err := rows.Scan(&status, &n); err != nil {
+			return nil, err
+		}
+		out[status] = n
+	}
+	return out, rows.Err()
+}
+
+// ListItems returns the items of a job ordered by charge point, starting after the
+// charge point after (keyset pagination).
+func (r *CommandJobsRepo) ListItems(ctx context.Context, jobId, status, after string, limit int) ([]models.CommandJobItem, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+	rows, err := r.db.Query(ctx, `
+		select i.job_id::text, i.charge_point_id, i.status, i.command_id::text, c.status, i.error, i.updated_at
+		from command_job_items i
+		left join commands c using (command_id)
+		where i.job_id::text=$1 and ($2 = '' or i.status=$2) and i.charge_point_id > $3
+		order by i.charge_point_id
+		limit $4
+	`, jobId, status, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.CommandJobItem
+	for rows.Next() {
+		var it models.CommandJobItem
+		if err := rows.Scan(&it.JobId, &it.ChargePointId, &it.Status, &it.CommandId, &it.CommandStatus, &it.Error, &it.UpdatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, it)
+	}
+	return out, rows.Err()
+}
+
+// SetStatus moves a job from one of the statuses in from to to. Returns false if the job
+// does not exist or is in another status.
+func (r *CommandJobsRepo) SetStatus(ctx context.Context, id string, from []string, to string) (bool, error) {
+	tag, err := r.db.Exec(ctx, `
+		update command_jobs set status=$3, updated_at=now()
+		where job_id::text=$1 and status = any($2)
+	`, id, from, to)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// Abort stops a Running or Paused job: its Pending items are Aborted and its commands
+// still Queued are Cancelled. Commands already sent run to their end. Returns false if
+// the job does not exist or already finished.
+//
+// The status changes first: it waits for a SubmitItem in progress, and the commands are
+// cancelled by a later statement that sees the command it created.
+func (r *CommandJobsRepo) Abort(ctx context.Context, id string) (bool, error) {
+	aborted := false
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, `
+			update command_jobs set status='Aborted', updated_at=now(), finished_at=now()
+			where job_id::text=$1 and status in ('Running','Paused')
+		`, id)
+		if err != nil || tag.RowsAffected() == 0 {
+			return err
+		}
+		aborted = true
+		_, err = tx.Exec(ctx, `
+			with i as (
+			  update command_job_items set status='Aborted', updated_at=now()
+			  where job_id::text=$1 and status='Pending'
+			)
+			update commands set status='Cancelled', locked_at=null, completed_at=now(), updated_at=now()
+			where status='Queued'
+			  and command_id in (select command_id from command_job_items where job_id::text=$1)
+		`, id)
+		return err
+	})
+	return aborted, err
+}
+
+// SyncItems copies the outcome of finished commands to their Running items and returns
+// how many items finished. A command is finished once Completed, NoEffect, Failed,
+// Expired or Cancelled, or Acked when no effect is tracked for it. Items claimed but
+// left without a command (the runner stopped in between) go back to Pending (Aborted in
+// an aborted job).
+func (r *CommandJobsRepo) SyncItems(ctx context.Context) (int64, error) {
+	tag, err := r.db.Exec(ctx, `
+		update command_job_items i set
+		  status=case c.status when 'Completed' then 'Succeeded' when 'Acked' then 'Succeeded'
+		    when 'NoEffect' then 'NoEffect' when 'Cancelled' then 'Aborted' else 'Failed' end,
+		  error=case when c.status in ('Failed','Expired') then coalesce(c.error, c.status) end,
+		  updated_at=now()
+		from commands c
+		where i.command_id=c.command_id and i.status='Running'
+		  and (c.status in ('Completed','NoEffect','Failed','Expired','Cancelled')
+		    or (c.status='Acked' and c.effect_event is null))
+	`)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := r.db.Exec(ctx, `
+		update command_job_items i set status=case when j.status='Aborted' then 'Aborted' else 'Pending' end, updated_at=now()
+		from command_jobs j
+		where j.job_id=i.job_id and i.status='Running' and i.command_id is null and i.updated_at < now() - interval '1 minute'
+	`); err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// CountRunning returns how many items of a job have a command in flight.
+func (r *CommandJobsRepo) CountRunning(ctx context.Context, jobId string) (int, error) {
+	var n int
+	err := r.db.QueryRow(ctx, `select count(*) from command_job_items where job_id::text=$1 and status='Running'`, jobId).Scan(&n)
+	return n, err
+}
+
+// ClaimPending marks up to n Pending items of a job as Running and returns their charge
+// points. Claims alternate between the chargers' gateways (the tenant's gateway_base_url,
+// else defaultGateway) so a slow gateway does not hold up the others; chargers on the
+// gateways in skipGateways are left Pending.
+func (r *CommandJobsRepo) ClaimPending(ctx context.Context, jobId string, n int, skipGateways []string, defaultGateway string) ([]string, error) {
+	rows, err := r.db.Query(ctx, `
+		update command_job_items set status='Running', updated_at=now()
+		where job_id::text=$1 and charge_point_id in (
+		  select charge_point_id from command_job_items
+		  where job_id::text=$1 and status='Pending' and charge_point_id in (
+		    select p.charge_point_id from (
+		      select i.charge_point_id,
+		        row_number() over (partition by coalesce(t.gateway_base_url, $4) order by i.charge_point_id) as turn
+		      from command_job_items i
+		      join chargers c using (charge_point_id)
+		      left join tenants t on t.tenant_id=c.tenant_id
+		      where i.job_id::text=$1 and i.status='Pending'
+		        and not coalesce(t.gateway_base_url, $4) = any(coalesce($3::text[], '{}'))
+		      order by turn, i.charge_point_id
+		      limit $2
+		    ) p
+		  )
+		  for update skip locked
+		)
+		returning charge_point_id
+	`, jobId, n, skipGateways, defaultGateway)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var cp string
+		if err := rows.Scan(&cp); err != nil {
+			return nil, err
+		}
+		out = append(out, cp)
+	}
+	return out, rows.Err()
+}
+
+// ReleaseItem puts a claimed item back to Pending, or Aborted if the job was aborted meanwhile.
+func (r *CommandJobsRepo) ReleaseItem(ctx context.Context, jobId, chargePointId string) error {
+	_, err := r.db.Exec(ctx, `
+		update command_job_items i set status=case when j.status='Aborted' then 'Aborted' else 'Pending' end, updated_at=now()
+		from command_jobs j
+		where j.job_id=i.job_id and i.job_id::text=$1 and i.charge_point_id=$2 and i.status='Running' and i.command_id is null
+	`, jobId, chargePointId)
+	return err
+}
+
+// SubmitItem creates the command of a claimed item (or reuses the one with the same
+// idempotency key) and links it, provided the job is still Running; otherwise the item
+// goes back to Pending and ok is false. The job stays locked until the command is linked,
+// so a pause or abort waits for it.
+func (r *CommandJobsRepo) SubmitItem(ctx context.Context, jobId string, c models.Command) (commandId string, ok bool, err error) {
+	err = pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		var status string
+		if err := tx.QueryRow(ctx, `select status from command_jobs where job_id::text=$1 for share`, jobId).Scan(&status); err != nil {
+			return err
+		}
+		if status != "Running" {
+			return NewCommandJobsRepo(tx).ReleaseItem(ctx, jobId, c.ChargePointId)
+		}
+		commands := NewCommandsRepo(tx)
+		existing, err := commands.GetByIdempotency(ctx, c.IdempotencyKey)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			commandId = existing.CommandId
+		} else if commandId, err = commands.Create(ctx, c); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `
+			update command_job_items set command_id=$3::uuid, updated_at=now()
+			where job_id::text=$1 and charge_point_id=$2
+		`, jobId, c.ChargePointId, commandId); err != nil {
+			return err
+		}
+		ok = true
+		return nil
+	})
+	return commandId, ok, err
+}
+
+// SkipItem finishes a claimed item without a command.
+func (r *CommandJobsRepo) SkipItem(ctx context.Context, jobId, chargePointId, reason string) error {
+	_, err := r.db.Exec(ctx, `
+		update command_job_items set status='Skipped', error=$3, updated_at=now()
+		where job_id::text=$1 and charge_point_id=$2
+	`, jobId, chargePointId, reason)
+	return err
+}
+
+// FinishIfDone completes a Running job without Pending or Running items.
+func (r *CommandJobsRepo) FinishIfDone(ctx context.Context, jobId string) (bool, error) {
+	tag, err := r.db.Exec(ctx, `
+		update command_jobs j set status='Completed', updated_at=now(), finished_at=now()
+		where j.job_id::text=$1 and j.status='Running'
+		  and not exists (select 1 from command_job_items i where i.job_id=j.job_id and i.status in ('Pending','Running'))
+	`, jobId)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
@@ -3,23 +3,51 @@ package repo
 import (
 	"context"
 	"errors"
+	"time"
 
 	"cpms/internal/models"
 
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-type CommandsRepo struct{ db *pgxpool.Pool }
+type CommandsRepo struct{ db DBTX }
 
-func NewCommandsRepo(db *pgxpool.Pool) *CommandsRepo { return &CommandsRepo{db: db} }
+func NewCommandsRepo(db DBTX) *CommandsRepo { return &CommandsRepo{db: db} }
 
+const commandCols = `command_id, charge_point_id, type, idempotency_key, payload, status, response, error, attempts, next_attempt_at, expires_at, completed_at, created_at, updated_at,
+  effect_event, effect_connector_id, effect_evse_id, effect_id_tag, effect_transaction_id, effect_statuses, effect_from_statuses,
+  effect_deadline, effect_event_id, effect_session_id::text, effect_at`
+
+func scanCommand(row pgx.Row) (models.Command, error) {
+	var c models.Command
+	var effectEvent *string
+	var e models.CommandEffect
+	err := row.Scan(&c.CommandId, &c.ChargePointId, &c.Type, &c.IdempotencyKey, &c.PayloadJSON, &c.Status, &c.ResponseJSON, &c.Error, &c.Attempts, &c.NextAttemptAt, &c.ExpiresAt, &c.CompletedAt, &c.CreatedAt, &c.UpdatedAt,
+		&effectEvent, &e.ConnectorId, &e.EvseId, &e.IdTag, &e.TransactionId, &e.Statuses, &e.FromStatuses,
+		&c.EffectDeadline, &c.EffectEventId, &c.EffectSessionId, &c.EffectAt)
+	if effectEvent != nil {
+		e.Event = *effectEvent
+		c.Effect = &e
+	}
+	return c, err
+}
+
+// Create stores a command for the dispatcher (status Queued) until c.ExpiresAt. A command
+// created as Sent is dispatched by the caller itself and never picked up by the dispatcher.
+// c.Effect, if set, is the event MatchEffect looks for once the command is acked.
 func (r *CommandsRepo) Create(ctx context.Context, c models.Command) (string, error) {
+	e := c.Effect
+	if e == nil {
+		e = &models.CommandEffect{}
+	}
 	row := r.db.QueryRow(ctx, `
-        insert into commands (charge_point_id, type, idempotency_key, payload, status)
-        values ($1,$2,$3,$4,$5)
+        insert into commands (charge_point_id, type, idempotency_key, payload, status, expires_at, locked_at, attempts,
+          effect_event, effect_connector_id, effect_evse_id, effect_id_tag, effect_transaction_id, effect_statuses, effect_from_statuses)
+        values ($1,$2,$3,$4,$5,$6, case when $5='Sent' then now() end, case when $5='Sent' then 1 else 0 end,
+          nullif($7,''),$8,$9,$10,$11,$12,$13)
         returning command_id
-    `, c.ChargePointId, c.Type, c.IdempotencyKey, c.PayloadJSON, c.Status)
+    `, c.ChargePointId, c.Type, c.IdempotencyKey, c.PayloadJSON, c.Status, c.ExpiresAt,
+		e.Event, e.ConnectorId, e.EvseId, e.IdTag, e.TransactionId, e.Statuses, e.FromStatuses)
 
 	var id string
 	if err := row.Scan(&id); err != nil {
@@ -28,14 +56,51 @@ func (r *CommandsRepo) Create(ctx context.Context, c models.Command) (string, er
 	return id, nil
 }
 
+func (r *CommandsRepo) Get(ctx context.Context, id string) (*models.Command, error) {
+	c, err := scanCommand(r.db.QueryRow(ctx, `select `+commandCols+` from commands where command_id::text=$1`, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &c, nil
+}
+
 func (r *CommandsRepo) GetByIdempotency(ctx context.Context, idem string) (*models.Command, error) {
+	c, err := scanCommand(r.db.QueryRow(ctx, `select `+commandCols+` from commands where idempotency_key=$1`, idem))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &c, nil
+}
+
+// ClaimNext marks the oldest due command as Sent, unless an earlier command of the same
+// charger is still open, so commands reach a charger in the order they were created.
+// Attempts stuck in Sent longer than lease are reclaimed. Returns nil when nothing is due.
+func (r *CommandsRepo) ClaimNext(ctx context.Context, lease time.Duration) (*models.Command, error) {
 	row := r.db.QueryRow(ctx, `
-        select command_id, charge_point_id, type, idempotency_key, payload, status, response, error, created_at, updated_at
-        from commands where idempotency_key=$1
-    `, idem)
+        update commands set status='Sent', attempts=attempts+1, locked_at=now(), updated_at=now()
+        where command_id = (
+          select c.command_id from commands c
+          where ((c.status='Queued' and c.next_attempt_at<=now())
+              or (c.status='Sent' and c.locked_at < now() - $1 * interval '1 second'))
+            and c.expires_at > now()
+            and not exists (
+              select 1 from commands p
+              where p.charge_point_id=c.charge_point_id and p.created_at<c.created_at
+                and p.status in ('Queued','Sent'))
+          order by c.next_attempt_at, c.created_at
+          limit 1
+          for update skip locked
+        )
+        returning `+commandCols, int64(lease.Seconds()))
 
-	var c models.Command
-	if err := row.Scan(&c.CommandId, &c.ChargePointId, &c.Type, &c.IdempotencyKey, &c.PayloadJSON, &c.Status, &c.ResponseJSON, &c.Error, &c.CreatedAt, &c.UpdatedAt); err != nil {
+	c, err := scanCommand(row)
+	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, nil
 		}
@@ -44,17 +109,228 @@ func (r *CommandsRepo) GetByIdempotency(ctx context.Context, idem string) (*mode
 	return &c, nil
 }
 
-func (r *CommandsRepo) MarkSent(ctx context.Context, id string) error {
-	_, err := r.db.Exec(ctx, `update commands set status='Sent', updated_at=now() where command_id=$1`, id)
+// MarkAcked records the gateway's answer. Commands with an expected effect wait for it
+// until now + effectWindow; one already Completed by an early event stays Completed. A
+// transition effect (effect_from_statuses) whose connector already shows a target status
+// completes right away: the charger has nothing to change and reports nothing.
+func (r *CommandsRepo) MarkAcked(ctx context.Context, id string, response []byte, effectWindow time.Duration) error {
+	_, err := r.db.Exec(ctx, `
+        with c as (
+          select c.command_id, c.status='Completed' or (c.effect_from_statuses is not null and exists (
+            select 1 from connector_state s
+            where s.charge_point_id=c.charge_point_id and s.connector_id=c.effect_connector_id
+              and s.status = any(c.effect_statuses)
+          )) as done
+          from commands c where c.command_id=$1
+        )
+        update commands set status=case when c.done then 'Completed' else 'Acked' end,
+          response=$2, locked_at=null, completed_at=now(), updated_at=now(),
+          effect_at=case when c.done then coalesce(effect_at, now()) end,
+          effect_deadline=case when effect_event is not null then now() + $3 * interval '1 second' end
+        from c
+        where commands.command_id=c.command_id
+    `, id, response, int64(effectWindow.Seconds()))
 	return err
 }
 
-func (r *CommandsRepo) MarkAcked(ctx context.Context, id string, response []byte) error {
-	_, err := r.db.Exec(ctx, `update commands set status='Acked', response=$2, updated_at=now() where command_id=$1`, id, response)
+// MarkRetry puts the command back to Queued until nextAttemptAt. MarkRetry, MarkFailed
+// and MarkExpired only touch a Sent command: one whose effect was observed in the
+// meantime stays Completed.
+func (r *CommandsRepo) MarkRetry(ctx context.Context, id string, errMsg string, nextAttemptAt time.Time) error {
+	_, err := r.db.Exec(ctx, `
+        update commands set status='Queued', error=$2, next_attempt_at=$3, locked_at=null, updated_at=now()
+        where command_id=$1 and status='Sent'
+    `, id, errMsg, nextAttemptAt)
 	return err
 }
 
 func (r *CommandsRepo) MarkFailed(ctx context.Context, id string, errMsg string) error {
-	_, err := r.db.Exec(ctx, `update commands set status='Failed', error=$2, updated_at=now() where command_id=$1`, id, errMsg)
+	_, err := r.db.Exec(ctx, `
+        update commands set status='Failed', error=$2, locked_at=null, completed_at=now(), updated_at=now()
+        where command_id=$1 and status='Sent'
+    `, id, errMsg)
+	return err
+}
+
+// MarkExpired gives up on a command that could not be delivered before its deadline.
+func (r *CommandsRepo) MarkExpired(ctx context.Context, id string, errMsg string) error {
+	_, err := r.db.Exec(ctx, `
+        update commands set status='Expired', error=coalesce(nullif($2,''), error), locked_at=null, completed_at=now(), updated_at=now()
+        where command_id=$1 and status='Sent'
+    `, id, errMsg)
+	return err
+}
+
+// ExpireDue expires open commands past their deadline (Sent ones only once their lease
+// ran out) and returns how many.
+func (r *CommandsRepo) ExpireDue(ctx context.Context, lease time.Duration) (int64, error) {
+	tag, err := r.db.Exec(ctx, `
+        update commands set status='Expired', locked_at=null, completed_at=now(), updated_at=now()
+        where expires_at <= now()
+          and (status='Queued' or (status='Sent' and locked_at < now() - $1 * interval '1 second'))
+    `, int64(lease.Seconds()))
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// EffectMatch is an applied charger event that may be the effect of a command.
+type EffectMatch struct {
+	ChargePointId string
+	Event         string
+	EventId       int64
+	ReceivedAt    time.Time
+	ConnectorId   *int
+	EvseId        *int
+	IdTag         string
+	TransactionId string
+	Status        string
+	PrevStatus    string // status of the connector before the event; "" if unknown
+	SessionId     *string
+}
+
+// MatchEffect completes the oldest command waiting for m: sent before the event arrived
+// and still within its effect window (NoEffect commands too, for late events). A transition
+// effect (FromStatuses) only matches an event received after the ack that moves the
+// connector out of one of those statuses. Returns the command id, or "" when no command
+// matches.
+func (r *CommandsRepo) MatchEffect(ctx context.Context, m EffectMatch) (string, error) {
+	var id string
+	err := r.db.QueryRow(ctx, `
+        update commands set status='Completed', effect_event_id=$2, effect_session_id=$9::uuid, effect_at=now(), updated_at=now()
+        where command_id = (
+          select command_id from commands
+          where charge_point_id=$1 and effect_event=$3
+            and status in ('Sent','Acked','NoEffect')
+            and created_at <= $4
+            and (effect_deadline is null or effect_deadline >= $4)
+            and (effect_connector_id is null or $5::int is null or effect_connector_id=$5)
+            and (effect_evse_id is null or $6::int is null or effect_evse_id=$6)
+            and (effect_id_tag is null or effect_id_tag=$7)
+            and (effect_transaction_id is null or effect_transaction_id=$8)
+            and (effect_statuses is null or $10 = any(effect_statuses))
+            and (effect_from_statuses is null or (
+              $11 = any(effect_from_statuses) and status in ('Acked','NoEffect') and completed_at <= $4))
+          order by created_at
+          limit 1
+          for update skip locked
+        )
+        returning command_id::text
+    `, m.ChargePointId, m.EventId, m.Event, m.ReceivedAt, m.ConnectorId, m.EvseId, m.IdTag, m.TransactionId, m.SessionId, m.Status, m.PrevStatus).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	return id, err
+}
+
+// MarkNoEffectDue moves Acked commands whose effect window passed without a matching
+// event to NoEffect and returns how many.
+func (r *CommandsRepo) MarkNoEffectDue(ctx context.Context) (int64, error) {
+	tag, err := r.db.Exec(ctx, `
+        update commands set status='NoEffect', updated_at=now()
+        where status='Acked' and effect_deadline < now()
+    `)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// ListBySession returns the commands whose effect was observed on a session, oldest first.
+func (r *CommandsRepo) ListBySession(ctx context.Context, sessionId string) ([]models.Command, error) {
+	rows, err := r.db.Query(ctx, `
+        select `+commandCols+`
+        from commands
+        where effect_session_id::text=$1
+        order by created_at
+    `, sessionId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.Command
+	for rows.Next() {
+		c, err := scanCommand(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// LinkSession links a command to the session its effect started or ended, unless it
+// is linked already.
+func (r *CommandsRepo) LinkSession(ctx context.Context, commandId, sessionId string) error {
+	_, err := r.db.Exec(ctx, `
+        update commands set effect_session_id=$2::uuid
+        where command_id::text=$1 and effect_session_id is null
+    `, commandId, sessionId)
 	return err
 }
+
+// Cancel cancels a Queued command; returns false if it does not exist or is no longer Queued.
+func (r *CommandsRepo) Cancel(ctx context.Context, id string) (bool, error) {
+	tag, err := r.db.Exec(ctx, `
+        update commands set status='Cancelled', locked_at=null, completed_at=now(), updated_at=now()
+        where command_id::text=$1 and status='Queued'
+    `, id)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// CommandFilter narrows ListByCharger; empty fields do not filter. From/To bound created_at.
+type CommandFilter struct {
+	Type   string
+	Status string
+	From   *time.Time
+	To     *time.Time
+}
+
+// CommandCursor is the position after the last command of a page.
+type CommandCursor struct {
+	CreatedAt time.Time
+	CommandId string
+}
+
+// ListByCharger returns a charger's commands newest first, starting after the cursor if given.
+func (r *CommandsRepo) ListByCharger(ctx context.Context, chargePointId string, f CommandFilter, after *CommandCursor, limit int) ([]models.Command, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+	var afterAt *time.Time
+	var afterId string
+	if after != nil {
+		afterAt, afterId = &after.CreatedAt, after.CommandId
+	}
+	rows, err := r.db.Query(ctx, `
+        select `+commandCols+`
+        from commands
+        where charge_point_id=$1
+          and ($2='' or type=$2)
+          and ($3='' or status=$3)
+          and ($4::timestamptz is null or created_at >= $4)
+          and ($5::timestamptz is null or created_at < $5)
+          and ($6::timestamptz is null or (created_at, command_id) < ($6, nullif($7,'')::uuid))
+        order by created_at desc, command_id desc
+        limit $8
+    `, chargePointId, f.Type, f.Status, f.From, f.To, afterAt, afterId, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.Command
+	for rows.Next() {
+		c, err := scanCommand(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,194 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"cpms/internal/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// CredentialsRepo stores the secrets chargers authenticate with (charger_credentials)
+// and the history of secret rotations (credential_rotations).
+type CredentialsRepo struct{ db DBTX }
+
+func NewCredentialsRepo(db DBTX) *CredentialsRepo { return &CredentialsRepo{db: db} }
+
+const credentialCols = `credential_id, charge_point_id, secret_hash, not_before, not_after, first_used_at, last_used_at, revoked_at, created_at`
+
+func scanCredential(row pgx.Row) (models.ChargerCredential, error) {
+	var c models.ChargerCredential
+	err := row.Scan(&c.CredentialId, &c.ChargePointId, &c.SecretHash, &c.NotBefore, &c.NotAfter, &c.FirstUsedAt, &c.LastUsedAt, &c.RevokedAt, &c.CreatedAt)
+	return c, err
+}
+
+func (r *CredentialsRepo) Add(ctx context.Context, cp, secretHash string, notBefore time.Time) (string, error) {
+	row := r.db.QueryRow(ctx, `
+		insert into charger_credentials (charge_point_id, secret_hash, not_before)
+		values ($1,$2,$3)
+		returning credential_id
+	`, cp, secretHash, notBefore)
+	var id string
+	if err := row.Scan(&id); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// ListValid returns the credentials of a charger valid at at, newest first.
+func (r *CredentialsRepo) ListValid(ctx context.Context, cp string, at time.Time) ([]models.ChargerCredential, error) {
+	return r.list(ctx, `
+		select `+credentialCols+` from charger_credentials
+		where charge_point_id=$1 and revoked_at is null and not_before <= $2 and (not_after is null or not_after > $2)
+		order by created_at desc
+	`, cp, at)
+}
+
+// List returns all credentials of a charger, newest first.
+func (r *CredentialsRepo) List(ctx context.Context, cp string) ([]models.ChargerCredential, error) {
+	return r.list(ctx, `select `+credentialCols+` from charger_credentials where charge_point_id=$1 order by created_at desc`, cp)
+}
+
+func (r *CredentialsRepo) list(ctx context.Context, sql string, args ...any) ([]models.ChargerCredential, error) {
+	rows, err := r.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.ChargerCredential
+	for rows.Next() {
+		c, err := scanCredential(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// MarkUsed records a successful authentication. Returns true on the first use of the credential.
+func (r *CredentialsRepo) MarkUsed(ctx context.Context, id string, at time.Time) (bool, error) {
+	row := r.db.QueryRow(ctx, `
+		with prev as (
+		  select credential_id, first_used_at from charger_credentials where credential_id=$1 for update
+		)
+		update charger_credentials c set last_used_at=$2, first_used_at=coalesce(c.first_used_at, $2)
+		from prev where c.credential_id=prev.credential_id
+		returning prev.first_used_at is null
+	`, id, at)
+	var first bool
+	if err := row.Scan(&first); err != nil {
+		return false, err
+	}
+	return first, nil
+}
+
+// UpdateHash replaces the stored hash of a credential (rehash after a successful
+// verification), unless it was changed meanwhile.
+func (r *CredentialsRepo) UpdateHash(ctx context.Context, id, oldHash, newHash string) error {
+	_, err := r.db.Exec(ctx, `update charger_credentials set secret_hash=$3 where credential_id=$1 and secret_hash=$2`, id, oldHash, newHash)
+	return err
+}
+
+// ExpireOlder ends the validity of the charger's credentials created before credential id
+// at at. Newer credentials (a rotation issued since) stay valid: the charger may not have
+// switched to them yet.
+func (r *CredentialsRepo) ExpireOlder(ctx context.Context, cp, id string, at time.Time) error {
+	_, err := r.db.Exec(ctx, `
+		update charger_credentials set not_after=$3
+		where charge_point_id=$1 and credential_id<>$2 and revoked_at is null
+		  and created_at < (select created_at from charger_credentials where credential_id=$2)
+		  and (not_after is null or not_after > $3)
+	`, cp, id, at)
+	return err
+}
+
+// LimitOthers caps the validity of all other valid credentials of the charger at until.
+func (r *CredentialsRepo) LimitOthers(ctx context.Context, cp, id string, until time.Time) error {
+	_, err := r.db.Exec(ctx, `
+		update charger_credentials set not_after=$3
+		where charge_point_id=$1 and credential_id<>$2 and revoked_at is null
+		  and (not_after is null or not_after > $3)
+	`, cp, id, until)
+	return err
+}
+
+// Revoke revokes one credential. Returns false if it does not exist or was already revoked.
+func (r *CredentialsRepo) Revoke(ctx context.Context, cp, id string) (bool, error) {
+	tag, err := r.db.Exec(ctx, `
+		update charger_credentials set revoked_at=now()
+		where charge_point_id=$1 and credential_id::text=$2 and revoked_at is null
+	`, cp, id)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// RevokeAll revokes every credential of a charger.
+func (r *CredentialsRepo) RevokeAll(ctx context.Context, cp string) error {
+	_, err := r.db.Exec(ctx, `update charger_credentials set revoked_at=now() where charge_point_id=$1 and revoked_at is null`, cp)
+	return err
+}
+
+// AddRotation records a rotation to credentialId; pending rotations of the charger are superseded.
+func (r *CredentialsRepo) AddRotation(ctx context.Context, cp, credentialId, actor string, oldValidUntil *time.Time) (string, error) {
+	row := r.db.QueryRow(ctx, `
+		with s as (
+		  update credential_rotations set status='Superseded' where charge_point_id=$1 and status='Pending'
+		)
+		insert into credential_rotations (charge_point_id, credential_id, actor, old_valid_until)
+		values ($1,$2,$3,$4)
+		returning rotation_id
+	`, cp, credentialId, actor, oldValidUntil)
+	var id string
+	if err := row.Scan(&id); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+func (r *CredentialsRepo) SetRotationCommand(ctx context.Context, rotationId, commandId string) error {
+	_, err := r.db.Exec(ctx, `update credential_rotations set command_id=$2 where rotation_id=$1`, rotationId, commandId)
+	return err
+}
+
+// CompleteRotation marks the pending rotation to credentialId as completed. Nothing
+// changes when credentialId is not the target of a pending rotation (e.g. an old
+// credential used for the first time).
+func (r *CredentialsRepo) CompleteRotation(ctx context.Context, credentialId string, at time.Time) error {
+	_, err := r.db.Exec(ctx, `
+		update credential_rotations set status='Completed', completed_at=$2
+		where credential_id=$1 and status='Pending'
+	`, credentialId, at)
+	return err
+}
+
+// ListRotations returns the rotations of a charger, newest first.
+func (r *CredentialsRepo) ListRotations(ctx context.Context, cp string, limit int) ([]models.CredentialRotation, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+	rows, err := r.db.Query(ctx, `
+		select rotation_id, charge_point_id, credential_id, actor, status, old_valid_until, command_id::text, created_at, completed_at
+		from credential_rotations where charge_point_id=$1
+		order by created_at desc
+		limit $2
+	`, cp, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.CredentialRotation
+	for rows.Next() {
+		var x models.CredentialRotation
+		if err := rows.Scan(&x.RotationId, &x.ChargePointId, &x.CredentialId, &x.Actor, &x.Status, &x.OldValidUntil, &x.CommandId, &x.CreatedAt, &x.CompletedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, x)
+	}
+	return out, rows.Err()
+}
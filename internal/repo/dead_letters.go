@@ -0,0 +1,87 @@
+package repo
+
+import (
+	"context"
+	"errors"
+
+	"cpms/internal/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type DeadLettersRepo struct{ db DBTX }
+
+func NewDeadLettersRepo(db DBTX) *DeadLettersRepo { return &DeadLettersRepo{db: db} }
+
+const deadLetterCols = `id, gateway_event_id, coalesce(charge_point_id,''), coalesce(event_type,''), payload, reason, status, created_at, updated_at`
+
+func scanDeadLetter(row pgx.Row) (models.DeadLetterEvent, error) {
+	var d models.DeadLetterEvent
+	err := row.Scan(&d.Id, &d.GatewayEventId, &d.ChargePointId, &d.EventType, &d.Payload, &d.Reason, &d.Status, &d.CreatedAt, &d.UpdatedAt)
+	return d, err
+}
+
+func (r *DeadLettersRepo) Insert(ctx context.Context, d models.DeadLetterEvent) (int64, error) {
+	row := r.db.QueryRow(ctx, `
+		insert into dead_letter_events (gateway_event_id, charge_point_id, event_type, payload, reason)
+		values ($1, nullif($2,''), nullif($3,''), $4, $5)
+		returning id
+	`, d.GatewayEventId, d.ChargePointId, d.EventType, d.Payload, d.Reason)
+	var id int64
+	if err := row.Scan(&id); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (r *DeadLettersRepo) Get(ctx context.Context, id int64) (*models.DeadLetterEvent, error) {
+	row := r.db.QueryRow(ctx, `select `+deadLetterCols+` from dead_letter_events where id=$1`, id)
+	d, err := scanDeadLetter(row)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &d, nil
+}
+
+func (r *DeadLettersRepo) List(ctx context.Context, tenantId, status string, chargePointId string, limit int) ([]models.DeadLetterEvent, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	rows, err := r.db.Query(ctx, `
+		select `+deadLetterCols+`
+		from dead_letter_events
+		where ($1='' or status=$1) and ($2='' or charge_point_id=$2) and `+chargerInTenant("charge_point_id", 4)+`
+		order by id desc
+		limit $3
+	`, status, chargePointId, limit, tenantId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]models.DeadLetterEvent, 0, limit)
+	for rows.Next() {
+		d, err := scanDeadLetter(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+// SetStatus moves an Open dead letter to Retried or Discarded.
+// Returns false if the dead letter does not exist or is no longer Open.
+func (r *DeadLettersRepo) SetStatus(ctx context.Context, id int64, status string) (bool, error) {
+	tag, err := r.db.Exec(ctx, `
+		update dead_letter_events set status=$2, updated_at=now()
+		where id=$1 and status='Open'
+	`, id, status)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
@@ -2,19 +2,261 @@ package repo
 
 import (
 	"context"
+	"errors"
 	"time"
 
-	"github.com/jackc/pgx/v5/pgxpool"
+	"cpms/internal/models"
+
+	"github.com/jackc/pgx/v5"
 )
 
-type EventsRepo struct{ db *pgxpool.Pool }
+type EventsRepo struct{ db DBTX }
+
+func NewEventsRepo(db DBTX) *EventsRepo { return &EventsRepo{db: db} }
+
+const gatewayEventCols = `id, charge_point_id, event_type, event_key, ts, payload, status, attempts, last_error, next_attempt_at, processed_at, received_at`
+
+func scanGatewayEvent(row pgx.Row) (models.GatewayEvent, error) {
+	var e models.GatewayEvent
+	err := row.Scan(&e.Id, &e.ChargePointId, &e.EventType, &e.EventKey, &e.Ts, &e.Payload, &e.Status, &e.Attempts, &e.LastError, &e.NextAttemptAt, &e.ProcessedAt, &e.ReceivedAt)
+	return e, err
+}
+
+// InsertRaw stores a raw event as Pending so the queue workers pick it up.
+// If an event with the same eventKey was stored before, nothing is inserted and
+// the existing id is returned with duplicate=true.
+func (r *EventsRepo) InsertRaw(ctx context.Context, chargePointId, eventType string, ts time.Time, eventKey string, payload []byte) (id int64, duplicate bool, err error) {
+	row := r.db.QueryRow(ctx, `
+		insert into gateway_events (charge_point_id, event_type, ts, event_key, payload, status)
+		values ($1,$2,$3,$4,$5,'Pending')
+		on conflict (event_key) do nothing
+		returning id
+	`, chargePointId, eventType, ts, eventKey, payload)
+	if err := row.Scan(&id); err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return 0, false, err
+		}
+		if err := r.db.QueryRow(ctx, `select id from gateway_events where event_key=$1`, eventKey).Scan(&id); err != nil {
+			return 0, false, err
+		}
+		return id, true, nil
+	}
+	return id, false, nil
+}
+
+type InsertResult struct {
+	Id        int64
+	Duplicate bool
+}
+
+// InsertBatch stores events of one charge point in a single transaction, in the
+// given order. Either all events are stored or none.
+func (r *EventsRepo) InsertBatch(ctx context.Context, events []models.GatewayEvent) ([]InsertResult, error) {
+	out := make([]InsertResult, 0, len(events))
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		txr := NewEventsRepo(tx)
+		for _, e := range events {
+			var key string
+			if e.EventKey != nil {
+				key = *e.EventKey
+			}
+			id, dup, err := txr.InsertRaw(ctx, e.ChargePointId, e.EventType, e.Ts, key, e.Payload)
+			if err != nil {
+				return err
+			}
+			out = append(out, InsertResult{Id: id, Duplicate: dup})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ClaimNext locks the oldest due event whose charge point has no earlier
+// unfinished event, so events of one charger are applied strictly in the order
+// they were stored (by id, not ts).
+// Events stuck in Processing longer than lease are reclaimed.
+// Returns nil when nothing is due.
+func (r *EventsRepo) ClaimNext(ctx context.Context, lease time.Duration) (*models.GatewayEvent, error) {
+	row := r.db.QueryRow(ctx, `
+		update gateway_events set status='Processing', attempts=attempts+1, locked_at=now()
+		where id = (
+		  select e.id from gateway_events e
+		  where ((e.status='Pending' and e.next_attempt_at<=now())
+		      or (e.status='Processing' and e.locked_at < now() - $1 * interval '1 second'))
+		    and not exists (
+		      select 1 from gateway_events p
+		      where p.charge_point_id=e.charge_point_id and p.id<e.id
+		        and p.status in ('Pending','Processing'))
+		  order by e.id
+		  limit 1
+		  for update skip locked
+		)
+		returning `+gatewayEventCols, int64(lease.Seconds()))
+
+	e, err := scanGatewayEvent(row)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &e, nil
+}
+
+func (r *EventsRepo) MarkDone(ctx context.Context, id int64) error {
+	_, err := r.db.Exec(ctx, `update gateway_events set status='Done', locked_at=null, processed_at=now() where id=$1`, id)
+	return err
+}
+
+// MarkRetry puts the event back to Pending until nextAttemptAt.
+func (r *EventsRepo) MarkRetry(ctx context.Context, id int64, errMsg string, nextAttemptAt time.Time) error {
+	_, err := r.db.Exec(ctx, `
+		update gateway_events set status='Pending', last_error=$2, next_attempt_at=$3, locked_at=null
+		where id=$1
+	`, id, errMsg, nextAttemptAt)
+	return err
+}
 
-func NewEventsRepo(db *pgxpool.Pool) *EventsRepo { return &EventsRepo{db: db} }
+// MarkFailed gives up on the event; later events of the charger are no longer blocked by it.
+func (r *EventsRepo) MarkFailed(ctx context.Context, id int64, errMsg string) error {
+	_, err := r.db.Exec(ctx, `update gateway_events set status='Failed', last_error=$2, locked_at=null where id=$1`, id, errMsg)
+	return err
+}
 
-func (r *EventsRepo) InsertRaw(ctx context.Context, chargePointId, eventType string, ts time.Time, payload []byte) error {
+// Requeue resets a Failed event to Pending with a fresh attempt budget.
+// A non-nil payload replaces the stored one (operator correction).
+func (r *EventsRepo) Requeue(ctx context.Context, id int64, payload []byte) error {
 	_, err := r.db.Exec(ctx, `
-		insert into gateway_events (charge_point_id, event_type, ts, payload)
-		values ($1,$2,$3,$4)
-	`, chargePointId, eventType, ts, payload)
+		update gateway_events
+		set status='Pending', attempts=0, next_attempt_at=now(), locked_at=null, payload=coalesce($2, payload)
+		where id=$1
+	`, id, payload)
 	return err
 }
+
+func (r *EventsRepo) Get(ctx context.Context, id int64) (*models.GatewayEvent, error) {
+	row := r.db.QueryRow(ctx, `select `+gatewayEventCols+` from gateway_events where id=$1`, id)
+	e, err := scanGatewayEvent(row)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &e, nil
+}
+
+// List returns events newest first, optionally filtered by tenant, status and charge point.
+func (r *EventsRepo) List(ctx context.Context, tenantId, status string, chargePointId string, limit int) ([]models.GatewayEvent, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	rows, err := r.db.Query(ctx, `
+		select `+gatewayEventCols+`
+		from gateway_events
+		where ($1='' or status=$1) and ($2='' or charge_point_id=$2) and `+chargerInTenant("charge_point_id", 4)+`
+		order by id desc
+		limit $3
+	`, status, chargePointId, limit, tenantId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]models.GatewayEvent, 0, limit)
+	for rows.Next() {
+		e, err := scanGatewayEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// CountOpen counts Pending/Processing events of a charge point.
+func (r *EventsRepo) CountOpen(ctx context.Context, chargePointId string) (int, error) {
+	var n int
+	err := r.db.QueryRow(ctx, `
+		select count(*) from gateway_events
+		where charge_point_id=$1 and status in ('Pending','Processing')
+	`, chargePointId).Scan(&n)
+	return n, err
+}
+
+// BootEpoch returns the number of ChargerBooted events of a charge point stored up to
+// event eventId, in the order they were received: charger clocks may reset on reboot.
+// Transaction ids are only unique within one epoch.
+func (r *EventsRepo) BootEpoch(ctx context.Context, chargePointId string, eventId int64) (int, error) {
+	var n int
+	err := r.db.QueryRow(ctx, `
+		select count(*) from gateway_events
+		where charge_point_id=$1 and event_type='ChargerBooted' and id<=$2
+	`, chargePointId, eventId).Scan(&n)
+	return n, err
+}
+
+// OfflineGaps returns the periods in [from, to) during which a charge point sent no
+// event for longer than offlineAfter: each gap starts offlineAfter after an event and
+// ends at the next event (or to). With no event before from, the time up to the first
+// event counts as offline as well.
+func (r *EventsRepo) OfflineGaps(ctx context.Context, chargePointId string, from, to time.Time, offlineAfter time.Duration) ([][2]time.Time, error) {
+	rows, err := r.db.Query(ctx, `
+		with t as (
+		  select ts from gateway_events where charge_point_id=$1 and ts >= $2 and ts < $3
+		  union all
+		  select max(ts) from gateway_events where charge_point_id=$1 and ts < $2
+		), g as (
+		  select ts, lead(ts) over (order by ts) as next_ts from t where ts is not null
+		)
+		select ts + $4::float8 * interval '1 second', coalesce(next_ts, $3)
+		from g where coalesce(next_ts, $3) > ts + $4::float8 * interval '1 second'
+		union all
+		select $2, coalesce((select min(ts) from g), $3)
+		where not exists (select 1 from g where ts < $2)
+	`, chargePointId, from, to, offlineAfter.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out [][2]time.Time
+	for rows.Next() {
+		var gap [2]time.Time
+		if err := rows.Scan(&gap[0], &gap[1]); err != nil {
+			return nil, err
+		}
+		out = append(out, gap)
+	}
+	return out, rows.Err()
+}
+
+// ListForReplay returns all events of a charge point in [from, to) ordered by ts, id.
+// Nil bounds are open.
+func (r *EventsRepo) ListForReplay(ctx context.Context, chargePointId string, from, to *time.Time) ([]models.GatewayEvent, error) {
+	rows, err := r.db.Query(ctx, `
+		select `+gatewayEventCols+`
+		from gateway_events
+		where charge_point_id=$1
+		  and ($2::timestamptz is null or ts >= $2)
+		  and ($3::timestamptz is null or ts < $3)
+		order by ts asc, id asc
+	`, chargePointId, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.GatewayEvent
+	for rows.Next() {
+		e, err := scanGatewayEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
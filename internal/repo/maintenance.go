@@ -0,0 +1,63 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"cpms/internal/models"
+)
+
+type MaintenanceRepo struct{ db DBTX }
+
+func NewMaintenanceRepo(db DBTX) *MaintenanceRepo { return &MaintenanceRepo{db: db} }
+
+const maintenanceCols = `maintenance_id, charge_point_id, site_id::text, tenant_id::text, starts_at, ends_at, reason, created_at`
+
+func (r *MaintenanceRepo) Create(ctx context.Context, m models.MaintenanceWindow) (string, error) {
+	row := r.db.QueryRow(ctx, `
+		insert into maintenance_windows (charge_point_id, site_id, tenant_id, starts_at, ends_at, reason)
+		values ($1,$2::uuid,$6::uuid,$3,$4,$5)
+		returning maintenance_id
+	`, m.ChargePointId, m.SiteId, m.StartsAt, m.EndsAt, m.Reason, m.TenantId)
+	var id string
+	if err := row.Scan(&id); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// ListOverlapping returns windows overlapping [from, to). Empty cp/siteId do not filter;
+// a non-empty tenantId returns the tenant's windows and those for every tenant.
+func (r *MaintenanceRepo) ListOverlapping(ctx context.Context, tenantId, cp, siteId string, from, to time.Time) ([]models.MaintenanceWindow, error) {
+	rows, err := r.db.Query(ctx, `
+		select `+maintenanceCols+`
+		from maintenance_windows
+		where starts_at < $4 and ends_at > $3
+		  and ($1 = '' or charge_point_id=$1)
+		  and ($2 = '' or site_id::text=$2)
+		  and ($5 = '' or tenant_id is null or tenant_id::text=$5)
+		order by starts_at
+	`, cp, siteId, from, to, tenantId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.MaintenanceWindow
+	for rows.Next() {
+		var m models.MaintenanceWindow
+		if err := rows.Scan(&m.MaintenanceId, &m.ChargePointId, &m.SiteId, &m.TenantId, &m.StartsAt, &m.EndsAt, &m.Reason, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+func (r *MaintenanceRepo) Delete(ctx context.Context, id string) (bool, error) {
+	tag, err := r.db.Exec(ctx, `delete from maintenance_windows where maintenance_id::text=$1`, id)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
@@ -0,0 +1,107 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"cpms/internal/models"
+)
+
+// OrphansRepo parks MeterSample/TransactionEnded events whose transaction
+// has no session yet, keyed by (charge_point_id, transaction_id, boot_epoch).
+type OrphansRepo struct{ db DBTX }
+
+func NewOrphansRepo(db DBTX) *OrphansRepo { return &OrphansRepo{db: db} }
+
+type OrphanKey struct {
+	ChargePointId string
+	TransactionId string
+	BootEpoch     int
+}
+
+// LockTransaction takes a transaction-scoped advisory lock on the orphan key, serializing
+// session creation for the transaction (TransactionStarted, the orphan reaper). Must run
+// inside a transaction.
+func (r *OrphansRepo) LockTransaction(ctx context.Context, cp string, transactionId string, bootEpoch int) error {
+	_, err := r.db.Exec(ctx, `
+		select pg_advisory_xact_lock(hashtextextended($1 || '/' || $2 || '/' || $3::text, 0))
+	`, cp, transactionId, bootEpoch)
+	return err
+}
+
+// Park is idempotent per gateway event.
+func (r *OrphansRepo) Park(ctx context.Context, ev models.GatewayEvent, transactionId string, bootEpoch int) error {
+	_, err := r.db.Exec(ctx, `
+		insert into orphan_events (gateway_event_id, charge_point_id, transaction_id, event_type, ts, boot_epoch)
+		values ($1,$2,$3,$4,$5,$6)
+		on conflict (gateway_event_id) do update set applied_at=null, boot_epoch=excluded.boot_epoch
+	`, ev.Id, ev.ChargePointId, transactionId, ev.EventType, ev.Ts, bootEpoch)
+	return err
+}
+
+// ListPending returns the parked events of a transaction in a boot epoch ordered by ts, id.
+func (r *OrphansRepo) ListPending(ctx context.Context, cp string, transactionId string, bootEpoch int) ([]models.GatewayEvent, error) {
+	rows, err := r.db.Query(ctx, `
+		select e.id, e.charge_point_id, e.event_type, e.event_key, e.ts, e.payload, e.status, e.attempts, e.last_error, e.next_attempt_at, e.processed_at, e.received_at
+		from orphan_events o join gateway_events e on e.id=o.gateway_event_id
+		where o.charge_point_id=$1 and o.transaction_id=$2 and o.boot_epoch=$3 and o.applied_at is null
+		order by e.ts asc, e.id asc
+	`, cp, transactionId, bootEpoch)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.GatewayEvent
+	for rows.Next() {
+		e, err := scanGatewayEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+func (r *OrphansRepo) MarkApplied(ctx context.Context, gatewayEventId int64) error {
+	_, err := r.db.Exec(ctx, `update orphan_events set applied_at=now() where gateway_event_id=$1`, gatewayEventId)
+	return err
+}
+
+// ListExpired returns transactions whose oldest unapplied orphan was parked before olderThan.
+func (r *OrphansRepo) ListExpired(ctx context.Context, olderThan time.Time, limit int) ([]OrphanKey, error) {
+	rows, err := r.db.Query(ctx, `
+		select charge_point_id, transaction_id, boot_epoch
+		from orphan_events
+		where applied_at is null
+		group by charge_point_id, transaction_id, boot_epoch
+		having min(created_at) < $1
+		order by min(created_at)
+		limit $2
+	`, olderThan, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []OrphanKey
+	for rows.Next() {
+		var k OrphanKey
+		if err := rows.Scan(&k.ChargePointId, &k.TransactionId, &k.BootEpoch); err != nil {
+			return nil, err
+		}
+		out = append(out, k)
+	}
+	return out, rows.Err()
+}
+
+// DeleteBetween removes orphans of a charge point with ts in [from, to) (used by replay).
+func (r *OrphansRepo) DeleteBetween(ctx context.Context, cp string, from, to *time.Time) error {
+	_, err := r.db.Exec(ctx, `
+		delete from orphan_events
+		where charge_point_id=$1
+		  and ($2::timestamptz is null or ts >= $2)
+		  and ($3::timestamptz is null or ts < $3)
+	`, cp, from, to)
+	return err
+}
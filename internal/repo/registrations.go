@@ -0,0 +1,130 @@
+package repo
+
+import (
+	"context"
+
+	"cpms/internal/models"
+)
+
+// RegistrationsRepo manages the onboarding state of chargers (chargers.registration_status)
+// and its audit trail.
+type RegistrationsRepo struct{ db DBTX }
+
+func NewRegistrationsRepo(db DBTX) *RegistrationsRepo { return &RegistrationsRepo{db: db} }
+
+// List returns chargers in the given registration status, oldest first. A non-empty
+// tenantId limits it to that tenant's chargers (unassigned ones are excluded).
+func (r *RegistrationsRepo) List(ctx context.Context, tenantId, status string, limit int) ([]models.Charger, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+	rows, err := r.db.Query(ctx, `
+		select `+chargerCols+` from chargers
+		where registration_status=$1 and ($3 = '' or tenant_id::text=$3)
+		order by created_at, charge_point_id
+		limit $2
+	`, status, limit, tenantId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.Charger
+	for rows.Next() {
+		c, err := scanCharger(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// Approve activates a charger that is not Accepted yet with a new credential and optional site,
+// assigns it to tenantId unless it already has a tenant, and records the approval.
+// Returns false if the charger is unknown or already Accepted.
+func (r *RegistrationsRepo) Approve(ctx context.Context, id, secretHash string, siteId *string, tenantId, actor, reason string) (bool, error) {
+	tag, err := r.db.Exec(ctx, `
+		with prev as (
+		  select charge_point_id, registration_status from chargers
+		  where charge_point_id=$1 and registration_status<>'Accepted'
+		  for update
+		), u as (
+		  update chargers c set registration_status='Accepted', registration_changed_at=now(),
+		    is_active=true, site_id=coalesce($3::uuid, c.site_id), tenant_id=coalesce(c.tenant_id, $6::uuid), updated_at=now()
+		  from prev where c.charge_point_id=prev.charge_point_id
+		  returning c.charge_point_id, c.site_id, prev.registration_status
+		), k as (
+		  insert into charger_credentials (charge_point_id, secret_hash)
+		  select charge_point_id, $2 from u
+		)
+		insert into charger_registration_audit (charge_point_id, action, from_status, actor, reason, site_id)
+		select charge_point_id, 'Approved', registration_status, $4, $5, site_id from u
+	`, id, secretHash, siteId, actor, reason, tenantId)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// Reject marks a Pending charger as Rejected; it becomes Pending again when it boots later.
+func (r *RegistrationsRepo) Reject(ctx context.Context, id, actor, reason string) (bool, error) {
+	return r.transition(ctx, id, []string{"Pending"}, "Rejected", actor, reason)
+}
+
+// Block deactivates a charger in any state and revokes its credentials; its boots no longer
+// bring it back to Pending.
+func (r *RegistrationsRepo) Block(ctx context.Context, id, actor, reason string) (bool, error) {
+	return r.transition(ctx, id, []string{"Pending", "Accepted", "Rejected"}, "Blocked", actor, reason)
+}
+
+func (r *RegistrationsRepo) transition(ctx context.Context, id string, from []string, to, actor, reason string) (bool, error) {
+	tag, err := r.db.Exec(ctx, `
+		with prev as (
+		  select charge_point_id, registration_status from chargers
+		  where charge_point_id=$1 and registration_status = any($2)
+		  for update
+		), u as (
+		  update chargers c set registration_status=$3, registration_changed_at=now(),
+		    is_active=false, updated_at=now()
+		  from prev where c.charge_point_id=prev.charge_point_id
+		  returning c.charge_point_id, prev.registration_status
+		), k as (
+		  update charger_credentials set revoked_at=now()
+		  where $3='Blocked' and revoked_at is null and charge_point_id in (select charge_point_id from u)
+		)
+		insert into charger_registration_audit (charge_point_id, action, from_status, actor, reason)
+		select charge_point_id, $3, registration_status, $4, $5 from u
+	`, id, from, to, actor, reason)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// ListAudit returns the registration decisions of a charger, newest first.
+func (r *RegistrationsRepo) ListAudit(ctx context.Context, id string, limit int) ([]models.RegistrationAudit, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+	rows, err := r.db.Query(ctx, `
+		select id, charge_point_id, action, from_status, actor, reason, site_id::text, created_at
+		from charger_registration_audit where charge_point_id=$1
+		order by created_at desc, id desc
+		limit $2
+	`, id, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.RegistrationAudit
+	for rows.Next() {
+		var a models.RegistrationAudit
+		if err := rows.Scan(&a.Id, &a.ChargePointId, &a.Action, &a.FromStatus, &a.Actor, &a.Reason, &a.SiteId, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
@@ -8,38 +8,88 @@ import (
 	"cpms/internal/models"
 
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-type SessionsRepo struct{ db *pgxpool.Pool }
+type SessionsRepo struct{ db DBTX }
 
-func NewSessionsRepo(db *pgxpool.Pool) *SessionsRepo { return &SessionsRepo{db: db} }
+func NewSessionsRepo(db DBTX) *SessionsRepo { return &SessionsRepo{db: db} }
 
+const sessionCols = `session_id, charge_point_id, connector_id, transaction_id, coalesce(id_tag,''), started_at, ended_at, meter_start_wh, meter_stop_wh, reason, energy_wh, energy_source, is_estimated, finalized_at, tariff_id::text, cost_amount::float8, cost_currency, priced_at, start_event_id, is_synthesized, evse_id, last_seq_no, boot_epoch`
+
+// sessionDest returns the scan destinations matching sessionCols.
+func sessionDest(s *models.Session) []any {
+	return []any{&s.SessionId, &s.ChargePointId, &s.ConnectorId, &s.TransactionId, &s.IdTag, &s.StartedAt, &s.EndedAt, &s.MeterStartWh, &s.MeterStopWh, &s.Reason, &s.EnergyWh, &s.EnergySource, &s.IsEstimated, &s.FinalizedAt, &s.TariffId, &s.CostAmount, &s.CostCurrency, &s.PricedAt, &s.StartEventId, &s.IsSynthesized, &s.EvseId, &s.LastSeqNo, &s.BootEpoch}
+}
+
+func scanSession(row pgx.Row) (models.Session, error) {
+	var s models.Session
+	err := row.Scan(sessionDest(&s)...)
+	return s, err
+}
+
+// Start inserts a session. When StartEventId is set and a session was already
+// created by that event, the existing session_id is returned instead.
 func (r *SessionsRepo) Start(ctx context.Context, s models.Session) (string, error) {
 	row := r.db.QueryRow(ctx, `
-		insert into sessions (charge_point_id, connector_id, transaction_id, id_tag, started_at, meter_start_wh)
-		values ($1,$2,$3,$4,$5,$6)
+		insert into sessions (charge_point_id, connector_id, transaction_id, id_tag, started_at, meter_start_wh, start_event_id, evse_id, last_seq_no, boot_epoch)
+		values ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)
+		on conflict (start_event_id) do nothing
 		returning session_id
-	`, s.ChargePointId, s.ConnectorId, s.TransactionId, s.IdTag, s.StartedAt, s.MeterStartWh)
+	`, s.ChargePointId, s.ConnectorId, s.TransactionId, s.IdTag, s.StartedAt, s.MeterStartWh, s.StartEventId, s.EvseId, s.LastSeqNo, s.BootEpoch)
 
 	var id string
 	if err := row.Scan(&id); err != nil {
-		return "", err
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return "", err
+		}
+		if err := r.db.QueryRow(ctx, `select session_id from sessions where start_event_id=$1`, s.StartEventId).Scan(&id); err != nil {
+			return "", err
+		}
 	}
 	return id, nil
 }
 
-func (r *SessionsRepo) FindByTx(ctx context.Context, cp string, tx int) (*models.Session, error) {
+// ListTxCandidates returns the sessions an event of transaction tx may belong to:
+// same charge point and transaction id, boot epoch <= maxEpoch, started before
+// startedBefore and, when connectorId is set, on that connector or one not known yet (0).
+// Ordered by boot_epoch desc, started_at desc.
+func (r *SessionsRepo) ListTxCandidates(ctx context.Context, cp string, tx string, maxEpoch int, connectorId *int, startedBefore time.Time) ([]models.Session, error) {
+	rows, err := r.db.Query(ctx, `
+		select `+sessionCols+`
+		from sessions
+		where charge_point_id=$1 and transaction_id=$2 and boot_epoch<=$3
+		  and ($4::int is null or connector_id in ($4, 0))
+		  and started_at<=$5
+		order by boot_epoch desc, started_at desc
+		limit 20
+	`, cp, tx, maxEpoch, connectorId, startedBefore)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.Session
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// FindSynthesized returns the latest session of a transaction in a boot epoch created by the orphan reaper.
+func (r *SessionsRepo) FindSynthesized(ctx context.Context, cp string, tx string, bootEpoch int) (*models.Session, error) {
 	row := r.db.QueryRow(ctx, `
-		select session_id, charge_point_id, connector_id, transaction_id, coalesce(id_tag,''), started_at, ended_at, meter_start_wh, meter_stop_wh, reason, energy_wh, energy_source, is_estimated, finalized_at, tariff_id::text, cost_amount::float8, cost_currency, priced_at
+		select `+sessionCols+`
 		from sessions
-		where charge_point_id=$1 and transaction_id=$2
+		where charge_point_id=$1 and transaction_id=$2 and boot_epoch=$3 and is_synthesized
 		order by started_at desc
 		limit 1
-	`, cp, tx)
-
-	var s models.Session
-	if err := row.Scan(&s.SessionId, &s.ChargePointId, &s.ConnectorId, &s.TransactionId, &s.IdTag, &s.StartedAt, &s.EndedAt, &s.MeterStartWh, &s.MeterStopWh, &s.Reason, &s.EnergyWh, &s.EnergySource, &s.IsEstimated, &s.FinalizedAt, &s.TariffId, &s.CostAmount, &s.CostCurrency, &s.PricedAt); err != nil {
+	`, cp, tx, bootEpoch)
+	s, err := scanSession(row)
+	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, nil
 		}
@@ -48,30 +98,151 @@ func (r *SessionsRepo) FindByTx(ctx context.Context, cp string, tx int) (*models
 	return &s, nil
 }
 
+// StartSynthesized inserts a session for a transaction whose TransactionStarted never arrived.
+func (r *SessionsRepo) StartSynthesized(ctx context.Context, s models.Session) (string, error) {
+	row := r.db.QueryRow(ctx, `
+		insert into sessions (charge_point_id, connector_id, transaction_id, id_tag, started_at, evse_id, boot_epoch, is_synthesized)
+		values ($1,$2,$3,$4,$5,$6,$7,true)
+		returning session_id
+	`, s.ChargePointId, s.ConnectorId, s.TransactionId, s.IdTag, s.StartedAt, s.EvseId, s.BootEpoch)
+	var id string
+	if err := row.Scan(&id); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// AdoptSynthesized fills a synthesized session with the data of the late TransactionStarted.
+func (r *SessionsRepo) AdoptSynthesized(ctx context.Context, sessionId string, s models.Session) error {
+	_, err := r.db.Exec(ctx, `
+		update sessions
+		set connector_id=$2, id_tag=$3, started_at=$4, meter_start_wh=$5, start_event_id=$6, evse_id=coalesce($7, evse_id),
+		    last_seq_no=greatest(last_seq_no, $8), is_synthesized=false, updated_at=now()
+		where session_id=$1
+	`, sessionId, s.ConnectorId, s.IdTag, s.StartedAt, s.MeterStartWh, s.StartEventId, s.EvseId, s.LastSeqNo)
+	return err
+}
+
+// TouchSeqNo records the highest OCPP 2.0.1 TransactionEvent seqNo seen for the session,
+// and fills id_tag and the connector if they were only sent after the transaction started.
+func (r *SessionsRepo) TouchSeqNo(ctx context.Context, sessionId string, seqNo int, idTag string, connectorId *int) error {
+	_, err := r.db.Exec(ctx, `
+		update sessions
+		set last_seq_no=greatest(last_seq_no, $2), id_tag=coalesce(nullif(id_tag,''), nullif($3,'')),
+		    connector_id=case when connector_id=0 then coalesce($4, 0) else connector_id end, updated_at=now()
+		where session_id=$1
+	`, sessionId, seqNo, idTag, connectorId)
+	return err
+}
+
+// EvseConnector returns the connector earlier sessions of the charger used on an EVSE,
+// or 0 if there were none or they used different connectors.
+func (r *SessionsRepo) EvseConnector(ctx context.Context, cp string, evseId int) (int, error) {
+	var connectorId int
+	err := r.db.QueryRow(ctx, `
+		select min(connector_id) from sessions
+		where charge_point_id=$1 and evse_id=$2 and connector_id>0
+		having count(distinct connector_id)=1
+	`, cp, evseId).Scan(&connectorId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	return connectorId, err
+}
+
+// MarkEstimated flags a finalized session for finance review.
+func (r *SessionsRepo) MarkEstimated(ctx context.Context, sessionId string) error {
+	_, err := r.db.Exec(ctx, `update sessions set is_estimated=true, updated_at=now() where session_id=$1`, sessionId)
+	return err
+}
+
+// End stores the end of a session. The reason of a session closed by the stale session
+// sweeper is replaced, even by none.
 func (r *SessionsRepo) End(ctx context.Context, sessionId string, endedAt time.Time, meterStop *int64, reason *string) error {
 	_, err := r.db.Exec(ctx, `
-		update sessions set ended_at=$2, meter_stop_wh=coalesce($3, meter_stop_wh), reason=coalesce($4, reason), updated_at=now()
+		update sessions set ended_at=$2, meter_stop_wh=coalesce($3, meter_stop_wh),
+		  reason=coalesce($4, nullif(reason, 'StaleClosed')), updated_at=now()
 		where session_id=$1
 	`, sessionId, endedAt, meterStop, reason)
 	return err
 }
 
+// StaleSession is an open session picked up by the stale session sweeper.
+type StaleSession struct {
+	models.Session
+	// LastActivityAt is the latest of started_at, the last meter sample and the connector status update.
+	LastActivityAt time.Time
+	// ConnectorAvailable is true when the connector went Available after the session started.
+	ConnectorAvailable bool
+}
+
+// ListStale returns open sessions without meter/status activity since inactiveBefore,
+// or whose connector has been Available since before availableBefore. Oldest first.
+func (r *SessionsRepo) ListStale(ctx context.Context, inactiveBefore, availableBefore time.Time, limit int) ([]StaleSession, error) {
+	rows, err := r.db.Query(ctx, `
+		select `+sessionCols+`, last_activity_at, connector_available
+		from (
+		  select s.*,
+		    greatest(s.started_at, (select max(m.ts) from meter_samples m where m.session_id=s.session_id), cs.updated_at) as last_activity_at,
+		    coalesce(cs.status='Available' and cs.updated_at > s.started_at, false) as connector_available,
+		    cs.updated_at as connector_updated_at
+		  from sessions s
+		  left join connector_state cs on cs.charge_point_id=s.charge_point_id and cs.connector_id=s.connector_id
+		  where s.ended_at is null
+		) x
+		where last_activity_at < $1 or (connector_available and connector_updated_at < $2)
+		order by started_at
+		limit $3
+	`, inactiveBefore, availableBefore, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []StaleSession
+	for rows.Next() {
+		var s StaleSession
+		if err := rows.Scan(append(sessionDest(&s.Session), &s.LastActivityAt, &s.ConnectorAvailable)...); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// CloseStale ends a session that is still open. Returns false if it was ended meanwhile.
+func (r *SessionsRepo) CloseStale(ctx context.Context, sessionId string, endedAt time.Time, reason string) (bool, error) {
+	tag, err := r.db.Exec(ctx, `
+		update sessions set ended_at=$2, reason=$3, updated_at=now()
+		where session_id=$1 and ended_at is null
+	`, sessionId, endedAt, reason)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// InsertMeterSample is a no-op when a sample for the same EventId, or the same 2.0.1
+// SeqNo of the session, already exists.
 func (r *SessionsRepo) InsertMeterSample(ctx context.Context, sample models.MeterSample) error {
 	_, err := r.db.Exec(ctx, `
-		insert into meter_samples (session_id, charge_point_id, transaction_id, ts, samples_json)
-		values ($1,$2,$3,$4,$5)
-	`, sample.SessionId, sample.ChargePointId, sample.TransactionId, sample.Ts, sample.SamplesJSON)
+		insert into meter_samples (session_id, charge_point_id, transaction_id, ts, samples_json, event_id, seq_no)
+		select $1,$2,$3,$4,$5,$6,$7
+		where $7::int is null
+		   or not exists (select 1 from meter_samples where session_id=$1 and seq_no=$7)
+		on conflict (event_id) do nothing
+	`, sample.SessionId, sample.ChargePointId, sample.TransactionId, sample.Ts, sample.SamplesJSON, sample.EventId, sample.SeqNo)
 	return err
 }
 
 func (r *SessionsRepo) GetByID(ctx context.Context, id string) (*models.Session, error) {
 	row := r.db.QueryRow(ctx, `
-		select session_id, charge_point_id, connector_id, transaction_id, coalesce(id_tag,''), started_at, ended_at, meter_start_wh, meter_stop_wh, reason, energy_wh, energy_source, is_estimated, finalized_at, tariff_id::text, cost_amount::float8, cost_currency, priced_at
+		select `+sessionCols+`
 		from sessions where session_id=$1
 	`, id)
 
-	var s models.Session
-	if err := row.Scan(&s.SessionId, &s.ChargePointId, &s.ConnectorId, &s.TransactionId, &s.IdTag, &s.StartedAt, &s.EndedAt, &s.MeterStartWh, &s.MeterStopWh, &s.Reason, &s.EnergyWh, &s.EnergySource, &s.IsEstimated, &s.FinalizedAt, &s.TariffId, &s.CostAmount, &s.CostCurrency, &s.PricedAt); err != nil {
+	s, err := scanSession(row)
+	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, nil
 		}
@@ -85,7 +256,7 @@ func (r *SessionsRepo) ListByCharger(ctx context.Context, cp string, limit int)
 		limit = 50
 	}
 	rows, err := r.db.Query(ctx, `
-		select session_id, charge_point_id, connector_id, transaction_id, coalesce(id_tag,''), started_at, ended_at, meter_start_wh, meter_stop_wh, reason, energy_wh, energy_source, is_estimated, finalized_at, tariff_id::text, cost_amount::float8, cost_currency, priced_at
+		select `+sessionCols+`
 		from sessions where charge_point_id=$1
 		order by started_at desc
 		limit $2
@@ -97,8 +268,43 @@ func (r *SessionsRepo) ListByCharger(ctx context.Context, cp string, limit int)
 
 	var out []models.Session
 	for rows.Next() {
-		var s models.Session
-		if err := rows.Scan(&s.SessionId, &s.ChargePointId, &s.ConnectorId, &s.TransactionId, &s.IdTag, &s.StartedAt, &s.EndedAt, &s.MeterStartWh, &s.MeterStopWh, &s.Reason, &s.EnergyWh, &s.EnergySource, &s.IsEstimated, &s.FinalizedAt, &s.TariffId, &s.CostAmount, &s.CostCurrency, &s.PricedAt); err != nil {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// ListReusedTransactions returns sessions whose (charge_point_id, transaction_id) is
+// shared with another session, grouped by charge point and transaction, oldest first.
+// cp="" means all charge points (of tenantId, if set).
+func (r *SessionsRepo) ListReusedTransactions(ctx context.Context, tenantId, cp string, limit int) ([]models.Session, error) {
+	if limit <= 0 || limit > 1000 {
+		limit = 200
+	}
+	rows, err := r.db.Query(ctx, `
+		select `+sessionCols+`
+		from sessions s
+		where ($1 = '' or s.charge_point_id=$1)
+		  and `+chargerInTenant("s.charge_point_id", 3)+`
+		  and exists (
+		    select 1 from sessions o
+		    where o.charge_point_id=s.charge_point_id and o.transaction_id=s.transaction_id and o.session_id<>s.session_id
+		  )
+		order by charge_point_id, transaction_id, started_at
+		limit $2
+	`, cp, limit, tenantId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.Session
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
 			return nil, err
 		}
 		out = append(out, s)
@@ -106,6 +312,66 @@ func (r *SessionsRepo) ListByCharger(ctx context.Context, cp string, limit int)
 	return out, rows.Err()
 }
 
+// ListStartedBetween returns sessions of a charge point started in [from, to), oldest first.
+// Nil bounds are open.
+func (r *SessionsRepo) ListStartedBetween(ctx context.Context, cp string, from, to *time.Time) ([]models.Session, error) {
+	rows, err := r.db.Query(ctx, `
+		select `+sessionCols+`
+		from sessions
+		where charge_point_id=$1
+		  and ($2::timestamptz is null or started_at >= $2)
+		  and ($3::timestamptz is null or started_at < $3)
+		order by started_at asc
+	`, cp, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.Session
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// Straddling returns the earliest start and the latest end of the charger's sessions
+// running at t (started before t and not ended before t); open is true if one of them
+// has not ended. start is nil if there is no such session.
+func (r *SessionsRepo) Straddling(ctx context.Context, cp string, t time.Time) (start, end *time.Time, open bool, err error) {
+	err = r.db.QueryRow(ctx, `
+		select min(started_at), max(ended_at), coalesce(bool_or(ended_at is null), false)
+		from sessions
+		where charge_point_id=$1 and started_at < $2 and (ended_at is null or ended_at >= $2)
+	`, cp, t).Scan(&start, &end, &open)
+	return start, end, open, err
+}
+
+// Rekey gives a session another id; meter samples, settlements and command links follow.
+func (r *SessionsRepo) Rekey(ctx context.Context, sessionId, newId string) error {
+	_, err := r.db.Exec(ctx, `update sessions set session_id=$2::uuid where session_id::text=$1`, sessionId, newId)
+	return err
+}
+
+// DeleteStartedBetween removes sessions started in [from, to) together with
+// their meter samples and settlements (on delete cascade).
+func (r *SessionsRepo) DeleteStartedBetween(ctx context.Context, cp string, from, to *time.Time) (int64, error) {
+	tag, err := r.db.Exec(ctx, `
+		delete from sessions
+		where charge_point_id=$1
+		  and ($2::timestamptz is null or started_at >= $2)
+		  and ($3::timestamptz is null or started_at < $3)
+	`, cp, from, to)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
 // FinalizeWithFallback computes energy_wh and marks session finalized.
 // Fallback order:
 // 1) meterStopWh - meterStartWh (if meterStopWh present)
@@ -200,7 +466,7 @@ func (r *SessionsRepo) GetLastEnergyRegisterWh(ctx context.Context, sessionId st
 	var val int64
 	err := r.db.QueryRow(ctx, `
         with candidates as (
-          select ms.ts,
+          select ms.ts, ms.seq_no,
                  (s->>'value')::bigint as v
           from meter_samples ms,
                jsonb_array_elements(ms.samples_json->'samples') s
@@ -208,7 +474,7 @@ func (r *SessionsRepo) GetLastEnergyRegisterWh(ctx context.Context, sessionId st
             and s->>'measurand'='Energy.Active.Import.Register'
             and (s->>'unit' is null or s->>'unit'='Wh')
         )
-        select v from candidates order by ts desc limit 1
+        select v from candidates order by ts desc, seq_no desc nulls last limit 1
     `, sessionId).Scan(&val)
 
 	if err != nil {
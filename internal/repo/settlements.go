@@ -3,22 +3,27 @@ package repo
 import (
 	"context"
 	"errors"
+	"time"
 
 	"cpms/internal/models"
 
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-type SettlementsRepo struct{ db *pgxpool.Pool }
+type SettlementsRepo struct{ db DBTX }
 
-func NewSettlementsRepo(db *pgxpool.Pool) *SettlementsRepo { return &SettlementsRepo{db: db} }
+func NewSettlementsRepo(db DBTX) *SettlementsRepo { return &SettlementsRepo{db: db} }
 
+// CreateForSession creates the Pending settlement of a session. If it already
+// exists and is still Pending, amount/currency follow a re-priced session.
 func (r *SettlementsRepo) CreateForSession(ctx context.Context, sessionId string, siteId string, amount float64, currency string) (string, error) {
 	row := r.db.QueryRow(ctx, `
 		insert into settlements (session_id, site_id, amount, currency, status)
 		values ($1,$2,$3,$4,'Pending')
-		on conflict (session_id) do update set updated_at=now()
+		on conflict (session_id) do update set
+		  amount=case when settlements.status='Pending' then excluded.amount else settlements.amount end,
+		  currency=case when settlements.status='Pending' then excluded.currency else settlements.currency end,
+		  updated_at=now()
 		returning settlement_id
 	`, sessionId, siteId, amount, currency)
 	var id string
@@ -43,7 +48,9 @@ func (r *SettlementsRepo) Get(ctx context.Context, settlementId string) (*models
 	return &s, nil
 }
 
-func (r *SettlementsRepo) List(ctx context.Context, status string, limit int) ([]models.Settlement, error) {
+// List returns settlements, newest first, or oldest first within one status. A
+// non-empty tenantId limits it to settlements of the tenant's sites.
+func (r *SettlementsRepo) List(ctx context.Context, tenantId, status string, limit int) ([]models.Settlement, error) {
 	if limit <= 0 || limit > 200 {
 		limit = 50
 	}
@@ -52,13 +59,13 @@ func (r *SettlementsRepo) List(ctx context.Context, status string, limit int) ([
 	if status == "" {
 		rows, err = r.db.Query(ctx, `
 			select settlement_id, session_id, site_id, amount::float8, currency, status, chain, tx_hash, external_ref, error, created_at, updated_at
-			from settlements order by created_at desc limit $1
-		`, limit)
+			from settlements where `+siteInTenant("site_id", 2)+` order by created_at desc limit $1
+		`, limit, tenantId)
 	} else {
 		rows, err = r.db.Query(ctx, `
 			select settlement_id, session_id, site_id, amount::float8, currency, status, chain, tx_hash, external_ref, error, created_at, updated_at
-			from settlements where status=$1 order by created_at asc limit $2
-		`, status, limit)
+			from settlements where status=$1 and `+siteInTenant("site_id", 3)+` order by created_at asc limit $2
+		`, status, limit, tenantId)
 	}
 	if err != nil {
 		return nil, err
@@ -99,3 +106,18 @@ func (r *SettlementsRepo) MarkFailed(ctx context.Context, settlementId string, e
 	`, settlementId, errMsg)
 	return err
 }
+
+// CountNonPendingForSessions counts settlements past Pending for sessions of a
+// charge point started in [from, to).
+func (r *SettlementsRepo) CountNonPendingForSessions(ctx context.Context, cp string, from, to *time.Time) (int, error) {
+	var n int
+	err := r.db.QueryRow(ctx, `
+		select count(*)
+		from settlements st join sessions s on s.session_id=st.session_id
+		where s.charge_point_id=$1
+		  and ($2::timestamptz is null or s.started_at >= $2)
+		  and ($3::timestamptz is null or s.started_at < $3)
+		  and st.status <> 'Pending'
+	`, cp, from, to).Scan(&n)
+	return n, err
+}
@@ -7,15 +7,19 @@ import (
 	"cpms/internal/models"
 
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-type SitesRepo struct{ db *pgxpool.Pool }
+type SitesRepo struct{ db DBTX }
 
-func NewSitesRepo(db *pgxpool.Pool) *SitesRepo { return &SitesRepo{db: db} }
+func NewSitesRepo(db DBTX) *SitesRepo { return &SitesRepo{db: db} }
 
-func (r *SitesRepo) Create(ctx context.Context, name string) (string, error) {
-	row := r.db.QueryRow(ctx, `insert into sites (name) values ($1) on conflict (name) do update set name=excluded.name returning site_id`, name)
+// Create returns the site of the tenant with this name, creating it if needed.
+func (r *SitesRepo) Create(ctx context.Context, tenantId, name string) (string, error) {
+	row := r.db.QueryRow(ctx, `
+		insert into sites (tenant_id, name) values ($1::uuid,$2)
+		on conflict (tenant_id, name) do update set name=excluded.name
+		returning site_id
+	`, tenantId, name)
 	var id string
 	if err := row.Scan(&id); err != nil {
 		return "", err
@@ -23,10 +27,22 @@ func (r *SitesRepo) Create(ctx context.Context, name string) (string, error) {
 	return id, nil
 }
 
-func (r *SitesRepo) GetByName(ctx context.Context, name string) (*models.Site, error) {
-	row := r.db.QueryRow(ctx, `select site_id, name, payout_wallet, created_at from sites where name=$1`, name)
+func (r *SitesRepo) GetByName(ctx context.Context, tenantId, name string) (*models.Site, error) {
+	row := r.db.QueryRow(ctx, `select site_id, tenant_id::text, name, payout_wallet, created_at from sites where tenant_id::text=$1 and name=$2`, tenantId, name)
 	var s models.Site
-	if err := row.Scan(&s.SiteId, &s.Name, &s.PayoutWallet, &s.CreatedAt); err != nil {
+	if err := row.Scan(&s.SiteId, &s.TenantId, &s.Name, &s.PayoutWallet, &s.CreatedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (r *SitesRepo) GetByID(ctx context.Context, siteId string) (*models.Site, error) {
+	row := r.db.QueryRow(ctx, `select site_id, tenant_id::text, name, payout_wallet, created_at from sites where site_id::text=$1`, siteId)
+	var s models.Site
+	if err := row.Scan(&s.SiteId, &s.TenantId, &s.Name, &s.PayoutWallet, &s.CreatedAt); err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, nil
 		}
@@ -2,16 +2,17 @@ package repo
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"cpms/internal/models"
 
-	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5"
 )
 
-type StateRepo struct{ db *pgxpool.Pool }
+type StateRepo struct{ db DBTX }
 
-func NewStateRepo(db *pgxpool.Pool) *StateRepo { return &StateRepo{db: db} }
+func NewStateRepo(db DBTX) *StateRepo { return &StateRepo{db: db} }
 
 func (r *StateRepo) UpsertConnector(ctx context.Context, st models.ConnectorState) error {
 	_, err := r.db.Exec(ctx, `
@@ -25,6 +26,18 @@ func (r *StateRepo) UpsertConnector(ctx context.Context, st models.ConnectorStat
 	return err
 }
 
+// ConnectorStatus returns the current status of a connector, or "" if it never reported one.
+func (r *StateRepo) ConnectorStatus(ctx context.Context, cp string, connectorId int) (string, error) {
+	var status string
+	err := r.db.QueryRow(ctx, `
+		select status from connector_state where charge_point_id=$1 and connector_id=$2
+	`, cp, connectorId).Scan(&status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	return status, err
+}
+
 func (r *StateRepo) ListConnectors(ctx context.Context, cp string) ([]models.ConnectorState, error) {
 	rows, err := r.db.Query(ctx, `
 		select charge_point_id, connector_id, status, error_code, updated_at
@@ -48,6 +61,101 @@ func (r *StateRepo) ListConnectors(ctx context.Context, cp string) ([]models.Con
 }
 
 func (r *StateRepo) TouchHeartbeat(ctx context.Context, cp string, t time.Time) error {
-	_, err := r.db.Exec(ctx, `update chargers set last_seen_at=$2, updated_at=now() where charge_point_id=$1`, cp, t)
+	_, err := r.db.Exec(ctx, `update chargers set last_seen_at=greatest(last_seen_at, $2), updated_at=now() where charge_point_id=$1`, cp, t)
+	return err
+}
+
+func (r *StateRepo) DeleteConnectors(ctx context.Context, cp string) error {
+	_, err := r.db.Exec(ctx, `delete from connector_state where charge_point_id=$1`, cp)
+	return err
+}
+
+// RecordStatus appends a status transition to connector_status_history. Nothing is
+// inserted when the status/errorCode in effect at ts is the same, or the event was already recorded.
+func (r *StateRepo) RecordStatus(ctx context.Context, st models.ConnectorState, eventId int64) error {
+	_, err := r.db.Exec(ctx, `
+		insert into connector_status_history (charge_point_id, connector_id, status, error_code, started_at, event_id)
+		select $1,$2,$3,$4,$5,$6
+		where not exists (
+		  select 1 from (
+		    select status, error_code from connector_status_history
+		    where charge_point_id=$1 and connector_id=$2 and started_at<=$5
+		    order by started_at desc, id desc
+		    limit 1
+		  ) prev
+		  where prev.status=$3 and prev.error_code=$4
+		)
+		on conflict (event_id) do nothing
+	`, st.ChargePointId, st.ConnectorId, st.Status, st.ErrorCode, st.UpdatedAt, eventId)
+	return err
+}
+
+// ListStatusHistory returns the transitions of a connector overlapping [from, to), oldest first,
+// including the one already in effect at from. Nil bounds are open; limit <= 0 means no limit.
+func (r *StateRepo) ListStatusHistory(ctx context.Context, cp string, connectorId int, from, to *time.Time, limit int) ([]models.ConnectorStatusChange, error) {
+	rows, err := r.db.Query(ctx, `
+		select id, charge_point_id, connector_id, status, error_code, started_at, ended_at, event_id
+		from (
+		  select h.*, lead(started_at) over (order by started_at, id) as ended_at
+		  from connector_status_history h
+		  where charge_point_id=$1 and connector_id=$2 and ($4::timestamptz is null or started_at < $4)
+		) x
+		where $3::timestamptz is null or ended_at is null or ended_at > $3
+		order by started_at, id
+		limit nullif($5, 0)
+	`, cp, connectorId, from, to, max(limit, 0))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.ConnectorStatusChange
+	for rows.Next() {
+		var h models.ConnectorStatusChange
+		if err := rows.Scan(&h.Id, &h.ChargePointId, &h.ConnectorId, &h.Status, &h.ErrorCode, &h.StartedAt, &h.EndedAt, &h.EventId); err != nil {
+			return nil, err
+		}
+		out = append(out, h)
+	}
+	return out, rows.Err()
+}
+
+// ListChargerStatusHistory is ListStatusHistory for all connectors of a charge point,
+// ordered by connector, started_at.
+func (r *StateRepo) ListChargerStatusHistory(ctx context.Context, cp string, from, to time.Time) ([]models.ConnectorStatusChange, error) {
+	rows, err := r.db.Query(ctx, `
+		select id, charge_point_id, connector_id, status, error_code, started_at, ended_at, event_id
+		from (
+		  select h.*, lead(started_at) over (partition by connector_id order by started_at, id) as ended_at
+		  from connector_status_history h
+		  where charge_point_id=$1 and started_at < $3
+		) x
+		where ended_at is null or ended_at > $2
+		order by connector_id, started_at, id
+	`, cp, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.ConnectorStatusChange
+	for rows.Next() {
+		var h models.ConnectorStatusChange
+		if err := rows.Scan(&h.Id, &h.ChargePointId, &h.ConnectorId, &h.Status, &h.ErrorCode, &h.StartedAt, &h.EndedAt, &h.EventId); err != nil {
+			return nil, err
+		}
+		out = append(out, h)
+	}
+	return out, rows.Err()
+}
+
+// DeleteStatusHistoryBetween removes transitions of a charge point with started_at in [from, to) (used by replay).
+func (r *StateRepo) DeleteStatusHistoryBetween(ctx context.Context, cp string, from, to *time.Time) error {
+	_, err := r.db.Exec(ctx, `
+		delete from connector_status_history
+		where charge_point_id=$1
+		  and ($2::timestamptz is null or started_at >= $2)
+		  and ($3::timestamptz is null or started_at < $3)
+	`, cp, from, to)
 	return err
 }
@@ -7,12 +7,11 @@ import (
 	"cpms/internal/models"
 
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-type TariffsRepo struct{ db *pgxpool.Pool }
+type TariffsRepo struct{ db DBTX }
 
-func NewTariffsRepo(db *pgxpool.Pool) *TariffsRepo { return &TariffsRepo{db: db} }
+func NewTariffsRepo(db DBTX) *TariffsRepo { return &TariffsRepo{db: db} }
 
 func (r *TariffsRepo) UpsertActiveForSite(ctx context.Context, siteId string, pricePerKwh float64, currency string) (string, error) {
 	// Deactivate previous active tariffs for the site, then insert a new active tariff
@@ -0,0 +1,200 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"cpms/internal/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// DefaultTenantId owns the data that existed before tenants were introduced.
+const DefaultTenantId = "00000000-0000-0000-0000-000000000001"
+
+// TenantsRepo stores tenants and answers which tenant owns a resource.
+type TenantsRepo struct{ db DBTX }
+
+func NewTenantsRepo(db DBTX) *TenantsRepo { return &TenantsRepo{db: db} }
+
+const tenantCols = `tenant_id, name, default_currency, gateway_base_url, gateway_api_key, created_at, updated_at`
+
+func scanTenant(row pgx.Row) (models.Tenant, error) {
+	var t models.Tenant
+	err := row.Scan(&t.TenantId, &t.Name, &t.DefaultCurrency, &t.GatewayBaseURL, &t.GatewayAPIKey, &t.CreatedAt, &t.UpdatedAt)
+	return t, err
+}
+
+// Create returns false if the name is taken.
+func (r *TenantsRepo) Create(ctx context.Context, t models.Tenant) (string, bool, error) {
+	row := r.db.QueryRow(ctx, `
+		insert into tenants (name, default_currency, gateway_base_url, gateway_api_key)
+		values ($1, coalesce(nullif($2,''),'USD'), $3, $4)
+		on conflict (name) do nothing
+		returning tenant_id
+	`, t.Name, t.DefaultCurrency, t.GatewayBaseURL, t.GatewayAPIKey)
+	var id string
+	if err := row.Scan(&id); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	return id, true, nil
+}
+
+func (r *TenantsRepo) Get(ctx context.Context, id string) (*models.Tenant, error) {
+	t, err := scanTenant(r.db.QueryRow(ctx, `select `+tenantCols+` from tenants where tenant_id::text=$1`, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &t, nil
+}
+
+// List returns tenants by name; tenantId != "" returns only that tenant.
+func (r *TenantsRepo) List(ctx context.Context, tenantId string) ([]models.Tenant, error) {
+	rows, err := r.db.Query(ctx, `select `+tenantCols+` from tenants where ($1='' or tenant_id::text=$1) order by name`, tenantId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.Tenant
+	for rows.Next() {
+		t, err := scanTenant(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+// TenantUpdate holds the fields to change; nil leaves a field as is. An empty
+// GatewayBaseURL/GatewayAPIKey falls back to the CPMS default again.
+type TenantUpdate struct {
+	Name            *string
+	DefaultCurrency *string
+	GatewayBaseURL  *string
+	GatewayAPIKey   *string
+}
+
+// Update returns false if the tenant does not exist.
+func (r *TenantsRepo) Update(ctx context.Context, id string, u TenantUpdate) (bool, error) {
+	tag, err := r.db.Exec(ctx, `
+		update tenants set
+		  name=coalesce($2, name),
+		  default_currency=coalesce($3, default_currency),
+		  gateway_base_url=case when $4::text is null then gateway_base_url else nullif($4,'') end,
+		  gateway_api_key=case when $5::text is null then gateway_api_key else nullif($5,'') end,
+		  updated_at=now()
+		where tenant_id::text=$1
+	`, id, u.Name, u.DefaultCurrency, u.GatewayBaseURL, u.GatewayAPIKey)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// GetByGatewayKey returns the tenant whose own gateway uses key, if any.
+func (r *TenantsRepo) GetByGatewayKey(ctx context.Context, key string) (*models.Tenant, error) {
+	t, err := scanTenant(r.db.QueryRow(ctx, `select `+tenantCols+` from tenants where gateway_api_key=$1`, key))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &t, nil
+}
+
+// ForCharger returns the tenant of a charger, or nil if it has none (or does not exist).
+func (r *TenantsRepo) ForCharger(ctx context.Context, cp string) (*models.Tenant, error) {
+	t, err := scanTenant(r.db.QueryRow(ctx, `
+		select `+tenantCols+` from tenants
+		where tenant_id=(select tenant_id from chargers where charge_point_id=$1)
+	`, cp))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &t, nil
+}
+
+// ForSite returns the tenant of a site, or nil if the site does not exist.
+func (r *TenantsRepo) ForSite(ctx context.Context, siteId string) (*models.Tenant, error) {
+	t, err := scanTenant(r.db.QueryRow(ctx, `
+		select `+tenantCols+` from tenants
+		where tenant_id=(select tenant_id from sites where site_id::text=$1)
+	`, siteId))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Resource kinds for Owns.
+const (
+	ResCharger     = "charger"
+	ResSite        = "site"
+	ResSession     = "session"
+	ResSettlement  = "settlement"
+	ResEvent       = "event"
+	ResDeadLetter  = "deadLetter"
+	ResMaintenance = "maintenance"
+	ResAPIKey      = "apiKey"
+	ResTenant      = "tenant"
+	ResCommand     = "command"
+	ResCommandJob  = "commandJob"
+)
+
+var ownerQueries = map[string]string{
+	ResCharger:     `select tenant_id::text from chargers where charge_point_id=$1`,
+	ResSite:        `select tenant_id::text from sites where site_id::text=$1`,
+	ResSession:     `select c.tenant_id::text from sessions s join chargers c using (charge_point_id) where s.session_id::text=$1`,
+	ResSettlement:  `select st.tenant_id::text from settlements x join sites st using (site_id) where x.settlement_id::text=$1`,
+	ResEvent:       `select c.tenant_id::text from gateway_events e join chargers c using (charge_point_id) where e.id::text=$1`,
+	ResDeadLetter:  `select c.tenant_id::text from dead_letter_events d join chargers c using (charge_point_id) where d.id::text=$1`,
+	ResMaintenance: `select tenant_id::text from maintenance_windows where maintenance_id::text=$1`,
+	ResAPIKey:      `select tenant_id::text from api_keys where key_id::text=$1`,
+	ResTenant:      `select tenant_id::text from tenants where tenant_id::text=$1`,
+	ResCommand:     `select c.tenant_id::text from commands x join chargers c using (charge_point_id) where x.command_id::text=$1`,
+	ResCommandJob:  `select tenant_id::text from command_jobs where job_id::text=$1`,
+}
+
+// Owns reports whether tenantId owns the resource. Resources that do not exist, or have no
+// tenant (unassigned chargers, platform keys, fleet-wide windows), are not owned by any tenant.
+func (r *TenantsRepo) Owns(ctx context.Context, kind, id, tenantId string) (bool, error) {
+	q, ok := ownerQueries[kind]
+	if !ok {
+		return false, fmt.Errorf("unknown resource kind %q", kind)
+	}
+	var owner *string
+	if err := r.db.QueryRow(ctx, q, id).Scan(&owner); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	return owner != nil && *owner == tenantId, nil
+}
+
+// chargerInTenant restricts a charge_point_id column to the chargers of the tenant in
+// parameter $n; an empty tenant does not filter.
+func chargerInTenant(col string, n int) string {
+	return fmt.Sprintf("($%d = '' or %s in (select charge_point_id from chargers where tenant_id::text=$%d))", n, col, n)
+}
+
+// siteInTenant is chargerInTenant for a site_id column.
+func siteInTenant(col string, n int) string {
+	return fmt.Sprintf("($%d = '' or %s in (select site_id from sites where tenant_id::text=$%d))", n, col, n)
+}
@@ -0,0 +1,79 @@
+package security
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// NormalizeFingerprint turns "AB:CD:..." or "abcd..." into lowercase hex without
+// separators. ok is false unless it is a SHA-256 fingerprint (32 bytes).
+func NormalizeFingerprint(fp string) (string, bool) {
+	fp = strings.ToLower(strings.NewReplacer(":", "", " ", "", "-", "").Replace(strings.TrimSpace(fp)))
+	b, err := hex.DecodeString(fp)
+	if err != nil || len(b) != sha256.Size {
+		return "", false
+	}
+	return fp, true
+}
+
+// CertFingerprint is the SHA-256 of the certificate's DER encoding, lowercase hex.
+func CertFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// ParseCertificatesPEM decodes all CERTIFICATE blocks; the first one is the leaf.
+func ParseCertificatesPEM(data string) ([]*x509.Certificate, error) {
+	var out []*x509.Certificate
+	rest := []byte(data)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	if len(out) == 0 {
+		return nil, errors.New("no certificate in PEM")
+	}
+	return out, nil
+}
+
+// VerifyClientCert checks that leaf chains to one of the CAs in caPEM (through the
+// given intermediates) at now, is valid for client auth and has subject CN expectedCN.
+func VerifyClientCert(leaf *x509.Certificate, intermediates []*x509.Certificate, caPEM, expectedCN string, now time.Time) error {
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM([]byte(caPEM)) {
+		return errors.New("invalid CA PEM")
+	}
+	inter := x509.NewCertPool()
+	for _, c := range intermediates {
+		inter.AddCert(c)
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: inter,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		return err
+	}
+	if leaf.Subject.CommonName != expectedCN {
+		return fmt.Errorf("certificate CN %q, expected %q", leaf.Subject.CommonName, expectedCN)
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package security
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+// JWTVerifier validates compact JWS tokens signed with HS256 (shared secret) or RS256
+// (public keys from a local JWKS file). Only these two algorithms are accepted.
+type JWTVerifier struct {
+	HS256Key []byte
+	RSAKeys  map[string]*rsa.PublicKey // by kid
+	// Issuer and Audience are checked when set.
+	Issuer   string
+	Audience string
+	// RolesClaim names the claim holding the roles (array or space separated string).
+	RolesClaim string
+	// TenantClaim names the claim holding the tenant id. A token without it is only
+	// accepted when its roles include PlatformRole, which makes it platform-wide.
+	TenantClaim  string
+	PlatformRole string
+	Leeway       time.Duration
+}
+
+// JWTClaims are the claims the API uses.
+type JWTClaims struct {
+	Subject   string
+	Roles     []string
+	Tenant    string
+	ExpiresAt time.Time
+}
+
+// Enabled reports whether any verification key is configured.
+func (v *JWTVerifier) Enabled() bool {
+	return v != nil && (len(v.HS256Key) > 0 || len(v.RSAKeys) > 0)
+}
+
+// Verify checks signature, exp (required), nbf, iss and aud and returns the claims.
+func (v *JWTVerifier) Verify(token string, now time.Time) (*JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("header: %w", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed signature")
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+
+	switch header.Alg {
+	case "HS256":
+		if len(v.HS256Key) == 0 {
+			return nil, errors.New("HS256 not configured")
+		}
+		mac := hmac.New(sha256.New, v.HS256Key)
+		mac.Write(signed)
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return nil, errors.New("invalid signature")
+		}
+	case "RS256":
+		key := v.rsaKey(header.Kid)
+		if key == nil {
+			return nil, fmt.Errorf("unknown key %q", header.Kid)
+		}
+		sum := sha256.Sum256(signed)
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig); err != nil {
+			return nil, errors.New("invalid signature")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported alg %q", header.Alg)
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("claims: %w", err)
+	}
+	exp, ok := numericDate(claims["exp"])
+	if !ok {
+		return nil, errors.New("exp required")
+	}
+	if !now.Before(exp.Add(v.Leeway)) {
+		return nil, errors.New("token expired")
+	}
+	if nbf, ok := numericDate(claims["nbf"]); ok && now.Add(v.Leeway).Before(nbf) {
+		return nil, errors.New("token not valid yet")
+	}
+	if v.Issuer != "" && claims["iss"] != v.Issuer {
+		return nil, errors.New("wrong issuer")
+	}
+	if v.Audience != "" && !containsString(claims["aud"], v.Audience) {
+		return nil, errors.New("wrong audience")
+	}
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return nil, errors.New("sub required")
+	}
+	rolesClaim := v.RolesClaim
+	if rolesClaim == "" {
+		rolesClaim = "roles"
+	}
+	tenantClaim := v.TenantClaim
+	if tenantClaim == "" {
+		tenantClaim = "tenant"
+	}
+	platformRole := v.PlatformRole
+	if platformRole == "" {
+		platformRole = "platform"
+	}
+	var tenant string
+	switch t := claims[tenantClaim].(type) {
+	case nil:
+	case string:
+		tenant = t
+	default:
+		return nil, fmt.Errorf("%s claim must be a string", tenantClaim)
+	}
+	roles := stringList(claims[rolesClaim])
+	if tenant == "" && !containsString(claims[rolesClaim], platformRole) {
+		return nil, fmt.Errorf("%s claim required", tenantClaim)
+	}
+	return &JWTClaims{Subject: sub, Roles: roles, Tenant: tenant, ExpiresAt: exp}, nil
+}
+
+// rsaKey returns the key for kid; without kid only an unambiguous single key is used.
+func (v *JWTVerifier) rsaKey(kid string) *rsa.PublicKey {
+	if kid != "" {
+		return v.RSAKeys[kid]
+	}
+	if len(v.RSAKeys) == 1 {
+		for _, k := range v.RSAKeys {
+			return k
+		}
+	}
+	return nil
+}
+
+// LoadJWKS reads the RSA public keys of a JWKS file ({"keys":[{"kty":"RSA","kid":..,"n":..,"e":..}]}).
+// Keys of other types are ignored.
+func LoadJWKS(path string) (map[string]*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("jwks: %w", err)
+	}
+	out := map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+		e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+		if err1 != nil || err2 != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("jwks: invalid key %q", k.Kid)
+		}
+		out[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	if len(out) == 0 {
+		return nil, errors.New("jwks: no RSA keys")
+	}
+	return out, nil
+}
+
+func decodeSegment(seg string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+func numericDate(v any) (time.Time, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(f), 0).UTC(), true
+}
+
+// stringList accepts a JSON array of strings or a space separated string.
+func stringList(v any) []string {
+	switch x := v.(type) {
+	case string:
+		return strings.Fields(x)
+	case []any:
+		var out []string
+		for _, e := range x {
+			if s, ok := e.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func containsString(v any, want string) bool {
+	for _, s := range stringList(v) {
+		if s == want {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package security
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Argon2id parameters for new hashes (OWASP minimum: 19 MiB, 2 iterations, 1 lane).
+const (
+	argonMemoryKiB = 19 * 1024
+	argonTime      = 2
+	argonThreads   = 1
+	argonKeyLen    = 32
+	argonSaltLen   = 16
+)
+
+// HashSecret hashes a charger secret with argon2id and a random salt. The result is
+// self-describing: $argon2id$v=19$m=19456,t=2,p=1$<salt>$<hash> (unpadded base64).
+func HashSecret(secret string) (string, error) {
+	salt := make([]byte, argonSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(secret), salt, argonTime, argonMemoryKiB, argonThreads, argonKeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, argonMemoryKiB, argonTime, argonThreads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// VerifySecret checks secret against an argon2id hash from HashSecret or a legacy unsalted
+// SHA-256 hex hash. needsRehash is true when the secret matched a legacy hash or one made
+// with other parameters, so the caller should store HashSecret(secret) instead.
+func VerifySecret(encoded, secret string) (ok, needsRehash bool) {
+	if !strings.HasPrefix(encoded, "$argon2id$") {
+		return ConstantTimeEqualHex(encoded, HashSecretSHA256(secret)), true
+	}
+
+	// "", "argon2id", "v=19", "m=..,t=..,p=..", salt, hash
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return false, false
+	}
+	var version int
+	var memory, iterations uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, false
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &threads); err != nil {
+		return false, false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(want) == 0 {
+		return false, false
+	}
+	got := argon2.IDKey([]byte(secret), salt, iterations, memory, threads, uint32(len(want)))
+	if subtle.ConstantTimeCompare(got, want) != 1 {
+		return false, false
+	}
+	stale := memory != argonMemoryKiB || iterations != argonTime || threads != argonThreads || len(want) != argonKeyLen
+	return true, stale
+}
@@ -1,6 +1,7 @@
 package security
 
 import (
+	"crypto/rand"
 	"crypto/sha256"
 	"crypto/subtle"
 	"encoding/hex"
@@ -22,3 +23,26 @@ func ConstantTimeEqualHex(aHex, bHex string) bool {
 	}
 	return subtle.ConstantTimeCompare(a, b) == 1
 }
+
+// GenerateSecret returns a random charger secret: 20 bytes hex encoded (40 characters),
+// the maximum length of the OCPP basic auth password (AuthorizationKey / BasicAuthPassword).
+func GenerateSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// APIKeyPrefix marks management API keys so they can be told apart from JWTs.
+const APIKeyPrefix = "cpms_"
+
+// GenerateAPIKey returns a random management API key ("cpms_" + 32 bytes hex). Keys are
+// high-entropy, so they are stored as HashSecretSHA256 and looked up by hash.
+func GenerateAPIKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return APIKeyPrefix + hex.EncodeToString(b), nil
+}
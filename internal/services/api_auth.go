@@ -0,0 +1,120 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"cpms/internal/models"
+	"cpms/internal/repo"
+	"cpms/internal/security"
+)
+
+// Roles of management API clients.
+const (
+	RoleViewer   = "viewer"
+	RoleOperator = "operator"
+	RoleFinance  = "finance"
+	RoleAdmin    = "admin"
+)
+
+// ValidRole reports whether role is one of the known roles.
+func ValidRole(role string) bool {
+	switch role {
+	case RoleViewer, RoleOperator, RoleFinance, RoleAdmin:
+		return true
+	}
+	return false
+}
+
+// Identity is an authenticated management API client.
+type Identity struct {
+	Subject string // "apikey:<name>" or the JWT sub
+	Method  string // ApiKey|JWT
+	Roles   []string
+	// TenantId scopes the client to one tenant; "" is a platform-wide client.
+	TenantId string
+}
+
+func (id *Identity) HasRole(role string) bool {
+	for _, r := range id.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// APIAuth authenticates management API clients by API key or JWT.
+type APIAuth struct {
+	Keys *repo.APIKeysRepo
+	JWT  *security.JWTVerifier // optional
+}
+
+func NewAPIAuth(keys *repo.APIKeysRepo, jwt *security.JWTVerifier) *APIAuth {
+	return &APIAuth{Keys: keys, JWT: jwt}
+}
+
+// NewJWTVerifier builds the verifier from configuration; it returns nil (JWT disabled)
+// when neither an HS256 secret nor a JWKS file is set. Tokens must carry tenantClaim
+// unless their roles include platformRole.
+func NewJWTVerifier(hs256Secret, jwksFile, issuer, audience, rolesClaim, tenantClaim, platformRole string) (*security.JWTVerifier, error) {
+	if hs256Secret == "" && jwksFile == "" {
+		return nil, nil
+	}
+	v := &security.JWTVerifier{Issuer: issuer, Audience: audience, RolesClaim: rolesClaim, TenantClaim: tenantClaim, PlatformRole: platformRole, Leeway: 30 * time.Second}
+	if hs256Secret != "" {
+		v.HS256Key = []byte(hs256Secret)
+	}
+	if jwksFile != "" {
+		keys, err := security.LoadJWKS(jwksFile)
+		if err != nil {
+			return nil, err
+		}
+		v.RSAKeys = keys
+	}
+	return v, nil
+}
+
+// Authenticate resolves a bearer token: keys starting with security.APIKeyPrefix are
+// looked up in api_keys, anything else is verified as a JWT. Returns nil for an invalid
+// token; the error is only set on lookup failures.
+func (a *APIAuth) Authenticate(ctx context.Context, token string) (*Identity, error) {
+	now := time.Now().UTC()
+	if strings.HasPrefix(token, security.APIKeyPrefix) {
+		k, err := a.Keys.GetActiveByHash(ctx, security.HashSecretSHA256(token))
+		if err != nil || k == nil {
+			return nil, err
+		}
+		_ = a.Keys.TouchUsed(ctx, k.KeyId, now)
+		id := &Identity{Subject: "apikey:" + k.Name, Method: "ApiKey", Roles: k.Roles}
+		if k.TenantId != nil {
+			id.TenantId = *k.TenantId
+		}
+		return id, nil
+	}
+	if !a.JWT.Enabled() {
+		return nil, nil
+	}
+	c, err := a.JWT.Verify(token, now)
+	if err != nil {
+		return nil, nil
+	}
+	return &Identity{Subject: c.Subject, Method: "JWT", Roles: c.Roles, TenantId: c.Tenant}, nil
+}
+
+// CreateKey generates an API key, scoped to tenantId unless it is nil; the key itself is
+// only returned here.
+func (a *APIAuth) CreateKey(ctx context.Context, name string, roles []string, tenantId *string, createdBy string) (id, key string, err error) {
+	if key, err = security.GenerateAPIKey(); err != nil {
+		return "", "", err
+	}
+	id, err = a.Keys.Create(ctx, models.APIKey{
+		Name:      name,
+		KeyPrefix: key[:len(security.APIKeyPrefix)+8],
+		Roles:     roles,
+		TenantId:  tenantId,
+		CreatedBy: createdBy,
+	}, security.HashSecretSHA256(key))
+	return id, key, err
+}
@@ -0,0 +1,70 @@
+package services
+
+import (
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+// authCacheMax bounds the number of cached verifications; expired entries are
+// dropped when it is reached.
+const authCacheMax = 10000
+
+// authCache remembers which credential a presented secret matched, so repeated
+// handshakes skip the (deliberately slow) password hash. Only a SHA-256 of charge point
+// and secret is kept. Validity of the credential is still checked on every call.
+type authCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[[32]byte]authCacheEntry
+}
+
+type authCacheEntry struct {
+	credentialId string
+	expiresAt    time.Time
+}
+
+func newAuthCache(ttl time.Duration) *authCache {
+	return &authCache{ttl: ttl, entries: map[[32]byte]authCacheEntry{}}
+}
+
+func authCacheKey(cp, secret string) [32]byte {
+	return sha256.Sum256([]byte(cp + "\x00" + secret))
+}
+
+func (c *authCache) get(cp, secret string, now time.Time) (string, bool) {
+	if c == nil || c.ttl <= 0 {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	k := authCacheKey(cp, secret)
+	e, ok := c.entries[k]
+	if !ok {
+		return "", false
+	}
+	if !now.Before(e.expiresAt) {
+		delete(c.entries, k)
+		return "", false
+	}
+	return e.credentialId, true
+}
+
+func (c *authCache) put(cp, secret, credentialId string, now time.Time) {
+	if c == nil || c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= authCacheMax {
+		for k, e := range c.entries {
+			if !now.Before(e.expiresAt) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= authCacheMax {
+			c.entries = map[[32]byte]authCacheEntry{}
+		}
+	}
+	c.entries[authCacheKey(cp, secret)] = authCacheEntry{credentialId: credentialId, expiresAt: now.Add(c.ttl)}
+}
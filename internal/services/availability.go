@@ -0,0 +1,329 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"cpms/internal/models"
+	"cpms/internal/repo"
+)
+
+// AvailabilityRules decide when a charger or connector counts as down.
+type AvailabilityRules struct {
+	// OfflineAfter: no event (heartbeat, status, ...) for this long means offline,
+	// e.g. 3 missed heartbeats of 5m = 15m.
+	OfflineAfter time.Duration
+	// UnavailableStatuses are connector statuses that count as down (default Faulted, Unavailable).
+	UnavailableStatuses []string
+	// TargetPercent is the contractual availability, reported as meetsTarget.
+	TargetPercent float64
+}
+
+type AvailabilityRequest struct {
+	From          time.Time
+	To            time.Time
+	SiteId        string
+	ChargePointId string
+	TenantId      string // "" = all tenants
+}
+
+// AvailabilityFigures are port-weighted: a charger with 2 connectors has twice
+// the eligible time of a single-connector charger. Maintenance is excluded from
+// eligible time; offline/unavailable time inside maintenance is not counted.
+type AvailabilityFigures struct {
+	EligibleSeconds    int64   `json:"eligibleSeconds"`
+	AvailableSeconds   int64   `json:"availableSeconds"`
+	OfflineSeconds     int64   `json:"offlineSeconds"`
+	UnavailableSeconds int64   `json:"unavailableSeconds"`
+	MaintenanceSeconds int64   `json:"maintenanceSeconds"`
+	AvailabilityPct    float64 `json:"availabilityPct"`
+	MeetsTarget        bool    `json:"meetsTarget"`
+}
+
+type ChargerAvailability struct {
+	ChargePointId string  `json:"chargePointId"`
+	SiteId        *string `json:"siteId"`
+	Connectors    int     `json:"connectors"`
+	AvailabilityFigures
+}
+
+type SiteAvailability struct {
+	SiteId   string `json:"siteId"`
+	Chargers int    `json:"chargers"`
+	AvailabilityFigures
+}
+
+type AvailabilityReport struct {
+	From                time.Time             `json:"from"`
+	To                  time.Time             `json:"to"`
+	OfflineAfterSeconds int64                 `json:"offlineAfterSeconds"`
+	UnavailableStatuses []string              `json:"unavailableStatuses"`
+	TargetPercent       float64               `json:"targetPercent"`
+	Fleet               AvailabilityFigures   `json:"fleet"`
+	Sites               []SiteAvailability    `json:"sites"`
+	Chargers            []ChargerAvailability `json:"chargers"`
+}
+
+// AvailabilityService computes uptime of chargers from gateway_events (offline gaps),
+// connector_status_history (unavailable statuses) and maintenance_windows.
+type AvailabilityService struct {
+	Chargers    *repo.ChargersRepo
+	Events      *repo.EventsRepo
+	State       *repo.StateRepo
+	Maintenance *repo.MaintenanceRepo
+	Rules       AvailabilityRules
+}
+
+func NewAvailabilityService(c *repo.ChargersRepo, e *repo.EventsRepo, st *repo.StateRepo, m *repo.MaintenanceRepo, rules AvailabilityRules) *AvailabilityService {
+	return &AvailabilityService{Chargers: c, Events: e, State: st, Maintenance: m, Rules: rules}
+}
+
+func (s *AvailabilityService) Report(ctx context.Context, req AvailabilityRequest, rules AvailabilityRules) (*AvailabilityReport, error) {
+	if !req.From.Before(req.To) {
+		return nil, errors.New("from must be before to")
+	}
+	if rules.OfflineAfter <= 0 {
+		return nil, errors.New("offlineAfter must be > 0")
+	}
+	unavailable := map[string]bool{}
+	for _, st := range rules.UnavailableStatuses {
+		unavailable[st] = true
+	}
+
+	chargers, err := s.Chargers.ListForReport(ctx, req.TenantId, req.SiteId, req.ChargePointId)
+	if err != nil {
+		return nil, err
+	}
+	windows, err := s.Maintenance.ListOverlapping(ctx, req.TenantId, "", "", req.From, req.To)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &AvailabilityReport{
+		From:                req.From,
+		To:                  req.To,
+		OfflineAfterSeconds: int64(rules.OfflineAfter / time.Second),
+		UnavailableStatuses: rules.UnavailableStatuses,
+		TargetPercent:       rules.TargetPercent,
+		Sites:               []SiteAvailability{},
+		Chargers:            []ChargerAvailability{},
+	}
+	var fleet availabilityTotals
+	sites := map[string]*availabilityTotals{}
+	siteChargers := map[string]int{}
+
+	for _, c := range chargers {
+		from := req.From
+		if c.CreatedAt.After(from) {
+			from = c.CreatedAt
+		}
+		if !from.Before(req.To) {
+			continue
+		}
+		t, connectors, err := s.chargerTotals(ctx, c, from, req.To, windows, rules.OfflineAfter, unavailable)
+		if err != nil {
+			return nil, err
+		}
+		report.Chargers = append(report.Chargers, ChargerAvailability{
+			ChargePointId:       c.ChargePointId,
+			SiteId:              c.SiteId,
+			Connectors:          connectors,
+			AvailabilityFigures: t.figures(rules.TargetPercent),
+		})
+		fleet.add(t)
+		if c.SiteId != nil {
+			if sites[*c.SiteId] == nil {
+				sites[*c.SiteId] = &availabilityTotals{}
+			}
+			sites[*c.SiteId].add(t)
+			siteChargers[*c.SiteId]++
+		}
+	}
+
+	for id, t := range sites {
+		report.Sites = append(report.Sites, SiteAvailability{SiteId: id, Chargers: siteChargers[id], AvailabilityFigures: t.figures(rules.TargetPercent)})
+	}
+	sort.Slice(report.Sites, func(i, j int) bool { return report.Sites[i].SiteId < report.Sites[j].SiteId })
+	report.Fleet = fleet.figures(rules.TargetPercent)
+	return report, nil
+}
+
+// chargerTotals computes the port-weighted totals of one charger in [from, to).
+// Each connector > 0 is a port (a charger without known connectors counts as one);
+// a port is down while the charger is offline, connector 0 is in an unavailable
+// status, or the port itself is.
+func (s *AvailabilityService) chargerTotals(ctx context.Context, c repo.ReportCharger, from, to time.Time, windows []models.MaintenanceWindow, offlineAfter time.Duration, unavailable map[string]bool) (availabilityTotals, int, error) {
+	window := interval{from, to}
+
+	var maintenance intervals
+	for _, m := range windows {
+		if m.ChargePointId != nil && *m.ChargePointId != c.ChargePointId {
+			continue
+		}
+		if m.SiteId != nil && (c.SiteId == nil || *m.SiteId != *c.SiteId) {
+			continue
+		}
+		if m.TenantId != nil && (c.TenantId == nil || *m.TenantId != *c.TenantId) {
+			continue
+		}
+		maintenance = append(maintenance, interval{m.StartsAt, m.EndsAt})
+	}
+	maintenance = maintenance.clip(window).merge()
+
+	gaps, err := s.Events.OfflineGaps(ctx, c.ChargePointId, from, to, offlineAfter)
+	if err != nil {
+		return availabilityTotals{}, 0, err
+	}
+	var offline intervals
+	for _, g := range gaps {
+		offline = append(offline, interval{g[0], g[1]})
+	}
+	offline = offline.clip(window).merge().subtract(maintenance)
+
+	history, err := s.State.ListChargerStatusHistory(ctx, c.ChargePointId, from, to)
+	if err != nil {
+		return availabilityTotals{}, 0, err
+	}
+	down := map[int]intervals{}
+	var ports []int
+	for _, h := range history {
+		if _, ok := down[h.ConnectorId]; !ok {
+			down[h.ConnectorId] = nil
+			if h.ConnectorId > 0 {
+				ports = append(ports, h.ConnectorId)
+			}
+		}
+		if !unavailable[h.Status] {
+			continue
+		}
+		end := to
+		if h.EndedAt != nil {
+			end = *h.EndedAt
+		}
+		down[h.ConnectorId] = append(down[h.ConnectorId], interval{h.StartedAt, end})
+	}
+	if len(ports) == 0 {
+		ports = []int{1}
+	}
+
+	eligible := window.duration() - maintenance.total()
+	var t availabilityTotals
+	t.maintenance = maintenance.total() * time.Duration(len(ports))
+	for _, port := range ports {
+		statusDown := append(append(intervals{}, down[0]...), down[port]...).clip(window).merge().subtract(maintenance)
+		unavail := statusDown.subtract(offline).total()
+		t.eligible += eligible
+		t.offline += offline.total()
+		t.unavailable += unavail
+		t.available += eligible - offline.total() - unavail
+	}
+	return t, len(ports), nil
+}
+
+type availabilityTotals struct {
+	eligible, available, offline, unavailable, maintenance time.Duration
+}
+
+func (t *availabilityTotals) add(o availabilityTotals) {
+	t.eligible += o.eligible
+	t.available += o.available
+	t.offline += o.offline
+	t.unavailable += o.unavailable
+	t.maintenance += o.maintenance
+}
+
+func (t availabilityTotals) figures(target float64) AvailabilityFigures {
+	f := AvailabilityFigures{
+		EligibleSeconds:    int64(t.eligible / time.Second),
+		AvailableSeconds:   int64(t.available / time.Second),
+		OfflineSeconds:     int64(t.offline / time.Second),
+		UnavailableSeconds: int64(t.unavailable / time.Second),
+		MaintenanceSeconds: int64(t.maintenance / time.Second),
+		AvailabilityPct:    100,
+	}
+	if t.eligible > 0 {
+		f.AvailabilityPct = round(float64(t.available)/float64(t.eligible)*100, 3)
+	}
+	f.MeetsTarget = f.AvailabilityPct >= target
+	return f
+}
+
+type interval struct{ from, to time.Time }
+
+func (i interval) duration() time.Duration { return i.to.Sub(i.from) }
+
+type intervals []interval
+
+// clip cuts the intervals to w and drops empty ones.
+func (is intervals) clip(w interval) intervals {
+	var out intervals
+	for _, i := range is {
+		if i.from.Before(w.from) {
+			i.from = w.from
+		}
+		if i.to.After(w.to) {
+			i.to = w.to
+		}
+		if i.to.After(i.from) {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// merge sorts and joins overlapping intervals.
+func (is intervals) merge() intervals {
+	if len(is) == 0 {
+		return nil
+	}
+	sorted := append(intervals{}, is...)
+	sort.Slice(sorted, func(a, b int) bool { return sorted[a].from.Before(sorted[b].from) })
+	out := intervals{sorted[0]}
+	for _, i := range sorted[1:] {
+		last := &out[len(out)-1]
+		if !i.from.After(last.to) {
+			if i.to.After(last.to) {
+				last.to = i.to
+			}
+			continue
+		}
+		out = append(out, i)
+	}
+	return out
+}
+
+// subtract removes the merged intervals cut from the merged intervals is.
+func (is intervals) subtract(cut intervals) intervals {
+	var out intervals
+	for _, i := range is {
+		rest := intervals{i}
+		for _, c := range cut {
+			var next intervals
+			for _, r := range rest {
+				if !c.from.Before(r.to) || !c.to.After(r.from) {
+					next = append(next, r)
+					continue
+				}
+				if c.from.After(r.from) {
+					next = append(next, interval{r.from, c.from})
+				}
+				if c.to.Before(r.to) {
+					next = append(next, interval{c.to, r.to})
+				}
+			}
+			rest = next
+		}
+		out = append(out, rest...)
+	}
+	return out
+}
+
+func (is intervals) total() time.Duration {
+	var d time.Duration
+	for _, i := range is {
+		d += i.duration()
+	}
+	return d
+}
@@ -0,0 +1,166 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// OCPP versions of the command catalog; chargers reporting 2.x use the 2.0.1 commands,
+// all others (including an unknown version) the 1.6 ones.
+const (
+	OCPP16  = "1.6"
+	OCPP201 = "2.0.1"
+)
+
+// CommandSpec describes a command the CPMS accepts for chargers of one OCPP version.
+type CommandSpec struct {
+	Type        string      `json:"type"`
+	Description string      `json:"description"`
+	Replaces    string      `json:"replaces,omitempty"` // the OCPP 1.6 command a 2.0.1 command replaces
+	Schema      *JSONSchema `json:"payloadSchema"`
+
+	payload reflect.Type
+}
+
+func commandSpec(cmdType, description, replaces string, payload any) CommandSpec {
+	t := reflect.TypeOf(payload)
+	return CommandSpec{Type: cmdType, Description: description, Replaces: replaces, Schema: schemaFor(t), payload: t}
+}
+
+var commandCatalog = map[string][]CommandSpec{
+	OCPP16: {
+		commandSpec("RemoteStartTransaction", "Start a transaction for idTag, optionally on a connector and with a charging profile", "", RemoteStartTransaction16{}),
+		commandSpec("RemoteStopTransaction", "Stop a running transaction", "", RemoteStopTransaction16{}),
+		commandSpec("Reset", "Soft or hard reboot of the charger", "", Reset16{}),
+		commandSpec("UnlockConnector", "Unlock the cable of a connector", "", UnlockConnector16{}),
+		commandSpec("ChangeAvailability", "Make the charger (connectorId 0) or a connector (in)operative", "", ChangeAvailability16{}),
+		commandSpec("ChangeConfiguration", "Set a configuration key", "", ChangeConfiguration16{}),
+		commandSpec("GetConfiguration", "Read configuration keys (all when key is empty)", "", GetConfiguration16{}),
+		commandSpec("TriggerMessage", "Ask the charger to send a message now", "", TriggerMessage16{}),
+		commandSpec("ClearCache", "Clear the authorization cache", "", ClearCache{}),
+		commandSpec("ReserveNow", "Reserve a connector for idTag until expiryDate", "", ReserveNow16{}),
+		commandSpec("CancelReservation", "Cancel a reservation", "", CancelReservation{}),
+		commandSpec("SetChargingProfile", "Install a charging profile", "", SetChargingProfile16{}),
+		commandSpec("ClearChargingProfile", "Remove charging profiles matching the criteria", "", ClearChargingProfile16{}),
+		commandSpec("UpdateFirmware", "Download and install firmware from location", "", UpdateFirmware16{}),
+		commandSpec("GetDiagnostics", "Upload a diagnostics file to location", "", GetDiagnostics16{}),
+		commandSpec("DataTransfer", "Vendor specific message", "", DataTransfer16{}),
+	},
+	OCPP201: {
+		commandSpec("RequestStartTransaction", "Start a transaction for idToken, optionally on an EVSE and with a charging profile", "RemoteStartTransaction", RequestStartTransaction201{}),
+		commandSpec("RequestStopTransaction", "Stop a running transaction", "RemoteStopTransaction", RequestStopTransaction201{}),
+		commandSpec("Reset", "Reboot the charging station (or an EVSE) now or when idle", "", Reset201{}),
+		commandSpec("UnlockConnector", "Unlock the cable of a connector", "", UnlockConnector201{}),
+		commandSpec("ChangeAvailability", "Make the station, an EVSE or a connector (in)operative", "", ChangeAvailability201{}),
+		commandSpec("SetVariables", "Set device model variables", "ChangeConfiguration", SetVariables201{}),
+		commandSpec("GetVariables", "Read device model variables", "GetConfiguration", GetVariables201{}),
+		commandSpec("TriggerMessage", "Ask the station to send a message now", "", TriggerMessage201{}),
+		commandSpec("ClearCache", "Clear the authorization cache", "", ClearCache{}),
+		commandSpec("ReserveNow", "Reserve an EVSE or connector type for idToken until expiryDateTime", "", ReserveNow201{}),
+		commandSpec("CancelReservation", "Cancel a reservation", "", CancelReservation{}),
+		commandSpec("SetChargingProfile", "Install a charging profile", "", SetChargingProfile201{}),
+		commandSpec("ClearChargingProfile", "Remove charging profiles by id or criteria", "", ClearChargingProfile201{}),
+		commandSpec("UpdateFirmware", "Download and install firmware", "", UpdateFirmware201{}),
+		commandSpec("GetLog", "Upload a diagnostics or security log", "GetDiagnostics", GetLog201{}),
+		commandSpec("DataTransfer", "Vendor specific message", "", DataTransfer201{}),
+	},
+}
+
+// CommandCatalogVersion maps a charger's reported OCPP version to a catalog version.
+func CommandCatalogVersion(ocppVersion string) string {
+	if strings.HasPrefix(ocppVersion, "2.") {
+		return OCPP201
+	}
+	return OCPP16
+}
+
+// CommandCatalog returns the commands of a catalog version, or nil for an unknown version.
+func CommandCatalog(version string) []CommandSpec {
+	return commandCatalog[version]
+}
+
+func findCommand(version, cmdType string) *CommandSpec {
+	for i, c := range commandCatalog[version] {
+		if c.Type == cmdType {
+			return &commandCatalog[version][i]
+		}
+	}
+	return nil
+}
+
+// ValidateCommand checks a command for a charger with the given OCPP version and returns
+// its decoded payload (a pointer to the payload type). Unknown types and payloads that do
+// not match the schema are reported as a *ValidationError.
+func ValidateCommand(ocppVersion, cmdType string, payload []byte) (any, error) {
+	version := CommandCatalogVersion(ocppVersion)
+	spec := findCommand(version, cmdType)
+	if spec == nil {
+		return nil, unknownCommand(version, cmdType)
+	}
+	if err := validateJSON(spec.Schema, payload); err != nil {
+		return nil, err
+	}
+	v := reflect.New(spec.payload).Interface()
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return nil, invalidf("invalid %s payload: %v", cmdType, err)
+	}
+	return v, nil
+}
+
+// unknownCommand explains why cmdType is not in the catalog of version, pointing to the
+// replacement or the closest known name.
+func unknownCommand(version, cmdType string) error {
+	for _, c := range commandCatalog[version] {
+		if c.Replaces == cmdType {
+			return invalidf("%s is not an OCPP %s command; use %s", cmdType, version, c.Type)
+		}
+	}
+	for other, specs := range commandCatalog {
+		if other == version {
+			continue
+		}
+		for _, c := range specs {
+			if c.Type == cmdType {
+				if c.Replaces != "" && findCommand(version, c.Replaces) != nil {
+					return invalidf("%s is an OCPP %s command; use %s for this OCPP %s charger", cmdType, other, c.Replaces, version)
+				}
+				return invalidf("%s is an OCPP %s command; the charger uses OCPP %s", cmdType, other, version)
+			}
+		}
+	}
+	best, bestDist := "", 4 // suggest only near misses
+	for _, c := range commandCatalog[version] {
+		if d := editDistance(strings.ToLower(cmdType), strings.ToLower(c.Type)); d < bestDist {
+			best, bestDist = c.Type, d
+		}
+	}
+	if best != "" {
+		return invalidf("unknown command type %q (did you mean %s?)", cmdType, best)
+	}
+	return invalidf("unknown command type %q for OCPP %s", cmdType, version)
+}
+
+// editDistance is the Levenshtein distance of a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,173 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"cpms/internal/models"
+	"cpms/internal/repo"
+)
+
+// CommandDispatcher delivers commands stored in the commands table to the gateway with a
+// pool of workers. Commands of one charger are delivered in creation order. Transport
+// errors and retryable gateway answers (5xx, 408, 429) are retried with exponential
+// backoff until the command's deadline, then the command is Expired; other gateway
+// errors fail the command at once. An Acked command with an expected effect (see
+// CommandEffect) waits EffectWindow for the matching event and becomes NoEffect without it.
+type CommandDispatcher struct {
+	Commands     *repo.CommandsRepo
+	Gateways     *Gateways
+	Workers      int
+	PollInterval time.Duration
+	RetryBase    time.Duration
+	RetryMax     time.Duration
+	SendTimeout  time.Duration
+	Lease        time.Duration
+	EffectWindow time.Duration
+	// Limiter counts the commands sent outside bulk jobs against their gateway's limit, so
+	// jobs leave room for them (optional).
+	Limiter *GatewayLimiter
+
+	wake chan struct{}
+}
+
+func NewCommandDispatcher(c *repo.CommandsRepo, gw *Gateways, workers int, pollInterval, retryBase, retryMax time.Duration) *CommandDispatcher {
+	if workers <= 0 {
+		workers = 1
+	}
+	if pollInterval <= 0 {
+		pollInterval = time.Second
+	}
+	return &CommandDispatcher{
+		Commands:     c,
+		Gateways:     gw,
+		Workers:      workers,
+		PollInterval: pollInterval,
+		RetryBase:    retryBase,
+		RetryMax:     retryMax,
+		SendTimeout:  15 * time.Second,
+		Lease:        time.Minute,
+		EffectWindow: 2 * time.Minute,
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// Notify wakes an idle worker after a command was queued. Never blocks.
+func (d *CommandDispatcher) Notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run blocks until ctx is cancelled.
+func (d *CommandDispatcher) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < d.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.worker(ctx)
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		d.expirer(ctx)
+	}()
+	wg.Wait()
+}
+
+func (d *CommandDispatcher) worker(ctx context.Context) {
+	ticker := time.NewTicker(d.PollInterval)
+	defer ticker.Stop()
+	for {
+		c, err := d.Commands.ClaimNext(ctx, d.Lease)
+		if err != nil && ctx.Err() == nil {
+			log.Println("command dispatcher: claim:", err)
+		}
+		if c != nil {
+			d.Notify()
+			d.dispatch(ctx, *c)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// expirer moves commands past their deadline to Expired and Acked commands past their
+// effect window to NoEffect.
+func (d *CommandDispatcher) expirer(ctx context.Context) {
+	ticker := time.NewTicker(d.PollInterval)
+	defer ticker.Stop()
+	for {
+		if n, err := d.Commands.ExpireDue(ctx, d.Lease); err != nil && ctx.Err() == nil {
+			log.Println("command dispatcher: expire:", err)
+		} else if n > 0 {
+			log.Printf("command dispatcher: %d command(s) expired undelivered", n)
+		}
+		if _, err := d.Commands.MarkNoEffectDue(ctx); err != nil && ctx.Err() == nil {
+			log.Println("command dispatcher: no effect:", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *CommandDispatcher) dispatch(ctx context.Context, c models.Command) {
+	gw, err := d.Gateways.For(ctx, c.ChargePointId)
+	if err != nil {
+		d.retry(ctx, c, err.Error())
+		return
+	}
+	if !isJobCommand(c) {
+		d.Limiter.Spend(gw.BaseURL)
+	}
+	sendCtx, cancel := context.WithTimeout(ctx, d.SendTimeout)
+	status, body, err := gw.SendCommand(sendCtx, c.PayloadJSON)
+	cancel()
+	if ctx.Err() != nil {
+		// Shutting down; the lease expires and the command is reclaimed on restart.
+		return
+	}
+	switch {
+	case err != nil:
+		d.retry(ctx, c, err.Error())
+	case status >= 200 && status < 300:
+		if err := d.Commands.MarkAcked(ctx, c.CommandId, body, d.EffectWindow); err != nil {
+			log.Println("command dispatcher: mark acked:", c.CommandId, err)
+		}
+	case status >= 500 || status == http.StatusRequestTimeout || status == http.StatusTooManyRequests:
+		d.retry(ctx, c, fmt.Sprintf("gateway status %d: %s", status, body))
+	default:
+		if err := d.Commands.MarkFailed(ctx, c.CommandId, fmt.Sprintf("gateway status %d: %s", status, body)); err != nil {
+			log.Println("command dispatcher: mark failed:", c.CommandId, err)
+		}
+	}
+}
+
+// retry schedules the next attempt, or expires the command if that would be past its deadline.
+func (d *CommandDispatcher) retry(ctx context.Context, c models.Command, errMsg string) {
+	next := time.Now().UTC().Add(retryDelay(c.Attempts, d.RetryBase, d.RetryMax))
+	if !next.Before(c.ExpiresAt) {
+		if err := d.Commands.MarkExpired(ctx, c.CommandId, errMsg); err != nil {
+			log.Println("command dispatcher: mark expired:", c.CommandId, err)
+		}
+		return
+	}
+	if err := d.Commands.MarkRetry(ctx, c.CommandId, errMsg, next); err != nil {
+		log.Println("command dispatcher: mark retry:", c.CommandId, err)
+	}
+}
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"context"
+	"strconv"
+
+	"cpms/internal/models"
+	"cpms/internal/repo"
+)
+
+// operativeStatuses are the connector statuses that show a connector is operative again.
+var operativeStatuses = []string{"Available", "Preparing", "Charging", "SuspendedEVSE", "SuspendedEV", "Finishing", "Reserved", "Occupied"}
+
+// CommandEffect returns the charger event expected to follow a command with the given
+// decoded payload (as returned by ValidateCommand), or nil if the command's effect is not
+// tracked.
+func CommandEffect(payload any) *models.CommandEffect {
+	switch p := payload.(type) {
+	case *RemoteStartTransaction16:
+		return &models.CommandEffect{Event: "TransactionStarted", ConnectorId: p.ConnectorId, IdTag: &p.IdTag}
+	case *RequestStartTransaction201:
+		return &models.CommandEffect{Event: "TransactionStarted", EvseId: p.EvseId, IdTag: &p.IdToken.IdToken}
+	case *RemoteStopTransaction16:
+		txId := strconv.Itoa(p.TransactionId)
+		return &models.CommandEffect{Event: "TransactionEnded", TransactionId: &txId}
+	case *RequestStopTransaction201:
+		return &models.CommandEffect{Event: "TransactionEnded", TransactionId: &p.TransactionId}
+	case *ChangeAvailability16:
+		// Connector 0 (the whole charger) is not tracked: one connector's status change
+		// does not show that all of them changed.
+		if p.ConnectorId <= 0 {
+			return nil
+		}
+		e := availabilityEffect(p.Type)
+		e.ConnectorId = &p.ConnectorId
+		return e
+	case *ChangeAvailability201:
+		// Likewise for the whole station or a whole EVSE.
+		if p.Evse == nil || p.Evse.Id <= 0 || p.Evse.ConnectorId == nil {
+			return nil
+		}
+		e := availabilityEffect(p.OperationalStatus)
+		e.EvseId = &p.Evse.Id
+		e.ConnectorId = p.Evse.ConnectorId
+		return e
+	case *Reset16:
+		return &models.CommandEffect{Event: "ChargerBooted"}
+	case *Reset201:
+		if p.EvseId != nil { // an EVSE reset does not reboot the station
+			return nil
+		}
+		return &models.CommandEffect{Event: "ChargerBooted"}
+	}
+	return nil
+}
+
+// availabilityEffect expects the connector to move from Unavailable to an operative
+// status, or the reverse for Inoperative. Other status changes (a session ending, a fault
+// clearing) are not the command's doing.
+func availabilityEffect(kind string) *models.CommandEffect {
+	to, from := operativeStatuses, []string{"Unavailable"}
+	if kind == "Inoperative" {
+		to, from = from, to
+	}
+	return &models.CommandEffect{Event: "ConnectorStatusChanged", Statuses: to, FromStatuses: from}
+}
+
+// correlateCommand completes the command that caused an applied event, if any. It is a
+// no-op without a commands repo (replay).
+func (p *EventsProcessor) correlateCommand(ctx context.Context, ev models.GatewayEvent, m repo.EffectMatch) error {
+	if p.Commands == nil {
+		return nil
+	}
+	m.ChargePointId = ev.ChargePointId
+	m.EventId = ev.Id
+	m.ReceivedAt = ev.ReceivedAt
+	_, err := p.Commands.MatchEffect(ctx, m)
+	return err
+}
@@ -0,0 +1,257 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"cpms/internal/models"
+	"cpms/internal/repo"
+)
+
+// CommandJobRunner fans bulk command jobs out through the command outbox: for every
+// Running job it keeps up to the job's concurrency of commands in flight and submits at
+// most the job's rate per minute to each gateway, within the gateway's limit shared by
+// all jobs (Limiter). An item finishes with the outcome of its command (see
+// CommandJobsRepo.SyncItems); a job completes when all items finished.
+// Limits apply per CPMS instance.
+type CommandJobRunner struct {
+	Jobs         *repo.CommandJobsRepo
+	Chargers     *repo.ChargersRepo
+	Commands     *repo.CommandsRepo
+	Gateways     *Gateways
+	Dispatcher   *CommandDispatcher // optional; notified of new commands
+	Limiter      *GatewayLimiter    // optional; shared with the dispatcher
+	PollInterval time.Duration
+	CommandTTL   time.Duration
+
+	wake chan struct{}
+
+	mu      sync.Mutex
+	buckets map[string]map[string]*tokenBucket // job id -> gateway base URL
+}
+
+// jobIdempotencyPrefix starts the idempotency key of the commands submitted by jobs.
+const jobIdempotencyPrefix = "job:"
+
+// isJobCommand tells whether a command was submitted by a bulk command job.
+func isJobCommand(c models.Command) bool {
+	return strings.HasPrefix(c.IdempotencyKey, jobIdempotencyPrefix)
+}
+
+func NewCommandJobRunner(jobs *repo.CommandJobsRepo, chargers *repo.ChargersRepo, commands *repo.CommandsRepo, gw *Gateways, pollInterval, commandTTL time.Duration) *CommandJobRunner {
+	if pollInterval <= 0 {
+		pollInterval = time.Second
+	}
+	return &CommandJobRunner{
+		Jobs:         jobs,
+		Chargers:     chargers,
+		Commands:     commands,
+		Gateways:     gw,
+		PollInterval: pollInterval,
+		CommandTTL:   commandTTL,
+		wake:         make(chan struct{}, 1),
+		buckets:      map[string]map[string]*tokenBucket{},
+	}
+}
+
+// Notify runs the next round now, e.g. after a job was created or resumed. Never blocks.
+func (j *CommandJobRunner) Notify() {
+	select {
+	case j.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run blocks until ctx is cancelled.
+func (j *CommandJobRunner) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.PollInterval)
+	defer ticker.Stop()
+	for {
+		if err := j.round(ctx); err != nil && ctx.Err() == nil {
+			log.Println("command jobs:", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-j.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (j *CommandJobRunner) round(ctx context.Context) error {
+	if _, err := j.Jobs.SyncItems(ctx); err != nil {
+		return err
+	}
+	jobs, err := j.Jobs.ListRunning(ctx)
+	if err != nil {
+		return err
+	}
+	j.forgetBuckets(jobs)
+	for _, job := range jobs {
+		if err := j.advance(ctx, job); err != nil {
+			log.Println("command jobs:", job.JobId, err)
+		}
+	}
+	return nil
+}
+
+// submitResult tells what became of a claimed item.
+type submitResult int
+
+const (
+	itemSubmitted submitResult = iota
+	itemSkipped
+	itemLimited // released: the charger's gateway is at its rate limit
+	itemStopped // released: the job is no longer Running
+)
+
+// advance submits commands for a job's Pending items while it has free slots. Items on a
+// gateway at its rate limit are released and that gateway is left out of further claims
+// this round, so the job keeps going on its other gateways.
+func (j *CommandJobRunner) advance(ctx context.Context, job models.CommandJob) error {
+	running, err := j.Jobs.CountRunning(ctx, job.JobId)
+	if err != nil {
+		return err
+	}
+	free := job.Concurrency - running
+	full := []string{}
+	submitted := false
+	for progress := true; free > 0 && progress; {
+		cps, err := j.Jobs.ClaimPending(ctx, job.JobId, free, full, j.Gateways.Default.BaseURL)
+		if err != nil {
+			return err
+		}
+		progress = false
+		for i, cp := range cps {
+			res, gateway, err := j.submit(ctx, job, cp)
+			if err != nil || res == itemStopped {
+				// Give back the remaining claims; they are retried next round.
+				for _, rest := range cps[i+1:] {
+					if err := j.Jobs.ReleaseItem(ctx, job.JobId, rest); err != nil {
+						log.Println("command jobs: release:", job.JobId, rest, err)
+					}
+				}
+				if err != nil {
+					if err := j.Jobs.ReleaseItem(ctx, job.JobId, cp); err != nil {
+						log.Println("command jobs: release:", job.JobId, cp, err)
+					}
+				}
+				j.notifyDispatcher(submitted)
+				return err
+			}
+			switch res {
+			case itemSubmitted:
+				free--
+				submitted, progress = true, true
+			case itemSkipped:
+				progress = true
+			case itemLimited:
+				if !slices.Contains(full, gateway) {
+					full = append(full, gateway)
+					progress = true
+				}
+			}
+		}
+	}
+	j.notifyDispatcher(submitted)
+	_, err = j.Jobs.FinishIfDone(ctx, job.JobId)
+	return err
+}
+
+func (j *CommandJobRunner) notifyDispatcher(submitted bool) {
+	if submitted && j.Dispatcher != nil {
+		j.Dispatcher.Notify()
+	}
+}
+
+// submit queues the job's command for one claimed charger and returns the charger's
+// gateway. The command is created and linked only while the job is still Running.
+func (j *CommandJobRunner) submit(ctx context.Context, job models.CommandJob, cp string) (submitResult, string, error) {
+	ch, err := j.Chargers.Get(ctx, cp)
+	if err != nil {
+		return 0, "", err
+	}
+	if ch == nil {
+		return itemSkipped, "", j.Jobs.SkipItem(ctx, job.JobId, cp, "charger no longer exists")
+	}
+	// Validated at creation; the charger's OCPP version may have changed since.
+	decoded, err := ValidateCommand(ch.OcppVersion, job.Type, job.PayloadJSON)
+	if err != nil {
+		return itemSkipped, "", j.Jobs.SkipItem(ctx, job.JobId, cp, err.Error())
+	}
+	gw, err := j.Gateways.For(ctx, cp)
+	if err != nil {
+		return 0, "", err
+	}
+	if !j.take(job, gw.BaseURL) {
+		return itemLimited, gw.BaseURL, j.Jobs.ReleaseItem(ctx, job.JobId, cp)
+	}
+
+	// The idempotency key makes a resubmission after a crash reuse the first command.
+	idem := jobIdempotencyPrefix + job.JobId + ":" + cp
+	body, _ := json.Marshal(map[string]any{
+		"type":           job.Type,
+		"chargePointId":  cp,
+		"idempotencyKey": idem,
+		"payload":        json.RawMessage(job.PayloadJSON),
+	})
+	_, ok, err := j.Jobs.SubmitItem(ctx, job.JobId, models.Command{
+		ChargePointId:  cp,
+		Type:           job.Type,
+		IdempotencyKey: idem,
+		PayloadJSON:    body,
+		Status:         "Queued",
+		ExpiresAt:      time.Now().UTC().Add(j.CommandTTL),
+		Effect:         CommandEffect(decoded),
+	})
+	if err != nil {
+		return 0, gw.BaseURL, err
+	}
+	if !ok {
+		return itemStopped, gw.BaseURL, nil
+	}
+	return itemSubmitted, gw.BaseURL, nil
+}
+
+// take reports whether the job may send one more command through gateway now: within
+// the job's own rate and the gateway's shared limit.
+func (j *CommandJobRunner) take(job models.CommandJob, gateway string) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	byGateway, ok := j.buckets[job.JobId]
+	if !ok {
+		byGateway = map[string]*tokenBucket{}
+		j.buckets[job.JobId] = byGateway
+	}
+	b, ok := byGateway[gateway]
+	if !ok {
+		b = &tokenBucket{}
+		byGateway[gateway] = b
+	}
+	now := time.Now()
+	if !b.ready(now, job.RatePerMinute) || !j.Limiter.Take(gateway) {
+		return false
+	}
+	return b.take(now, job.RatePerMinute)
+}
+
+// forgetBuckets drops the rate limits of jobs that are no longer Running.
+func (j *CommandJobRunner) forgetBuckets(running []models.CommandJob) {
+	keep := make(map[string]bool, len(running))
+	for _, job := range running {
+		keep[job.JobId] = true
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for id := range j.buckets {
+		if !keep[id] {
+			delete(j.buckets, id)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JSONSchema is the subset of JSON Schema the command catalog uses.
+type JSONSchema struct {
+	Type                 string                 `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Enum                 []string               `json:"enum,omitempty"`
+	Minimum              *float64               `json:"minimum,omitempty"`
+	MaxLength            *int                   `json:"maxLength,omitempty"`
+	MinItems             *int                   `json:"minItems,omitempty"`
+	Items                *JSONSchema            `json:"items,omitempty"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *bool                  `json:"additionalProperties,omitempty"`
+}
+
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+// schemaFor derives the schema of a payload type. Objects do not allow unknown
+// properties, so misspelled field names are rejected too.
+func schemaFor(t reflect.Type) *JSONSchema {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == rawMessageType:
+		return &JSONSchema{} // any value
+	case t.Kind() == reflect.String:
+		return &JSONSchema{Type: "string"}
+	case t.Kind() == reflect.Bool:
+		return &JSONSchema{Type: "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Int64:
+		return &JSONSchema{Type: "integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return &JSONSchema{Type: "number"}
+	case t.Kind() == reflect.Slice:
+		return &JSONSchema{Type: "array", Items: schemaFor(t.Elem())}
+	case t.Kind() == reflect.Struct:
+		no := false
+		s := &JSONSchema{Type: "object", Properties: map[string]*JSONSchema{}, AdditionalProperties: &no}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "" || name == "-" {
+				continue
+			}
+			p := schemaFor(f.Type)
+			applySchemaTag(p, f.Tag.Get("schema"))
+			s.Properties[name] = p
+			if !strings.Contains(opts, "omitempty") {
+				s.Required = append(s.Required, name)
+			}
+		}
+		return s
+	}
+	panic("command schema: unsupported type " + t.String())
+}
+
+// applySchemaTag applies `schema:"enum=A|B,min=0,maxLength=20,minItems=1,format=date-time"`.
+func applySchemaTag(s *JSONSchema, tag string) {
+	if tag == "" {
+		return
+	}
+	for _, kv := range strings.Split(tag, ",") {
+		k, v, _ := strings.Cut(kv, "=")
+		switch k {
+		case "enum":
+			s.Enum = strings.Split(v, "|")
+		case "min":
+			f, _ := strconv.ParseFloat(v, 64)
+			s.Minimum = &f
+		case "maxLength":
+			n, _ := strconv.Atoi(v)
+			s.MaxLength = &n
+		case "minItems":
+			n, _ := strconv.Atoi(v)
+			s.MinItems = &n
+		case "format":
+			s.Format = v
+		default:
+			panic("command schema: unknown tag " + k)
+		}
+	}
+}
+
+// validateJSON checks raw against s; the error names the offending field.
+func validateJSON(s *JSONSchema, raw []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return invalidf("payload is not valid JSON: %v", err)
+	}
+	return s.validate(v, "payload")
+}
+
+func (s *JSONSchema) validate(v any, path string) error {
+	switch s.Type {
+	case "":
+		return nil
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return invalidf("%s must be an object", path)
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				return invalidf("%s.%s is required", path, name)
+			}
+		}
+		names := make([]string, 0, len(obj))
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			p, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					return invalidf("%s.%s is not a known field", path, name)
+				}
+				continue
+			}
+			if err := p.validate(obj[name], path+"."+name); err != nil {
+				return err
+			}
+		}
+	case "array":
+		arr, ok := v.([]any)
+		if !ok {
+			return invalidf("%s must be an array", path)
+		}
+		if s.MinItems != nil && len(arr) < *s.MinItems {
+			return invalidf("%s must have at least %d item(s)", path, *s.MinItems)
+		}
+		for i, item := range arr {
+			if err := s.Items.validate(item, path+"["+strconv.Itoa(i)+"]"); err != nil {
+				return err
+			}
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return invalidf("%s must be a string", path)
+		}
+		if s.MaxLength != nil && len([]rune(str)) > *s.MaxLength {
+			return invalidf("%s must be at most %d characters", path, *s.MaxLength)
+		}
+		if len(s.Enum) > 0 && !containsStr(s.Enum, str) {
+			return invalidf("%s must be one of %s", path, strings.Join(s.Enum, ", "))
+		}
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, str); err != nil {
+				return invalidf("%s must be an RFC 3339 date-time", path)
+			}
+		}
+	case "integer", "number":
+		n, ok := v.(json.Number)
+		if !ok {
+			return invalidf("%s must be a number", path)
+		}
+		f, err := n.Float64()
+		if err != nil {
+			return invalidf("%s must be a number", path)
+		}
+		if s.Type == "integer" {
+			if _, err := n.Int64(); err != nil {
+				return invalidf("%s must be an integer", path)
+			}
+		}
+		if s.Minimum != nil && f < *s.Minimum {
+			return invalidf("%s must be >= %v", path, *s.Minimum)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return invalidf("%s must be a boolean", path)
+		}
+	}
+	return nil
+}
+
+func containsStr(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,284 @@
+package services
+
+import "encoding/json"
+
+// Command payloads accepted by POST /v1/commands, per OCPP version. The JSON schemas of the
+// catalog are derived from these types (see command_schema.go): fields without omitempty
+// are required, and the schema tag adds constraints (enum, min, maxLength, minItems,
+// format=date-time).
+
+// OCPP 1.6
+
+type ChargingSchedulePeriod16 struct {
+	StartPeriod  int     `json:"startPeriod" schema:"min=0"`
+	Limit        float64 `json:"limit" schema:"min=0"`
+	NumberPhases *int    `json:"numberPhases,omitempty" schema:"min=1"`
+}
+
+type ChargingSchedule16 struct {
+	Duration               *int                       `json:"duration,omitempty" schema:"min=0"`
+	StartSchedule          string                     `json:"startSchedule,omitempty" schema:"format=date-time"`
+	ChargingRateUnit       string                     `json:"chargingRateUnit" schema:"enum=A|W"`
+	ChargingSchedulePeriod []ChargingSchedulePeriod16 `json:"chargingSchedulePeriod" schema:"minItems=1"`
+	MinChargingRate        *float64                   `json:"minChargingRate,omitempty" schema:"min=0"`
+}
+
+type ChargingProfile16 struct {
+	ChargingProfileId      int                `json:"chargingProfileId"`
+	TransactionId          *int               `json:"transactionId,omitempty"`
+	StackLevel             int                `json:"stackLevel" schema:"min=0"`
+	ChargingProfilePurpose string             `json:"chargingProfilePurpose" schema:"enum=ChargePointMaxProfile|TxDefaultProfile|TxProfile"`
+	ChargingProfileKind    string             `json:"chargingProfileKind" schema:"enum=Absolute|Recurring|Relative"`
+	RecurrencyKind         string             `json:"recurrencyKind,omitempty" schema:"enum=Daily|Weekly"`
+	ValidFrom              string             `json:"validFrom,omitempty" schema:"format=date-time"`
+	ValidTo                string             `json:"validTo,omitempty" schema:"format=date-time"`
+	ChargingSchedule       ChargingSchedule16 `json:"chargingSchedule"`
+}
+
+type RemoteStartTransaction16 struct {
+	ConnectorId     *int               `json:"connectorId,omitempty" schema:"min=1"`
+	IdTag           string             `json:"idTag" schema:"maxLength=20"`
+	ChargingProfile *ChargingProfile16 `json:"chargingProfile,omitempty"`
+}
+
+type RemoteStopTransaction16 struct {
+	TransactionId int `json:"transactionId"`
+}
+
+type Reset16 struct {
+	Type string `json:"type" schema:"enum=Hard|Soft"`
+}
+
+type UnlockConnector16 struct {
+	ConnectorId int `json:"connectorId" schema:"min=1"`
+}
+
+type ChangeAvailability16 struct {
+	ConnectorId int    `json:"connectorId" schema:"min=0"`
+	Type        string `json:"type" schema:"enum=Inoperative|Operative"`
+}
+
+type ChangeConfiguration16 struct {
+	Key   string `json:"key" schema:"maxLength=50"`
+	Value string `json:"value" schema:"maxLength=500"`
+}
+
+type GetConfiguration16 struct {
+	Key []string `json:"key,omitempty"`
+}
+
+type TriggerMessage16 struct {
+	RequestedMessage string `json:"requestedMessage" schema:"enum=BootNotification|DiagnosticsStatusNotification|FirmwareStatusNotification|Heartbeat|MeterValues|StatusNotification"`
+	ConnectorId      *int   `json:"connectorId,omitempty" schema:"min=1"`
+}
+
+type ClearCache struct{}
+
+type ReserveNow16 struct {
+	ConnectorId   int    `json:"connectorId" schema:"min=0"`
+	ExpiryDate    string `json:"expiryDate" schema:"format=date-time"`
+	IdTag         string `json:"idTag" schema:"maxLength=20"`
+	ParentIdTag   string `json:"parentIdTag,omitempty" schema:"maxLength=20"`
+	ReservationId int    `json:"reservationId"`
+}
+
+type CancelReservation struct {
+	ReservationId int `json:"reservationId"`
+}
+
+type SetChargingProfile16 struct {
+	ConnectorId        int               `json:"connectorId" schema:"min=0"`
+	CsChargingProfiles ChargingProfile16 `json:"csChargingProfiles"`
+}
+
+type ClearChargingProfile16 struct {
+	Id                     *int   `json:"id,omitempty"`
+	ConnectorId            *int   `json:"connectorId,omitempty" schema:"min=0"`
+	ChargingProfilePurpose string `json:"chargingProfilePurpose,omitempty" schema:"enum=ChargePointMaxProfile|TxDefaultProfile|TxProfile"`
+	StackLevel             *int   `json:"stackLevel,omitempty" schema:"min=0"`
+}
+
+type UpdateFirmware16 struct {
+	Location      string `json:"location"`
+	Retries       *int   `json:"retries,omitempty" schema:"min=0"`
+	RetrieveDate  string `json:"retrieveDate" schema:"format=date-time"`
+	RetryInterval *int   `json:"retryInterval,omitempty" schema:"min=0"`
+}
+
+type GetDiagnostics16 struct {
+	Location      string `json:"location"`
+	Retries       *int   `json:"retries,omitempty" schema:"min=0"`
+	RetryInterval *int   `json:"retryInterval,omitempty" schema:"min=0"`
+	StartTime     string `json:"startTime,omitempty" schema:"format=date-time"`
+	StopTime      string `json:"stopTime,omitempty" schema:"format=date-time"`
+}
+
+type DataTransfer16 struct {
+	VendorId  string `json:"vendorId" schema:"maxLength=255"`
+	MessageId string `json:"messageId,omitempty" schema:"maxLength=50"`
+	Data      string `json:"data,omitempty"`
+}
+
+// OCPP 2.0.1
+
+type IdToken201 struct {
+	IdToken string `json:"idToken" schema:"maxLength=36"`
+	Type    string `json:"type" schema:"enum=Central|eMAID|ISO14443|ISO15693|KeyCode|Local|MacAddress|NoAuthorization"`
+}
+
+type EVSE201 struct {
+	Id          int  `json:"id" schema:"min=0"`
+	ConnectorId *int `json:"connectorId,omitempty" schema:"min=1"`
+}
+
+type Component201 struct {
+	Name     string   `json:"name" schema:"maxLength=50"`
+	Instance string   `json:"instance,omitempty" schema:"maxLength=50"`
+	Evse     *EVSE201 `json:"evse,omitempty"`
+}
+
+type Variable201 struct {
+	Name     string `json:"name" schema:"maxLength=50"`
+	Instance string `json:"instance,omitempty" schema:"maxLength=50"`
+}
+
+type ChargingSchedulePeriod201 struct {
+	StartPeriod  int     `json:"startPeriod" schema:"min=0"`
+	Limit        float64 `json:"limit" schema:"min=0"`
+	NumberPhases *int    `json:"numberPhases,omitempty" schema:"min=1"`
+	PhaseToUse   *int    `json:"phaseToUse,omitempty" schema:"min=1"`
+}
+
+type ChargingSchedule201 struct {
+	Id                     int                         `json:"id"`
+	StartSchedule          string                      `json:"startSchedule,omitempty" schema:"format=date-time"`
+	Duration               *int                        `json:"duration,omitempty" schema:"min=0"`
+	ChargingRateUnit       string                      `json:"chargingRateUnit" schema:"enum=A|W"`
+	ChargingSchedulePeriod []ChargingSchedulePeriod201 `json:"chargingSchedulePeriod" schema:"minItems=1"`
+	MinChargingRate        *float64                    `json:"minChargingRate,omitempty" schema:"min=0"`
+}
+
+type ChargingProfile201 struct {
+	Id                     int                   `json:"id"`
+	StackLevel             int                   `json:"stackLevel" schema:"min=0"`
+	ChargingProfilePurpose string                `json:"chargingProfilePurpose" schema:"enum=ChargingStationExternalConstraints|ChargingStationMaxProfile|TxDefaultProfile|TxProfile"`
+	ChargingProfileKind    string                `json:"chargingProfileKind" schema:"enum=Absolute|Recurring|Relative"`
+	RecurrencyKind         string                `json:"recurrencyKind,omitempty" schema:"enum=Daily|Weekly"`
+	ValidFrom              string                `json:"validFrom,omitempty" schema:"format=date-time"`
+	ValidTo                string                `json:"validTo,omitempty" schema:"format=date-time"`
+	TransactionId          string                `json:"transactionId,omitempty" schema:"maxLength=36"`
+	ChargingSchedule       []ChargingSchedule201 `json:"chargingSchedule" schema:"minItems=1"`
+}
+
+type RequestStartTransaction201 struct {
+	EvseId          *int                `json:"evseId,omitempty" schema:"min=1"`
+	RemoteStartId   int                 `json:"remoteStartId"`
+	IdToken         IdToken201          `json:"idToken"`
+	GroupIdToken    *IdToken201         `json:"groupIdToken,omitempty"`
+	ChargingProfile *ChargingProfile201 `json:"chargingProfile,omitempty"`
+}
+
+type RequestStopTransaction201 struct {
+	TransactionId string `json:"transactionId" schema:"maxLength=36"`
+}
+
+type Reset201 struct {
+	Type   string `json:"type" schema:"enum=Immediate|OnIdle"`
+	EvseId *int   `json:"evseId,omitempty" schema:"min=1"`
+}
+
+type UnlockConnector201 struct {
+	EvseId      int `json:"evseId" schema:"min=1"`
+	ConnectorId int `json:"connectorId" schema:"min=1"`
+}
+
+type ChangeAvailability201 struct {
+	OperationalStatus string   `json:"operationalStatus" schema:"enum=Inoperative|Operative"`
+	Evse              *EVSE201 `json:"evse,omitempty"`
+}
+
+type SetVariableData201 struct {
+	AttributeType  string       `json:"attributeType,omitempty" schema:"enum=Actual|Target|MinSet|MaxSet"`
+	AttributeValue string       `json:"attributeValue" schema:"maxLength=1000"`
+	Component      Component201 `json:"component"`
+	Variable       Variable201  `json:"variable"`
+}
+
+type SetVariables201 struct {
+	SetVariableData []SetVariableData201 `json:"setVariableData" schema:"minItems=1"`
+}
+
+type GetVariableData201 struct {
+	AttributeType string       `json:"attributeType,omitempty" schema:"enum=Actual|Target|MinSet|MaxSet"`
+	Component     Component201 `json:"component"`
+	Variable      Variable201  `json:"variable"`
+}
+
+type GetVariables201 struct {
+	GetVariableData []GetVariableData201 `json:"getVariableData" schema:"minItems=1"`
+}
+
+type TriggerMessage201 struct {
+	RequestedMessage string   `json:"requestedMessage" schema:"enum=BootNotification|LogStatusNotification|FirmwareStatusNotification|Heartbeat|MeterValues|SignChargingStationCertificate|SignV2GCertificate|StatusNotification|TransactionEvent|SignCombinedCertificate|PublishFirmwareStatusNotification"`
+	Evse             *EVSE201 `json:"evse,omitempty"`
+}
+
+type ReserveNow201 struct {
+	Id             int         `json:"id"`
+	ExpiryDateTime string      `json:"expiryDateTime" schema:"format=date-time"`
+	ConnectorType  string      `json:"connectorType,omitempty" schema:"maxLength=20"`
+	EvseId         *int        `json:"evseId,omitempty" schema:"min=1"`
+	IdToken        IdToken201  `json:"idToken"`
+	GroupIdToken   *IdToken201 `json:"groupIdToken,omitempty"`
+}
+
+type SetChargingProfile201 struct {
+	EvseId          int                `json:"evseId" schema:"min=0"`
+	ChargingProfile ChargingProfile201 `json:"chargingProfile"`
+}
+
+type ClearChargingProfileCriteria201 struct {
+	EvseId                 *int   `json:"evseId,omitempty" schema:"min=0"`
+	ChargingProfilePurpose string `json:"chargingProfilePurpose,omitempty" schema:"enum=ChargingStationExternalConstraints|ChargingStationMaxProfile|TxDefaultProfile|TxProfile"`
+	StackLevel             *int   `json:"stackLevel,omitempty" schema:"min=0"`
+}
+
+type ClearChargingProfile201 struct {
+	ChargingProfileId       *int                             `json:"chargingProfileId,omitempty"`
+	ChargingProfileCriteria *ClearChargingProfileCriteria201 `json:"chargingProfileCriteria,omitempty"`
+}
+
+type Firmware201 struct {
+	Location           string `json:"location" schema:"maxLength=512"`
+	RetrieveDateTime   string `json:"retrieveDateTime" schema:"format=date-time"`
+	InstallDateTime    string `json:"installDateTime,omitempty" schema:"format=date-time"`
+	SigningCertificate string `json:"signingCertificate,omitempty" schema:"maxLength=5500"`
+	Signature          string `json:"signature,omitempty" schema:"maxLength=800"`
+}
+
+type UpdateFirmware201 struct {
+	RequestId     int         `json:"requestId"`
+	Retries       *int        `json:"retries,omitempty" schema:"min=0"`
+	RetryInterval *int        `json:"retryInterval,omitempty" schema:"min=0"`
+	Firmware      Firmware201 `json:"firmware"`
+}
+
+type LogParameters201 struct {
+	RemoteLocation  string `json:"remoteLocation" schema:"maxLength=512"`
+	OldestTimestamp string `json:"oldestTimestamp,omitempty" schema:"format=date-time"`
+	LatestTimestamp string `json:"latestTimestamp,omitempty" schema:"format=date-time"`
+}
+
+type GetLog201 struct {
+	LogType       string           `json:"logType" schema:"enum=DiagnosticsLog|SecurityLog"`
+	RequestId     int              `json:"requestId"`
+	Retries       *int             `json:"retries,omitempty" schema:"min=0"`
+	RetryInterval *int             `json:"retryInterval,omitempty" schema:"min=0"`
+	Log           LogParameters201 `json:"log"`
+}
+
+type DataTransfer201 struct {
+	VendorId  string          `json:"vendorId" schema:"maxLength=255"`
+	MessageId string          `json:"messageId,omitempty" schema:"maxLength=50"`
+	Data      json.RawMessage `json:"data,omitempty"` // any JSON value
+}
@@ -0,0 +1,140 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"cpms/internal/alerts"
+	"cpms/internal/models"
+	"cpms/internal/repo"
+)
+
+const (
+	ConnectivityUnknown  = "Unknown"
+	ConnectivityOnline   = "Online"
+	ConnectivityDegraded = "Degraded"
+	ConnectivityOffline  = "Offline"
+)
+
+// ConnectivityMonitor moves chargers through Online/Degraded/Offline based on
+// last_seen_at and their expected heartbeat interval (chargers.heartbeat_interval_seconds,
+// else DefaultInterval). Each change is recorded in charger_state_events and
+// raises/resolves alerts.
+type ConnectivityMonitor struct {
+	Chargers        *repo.ChargersRepo
+	Alerts          *alerts.Engine
+	DefaultInterval time.Duration
+	DegradedMissed  int
+	OfflineMissed   int
+	CheckInterval   time.Duration
+}
+
+func NewConnectivityMonitor(c *repo.ChargersRepo, a *alerts.Engine, defaultInterval time.Duration, degradedMissed, offlineMissed int, checkInterval time.Duration) *ConnectivityMonitor {
+	if checkInterval <= 0 {
+		checkInterval = 30 * time.Second
+	}
+	return &ConnectivityMonitor{Chargers: c, Alerts: a, DefaultInterval: defaultInterval, DegradedMissed: degradedMissed, OfflineMissed: offlineMissed, CheckInterval: checkInterval}
+}
+
+// Run blocks until ctx is cancelled. A zero DefaultInterval disables the monitor.
+func (m *ConnectivityMonitor) Run(ctx context.Context) {
+	if m.DefaultInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(m.CheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.check(ctx)
+		}
+	}
+}
+
+// connectivityState derives the state from the time since the charger was last seen.
+func (m *ConnectivityMonitor) connectivityState(c models.Charger, now time.Time) string {
+	if c.LastSeenAt == nil {
+		return ConnectivityUnknown
+	}
+	interval := m.DefaultInterval
+	if c.HeartbeatIntervalSeconds != nil && *c.HeartbeatIntervalSeconds > 0 {
+		interval = time.Duration(*c.HeartbeatIntervalSeconds) * time.Second
+	}
+	silent := now.Sub(*c.LastSeenAt)
+	switch {
+	case silent > interval*time.Duration(m.OfflineMissed):
+		return ConnectivityOffline
+	case silent > interval*time.Duration(m.DegradedMissed):
+		return ConnectivityDegraded
+	default:
+		return ConnectivityOnline
+	}
+}
+
+func (m *ConnectivityMonitor) check(ctx context.Context) {
+	chargers, err := m.Chargers.ListForConnectivity(ctx)
+	if err != nil {
+		log.Println("connectivity monitor:", err)
+		return
+	}
+	now := time.Now().UTC()
+	for _, c := range chargers {
+		to := m.connectivityState(c, now)
+		if to == c.ConnectivityState {
+			continue
+		}
+		changed, err := m.Chargers.SetConnectivityState(ctx, c.ChargePointId, c.ConnectivityState, to, c.LastSeenAt)
+		if err != nil {
+			log.Printf("connectivity monitor: %s: %v", c.ChargePointId, err)
+			continue
+		}
+		if !changed {
+			continue
+		}
+		log.Printf("connectivity monitor: %s %s -> %s", c.ChargePointId, c.ConnectivityState, to)
+		if err := m.alert(ctx, c, to); err != nil {
+			log.Printf("connectivity monitor: %s: alert: %v", c.ChargePointId, err)
+		}
+	}
+}
+
+func (m *ConnectivityMonitor) alert(ctx context.Context, c models.Charger, to string) error {
+	if m.Alerts == nil {
+		return nil
+	}
+	degradedKey := "degraded:" + c.ChargePointId
+	offlineKey := "offline:" + c.ChargePointId
+	lastSeen := c.LastSeenAt.UTC().Format(time.RFC3339)
+
+	switch to {
+	case ConnectivityOnline:
+		if err := m.Alerts.Resolve(ctx, degradedKey); err != nil {
+			return err
+		}
+		return m.Alerts.Resolve(ctx, offlineKey)
+	case ConnectivityDegraded:
+		return m.Alerts.Fire(ctx, models.Alert{
+			DedupKey:      degradedKey,
+			ChargePointId: c.ChargePointId,
+			Kind:          "ChargerDegraded",
+			Severity:      alerts.SeverityWarning,
+			Message:       fmt.Sprintf("Charger %s missed %d heartbeats (last seen %s)", c.ChargePointId, m.DegradedMissed, lastSeen),
+		})
+	case ConnectivityOffline:
+		if err := m.Alerts.Resolve(ctx, degradedKey); err != nil {
+			return err
+		}
+		return m.Alerts.Fire(ctx, models.Alert{
+			DedupKey:      offlineKey,
+			ChargePointId: c.ChargePointId,
+			Kind:          "ChargerOffline",
+			Severity:      alerts.SeverityCritical,
+			Message:       fmt.Sprintf("Charger %s is offline (last seen %s)", c.ChargePointId, lastSeen),
+		})
+	}
+	return nil
+}
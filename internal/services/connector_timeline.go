@@ -0,0 +1,63 @@
+package services
+
+import (
+	"sort"
+	"time"
+
+	"cpms/internal/models"
+)
+
+// UnknownStatus covers the part of a window before the first recorded transition.
+const UnknownStatus = "Unknown"
+
+type StatusDuration struct {
+	Status  string  `json:"status"`
+	Seconds int64   `json:"seconds"`
+	Percent float64 `json:"percent"`
+}
+
+// ConnectorTimeline sums the time spent per status in [from, to) from the
+// transitions returned by StateRepo.ListStatusHistory. Longest first.
+func ConnectorTimeline(history []models.ConnectorStatusChange, from, to time.Time) []StatusDuration {
+	totals := map[string]time.Duration{}
+	cursor := from
+	for _, h := range history {
+		start := h.StartedAt
+		if start.Before(from) {
+			start = from
+		}
+		if start.After(cursor) {
+			totals[UnknownStatus] += start.Sub(cursor)
+		}
+		end := to
+		if h.EndedAt != nil && h.EndedAt.Before(to) {
+			end = *h.EndedAt
+		}
+		if end.After(start) {
+			totals[h.Status] += end.Sub(start)
+		}
+		if end.After(cursor) {
+			cursor = end
+		}
+	}
+	if to.After(cursor) {
+		totals[UnknownStatus] += to.Sub(cursor)
+	}
+
+	window := to.Sub(from)
+	out := make([]StatusDuration, 0, len(totals))
+	for status, d := range totals {
+		sd := StatusDuration{Status: status, Seconds: int64(d / time.Second)}
+		if window > 0 {
+			sd.Percent = round(float64(d)/float64(window)*100, 2)
+		}
+		out = append(out, sd)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Seconds != out[j].Seconds {
+			return out[i].Seconds > out[j].Seconds
+		}
+		return out[i].Status < out[j].Status
+	})
+	return out
+}
@@ -0,0 +1,459 @@
+package services
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"cpms/internal/models"
+	"cpms/internal/repo"
+	"cpms/internal/security"
+)
+
+// CredentialService authenticates chargers against their credentials and rotates secrets.
+//
+// A charger may hold several valid credentials. After a rotation both the old and the new
+// secret work; the first successful authentication with the new one expires the older ones
+// (or they expire at OldValidFor, whichever comes first).
+type CredentialService struct {
+	DB          repo.DBTX
+	Chargers    *repo.ChargersRepo
+	Credentials *repo.CredentialsRepo
+	// Commands and Gateways push a new secret to the charger (optional).
+	Commands *repo.CommandsRepo
+	Gateways *Gateways
+	// Limiter counts pushes against their gateway's limit, like the dispatcher's commands (optional).
+	Limiter *GatewayLimiter
+	// CertTrust holds the client certificates accepted for security profile 3.
+	CertTrust *repo.CertTrustRepo
+	// Attempts audits every attempt and locks out repeated failures (optional).
+	Attempts *repo.AuthAttemptsRepo
+	Lockout  LockoutPolicy
+
+	cache *authCache
+}
+
+// NewCredentialService caches successful verifications for cacheTTL (0 disables the cache).
+func NewCredentialService(db repo.DBTX, chargers *repo.ChargersRepo, credentials *repo.CredentialsRepo, cacheTTL time.Duration) *CredentialService {
+	return &CredentialService{DB: db, Chargers: chargers, Credentials: credentials, cache: newAuthCache(cacheTTL)}
+}
+
+// AuthAttempt is what the gateway saw on a charger's connection.
+type AuthAttempt struct {
+	Secret          string
+	TLS             bool
+	CertFingerprint string
+	// CertPEM is the client certificate (optionally followed by its chain); required
+	// for CA/CN trust, optional for pinned fingerprints.
+	CertPEM    string
+	RemoteAddr string
+}
+
+// AuthResult tells whether a charger may connect; Reason says why not.
+type AuthResult struct {
+	Charger *models.Charger
+	Allowed bool
+	Method  string // Password|Certificate
+	Reason  string
+	// LockedUntil is set when the attempt was refused because of a lockout.
+	LockedUntil *time.Time
+}
+
+// LockoutPolicy locks a charger ID for a remote IP (or the IP for all chargers) after
+// Threshold (IPThreshold) consecutive wrong secrets or certificates, for Base doubling
+// with every further failure up to Max. A threshold of 0 disables that lockout. Failures older than Max are forgotten.
+type LockoutPolicy struct {
+	Threshold   int
+	IPThreshold int
+	Base        time.Duration
+	Max         time.Duration
+}
+
+// Authenticate checks an attempt against the charger's security profile:
+// 1 = password, 2 = TLS + password, 3 = TLS + trusted client certificate.
+// While the charger (from this IP) or the IP is locked out, attempts are refused unchecked.
+func (s *CredentialService) Authenticate(ctx context.Context, cp string, a AuthAttempt) (AuthResult, error) {
+	if s.Attempts == nil {
+		return s.authenticate(ctx, cp, a)
+	}
+	now := time.Now().UTC()
+	ip := remoteIP(a.RemoteAddr)
+	until, err := s.Attempts.LockedUntil(ctx, cp, ip, now)
+	if err != nil {
+		return AuthResult{}, err
+	}
+	var res AuthResult
+	if until != nil {
+		res = AuthResult{Reason: "LockedOut", LockedUntil: until}
+	} else if res, err = s.authenticate(ctx, cp, a); err != nil {
+		return res, err
+	}
+
+	rec := models.AuthAttemptRecord{ChargePointId: cp, RemoteAddr: ip, Outcome: "Denied", Reason: res.Reason, Method: res.Method, CreatedAt: now}
+	switch {
+	case res.Allowed:
+		rec.Outcome = "Allowed"
+	case until != nil:
+		rec.Outcome = "Locked"
+	}
+	if err := s.Attempts.Record(ctx, rec); err != nil {
+		return res, err
+	}
+
+	switch {
+	case res.Allowed:
+		// The IP counter only decays so a working charger can't shield others behind the same address.
+		if err = s.Attempts.Reset(ctx, repo.LockoutCharger, cp, ip); err == nil && ip != "" {
+			err = s.Attempts.Decay(ctx, repo.LockoutIP, ip, "")
+		}
+	case until == nil && credentialFailure(res.Reason):
+		if err = s.registerFailure(ctx, repo.LockoutCharger, cp, ip, s.Lockout.Threshold, now); err == nil && ip != "" {
+			err = s.registerFailure(ctx, repo.LockoutIP, ip, "", s.Lockout.IPThreshold, now)
+		}
+	}
+	return res, err
+}
+
+// credentialFailure tells whether a refusal was caused by a wrong secret or certificate.
+// Only those count toward a lockout: an unknown, inactive or misconfigured charger is not
+// a guessing attempt, and counting it would let anyone lock a charger out.
+func credentialFailure(reason string) bool {
+	return reason == "InvalidPassword" || reason == "CertificateNotTrusted"
+}
+
+// PruneAttempts deletes auth attempts older than retention every interval, and the
+// lockout counters that expired. Blocks until ctx is cancelled; a zero retention keeps
+// attempts forever.
+func (s *CredentialService) PruneAttempts(ctx context.Context, retention, interval time.Duration) {
+	if s.Attempts == nil || retention <= 0 {
+		return
+	}
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		now := time.Now().UTC()
+		if n, err := s.Attempts.Prune(ctx, now.Add(-retention), now, s.Lockout.Max); err != nil {
+			log.Println("auth attempts: prune:", err)
+		} else if n > 0 {
+			log.Printf("auth attempts: pruned %d attempts", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// registerFailure counts a failure and locks the key once threshold is reached.
+func (s *CredentialService) registerFailure(ctx context.Context, scope, key, addr string, threshold int, now time.Time) error {
+	if threshold <= 0 {
+		return nil
+	}
+	n, err := s.Attempts.RegisterFailure(ctx, scope, key, addr, now, s.Lockout.Max)
+	if err != nil || n < threshold {
+		return err
+	}
+	return s.Attempts.Lock(ctx, scope, key, addr, now.Add(retryDelay(n-threshold+1, s.Lockout.Base, s.Lockout.Max)))
+}
+
+// remoteIP strips the port from a remote address.
+func remoteIP(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+func (s *CredentialService) authenticate(ctx context.Context, cp string, a AuthAttempt) (AuthResult, error) {
+	ch, err := s.Chargers.Get(ctx, cp)
+	if err != nil || ch == nil {
+		return AuthResult{Reason: "UnknownCharger"}, err
+	}
+	res := AuthResult{Charger: ch}
+	switch {
+	case !ch.IsActive:
+		res.Reason = "Inactive"
+		return res, nil
+	case ch.RegistrationStatus != "Accepted":
+		res.Reason = "NotAccepted"
+		return res, nil
+	case ch.SecurityProfile >= 2 && !a.TLS:
+		res.Reason = "TLSRequired"
+		return res, nil
+	}
+
+	now := time.Now().UTC()
+	if ch.SecurityProfile == 3 {
+		res.Method = "Certificate"
+		res.Reason, err = s.verifyCert(ctx, cp, a, now)
+		res.Allowed = err == nil && res.Reason == ""
+		return res, err
+	}
+
+	res.Method = "Password"
+	if a.Secret == "" {
+		res.Reason = "PasswordRequired"
+		return res, nil
+	}
+	creds, err := s.Credentials.ListValid(ctx, cp, now)
+	if err != nil {
+		return res, err
+	}
+	match, err := s.verify(ctx, cp, a.Secret, creds, now)
+	if err != nil {
+		return res, err
+	}
+	if match == nil {
+		res.Reason = "InvalidPassword"
+		return res, nil
+	}
+
+	first, err := s.Credentials.MarkUsed(ctx, match.CredentialId, now)
+	if err != nil {
+		return res, err
+	}
+	if first {
+		// Only what predates the credential expires: an old credential used for the first
+		// time after a rotation must not end the newer one.
+		if err := s.Credentials.ExpireOlder(ctx, cp, match.CredentialId, now); err != nil {
+			return res, err
+		}
+		if err := s.Credentials.CompleteRotation(ctx, match.CredentialId, now); err != nil {
+			return res, err
+		}
+	}
+	res.Allowed = true
+	return res, nil
+}
+
+// verifyCert matches the client certificate against the charger's pinned fingerprints and
+// trusted CAs. It returns the refusal reason, or "" if the certificate is trusted.
+func (s *CredentialService) verifyCert(ctx context.Context, cp string, a AuthAttempt, now time.Time) (string, error) {
+	if s.CertTrust == nil {
+		return "NoCertificateTrust", nil
+	}
+	trust, err := s.CertTrust.List(ctx, cp)
+	if err != nil {
+		return "", err
+	}
+	if len(trust) == 0 {
+		return "NoCertificateTrust", nil
+	}
+
+	fp, _ := security.NormalizeFingerprint(a.CertFingerprint)
+	var chain []*x509.Certificate
+	if a.CertPEM != "" {
+		if chain, err = security.ParseCertificatesPEM(a.CertPEM); err != nil {
+			return "InvalidCertificate", nil
+		}
+		leafFp := security.CertFingerprint(chain[0])
+		if fp != "" && fp != leafFp {
+			return "FingerprintMismatch", nil
+		}
+		fp = leafFp
+	}
+	if fp == "" {
+		return "CertificateRequired", nil
+	}
+
+	for _, t := range trust {
+		if t.Fingerprint != nil && *t.Fingerprint == fp {
+			return "", nil
+		}
+		if t.CAPEM != nil && len(chain) > 0 {
+			cn := cp
+			if t.ExpectedCN != nil && *t.ExpectedCN != "" {
+				cn = *t.ExpectedCN
+			}
+			if security.VerifyClientCert(chain[0], chain[1:], *t.CAPEM, cn, now) == nil {
+				return "", nil
+			}
+		}
+	}
+	return "CertificateNotTrusted", nil
+}
+
+// verify returns the valid credential matching secret, if any. Legacy SHA-256 hashes and
+// hashes with outdated parameters are replaced by a current hash on success.
+func (s *CredentialService) verify(ctx context.Context, cp, secret string, creds []models.ChargerCredential, now time.Time) (*models.ChargerCredential, error) {
+	if id, ok := s.cache.get(cp, secret, now); ok {
+		for i := range creds {
+			if creds[i].CredentialId == id {
+				return &creds[i], nil
+			}
+		}
+	}
+	for i := range creds {
+		ok, rehash := security.VerifySecret(creds[i].SecretHash, secret)
+		if !ok {
+			continue
+		}
+		if rehash {
+			h, err := security.HashSecret(secret)
+			if err != nil {
+				return nil, err
+			}
+			if err := s.Credentials.UpdateHash(ctx, creds[i].CredentialId, creds[i].SecretHash, h); err != nil {
+				return nil, err
+			}
+		}
+		s.cache.put(cp, secret, creds[i].CredentialId, now)
+		return &creds[i], nil
+	}
+	return nil, nil
+}
+
+type RotateRequest struct {
+	ChargePointId string
+	Actor         string
+	// NotBefore delays the new credential (default now).
+	NotBefore time.Time
+	// OldValidFor caps the validity of the previous credentials; 0 = until the new one is used.
+	OldValidFor time.Duration
+	// Push sends the new secret to the charger (ChangeConfiguration AuthorizationKey,
+	// SetVariables BasicAuthPassword for OCPP 2.0.1).
+	Push bool
+}
+
+type RotateResult struct {
+	RotationId   string
+	CredentialId string
+	Secret       string
+	// CommandId/CommandStatus/CommandError describe the push, if requested.
+	CommandId     string
+	CommandStatus string
+	CommandError  string
+}
+
+// Rotate generates a new secret for an existing charger. The secret is only returned here.
+func (s *CredentialService) Rotate(ctx context.Context, req RotateRequest) (*RotateResult, error) {
+	ch, err := s.Chargers.Get(ctx, req.ChargePointId)
+	if err != nil || ch == nil {
+		return nil, err
+	}
+	secret, err := security.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	hash, err := security.HashSecret(secret)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	notBefore := req.NotBefore
+	if notBefore.IsZero() || notBefore.Before(now) {
+		notBefore = now
+	}
+	var oldValidUntil *time.Time
+	if req.OldValidFor > 0 {
+		t := notBefore.Add(req.OldValidFor)
+		oldValidUntil = &t
+	}
+
+	tx, err := s.DB.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+	creds := repo.NewCredentialsRepo(tx)
+
+	res := &RotateResult{Secret: secret}
+	if res.CredentialId, err = creds.Add(ctx, ch.ChargePointId, hash, notBefore); err != nil {
+		return nil, err
+	}
+	if oldValidUntil != nil {
+		if err := creds.LimitOthers(ctx, ch.ChargePointId, res.CredentialId, *oldValidUntil); err != nil {
+			return nil, err
+		}
+	}
+	if res.RotationId, err = creds.AddRotation(ctx, ch.ChargePointId, res.CredentialId, req.Actor, oldValidUntil); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	if req.Push {
+		if err := s.push(ctx, ch, res); err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// push sends the new secret through the gateway. The stored command payload has the
+// secret redacted.
+func (s *CredentialService) push(ctx context.Context, ch *models.Charger, res *RotateResult) error {
+	if s.Gateways == nil || s.Commands == nil {
+		return fmt.Errorf("no gateway configured")
+	}
+	cmdType, payload, redacted := authorizationKeyCommand(ch.OcppVersion, res.Secret)
+	idem := "rotate:" + res.RotationId
+	body := func(p any) []byte {
+		b, _ := json.Marshal(map[string]any{"type": cmdType, "chargePointId": ch.ChargePointId, "idempotencyKey": idem, "payload": p})
+		return b
+	}
+
+	// Sent directly rather than through the command dispatcher: the stored payload has
+	// the secret redacted, so it must never be dispatched from the table.
+	cmdId, err := s.Commands.Create(ctx, models.Command{
+		ChargePointId:  ch.ChargePointId,
+		Type:           cmdType,
+		IdempotencyKey: idem,
+		PayloadJSON:    body(redacted),
+		Status:         "Sent",
+		ExpiresAt:      time.Now().UTC().Add(15 * time.Second),
+	})
+	if err != nil {
+		return err
+	}
+	res.CommandId = cmdId
+	if err := s.Credentials.SetRotationCommand(ctx, res.RotationId, cmdId); err != nil {
+		return err
+	}
+
+	gw, err := s.Gateways.For(ctx, ch.ChargePointId)
+	if err != nil {
+		return err
+	}
+	s.Limiter.Spend(gw.BaseURL)
+	sendCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	status, respBody, err := gw.SendCommand(sendCtx, body(payload))
+	switch {
+	case err != nil:
+		res.CommandStatus, res.CommandError = "Failed", err.Error()
+	case status < 200 || status >= 300:
+		res.CommandStatus, res.CommandError = "Failed", string(respBody)
+	default:
+		res.CommandStatus = "Acked"
+		return s.Commands.MarkAcked(ctx, cmdId, respBody, 0)
+	}
+	return s.Commands.MarkFailed(ctx, cmdId, res.CommandError)
+}
+
+// authorizationKeyCommand returns the command setting the basic auth password for the
+// charger's OCPP version, and the same payload with the secret redacted.
+func authorizationKeyCommand(ocppVersion, secret string) (string, any, any) {
+	if strings.HasPrefix(ocppVersion, "2.") {
+		p := func(v string) any {
+			return map[string]any{"setVariableData": []any{map[string]any{
+				"component":      map[string]any{"name": "SecurityCtrlr"},
+				"variable":       map[string]any{"name": "BasicAuthPassword"},
+				"attributeValue": v,
+			}}}
+		}
+		return "SetVariables", p(secret), p("<redacted>")
+	}
+	p := func(v string) any { return map[string]any{"key": "AuthorizationKey", "value": v} }
+	return "ChangeConfiguration", p(secret), p("<redacted>")
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"context"
+	"errors"
+
+	"cpms/internal/repo"
+)
+
+var (
+	ErrDeadLetterNotFound = errors.New("dead letter not found")
+	ErrDeadLetterNotOpen  = errors.New("dead letter is not Open")
+)
+
+// DeadLetterService lets operators retry (optionally with a corrected payload)
+// or discard events that failed validation or processing.
+type DeadLetterService struct {
+	DeadLetters *repo.DeadLettersRepo
+	Events      *repo.EventsRepo
+	Processor   *EventsProcessor
+}
+
+func NewDeadLetterService(d *repo.DeadLettersRepo, e *repo.EventsRepo, p *EventsProcessor) *DeadLetterService {
+	return &DeadLetterService{DeadLetters: d, Events: e, Processor: p}
+}
+
+// Retry re-submits a dead letter. If it references a stored gateway event, that
+// event is requeued (with payload replacing the stored one if given); otherwise
+// the payload is ingested as a new event. An invalid payload is rejected with a
+// *ValidationError and the dead letter stays Open.
+func (s *DeadLetterService) Retry(ctx context.Context, id int64, payload []byte) (IngestResult, error) {
+	d, err := s.DeadLetters.Get(ctx, id)
+	if err != nil {
+		return IngestResult{}, err
+	}
+	if d == nil {
+		return IngestResult{}, ErrDeadLetterNotFound
+	}
+	if d.Status != "Open" {
+		return IngestResult{}, ErrDeadLetterNotOpen
+	}
+	corrected := payload
+	if corrected == nil {
+		corrected = d.Payload
+	}
+
+	ev, err := s.Processor.parse(corrected)
+	if err != nil {
+		return IngestResult{Type: ev.EventType}, err
+	}
+
+	var res IngestResult
+	if d.GatewayEventId != nil {
+		if ev.ChargePointId != d.ChargePointId || ev.EventType != d.EventType {
+			return IngestResult{Type: ev.EventType}, invalidf("corrected payload must keep chargePointId and type")
+		}
+		if err := s.Events.Requeue(ctx, *d.GatewayEventId, payload); err != nil {
+			return IngestResult{}, err
+		}
+		res = IngestResult{EventId: *d.GatewayEventId, Type: ev.EventType}
+	} else {
+		res, err = s.Processor.Ingest(ctx, "", corrected)
+		if err != nil {
+			return res, err
+		}
+	}
+
+	ok, err := s.DeadLetters.SetStatus(ctx, id, "Retried")
+	if err != nil {
+		return res, err
+	}
+	if !ok {
+		return res, ErrDeadLetterNotOpen
+	}
+	return res, nil
+}
+
+func (s *DeadLetterService) Discard(ctx context.Context, id int64) error {
+	ok, err := s.DeadLetters.SetStatus(ctx, id, "Discarded")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		d, err := s.DeadLetters.Get(ctx, id)
+		if err != nil {
+			return err
+		}
+		if d == nil {
+			return ErrDeadLetterNotFound
+		}
+		return ErrDeadLetterNotOpen
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"cpms/internal/models"
+	"cpms/internal/repo"
+)
+
+// EventQueue applies events stored in gateway_events with a pool of workers.
+// Events of one charge point are applied in id order; failures are retried
+// with exponential backoff until MaxAttempts, then marked Failed.
+type EventQueue struct {
+	Events       *repo.EventsRepo
+	Processor    *EventsProcessor
+	Workers      int
+	PollInterval time.Duration
+	MaxAttempts  int
+	RetryBase    time.Duration
+	RetryMax     time.Duration
+	Lease        time.Duration
+
+	wake chan struct{}
+}
+
+func NewEventQueue(
+	e *repo.EventsRepo,
+	p *EventsProcessor,
+	workers int,
+	pollInterval time.Duration,
+	maxAttempts int,
+	retryBase time.Duration,
+	retryMax time.Duration,
+) *EventQueue {
+	if workers <= 0 {
+		workers = 1
+	}
+	if pollInterval <= 0 {
+		pollInterval = time.Second
+	}
+	if maxAttempts <= 0 {
+		maxAttempts = 1
+	}
+	return &EventQueue{
+		Events:       e,
+		Processor:    p,
+		Workers:      workers,
+		PollInterval: pollInterval,
+		MaxAttempts:  maxAttempts,
+		RetryBase:    retryBase,
+		RetryMax:     retryMax,
+		Lease:        5 * time.Minute,
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// Notify wakes an idle worker after a new event was stored. Never blocks.
+func (q *EventQueue) Notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run blocks until ctx is cancelled.
+func (q *EventQueue) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < q.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.worker(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (q *EventQueue) worker(ctx context.Context) {
+	ticker := time.NewTicker(q.PollInterval)
+	defer ticker.Stop()
+	for {
+		ev, err := q.Events.ClaimNext(ctx, q.Lease)
+		if err != nil && ctx.Err() == nil {
+			log.Println("event queue: claim:", err)
+		}
+		if ev != nil {
+			// Wake another idle worker: during bursts more events are likely due.
+			q.Notify()
+			q.process(ctx, *ev)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (q *EventQueue) process(ctx context.Context, ev models.GatewayEvent) {
+	err := q.Processor.Apply(ctx, ev)
+	if err == nil {
+		if err := q.Events.MarkDone(ctx, ev.Id); err != nil {
+			log.Println("event queue: mark done:", ev.Id, err)
+		}
+		return
+	}
+	if ctx.Err() != nil {
+		// Shutting down; the lease expires and the event is reclaimed on restart.
+		return
+	}
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		q.fail(ctx, ev, err.Error(), verr.Reason)
+		return
+	}
+	if ev.Attempts >= q.MaxAttempts {
+		log.Printf("event queue: event %d (%s %s) failed after %d attempts: %v", ev.Id, ev.ChargePointId, ev.EventType, ev.Attempts, err)
+		q.fail(ctx, ev, err.Error(), fmt.Sprintf("apply failed after %d attempts: %v", ev.Attempts, err))
+		return
+	}
+	next := time.Now().UTC().Add(retryDelay(ev.Attempts, q.RetryBase, q.RetryMax))
+	if err := q.Events.MarkRetry(ctx, ev.Id, err.Error(), next); err != nil {
+		log.Println("event queue: mark retry:", ev.Id, err)
+	}
+}
+
+// fail marks the event Failed and moves it to the dead-letter table.
+func (q *EventQueue) fail(ctx context.Context, ev models.GatewayEvent, errMsg, reason string) {
+	if err := q.Events.MarkFailed(ctx, ev.Id, errMsg); err != nil {
+		log.Println("event queue: mark failed:", ev.Id, err)
+		return
+	}
+	if err := q.Processor.DeadLetterEvent(ctx, ev, reason); err != nil {
+		log.Println("event queue: dead letter:", ev.Id, err)
+	}
+}
+
+// retryDelay returns base * 2^(attempt-1), capped at max.
+func retryDelay(attempt int, base, max time.Duration) time.Duration {
+	if base <= 0 {
+		base = time.Second
+	}
+	d := base
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if max > 0 && d >= max {
+			return max
+		}
+	}
+	if max > 0 && d > max {
+		return max
+	}
+	return d
+}
@@ -0,0 +1,227 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ValidationError marks an event that can never be applied as sent
+// (unknown type, missing or out-of-range fields). Such events are routed to
+// dead_letter_events instead of being retried.
+type ValidationError struct {
+	Reason string
+}
+
+func (e *ValidationError) Error() string { return e.Reason }
+
+func invalidf(format string, args ...any) error {
+	return &ValidationError{Reason: fmt.Sprintf(format, args...)}
+}
+
+// Normalized event payloads sent by the gateway (OCPP 1.6 shaped; see
+// ocpp201_events.go for the OCPP 2.0.1 TransactionEvent).
+// Required numeric fields are pointers so a missing field is not mistaken for 0.
+
+type ChargerBootedEvent struct {
+	Vendor      string `json:"vendor"`
+	Model       string `json:"model"`
+	OcppVersion string `json:"ocppVersion"`
+	// HeartbeatInterval (seconds) the gateway returned in the BootNotification response.
+	HeartbeatInterval *int `json:"heartbeatInterval,omitempty"`
+}
+
+type ChargerHeartbeatEvent struct{}
+
+type ConnectorStatusChangedEvent struct {
+	EvseId      *int   `json:"evseId,omitempty"` // OCPP 2.0.1 only
+	ConnectorId *int   `json:"connectorId"`
+	Status      string `json:"status"`
+	ErrorCode   string `json:"errorCode"`
+}
+
+type TransactionStartedEvent struct {
+	ConnectorId   *int   `json:"connectorId"`
+	TransactionId *int   `json:"transactionId"`
+	IdTag         string `json:"idTag"`
+	MeterStartWh  *int64 `json:"meterStartWh"`
+}
+
+type SampledValue struct {
+	Measurand string          `json:"measurand"`
+	Value     json.RawMessage `json:"value"`
+	Unit      string          `json:"unit,omitempty"`
+	Context   string          `json:"context,omitempty"`
+	Phase     string          `json:"phase,omitempty"`
+}
+
+type MeterSampleEvent struct {
+	ConnectorId   *int           `json:"connectorId"`
+	TransactionId *int           `json:"transactionId"`
+	Samples       []SampledValue `json:"samples"`
+}
+
+type TransactionEndedEvent struct {
+	TransactionId *int    `json:"transactionId"`
+	MeterStopWh   *int64  `json:"meterStopWh"`
+	Reason        *string `json:"reason"`
+	IdTag         string  `json:"idTag"`
+}
+
+var connectorStatuses = map[string]bool{
+	"Available": true, "Preparing": true, "Charging": true, "SuspendedEVSE": true, "SuspendedEV": true,
+	"Finishing": true, "Reserved": true, "Unavailable": true, "Faulted": true,
+	"Occupied": true, // OCPP 2.0.1
+}
+
+var stopReasons = map[string]bool{
+	"EmergencyStop": true, "EVDisconnected": true, "HardReset": true, "Local": true, "Other": true,
+	"PowerLoss": true, "Reboot": true, "Remote": true, "SoftReset": true, "UnlockCommand": true, "DeAuthorized": true,
+}
+
+func (e *ChargerBootedEvent) Validate() error {
+	if e.Vendor == "" {
+		return invalidf("vendor is required")
+	}
+	if e.Model == "" {
+		return invalidf("model is required")
+	}
+	if e.HeartbeatInterval != nil && *e.HeartbeatInterval <= 0 {
+		return invalidf("heartbeatInterval must be > 0")
+	}
+	return nil
+}
+
+func (e *ChargerHeartbeatEvent) Validate() error { return nil }
+
+func (e *ConnectorStatusChangedEvent) Validate() error {
+	if e.ConnectorId == nil {
+		return invalidf("connectorId is required")
+	}
+	if *e.ConnectorId < 0 {
+		return invalidf("connectorId must be >= 0")
+	}
+	if !connectorStatuses[e.Status] {
+		return invalidf("unknown status %q", e.Status)
+	}
+	if e.ErrorCode == "" {
+		e.ErrorCode = "NoError"
+	}
+	return nil
+}
+
+func (e *TransactionStartedEvent) Validate() error {
+	if e.ConnectorId == nil {
+		return invalidf("connectorId is required")
+	}
+	if *e.ConnectorId < 1 {
+		return invalidf("connectorId must be >= 1")
+	}
+	if e.TransactionId == nil {
+		return invalidf("transactionId is required")
+	}
+	if e.MeterStartWh != nil && *e.MeterStartWh < 0 {
+		return invalidf("meterStartWh must be >= 0")
+	}
+	return nil
+}
+
+func (e *MeterSampleEvent) Validate() error {
+	if e.TransactionId == nil {
+		return invalidf("transactionId is required")
+	}
+	if e.ConnectorId != nil && *e.ConnectorId < 0 {
+		return invalidf("connectorId must be >= 0")
+	}
+	if len(e.Samples) == 0 {
+		return invalidf("samples must not be empty")
+	}
+	for i, s := range e.Samples {
+		if s.Measurand == "" {
+			return invalidf("samples[%d].measurand is required", i)
+		}
+		v, ok := sampleNumber(s.Value)
+		if !ok {
+			return invalidf("samples[%d].value must be numeric", i)
+		}
+		// Energy values are summed/cast as bigint Wh during finalization.
+		if strings.HasPrefix(s.Measurand, "Energy.") {
+			if _, err := strconv.ParseInt(v, 10, 64); err != nil {
+				return invalidf("samples[%d].value must be an integer for %s", i, s.Measurand)
+			}
+			if strings.HasPrefix(v, "-") {
+				return invalidf("samples[%d].value must be >= 0 for %s", i, s.Measurand)
+			}
+		}
+	}
+	return nil
+}
+
+func (e *TransactionEndedEvent) Validate() error {
+	if e.TransactionId == nil {
+		return invalidf("transactionId is required")
+	}
+	if e.MeterStopWh != nil && *e.MeterStopWh < 0 {
+		return invalidf("meterStopWh must be >= 0")
+	}
+	if e.Reason != nil && !stopReasons[*e.Reason] {
+		return invalidf("unknown reason %q", *e.Reason)
+	}
+	return nil
+}
+
+// sampleNumber accepts a JSON number or a numeric string and returns its text.
+func sampleNumber(raw json.RawMessage) (string, bool) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 {
+		return "", false
+	}
+	var text string
+	if raw[0] == '"' {
+		if err := json.Unmarshal(raw, &text); err != nil {
+			return "", false
+		}
+	} else {
+		text = string(raw)
+	}
+	if _, err := strconv.ParseFloat(text, 64); err != nil {
+		return "", false
+	}
+	return text, true
+}
+
+type validator interface {
+	Validate() error
+}
+
+// decodeEventPayload decodes raw into the typed struct for eventType and validates it.
+func decodeEventPayload(eventType string, raw []byte) (validator, error) {
+	var v validator
+	switch eventType {
+	case "ChargerBooted":
+		v = &ChargerBootedEvent{}
+	case "ChargerHeartbeat":
+		v = &ChargerHeartbeatEvent{}
+	case "ConnectorStatusChanged":
+		v = &ConnectorStatusChangedEvent{}
+	case "TransactionStarted":
+		v = &TransactionStartedEvent{}
+	case "MeterSample":
+		v = &MeterSampleEvent{}
+	case "TransactionEnded":
+		v = &TransactionEndedEvent{}
+	case "TransactionEvent":
+		v = &TransactionEvent201{}
+	default:
+		return nil, invalidf("unknown event type %q", eventType)
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return nil, invalidf("invalid %s payload: %v", eventType, err)
+	}
+	if err := v.Validate(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
@@ -2,12 +2,20 @@ package services
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"sort"
+	"strconv"
 	"time"
 
+	"cpms/internal/alerts"
 	"cpms/internal/models"
 	"cpms/internal/repo"
+
+	"github.com/jackc/pgx/v5"
 )
 
 type EventsProcessor struct {
@@ -17,7 +25,16 @@ type EventsProcessor struct {
 	Sessions    *repo.SessionsRepo
 	Pricing     *PricingService
 	Settlements *SettlementService
+	DeadLetters *repo.DeadLettersRepo
+	Orphans     *repo.OrphansRepo
 	MaxSkew     time.Duration
+	// Alerts is optional (nil during replay): Faulted connectors raise alerts.
+	Alerts *alerts.Engine
+	// Commands is optional (nil during replay): events complete the commands that caused them.
+	Commands *repo.CommandsRepo
+	// DB is where session creation for a transaction runs in a transaction (see
+	// lockTransaction); without it the steps run unguarded.
+	DB repo.DBTX
 }
 
 func NewEventsProcessor(
@@ -27,6 +44,8 @@ func NewEventsProcessor(
 	s *repo.SessionsRepo,
 	pricing *PricingService,
 	settlements *SettlementService,
+	deadLetters *repo.DeadLettersRepo,
+	orphans *repo.OrphansRepo,
 	maxSkew time.Duration,
 ) *EventsProcessor {
 	return &EventsProcessor{
@@ -36,37 +55,259 @@ func NewEventsProcessor(
 		Sessions:    s,
 		Pricing:     pricing,
 		Settlements: settlements,
+		DeadLetters: deadLetters,
+		Orphans:     orphans,
 		MaxSkew:     maxSkew,
 	}
 }
 
 type baseEvent struct {
-	Type string `json:"type"`
+	Type    string `json:"type"`
+	EventId string `json:"eventId"`
 }
 
-func (p *EventsProcessor) Ingest(ctx context.Context, raw []byte) (string, error) {
-	var b baseEvent
-	if err := json.Unmarshal(raw, &b); err != nil {
-		return "", err
+type IngestResult struct {
+	EventId   int64
+	Type      string
+	Duplicate bool
+	// DeadLetterId is set when the event failed validation and was stored
+	// in dead_letter_events instead of the queue.
+	DeadLetterId int64
+	Reason       string
+}
+
+// ErrForeignCharger refuses an event a tenant's gateway posted for another tenant's charger.
+var ErrForeignCharger = errors.New("charger does not belong to the gateway's tenant")
+
+// Ingest validates the envelope and persists the raw event as Pending.
+// Side effects are applied later by the EventQueue workers via Apply.
+// Events failing validation are dead-lettered; only malformed JSON and events refused
+// by admit return an error. tenantId is the posting gateway's tenant ("" = CPMS gateway).
+func (p *EventsProcessor) Ingest(ctx context.Context, tenantId string, raw []byte) (IngestResult, error) {
+	ev, err := p.parse(raw)
+	if err := p.admit(ctx, tenantId, ev); err != nil {
+		return IngestResult{Type: ev.EventType}, err
 	}
-	if b.Type == "" {
-		return "", errors.New("missing type")
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		return p.deadLetterRaw(ctx, ev, raw, verr.Reason)
+	}
+	if err != nil {
+		return IngestResult{Type: ev.EventType}, err
+	}
+	if err := p.ensureBootedCharger(ctx, tenantId, ev); err != nil {
+		return IngestResult{Type: ev.EventType}, err
+	}
+	id, dup, err := p.Events.InsertRaw(ctx, ev.ChargePointId, ev.EventType, ev.Ts, *ev.EventKey, raw)
+	if err != nil {
+		return IngestResult{Type: ev.EventType}, err
+	}
+	return IngestResult{EventId: id, Type: ev.EventType, Duplicate: dup}, nil
+}
+
+// admit refuses events a tenant's gateway posts for chargers of other tenants (or of none);
+// the CPMS gateway (tenantId "") posts for every charger. A ChargerBooted of an unknown
+// charge point is admitted and creates the charger in the gateway's tenant.
+func (p *EventsProcessor) admit(ctx context.Context, tenantId string, ev models.GatewayEvent) error {
+	if tenantId == "" || ev.ChargePointId == "" {
+		return nil
+	}
+	ch, err := p.Chargers.Get(ctx, ev.ChargePointId)
+	if err != nil {
+		return err
+	}
+	if ch == nil && ev.EventType == "ChargerBooted" {
+		return nil
+	}
+	if ch == nil || ch.TenantId == nil || *ch.TenantId != tenantId {
+		return ErrForeignCharger
+	}
+	return nil
+}
+
+// ensureBootedCharger inserts a Pending charger for a ChargerBooted of an unknown
+// charge point, so the event can be stored and the charger shows up for approval.
+// A charger booted through a tenant's gateway belongs to that tenant.
+func (p *EventsProcessor) ensureBootedCharger(ctx context.Context, tenantId string, ev models.GatewayEvent) error {
+	if ev.EventType != "ChargerBooted" {
+		return nil
+	}
+	return p.Chargers.EnsureExists(ctx, ev.ChargePointId, tenantId)
+}
+
+func (p *EventsProcessor) deadLetterRaw(ctx context.Context, ev models.GatewayEvent, raw []byte, reason string) (IngestResult, error) {
+	id, err := p.DeadLetters.Insert(ctx, models.DeadLetterEvent{
+		ChargePointId: ev.ChargePointId,
+		EventType:     ev.EventType,
+		Payload:       raw,
+		Reason:        reason,
+	})
+	if err != nil {
+		return IngestResult{Type: ev.EventType}, err
+	}
+	return IngestResult{Type: ev.EventType, DeadLetterId: id, Reason: reason}, nil
+}
+
+// DeadLetterEvent records a stored event that could not be applied.
+func (p *EventsProcessor) DeadLetterEvent(ctx context.Context, ev models.GatewayEvent, reason string) error {
+	_, err := p.DeadLetters.Insert(ctx, models.DeadLetterEvent{
+		GatewayEventId: &ev.Id,
+		ChargePointId:  ev.ChargePointId,
+		EventType:      ev.EventType,
+		Payload:        ev.Payload,
+		Reason:         reason,
+	})
+	return err
+}
+
+type BatchItemResult struct {
+	Index         int
+	ChargePointId string
+	IngestResult
+	Err error
+}
+
+// IngestBatch stores many envelopes at once. Events are grouped by charge point,
+// sorted by ts (stable, so equal timestamps keep their input order), then stored and
+// applied in that order in one transaction per charge point (see storeAndApply).
+// Results are returned in input order. tenantId is the posting gateway's tenant, see Ingest.
+func (p *EventsProcessor) IngestBatch(ctx context.Context, tenantId string, items [][]byte) []BatchItemResult {
+	results := make([]BatchItemResult, len(items))
+	groups := map[string][]int{}
+	var order []string
+	parsed := make([]models.GatewayEvent, len(items))
+
+	for i, raw := range items {
+		results[i].Index = i
+		ev, err := p.parse(raw)
+		results[i].Type = ev.EventType
+		results[i].ChargePointId = ev.ChargePointId
+		if err := p.admit(ctx, tenantId, ev); err != nil {
+			results[i].Err = err
+			continue
+		}
+		var verr *ValidationError
+		if errors.As(err, &verr) {
+			res, err := p.deadLetterRaw(ctx, ev, raw, verr.Reason)
+			results[i].IngestResult = res
+			results[i].Err = err
+			continue
+		}
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		if err := p.ensureBootedCharger(ctx, tenantId, ev); err != nil {
+			results[i].Err = err
+			continue
+		}
+		parsed[i] = ev
+		if _, ok := groups[ev.ChargePointId]; !ok {
+			order = append(order, ev.ChargePointId)
+		}
+		groups[ev.ChargePointId] = append(groups[ev.ChargePointId], i)
+	}
+
+	for _, cp := range order {
+		idx := groups[cp]
+		sort.SliceStable(idx, func(a, b int) bool { return parsed[idx[a]].Ts.Before(parsed[idx[b]].Ts) })
+
+		events := make([]models.GatewayEvent, len(idx))
+		for j, i := range idx {
+			events[j] = parsed[i]
+		}
+		stored, err := p.storeAndApply(ctx, cp, events)
+		for j, i := range idx {
+			if err != nil {
+				results[i].Err = err
+				continue
+			}
+			results[i].EventId = stored[j].Id
+			results[i].Duplicate = stored[j].Duplicate
+		}
 	}
+	return results
+}
 
+// storeAndApply stores the events of one charge point and applies them in the given
+// order, all in one transaction. Each event is applied in a savepoint and marked Done;
+// at the first one that fails, it and the rest stay Pending for the queue, which
+// retries or dead-letters them in the same order. Nothing is applied while the charger
+// still has unfinished events from before the batch: those must go first, so the queue
+// handles the batch. Without DB the events are only stored.
+func (p *EventsProcessor) storeAndApply(ctx context.Context, cp string, events []models.GatewayEvent) ([]repo.InsertResult, error) {
+	if p.DB == nil {
+		return p.Events.InsertBatch(ctx, events)
+	}
+	var stored []repo.InsertResult
+	err := pgx.BeginFunc(ctx, p.DB, func(tx pgx.Tx) error {
+		q := p.on(tx)
+		open, err := q.Events.CountOpen(ctx, cp)
+		if err != nil {
+			return err
+		}
+		if stored, err = q.Events.InsertBatch(ctx, events); err != nil || open > 0 {
+			return err
+		}
+		for _, st := range stored {
+			if st.Duplicate {
+				continue
+			}
+			ev, err := q.Events.Get(ctx, st.Id)
+			if err != nil {
+				return err
+			}
+			err = pgx.BeginFunc(ctx, tx, func(sp pgx.Tx) error {
+				r := p.on(sp)
+				if err := r.Apply(ctx, *ev); err != nil {
+					return err
+				}
+				return r.Events.MarkDone(ctx, ev.Id)
+			})
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				return nil // left to the queue from here on
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
+
+// parse decodes the envelope, resolves chargePointId, type, ts and the dedup key,
+// and validates the payload against its typed event struct.
+// Everything except malformed JSON is reported as a *ValidationError.
+func (p *EventsProcessor) parse(raw []byte) (models.GatewayEvent, error) {
+	var b baseEvent
+	if err := json.Unmarshal(raw, &b); err != nil {
+		return models.GatewayEvent{}, err
+	}
 	var envelope map[string]any
 	_ = json.Unmarshal(raw, &envelope)
 
 	cp, _ := envelope["chargePointId"].(string)
-	tsStr, _ := envelope["ts"].(string)
+	ev := models.GatewayEvent{ChargePointId: cp, EventType: b.Type, Payload: raw}
+	if b.Type == "" {
+		return ev, invalidf("missing type")
+	}
 	if cp == "" {
-		return "", errors.New("missing chargePointId")
+		return ev, invalidf("missing chargePointId")
 	}
 
-	ts := time.Now().UTC()
+	tsStr, _ := envelope["ts"].(string)
+	receivedTs := time.Now().UTC()
+	ts := receivedTs
 	if tsStr != "" {
-		if t, err := time.Parse(time.RFC3339, tsStr); err == nil {
-			ts = t.UTC()
+		t, err := time.Parse(time.RFC3339, tsStr)
+		if err != nil {
+			return ev, invalidf("invalid ts %q", tsStr)
 		}
+		ts = t.UTC()
 	}
 	if p.MaxSkew > 0 {
 		now := time.Now().UTC()
@@ -74,148 +315,401 @@ func (p *EventsProcessor) Ingest(ctx context.Context, raw []byte) (string, error
 			ts = now
 		}
 	}
+	ev.Ts = ts
 
-	if err := p.Events.InsertRaw(ctx, cp, b.Type, ts, raw); err != nil {
-		return b.Type, err
+	if _, err := decodeEventPayload(b.Type, raw); err != nil {
+		return ev, err
 	}
 
-	switch b.Type {
-	case "ChargerBooted":
-		vendor, _ := envelope["vendor"].(string)
-		model, _ := envelope["model"].(string)
-		ocpp, _ := envelope["ocppVersion"].(string)
+	keyTs := tsStr
+	if keyTs == "" {
+		// Without ts the envelope does not tell copies from retries; the receipt time keeps
+		// identical events (heartbeats, repeated statuses) from being taken as duplicates.
+		keyTs = receivedTs.Format(time.RFC3339Nano)
+	}
+	key := eventKey(cp, b, keyTs, envelope)
+	ev.EventKey = &key
+	return ev, nil
+}
 
-		existing, err := p.Chargers.Get(ctx, cp)
-		if err != nil {
-			return b.Type, err
-		}
-		if existing != nil {
-			_ = p.Chargers.TouchLastSeen(ctx, cp, ts)
-		} else {
-			_ = p.Chargers.Upsert(ctx, models.Charger{
-				ChargePointId: cp,
-				SecretHash:    "",
-				IsActive:      false,
-				Vendor:        vendor,
-				Model:         model,
-				OcppVersion:   ocpp,
-			})
+// eventKey identifies an event across gateway retries. It uses the gateway
+// message ID when present, otherwise a hash of chargePointId+type+ts+payload, where ts
+// is the envelope's ts as sent, or the receipt time when it has none.
+// The payload is re-marshaled from the decoded map so key order does not matter.
+func eventKey(cp string, b baseEvent, ts string, envelope map[string]any) string {
+	if b.EventId != "" {
+		return "id:" + cp + ":" + b.EventId
+	}
+	canonical, _ := json.Marshal(envelope)
+	h := sha256.New()
+	for _, part := range [][]byte{[]byte(cp), []byte(b.Type), []byte(ts), canonical} {
+		h.Write(part)
+		h.Write([]byte{0})
+	}
+	return "h:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// Apply runs the state/session side effects of a stored event.
+// Any error is returned so the queue can retry the event; a *ValidationError
+// means retrying is pointless.
+func (p *EventsProcessor) Apply(ctx context.Context, ev models.GatewayEvent) error {
+	payload, err := decodeEventPayload(ev.EventType, ev.Payload)
+	if err != nil {
+		return err
+	}
+	cp := ev.ChargePointId
+	ts := ev.Ts
+
+	switch e := payload.(type) {
+	case *ChargerBootedEvent:
+		// Unknown chargers were inserted as Pending at ingest; they wait for approval
+		// (see /v1/registrations).
+		if err := p.Chargers.RecordBoot(ctx, cp, e.Vendor, e.Model, e.OcppVersion, ts); err != nil {
+			return err
+		}
+		if err := p.correlateCommand(ctx, ev, repo.EffectMatch{Event: "ChargerBooted"}); err != nil {
+			return err
+		}
+		err := p.Chargers.TouchLastSeen(ctx, cp, ts)
+		if err != nil || e.HeartbeatInterval == nil {
+			return err
 		}
+		return p.Chargers.SetHeartbeatInterval(ctx, cp, *e.HeartbeatInterval)
 
-	case "ChargerHeartbeat":
-		_ = p.State.TouchHeartbeat(ctx, cp, ts)
+	case *ChargerHeartbeatEvent:
+		return p.State.TouchHeartbeat(ctx, cp, ts)
 
-	case "ConnectorStatusChanged":
-		connId := intFromAny(envelope["connectorId"])
-		status, _ := envelope["status"].(string)
-		errCode, _ := envelope["errorCode"].(string)
-		_ = p.State.UpsertConnector(ctx, models.ConnectorState{
+	case *ConnectorStatusChangedEvent:
+		prev, err := p.State.ConnectorStatus(ctx, cp, *e.ConnectorId)
+		if err != nil {
+			return err
+		}
+		st := models.ConnectorState{
 			ChargePointId: cp,
-			ConnectorId:   connId,
-			Status:        status,
-			ErrorCode:     errCode,
+			ConnectorId:   *e.ConnectorId,
+			Status:        e.Status,
+			ErrorCode:     e.ErrorCode,
 			UpdatedAt:     ts,
-		})
-		_ = p.Chargers.TouchLastSeen(ctx, cp, ts)
-
-	case "TransactionStarted":
-		connId := intFromAny(envelope["connectorId"])
-		txId := intFromAny(envelope["transactionId"])
-		idTag, _ := envelope["idTag"].(string)
+		}
+		if err := p.State.UpsertConnector(ctx, st); err != nil {
+			return err
+		}
+		if err := p.State.RecordStatus(ctx, st, ev.Id); err != nil {
+			return err
+		}
+		if err := p.alertConnectorFault(ctx, e, cp); err != nil {
+			return err
+		}
+		if err := p.correlateCommand(ctx, ev, repo.EffectMatch{
+			Event: "ConnectorStatusChanged", ConnectorId: e.ConnectorId, EvseId: e.EvseId, Status: e.Status, PrevStatus: prev,
+		}); err != nil {
+			return err
+		}
+		return p.Chargers.TouchLastSeen(ctx, cp, ts)
 
-		var ms *int64
-		if v, ok := envelope["meterStartWh"]; ok {
-			x := int64FromAny(v)
-			ms = &x
+	case *TransactionStartedEvent:
+		txId := strconv.Itoa(*e.TransactionId)
+		epoch, err := p.Events.BootEpoch(ctx, cp, ev.Id)
+		if err != nil {
+			return err
 		}
 		session := models.Session{
 			ChargePointId: cp,
-			ConnectorId:   connId,
+			ConnectorId:   *e.ConnectorId,
 			TransactionId: txId,
-			IdTag:         idTag,
+			IdTag:         e.IdTag,
 			StartedAt:     ts,
-			MeterStartWh:  ms,
+			MeterStartWh:  e.MeterStartWh,
+			StartEventId:  &ev.Id,
+			BootEpoch:     epoch,
 		}
-		_, _ = p.Sessions.Start(ctx, session)
-		_ = p.Chargers.TouchLastSeen(ctx, cp, ts)
+		var sessionId string
+		if err := p.lockTransaction(ctx, cp, txId, epoch, func(q *EventsProcessor) error {
+			if sessionId, err = q.startSession(ctx, session); err != nil {
+				return err
+			}
+			return q.applyOrphans(ctx, cp, txId, epoch, sessionId)
+		}); err != nil {
+			return err
+		}
+		if err := p.correlateCommand(ctx, ev, repo.EffectMatch{
+			Event: "TransactionStarted", ConnectorId: e.ConnectorId, IdTag: e.IdTag, TransactionId: txId, SessionId: &sessionId,
+		}); err != nil {
+			return err
+		}
+		return p.Chargers.TouchLastSeen(ctx, cp, ts)
 
-	case "MeterSample":
-		txId := intFromAny(envelope["transactionId"])
-		sess, err := p.Sessions.FindByTx(ctx, cp, txId)
+	case *MeterSampleEvent:
+		txId := strconv.Itoa(*e.TransactionId)
+		sess, epoch, err := p.findTxSession(ctx, ev, txId, e.ConnectorId)
 		if err != nil {
-			return b.Type, err
+			return err
 		}
 		if sess == nil {
-			return b.Type, nil
+			return p.Orphans.Park(ctx, ev, txId, epoch)
 		}
-		_ = p.Sessions.InsertMeterSample(ctx, models.MeterSample{
-			SessionId:     sess.SessionId,
-			ChargePointId: cp,
-			TransactionId: txId,
-			Ts:            ts,
-			SamplesJSON:   raw,
-		})
-		_ = p.Chargers.TouchLastSeen(ctx, cp, ts)
-
-	case "TransactionEnded":
-		txId := intFromAny(envelope["transactionId"])
-		sess, err := p.Sessions.FindByTx(ctx, cp, txId)
+		return p.applyMeterSample(ctx, ev, e, sess)
+
+	case *TransactionEndedEvent:
+		txId := strconv.Itoa(*e.TransactionId)
+		sess, epoch, err := p.findTxSession(ctx, ev, txId, nil)
 		if err != nil {
-			return b.Type, err
+			return err
 		}
 		if sess == nil {
-			return b.Type, nil
+			return p.Orphans.Park(ctx, ev, txId, epoch)
 		}
-		var stop *int64
-		if v, ok := envelope["meterStopWh"]; ok {
-			x := int64FromAny(v)
-			stop = &x
+		return p.applyTransactionEnded(ctx, ev, e, sess)
+
+	case *TransactionEvent201:
+		return p.applyTransactionEvent(ctx, ev, e)
+	}
+
+	return nil
+}
+
+// startSession inserts the session, or adopts the session the orphan reaper
+// synthesized for this transaction before TransactionStarted showed up.
+func (p *EventsProcessor) startSession(ctx context.Context, s models.Session) (string, error) {
+	synth, err := p.Sessions.FindSynthesized(ctx, s.ChargePointId, s.TransactionId, s.BootEpoch)
+	if err != nil {
+		return "", err
+	}
+	if synth == nil {
+		return p.Sessions.Start(ctx, s)
+	}
+	if err := p.Sessions.AdoptSynthesized(ctx, synth.SessionId, s); err != nil {
+		return "", err
+	}
+	if synth.FinalizedAt != nil {
+		// meter_start is known now, so energy can be computed properly.
+		if err := p.Sessions.FinalizeWithFallbackForce(ctx, synth.SessionId, true); err != nil {
+			return "", err
 		}
-		var reason *string
-		if v, ok := envelope["reason"].(string); ok {
-			reason = &v
+		if err := p.finalizeAndSettle(ctx, synth.SessionId); err != nil {
+			return "", err
 		}
-		// Store end markers (meter_stop may be missing)
-		_ = p.Sessions.End(ctx, sess.SessionId, ts, stop, reason)
-		// Finalize with fallback (StopTransaction -> last register -> sum interval -> Missing)
-		_ = p.Sessions.FinalizeWithFallback(ctx, sess.SessionId)
-		if p.Pricing != nil {
-			_ = p.Pricing.PriceSessionPerKwh(ctx, sess.SessionId)
+	}
+	return synth.SessionId, nil
+}
+
+func (p *EventsProcessor) applyMeterSample(ctx context.Context, ev models.GatewayEvent, e *MeterSampleEvent, sess *models.Session) error {
+	if err := p.Sessions.InsertMeterSample(ctx, models.MeterSample{
+		SessionId:     sess.SessionId,
+		ChargePointId: ev.ChargePointId,
+		TransactionId: sess.TransactionId,
+		Ts:            ev.Ts,
+		SamplesJSON:   ev.Payload,
+		EventId:       &ev.Id,
+	}); err != nil {
+		return err
+	}
+	return p.Chargers.TouchLastSeen(ctx, ev.ChargePointId, ev.Ts)
+}
+
+func (p *EventsProcessor) applyTransactionEnded(ctx context.Context, ev models.GatewayEvent, e *TransactionEndedEvent, sess *models.Session) error {
+	// Store end markers (meter_stop may be missing)
+	if err := p.Sessions.End(ctx, sess.SessionId, ev.Ts, e.MeterStopWh, e.Reason); err != nil {
+		return err
+	}
+	if err := p.refinalizeStale(ctx, sess); err != nil {
+		return err
+	}
+	if err := p.finalizeAndSettle(ctx, sess.SessionId); err != nil {
+		return err
+	}
+	if sess.IsSynthesized {
+		// meter_start is unknown for synthesized sessions
+		if err := p.Sessions.MarkEstimated(ctx, sess.SessionId); err != nil {
+			return err
 		}
-		if p.Settlements != nil {
-			_ = p.Settlements.CreatePendingFromSession(ctx, sess.SessionId)
+	}
+	if err := p.correlateCommand(ctx, ev, repo.EffectMatch{
+		Event: "TransactionEnded", TransactionId: sess.TransactionId, SessionId: &sess.SessionId,
+	}); err != nil {
+		return err
+	}
+	return p.Chargers.TouchLastSeen(ctx, ev.ChargePointId, ev.Ts)
+}
+
+// alertConnectorFault raises an alert while a connector is Faulted and resolves it
+// on the next non-Faulted status.
+func (p *EventsProcessor) alertConnectorFault(ctx context.Context, e *ConnectorStatusChangedEvent, cp string) error {
+	if p.Alerts == nil {
+		return nil
+	}
+	key := fmt.Sprintf("fault:%s:%d", cp, *e.ConnectorId)
+	if e.Status != "Faulted" {
+		return p.Alerts.Resolve(ctx, key)
+	}
+	return p.Alerts.Fire(ctx, models.Alert{
+		DedupKey:      key,
+		ChargePointId: cp,
+		ConnectorId:   e.ConnectorId,
+		Kind:          "ConnectorFaulted",
+		Severity:      alerts.SeverityWarning,
+		Message:       fmt.Sprintf("Connector %d of %s is Faulted (%s)", *e.ConnectorId, cp, e.ErrorCode),
+	})
+}
+
+// refinalizeStale clears the estimate of a session the stale sweeper closed
+// before its real end arrived, so energy and cost are recomputed.
+func (p *EventsProcessor) refinalizeStale(ctx context.Context, sess *models.Session) error {
+	if sess.Reason == nil || *sess.Reason != StaleClosedReason {
+		return nil
+	}
+	return p.Sessions.FinalizeWithFallbackForce(ctx, sess.SessionId, true)
+}
+
+// applyOrphans applies parked events of a transaction to its session in ts order.
+func (p *EventsProcessor) applyOrphans(ctx context.Context, cp string, transactionId string, bootEpoch int, sessionId string) error {
+	orphans, err := p.Orphans.ListPending(ctx, cp, transactionId, bootEpoch)
+	if err != nil || len(orphans) == 0 {
+		return err
+	}
+	for _, ev := range orphans {
+		sess, err := p.Sessions.GetByID(ctx, sessionId)
+		if err != nil {
+			return err
+		}
+		if sess == nil {
+			return nil
+		}
+		payload, err := decodeEventPayload(ev.EventType, ev.Payload)
+		if err != nil {
+			return err
 		}
+		switch e := payload.(type) {
+		case *MeterSampleEvent:
+			err = p.applyMeterSample(ctx, ev, e, sess)
+		case *TransactionEndedEvent:
+			err = p.applyTransactionEnded(ctx, ev, e, sess)
+		case *TransactionEvent201:
+			err = p.applyTransactionEventToSession(ctx, ev, e, sess)
+		}
+		if err != nil {
+			return err
+		}
+		if err := p.Orphans.MarkApplied(ctx, ev.Id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-		_ = p.Chargers.TouchLastSeen(ctx, cp, ts)
-		_ = p.Sessions.End(ctx, sess.SessionId, ts, stop, reason)
-		_ = p.Chargers.TouchLastSeen(ctx, cp, ts)
+// on returns a copy of the processor whose repos run on tx.
+func (p *EventsProcessor) on(tx pgx.Tx) *EventsProcessor {
+	q := newEventsProcessorOn(tx, p.MaxSkew)
+	q.Alerts = p.Alerts
+	if p.Commands != nil {
+		q.Commands = repo.NewCommandsRepo(tx)
 	}
+	return q
+}
+
+// lockTransaction runs fn with a processor on a database transaction holding the lock of
+// the transaction's orphan key, so a TransactionStarted and the orphan reaper cannot both
+// create a session for it and parked events are consumed with the session they go to.
+func (p *EventsProcessor) lockTransaction(ctx context.Context, cp string, transactionId string, bootEpoch int, fn func(q *EventsProcessor) error) error {
+	if p.DB == nil {
+		return fn(p)
+	}
+	return pgx.BeginFunc(ctx, p.DB, func(tx pgx.Tx) error {
+		q := p.on(tx)
+		if err := q.Orphans.LockTransaction(ctx, cp, transactionId, bootEpoch); err != nil {
+			return err
+		}
+		return fn(q)
+	})
+}
 
-	return b.Type, nil
+// SynthesizeFromOrphans creates an estimated session for a transaction whose
+// TransactionStarted never arrived and applies its parked events to it.
+// started_at is the ts of the earliest orphan; meter_start stays unknown.
+// Events parked after an earlier synthesis go to that session. Returns "" when a
+// TransactionStarted consumed the orphans meanwhile.
+func (p *EventsProcessor) SynthesizeFromOrphans(ctx context.Context, cp string, transactionId string, bootEpoch int) (string, error) {
+	var sessionId string
+	err := p.lockTransaction(ctx, cp, transactionId, bootEpoch, func(q *EventsProcessor) error {
+		var err error
+		sessionId, err = q.synthesizeFromOrphans(ctx, cp, transactionId, bootEpoch)
+		return err
+	})
+	return sessionId, err
 }
 
-func intFromAny(v any) int {
-	switch t := v.(type) {
-	case float64:
-		return int(t)
-	case int:
-		return t
-	case int64:
-		return int(t)
-	default:
-		return 0
+func (p *EventsProcessor) synthesizeFromOrphans(ctx context.Context, cp string, transactionId string, bootEpoch int) (string, error) {
+	orphans, err := p.Orphans.ListPending(ctx, cp, transactionId, bootEpoch)
+	if err != nil || len(orphans) == 0 {
+		return "", err
+	}
+	synth, err := p.Sessions.FindSynthesized(ctx, cp, transactionId, bootEpoch)
+	if err != nil {
+		return "", err
+	}
+	if synth != nil {
+		if err := p.applyOrphans(ctx, cp, transactionId, bootEpoch, synth.SessionId); err != nil {
+			return "", err
+		}
+		return synth.SessionId, p.Sessions.MarkEstimated(ctx, synth.SessionId)
+	}
+	session := models.Session{
+		ChargePointId: cp,
+		TransactionId: transactionId,
+		StartedAt:     orphans[0].Ts,
+		BootEpoch:     bootEpoch,
+	}
+	for _, ev := range orphans {
+		payload, err := decodeEventPayload(ev.EventType, ev.Payload)
+		if err != nil {
+			continue
+		}
+		switch e := payload.(type) {
+		case *MeterSampleEvent:
+			if e.ConnectorId != nil && session.ConnectorId == 0 {
+				session.ConnectorId = *e.ConnectorId
+			}
+		case *TransactionEndedEvent:
+			if e.IdTag != "" {
+				session.IdTag = e.IdTag
+			}
+		case *TransactionEvent201:
+			if id := e.connectorId(); id != nil && session.ConnectorId == 0 {
+				session.ConnectorId = *id
+			}
+			if e.Evse != nil && session.EvseId == nil {
+				session.EvseId = e.Evse.Id
+			}
+			if e.idTag() != "" && session.IdTag == "" {
+				session.IdTag = e.idTag()
+			}
+		}
+	}
+	sessionId, err := p.Sessions.StartSynthesized(ctx, session)
+	if err != nil {
+		return "", err
+	}
+	if err := p.applyOrphans(ctx, cp, transactionId, bootEpoch, sessionId); err != nil {
+		return "", err
 	}
+	return sessionId, p.Sessions.MarkEstimated(ctx, sessionId)
 }
 
-func int64FromAny(v any) int64 {
-	switch t := v.(type) {
-	case float64:
-		return int64(t)
-	case int64:
-		return t
-	case int:
-		return int64(t)
-	default:
-		return 0
+// finalizeAndSettle finalizes with fallback (StopTransaction -> last register -> sum interval -> Missing),
+// prices the session and creates its Pending settlement. Every step is idempotent.
+func (p *EventsProcessor) finalizeAndSettle(ctx context.Context, sessionId string) error {
+	if err := p.Sessions.FinalizeWithFallback(ctx, sessionId); err != nil {
+		return err
+	}
+	if p.Pricing != nil {
+		if err := p.Pricing.PriceSessionPerKwh(ctx, sessionId); err != nil {
+			return err
+		}
+	}
+	if p.Settlements != nil {
+		if err := p.Settlements.CreatePendingFromSession(ctx, sessionId); err != nil {
+			return err
+		}
 	}
+	return nil
 }
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// GatewayLimiter rate-limits the commands sent through each gateway (by base URL), shared
+// by all bulk command jobs. Jobs wait for a token; other commands always go out but use
+// up tokens, so jobs yield to them. The limit applies per CPMS instance.
+type GatewayLimiter struct {
+	PerMinute int // 0 disables the limit
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func NewGatewayLimiter(perMinute int) *GatewayLimiter {
+	return &GatewayLimiter{PerMinute: perMinute, buckets: map[string]*tokenBucket{}}
+}
+
+// Take reports whether a job may send one more command through gateway now.
+func (l *GatewayLimiter) Take(gateway string) bool {
+	if l == nil || l.PerMinute <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.bucket(gateway).take(time.Now(), l.PerMinute)
+}
+
+// Spend counts a command sent through gateway regardless of the limit.
+func (l *GatewayLimiter) Spend(gateway string) {
+	if l == nil || l.PerMinute <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.bucket(gateway).spend(time.Now(), l.PerMinute)
+}
+
+func (l *GatewayLimiter) bucket(gateway string) *tokenBucket {
+	b, ok := l.buckets[gateway]
+	if !ok {
+		b = &tokenBucket{}
+		l.buckets[gateway] = b
+	}
+	return b
+}
+
+// tokenBucket allows perMinute commands per minute with bursts of up to one second's worth.
+type tokenBucket struct {
+	tokens float64
+	at     time.Time
+}
+
+func (b *tokenBucket) refill(now time.Time, perMinute int) {
+	rate := float64(perMinute) / 60
+	burst := math.Max(1, rate)
+	if b.at.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens = math.Min(burst, b.tokens+now.Sub(b.at).Seconds()*rate)
+	}
+	b.at = now
+}
+
+// ready reports whether a token is available without using it.
+func (b *tokenBucket) ready(now time.Time, perMinute int) bool {
+	b.refill(now, perMinute)
+	return b.tokens >= 1
+}
+
+func (b *tokenBucket) take(now time.Time, perMinute int) bool {
+	if !b.ready(now, perMinute) {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// spend uses a token even if none is left; the debt is capped at one minute's worth.
+func (b *tokenBucket) spend(now time.Time, perMinute int) {
+	b.refill(now, perMinute)
+	b.tokens = math.Max(b.tokens-1, -float64(perMinute))
+}
@@ -0,0 +1,52 @@
+package services
+
+import (
+	"context"
+	"sync"
+
+	"cpms/internal/gatewayclient"
+	"cpms/internal/repo"
+)
+
+// Gateways picks the gateway that serves a charger: its tenant's gateway when the tenant
+// sets gateway_base_url, otherwise the default one. A tenant without its own API key
+// uses the default key.
+type Gateways struct {
+	Tenants *repo.TenantsRepo // optional; without it every charger uses Default
+	Default *gatewayclient.Client
+
+	mu      sync.Mutex
+	clients map[string]*gatewayclient.Client // by base URL + key
+}
+
+func NewGateways(tenants *repo.TenantsRepo, def *gatewayclient.Client) *Gateways {
+	return &Gateways{Tenants: tenants, Default: def, clients: map[string]*gatewayclient.Client{}}
+}
+
+// For returns the gateway client for chargePointId.
+func (g *Gateways) For(ctx context.Context, chargePointId string) (*gatewayclient.Client, error) {
+	if g.Tenants == nil {
+		return g.Default, nil
+	}
+	t, err := g.Tenants.ForCharger(ctx, chargePointId)
+	if err != nil {
+		return nil, err
+	}
+	if t == nil || t.GatewayBaseURL == nil {
+		return g.Default, nil
+	}
+	key := g.Default.APIKey
+	if t.GatewayAPIKey != nil {
+		key = *t.GatewayAPIKey
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	id := *t.GatewayBaseURL + "\x00" + key
+	c, ok := g.clients[id]
+	if !ok {
+		c = gatewayclient.New(*t.GatewayBaseURL, key)
+		g.clients[id] = c
+	}
+	return c, nil
+}
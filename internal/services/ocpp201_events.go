@@ -0,0 +1,328 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"strings"
+
+	"cpms/internal/models"
+	"cpms/internal/repo"
+)
+
+// OCPP 2.0.1 TransactionEvent as forwarded by the gateway: the envelope
+// (type/chargePointId/ts/eventId) plus the TransactionEventRequest fields.
+
+type EvseRef struct {
+	Id          *int `json:"id"`
+	ConnectorId *int `json:"connectorId"`
+}
+
+type IdToken struct {
+	IdToken string `json:"idToken"`
+	Type    string `json:"type"`
+}
+
+type TransactionInfo struct {
+	TransactionId string `json:"transactionId"`
+	ChargingState string `json:"chargingState,omitempty"`
+	StoppedReason string `json:"stoppedReason,omitempty"`
+}
+
+type UnitOfMeasure struct {
+	Unit       string `json:"unit"`
+	Multiplier int    `json:"multiplier"`
+}
+
+type SampledValue201 struct {
+	Value         float64        `json:"value"`
+	Context       string         `json:"context,omitempty"`
+	Measurand     string         `json:"measurand,omitempty"`
+	Phase         string         `json:"phase,omitempty"`
+	Location      string         `json:"location,omitempty"`
+	UnitOfMeasure *UnitOfMeasure `json:"unitOfMeasure,omitempty"`
+}
+
+type MeterValue201 struct {
+	Timestamp    string            `json:"timestamp"`
+	SampledValue []SampledValue201 `json:"sampledValue"`
+}
+
+type TransactionEvent201 struct {
+	EventType       string          `json:"eventType"` // Started|Updated|Ended
+	TriggerReason   string          `json:"triggerReason"`
+	SeqNo           *int            `json:"seqNo"`
+	Offline         bool            `json:"offline,omitempty"`
+	TransactionInfo TransactionInfo `json:"transactionInfo"`
+	Evse            *EvseRef        `json:"evse,omitempty"`
+	IdToken         *IdToken        `json:"idToken,omitempty"`
+	MeterValue      []MeterValue201 `json:"meterValue,omitempty"`
+}
+
+var triggerReasons = map[string]bool{
+	"Authorized": true, "CablePluggedIn": true, "ChargingRateChanged": true, "ChargingStateChanged": true,
+	"Deauthorized": true, "EnergyLimitReached": true, "EVCommunicationLost": true, "EVConnectTimeout": true,
+	"MeterValueClock": true, "MeterValuePeriodic": true, "TimeLimitReached": true, "Trigger": true,
+	"UnlockCommand": true, "StopAuthorized": true, "EVDeparted": true, "EVDetected": true,
+	"RemoteStop": true, "RemoteStart": true, "AbnormalCondition": true, "SignedDataReceived": true, "ResetCommand": true,
+}
+
+func (e *TransactionEvent201) Validate() error {
+	switch e.EventType {
+	case "Started", "Updated", "Ended":
+	default:
+		return invalidf("unknown eventType %q", e.EventType)
+	}
+	if e.TransactionInfo.TransactionId == "" {
+		return invalidf("transactionInfo.transactionId is required")
+	}
+	if len(e.TransactionInfo.TransactionId) > 36 {
+		return invalidf("transactionInfo.transactionId longer than 36 characters")
+	}
+	if e.SeqNo == nil {
+		return invalidf("seqNo is required")
+	}
+	if *e.SeqNo < 0 {
+		return invalidf("seqNo must be >= 0")
+	}
+	if !triggerReasons[e.TriggerReason] {
+		return invalidf("unknown triggerReason %q", e.TriggerReason)
+	}
+	if e.Evse != nil {
+		if e.Evse.Id == nil || *e.Evse.Id < 1 {
+			return invalidf("evse.id must be >= 1")
+		}
+		if e.Evse.ConnectorId != nil && *e.Evse.ConnectorId < 1 {
+			return invalidf("evse.connectorId must be >= 1")
+		}
+	}
+	if e.EventType == "Started" && e.Evse == nil {
+		return invalidf("evse is required for Started")
+	}
+	for i, mv := range e.MeterValue {
+		if len(mv.SampledValue) == 0 {
+			return invalidf("meterValue[%d].sampledValue must not be empty", i)
+		}
+	}
+	return nil
+}
+
+// samples normalizes the embedded meter values into the shape stored in
+// meter_samples.samples_json ({"samples":[...]}), so energy fallbacks work for
+// both OCPP versions. Energy is converted to integer Wh.
+func (e *TransactionEvent201) samples() []map[string]any {
+	var out []map[string]any
+	for _, mv := range e.MeterValue {
+		for _, sv := range mv.SampledValue {
+			measurand := sv.Measurand
+			if measurand == "" {
+				measurand = "Energy.Active.Import.Register"
+			}
+			value, unit := sv.Value, ""
+			if sv.UnitOfMeasure != nil {
+				unit = sv.UnitOfMeasure.Unit
+				value *= math.Pow10(sv.UnitOfMeasure.Multiplier)
+			}
+			s := map[string]any{"measurand": measurand}
+			if strings.HasPrefix(measurand, "Energy.") {
+				switch {
+				case unit == "":
+					unit = "Wh"
+				case strings.HasPrefix(unit, "k"):
+					value *= 1000
+					unit = unit[1:]
+				}
+				s["value"] = int64(math.Round(value))
+			} else {
+				s["value"] = value
+			}
+			if unit != "" {
+				s["unit"] = unit
+			}
+			if sv.Context != "" {
+				s["context"] = sv.Context
+			}
+			if sv.Phase != "" {
+				s["phase"] = sv.Phase
+			}
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// registerWh returns the Energy.Active.Import.Register value (Wh) of the
+// embedded meter values, preferring the sample with the given reading context
+// (e.g. Transaction.Begin / Transaction.End).
+func (e *TransactionEvent201) registerWh(context string) *int64 {
+	var found *int64
+	for _, s := range e.samples() {
+		if s["measurand"] != "Energy.Active.Import.Register" || s["unit"] != "Wh" {
+			continue
+		}
+		v := s["value"].(int64)
+		if s["context"] == context {
+			return &v
+		}
+		found = &v
+	}
+	return found
+}
+
+// connectorId returns evse.connectorId, or nil when the event does not name one.
+func (e *TransactionEvent201) connectorId() *int {
+	if e.Evse != nil {
+		return e.Evse.ConnectorId
+	}
+	return nil
+}
+
+// resolveConnector returns the connector of a Started event: evse.connectorId, else the
+// one earlier sessions used on the EVSE, else 0 (unknown; filled in by a later event
+// naming it, see SessionsRepo.TouchSeqNo).
+func (p *EventsProcessor) resolveConnector(ctx context.Context, cp string, e *TransactionEvent201) (int, error) {
+	if id := e.connectorId(); id != nil {
+		return *id, nil
+	}
+	if e.Evse == nil || e.Evse.Id == nil {
+		return 0, nil
+	}
+	return p.Sessions.EvseConnector(ctx, cp, *e.Evse.Id)
+}
+
+func (e *TransactionEvent201) idTag() string {
+	if e.IdToken != nil {
+		return e.IdToken.IdToken
+	}
+	return ""
+}
+
+func (p *EventsProcessor) applyTransactionEvent(ctx context.Context, ev models.GatewayEvent, e *TransactionEvent201) error {
+	cp := ev.ChargePointId
+	txId := e.TransactionInfo.TransactionId
+
+	if e.EventType == "Started" {
+		epoch, err := p.Events.BootEpoch(ctx, cp, ev.Id)
+		if err != nil {
+			return err
+		}
+		connectorId, err := p.resolveConnector(ctx, cp, e)
+		if err != nil {
+			return err
+		}
+		session := models.Session{
+			ChargePointId: cp,
+			ConnectorId:   connectorId,
+			EvseId:        e.Evse.Id,
+			TransactionId: txId,
+			IdTag:         e.idTag(),
+			StartedAt:     ev.Ts,
+			MeterStartWh:  e.registerWh("Transaction.Begin"),
+			StartEventId:  &ev.Id,
+			LastSeqNo:     e.SeqNo,
+			BootEpoch:     epoch,
+		}
+		var sessionId string
+		if err := p.lockTransaction(ctx, cp, txId, epoch, func(q *EventsProcessor) error {
+			if sessionId, err = q.startSession(ctx, session); err != nil {
+				return err
+			}
+			if len(e.MeterValue) > 0 {
+				if err := q.insertTransactionEventSample(ctx, ev, e, sessionId); err != nil {
+					return err
+				}
+			}
+			return q.applyOrphans(ctx, cp, txId, epoch, sessionId)
+		}); err != nil {
+			return err
+		}
+		if err := p.correlateCommand(ctx, ev, repo.EffectMatch{
+			Event: "TransactionStarted", ConnectorId: e.connectorId(), EvseId: e.Evse.Id, IdTag: e.idTag(), TransactionId: txId, SessionId: &sessionId,
+		}); err != nil {
+			return err
+		}
+		return p.Chargers.TouchLastSeen(ctx, cp, ev.Ts)
+	}
+
+	sess, epoch, err := p.findTxSession(ctx, ev, txId, e.connectorId())
+	if err != nil {
+		return err
+	}
+	if sess == nil {
+		return p.Orphans.Park(ctx, ev, txId, epoch)
+	}
+	return p.applyTransactionEventToSession(ctx, ev, e, sess)
+}
+
+// applyTransactionEventToSession handles Updated/Ended for an existing session. An event
+// whose seqNo is at or below the session's last one is a repeat or arrived out of order:
+// only its meter values are kept (unless a sample with that seqNo exists), nothing else
+// about the session changes.
+func (p *EventsProcessor) applyTransactionEventToSession(ctx context.Context, ev models.GatewayEvent, e *TransactionEvent201, sess *models.Session) error {
+	if len(e.MeterValue) > 0 {
+		if err := p.insertTransactionEventSample(ctx, ev, e, sess.SessionId); err != nil {
+			return err
+		}
+	}
+	if sess.LastSeqNo != nil && *e.SeqNo <= *sess.LastSeqNo {
+		return p.Chargers.TouchLastSeen(ctx, ev.ChargePointId, ev.Ts)
+	}
+	if err := p.Sessions.TouchSeqNo(ctx, sess.SessionId, *e.SeqNo, e.idTag(), e.connectorId()); err != nil {
+		return err
+	}
+	if e.EventType != "Ended" {
+		return p.Chargers.TouchLastSeen(ctx, ev.ChargePointId, ev.Ts)
+	}
+
+	var reason *string
+	if e.TransactionInfo.StoppedReason != "" {
+		reason = &e.TransactionInfo.StoppedReason
+	} else {
+		// OCPP 2.0.1: stoppedReason is omitted for a normal local stop
+		local := "Local"
+		reason = &local
+	}
+	if err := p.Sessions.End(ctx, sess.SessionId, ev.Ts, e.registerWh("Transaction.End"), reason); err != nil {
+		return err
+	}
+	if err := p.refinalizeStale(ctx, sess); err != nil {
+		return err
+	}
+	if err := p.finalizeAndSettle(ctx, sess.SessionId); err != nil {
+		return err
+	}
+	if sess.IsSynthesized {
+		if err := p.Sessions.MarkEstimated(ctx, sess.SessionId); err != nil {
+			return err
+		}
+	}
+	if err := p.correlateCommand(ctx, ev, repo.EffectMatch{
+		Event: "TransactionEnded", TransactionId: sess.TransactionId, SessionId: &sess.SessionId,
+	}); err != nil {
+		return err
+	}
+	return p.Chargers.TouchLastSeen(ctx, ev.ChargePointId, ev.Ts)
+}
+
+func (p *EventsProcessor) insertTransactionEventSample(ctx context.Context, ev models.GatewayEvent, e *TransactionEvent201, sessionId string) error {
+	normalized, err := json.Marshal(map[string]any{
+		"type":          "MeterSample",
+		"chargePointId": ev.ChargePointId,
+		"transactionId": e.TransactionInfo.TransactionId,
+		"seqNo":         e.SeqNo,
+		"triggerReason": e.TriggerReason,
+		"samples":       e.samples(),
+	})
+	if err != nil {
+		return err
+	}
+	return p.Sessions.InsertMeterSample(ctx, models.MeterSample{
+		SessionId:     sessionId,
+		ChargePointId: ev.ChargePointId,
+		TransactionId: e.TransactionInfo.TransactionId,
+		Ts:            ev.Ts,
+		SamplesJSON:   normalized,
+		EventId:       &ev.Id,
+		SeqNo:         e.SeqNo,
+	})
+}
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"cpms/internal/repo"
+)
+
+// OrphanReaper synthesizes sessions for transactions whose parked
+// MeterSample/TransactionEnded events waited longer than Timeout for their
+// TransactionStarted.
+type OrphanReaper struct {
+	Orphans   *repo.OrphansRepo
+	Processor *EventsProcessor
+	Timeout   time.Duration
+	Interval  time.Duration
+}
+
+func NewOrphanReaper(o *repo.OrphansRepo, p *EventsProcessor, timeout, interval time.Duration) *OrphanReaper {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	return &OrphanReaper{Orphans: o, Processor: p, Timeout: timeout, Interval: interval}
+}
+
+// Run blocks until ctx is cancelled. A zero Timeout disables the reaper.
+func (r *OrphanReaper) Run(ctx context.Context) {
+	if r.Timeout <= 0 {
+		return
+	}
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.reap(ctx)
+		}
+	}
+}
+
+func (r *OrphanReaper) reap(ctx context.Context) {
+	keys, err := r.Orphans.ListExpired(ctx, time.Now().UTC().Add(-r.Timeout), 100)
+	if err != nil {
+		log.Println("orphan reaper:", err)
+		return
+	}
+	for _, k := range keys {
+		id, err := r.Processor.SynthesizeFromOrphans(ctx, k.ChargePointId, k.TransactionId, k.BootEpoch)
+		if err != nil {
+			log.Printf("orphan reaper: %s tx %s: %v", k.ChargePointId, k.TransactionId, err)
+			continue
+		}
+		if id == "" {
+			continue // TransactionStarted arrived meanwhile
+		}
+		log.Printf("orphan reaper: synthesized session %s for %s tx %s", id, k.ChargePointId, k.TransactionId)
+	}
+}
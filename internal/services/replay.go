@@ -0,0 +1,356 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"cpms/internal/models"
+	"cpms/internal/repo"
+)
+
+var (
+	ErrReplayEventsPending = errors.New("charger has pending events; wait for the queue to drain")
+	ErrReplaySettled       = errors.New("sessions in range have settlements past Pending; use force to replay anyway")
+)
+
+// ReplayService re-derives connector state, sessions, meter samples, pricing and
+// settlements of one charger from gateway_events. Everything runs in a single
+// transaction which is rolled back in dry-run mode.
+//
+// A window is widened until no session runs across its bounds, so every session that is
+// wiped is rebuilt from all of its events. Rebuilt sessions keep the ids of the sessions
+// they replace (matched by start event), and the commands linked to them stay linked.
+type ReplayService struct {
+	DB      repo.DBTX
+	MaxSkew time.Duration
+}
+
+func NewReplayService(db repo.DBTX, maxSkew time.Duration) *ReplayService {
+	return &ReplayService{DB: db, MaxSkew: maxSkew}
+}
+
+type ReplayRequest struct {
+	ChargePointId string
+	From          *time.Time
+	To            *time.Time
+	Apply         bool
+	// Force allows wiping sessions whose settlement was already Submitted/Confirmed/Failed.
+	Force bool
+}
+
+type ReplaySessionSnapshot struct {
+	SessionId    string   `json:"sessionId"`
+	EnergyWh     *int64   `json:"energyWh"`
+	EnergySource *string  `json:"energySource"`
+	CostAmount   *float64 `json:"costAmount"`
+	CostCurrency *string  `json:"costCurrency"`
+}
+
+type ReplaySessionDiff struct {
+	TransactionId string                 `json:"transactionId"`
+	StartedAt     time.Time              `json:"startedAt"`
+	Change        string                 `json:"change"` // Added|Removed|Changed
+	Before        *ReplaySessionSnapshot `json:"before,omitempty"`
+	After         *ReplaySessionSnapshot `json:"after,omitempty"`
+}
+
+type ReplayEventError struct {
+	EventId int64  `json:"eventId"`
+	Type    string `json:"type"`
+	Error   string `json:"error"`
+}
+
+// ReplayReport describes a replay. From and To are the window replayed, widened so that
+// no session crosses them.
+type ReplayReport struct {
+	ChargePointId  string              `json:"chargePointId"`
+	From           *time.Time          `json:"from"`
+	To             *time.Time          `json:"to"`
+	Applied        bool                `json:"applied"`
+	EventsReplayed int                 `json:"eventsReplayed"`
+	EventsFailed   int                 `json:"eventsFailed"`
+	SessionsBefore int                 `json:"sessionsBefore"`
+	SessionsAfter  int                 `json:"sessionsAfter"`
+	Changes        []ReplaySessionDiff `json:"changes"`
+	Errors         []ReplayEventError  `json:"errors"`
+}
+
+func (s *ReplayService) Run(ctx context.Context, req ReplayRequest) (*ReplayReport, error) {
+	if req.ChargePointId == "" {
+		return nil, errors.New("missing chargePointId")
+	}
+
+	tx, err := s.DB.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	events := repo.NewEventsRepo(tx)
+	sessions := repo.NewSessionsRepo(tx)
+	state := repo.NewStateRepo(tx)
+	orphans := repo.NewOrphansRepo(tx)
+	settlements := repo.NewSettlementsRepo(tx)
+	commands := repo.NewCommandsRepo(tx)
+	processor := newEventsProcessorOn(tx, s.MaxSkew)
+
+	open, err := events.CountOpen(ctx, req.ChargePointId)
+	if err != nil {
+		return nil, err
+	}
+	if open > 0 {
+		return nil, ErrReplayEventsPending
+	}
+	if req.From, req.To, err = widenWindow(ctx, sessions, req.ChargePointId, req.From, req.To); err != nil {
+		return nil, err
+	}
+	if !req.Force {
+		n, err := settlements.CountNonPendingForSessions(ctx, req.ChargePointId, req.From, req.To)
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			return nil, ErrReplaySettled
+		}
+	}
+
+	before, err := sessions.ListStartedBetween(ctx, req.ChargePointId, req.From, req.To)
+	if err != nil {
+		return nil, err
+	}
+	// Command links are cleared with the sessions; remember them to restore them.
+	links := map[string][]string{}
+	for _, b := range before {
+		cmds, err := commands.ListBySession(ctx, b.SessionId)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range cmds {
+			links[b.SessionId] = append(links[b.SessionId], c.CommandId)
+		}
+	}
+
+	// Wipe derived state (sessions cascade to meter samples and settlements; parked
+	// orphans and status history are re-created by the replay). Connector state is only rebuilt from scratch for a full replay;
+	// a windowed replay overwrites it with the latest status seen in the window.
+	if _, err := sessions.DeleteStartedBetween(ctx, req.ChargePointId, req.From, req.To); err != nil {
+		return nil, err
+	}
+	if err := orphans.DeleteBetween(ctx, req.ChargePointId, req.From, req.To); err != nil {
+		return nil, err
+	}
+	if err := state.DeleteStatusHistoryBetween(ctx, req.ChargePointId, req.From, req.To); err != nil {
+		return nil, err
+	}
+	if req.From == nil && req.To == nil {
+		if err := state.DeleteConnectors(ctx, req.ChargePointId); err != nil {
+			return nil, err
+		}
+	}
+
+	evs, err := events.ListForReplay(ctx, req.ChargePointId, req.From, req.To)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ReplayReport{
+		ChargePointId:  req.ChargePointId,
+		From:           req.From,
+		To:             req.To,
+		Applied:        req.Apply,
+		SessionsBefore: len(before),
+		Changes:        []ReplaySessionDiff{},
+		Errors:         []ReplayEventError{},
+	}
+
+	for _, ev := range evs {
+		// Each event runs in a savepoint so one failure does not abort the replay.
+		sp, err := tx.Begin(ctx)
+		if err != nil {
+			return nil, err
+		}
+		applyErr := processor.Apply(ctx, ev)
+		if applyErr != nil {
+			if err := sp.Rollback(ctx); err != nil {
+				return nil, err
+			}
+			report.EventsFailed++
+			report.Errors = append(report.Errors, ReplayEventError{EventId: ev.Id, Type: ev.EventType, Error: applyErr.Error()})
+			if err := events.MarkFailed(ctx, ev.Id, applyErr.Error()); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := sp.Commit(ctx); err != nil {
+			return nil, err
+		}
+		if err := events.MarkDone(ctx, ev.Id); err != nil {
+			return nil, err
+		}
+		report.EventsReplayed++
+	}
+
+	after, err := sessions.ListStartedBetween(ctx, req.ChargePointId, req.From, req.To)
+	if err != nil {
+		return nil, err
+	}
+	if err := keepSessionIds(ctx, sessions, commands, before, after, links); err != nil {
+		return nil, err
+	}
+	report.SessionsAfter = len(after)
+	report.Changes = diffSessions(before, after)
+
+	if req.Apply {
+		if err := tx.Commit(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// widenWindow extends [from, to) until no session of the charger runs across a bound. A
+// session still open at to extends the window to the end of the events.
+func widenWindow(ctx context.Context, sessions *repo.SessionsRepo, cp string, from, to *time.Time) (*time.Time, *time.Time, error) {
+	for {
+		widened := false
+		if from != nil {
+			start, _, _, err := sessions.Straddling(ctx, cp, *from)
+			if err != nil {
+				return nil, nil, err
+			}
+			if start != nil {
+				from, widened = start, true
+			}
+		}
+		if to != nil {
+			start, end, open, err := sessions.Straddling(ctx, cp, *to)
+			if err != nil {
+				return nil, nil, err
+			}
+			switch {
+			case open:
+				to, widened = nil, true
+			case start != nil:
+				// to is exclusive; the end event has ts = ended_at
+				t := end.Add(time.Microsecond)
+				to, widened = &t, true
+			}
+		}
+		if !widened {
+			return from, to, nil
+		}
+	}
+}
+
+// keepSessionIds gives rebuilt sessions the ids of the sessions they replace and restores
+// their command links (links maps old session ids to command ids). after is updated in place.
+func keepSessionIds(ctx context.Context, sessions *repo.SessionsRepo, commands *repo.CommandsRepo, before, after []models.Session, links map[string][]string) error {
+	oldIds := make(map[string]string, len(before))
+	for _, b := range before {
+		oldIds[sessionIdentity(b)] = b.SessionId
+	}
+	for i, a := range after {
+		id, ok := oldIds[sessionIdentity(a)]
+		if !ok {
+			continue
+		}
+		if id != a.SessionId {
+			if err := sessions.Rekey(ctx, a.SessionId, id); err != nil {
+				return err
+			}
+			after[i].SessionId = id
+		}
+		for _, cmdId := range links[id] {
+			if err := commands.LinkSession(ctx, cmdId, id); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sessionIdentity matches a rebuilt session to the one it replaces: by start event, or by
+// transaction and start for synthesized sessions.
+func sessionIdentity(s models.Session) string {
+	if s.StartEventId != nil {
+		return fmt.Sprintf("event:%d", *s.StartEventId)
+	}
+	return "tx:" + sessionReplayKey(s)
+}
+
+// newEventsProcessorOn wires a processor with all its dependencies on db.
+func newEventsProcessorOn(db repo.DBTX, maxSkew time.Duration) *EventsProcessor {
+	chargers := repo.NewChargersRepo(db)
+	sessions := repo.NewSessionsRepo(db)
+	pricing := NewPricingService(chargers, repo.NewTariffsRepo(db), sessions)
+	settlements := &SettlementService{Chargers: chargers, Sites: repo.NewSitesRepo(db), Sessions: sessions, Settlements: repo.NewSettlementsRepo(db)}
+	p := NewEventsProcessor(repo.NewEventsRepo(db), chargers, repo.NewStateRepo(db), sessions, pricing, settlements, repo.NewDeadLettersRepo(db), repo.NewOrphansRepo(db), maxSkew)
+	p.DB = db
+	return p
+}
+
+func sessionReplayKey(s models.Session) string {
+	return fmt.Sprintf("%s@%s", s.TransactionId, s.StartedAt.UTC().Format(time.RFC3339Nano))
+}
+
+func snapshotSession(s models.Session) *ReplaySessionSnapshot {
+	return &ReplaySessionSnapshot{
+		SessionId:    s.SessionId,
+		EnergyWh:     s.EnergyWh,
+		EnergySource: s.EnergySource,
+		CostAmount:   s.CostAmount,
+		CostCurrency: s.CostCurrency,
+	}
+}
+
+// diffSessions matches sessions by (transactionId, startedAt) and reports
+// added/removed sessions and sessions whose energy or cost changed.
+func diffSessions(before, after []models.Session) []ReplaySessionDiff {
+	out := []ReplaySessionDiff{}
+	afterByKey := make(map[string]models.Session, len(after))
+	for _, s := range after {
+		afterByKey[sessionReplayKey(s)] = s
+	}
+	seen := make(map[string]bool, len(before))
+	for _, b := range before {
+		k := sessionReplayKey(b)
+		seen[k] = true
+		a, ok := afterByKey[k]
+		if !ok {
+			out = append(out, ReplaySessionDiff{TransactionId: b.TransactionId, StartedAt: b.StartedAt, Change: "Removed", Before: snapshotSession(b)})
+			continue
+		}
+		if !eqInt64(b.EnergyWh, a.EnergyWh) || !eqFloat64(b.CostAmount, a.CostAmount) || !eqString(b.CostCurrency, a.CostCurrency) {
+			out = append(out, ReplaySessionDiff{TransactionId: b.TransactionId, StartedAt: b.StartedAt, Change: "Changed", Before: snapshotSession(b), After: snapshotSession(a)})
+		}
+	}
+	for _, a := range after {
+		if !seen[sessionReplayKey(a)] {
+			out = append(out, ReplaySessionDiff{TransactionId: a.TransactionId, StartedAt: a.StartedAt, Change: "Added", After: snapshotSession(a)})
+		}
+	}
+	return out
+}
+
+func eqInt64(a, b *int64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func eqFloat64(a, b *float64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func eqString(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
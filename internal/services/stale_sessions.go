@@ -0,0 +1,94 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"cpms/internal/repo"
+)
+
+// StaleClosedReason is stored in sessions.reason for sessions closed by the sweeper.
+const StaleClosedReason = "StaleClosed"
+
+// StaleSessionSweeper closes sessions whose TransactionEnded never arrived:
+// no meter/status activity for After, or the connector went Available more than
+// AvailableGrace ago. Closed sessions are finalized, priced, settled and flagged
+// as estimated for finance review, in the same transaction as the close.
+type StaleSessionSweeper struct {
+	DB             repo.DBTX
+	Sessions       *repo.SessionsRepo
+	MaxSkew        time.Duration
+	After          time.Duration
+	AvailableGrace time.Duration
+	Interval       time.Duration
+}
+
+func NewStaleSessionSweeper(db repo.DBTX, s *repo.SessionsRepo, maxSkew, after, availableGrace, interval time.Duration) *StaleSessionSweeper {
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+	return &StaleSessionSweeper{DB: db, Sessions: s, MaxSkew: maxSkew, After: after, AvailableGrace: availableGrace, Interval: interval}
+}
+
+// Run blocks until ctx is cancelled. A zero After disables the sweeper.
+func (w *StaleSessionSweeper) Run(ctx context.Context) {
+	if w.After <= 0 {
+		return
+	}
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.sweep(ctx)
+		}
+	}
+}
+
+func (w *StaleSessionSweeper) sweep(ctx context.Context) {
+	now := time.Now().UTC()
+	stale, err := w.Sessions.ListStale(ctx, now.Add(-w.After), now.Add(-w.AvailableGrace), 100)
+	if err != nil {
+		log.Println("stale sessions:", err)
+		return
+	}
+	for _, s := range stale {
+		closed, err := w.close(ctx, s)
+		if err != nil {
+			log.Printf("stale sessions: close %s: %v", s.SessionId, err)
+			continue
+		}
+		if !closed {
+			continue
+		}
+		log.Printf("stale sessions: closed %s (%s tx %s, last activity %s, connector available=%v)",
+			s.SessionId, s.ChargePointId, s.TransactionId, s.LastActivityAt.Format(time.RFC3339), s.ConnectorAvailable)
+	}
+}
+
+// close ends a stale session and finalizes, prices, settles and flags it in one
+// transaction, so a failure leaves the session open for the next sweep. Returns false if
+// the session ended meanwhile.
+func (w *StaleSessionSweeper) close(ctx context.Context, s repo.StaleSession) (bool, error) {
+	tx, err := w.DB.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	sessions := repo.NewSessionsRepo(tx)
+	closed, err := sessions.CloseStale(ctx, s.SessionId, s.LastActivityAt, StaleClosedReason)
+	if err != nil || !closed {
+		return false, err
+	}
+	if err := newEventsProcessorOn(tx, w.MaxSkew).finalizeAndSettle(ctx, s.SessionId); err != nil {
+		return false, err
+	}
+	if err := sessions.MarkEstimated(ctx, s.SessionId); err != nil {
+		return false, err
+	}
+	return true, tx.Commit(ctx)
+}
@@ -0,0 +1,81 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"cpms/internal/models"
+)
+
+// txMatchSlack tolerates events stamped slightly before their TransactionStarted
+// (charger clock vs. meter timestamps).
+const txMatchSlack = time.Minute
+
+// findTxSession resolves the session of a transaction event. Transaction ids are
+// only unique within a boot epoch, so the lookup is limited to the epoch of the
+// event (or an earlier one when the transaction was carried over a reboot), the
+// connector when the event names one, and sessions started before the event.
+// It also returns the event's boot epoch, used to park the event when no session matches.
+func (p *EventsProcessor) findTxSession(ctx context.Context, ev models.GatewayEvent, txId string, connectorId *int) (*models.Session, int, error) {
+	epoch, err := p.Events.BootEpoch(ctx, ev.ChargePointId, ev.Id)
+	if err != nil {
+		return nil, 0, err
+	}
+	if connectorId != nil && *connectorId == 0 {
+		// connector 0 is the main meter, not a transaction connector
+		connectorId = nil
+	}
+	candidates, err := p.Sessions.ListTxCandidates(ctx, ev.ChargePointId, txId, epoch, connectorId, ev.Ts.Add(txMatchSlack))
+	if err != nil {
+		return nil, 0, err
+	}
+	sess, err := matchTxSession(candidates, epoch, ev.Ts)
+	if err != nil {
+		return nil, 0, err
+	}
+	return sess, epoch, nil
+}
+
+// matchTxSession picks the session among candidates (ordered by boot_epoch desc,
+// started_at desc). Only the latest epoch is considered, and a session of an
+// earlier epoch than the event only if it is still open. Several sessions left
+// in that epoch are narrowed to the one whose [started_at, ended_at] covers ts;
+// if that is not exactly one the match is ambiguous and the event is quarantined
+// as a *ValidationError.
+func matchTxSession(candidates []models.Session, epoch int, ts time.Time) (*models.Session, error) {
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+	latest := candidates[0].BootEpoch
+	var group []models.Session
+	for _, s := range candidates {
+		if s.BootEpoch != latest {
+			break
+		}
+		if latest < epoch && s.EndedAt != nil {
+			continue
+		}
+		group = append(group, s)
+	}
+	if len(group) <= 1 {
+		if len(group) == 0 {
+			return nil, nil
+		}
+		return &group[0], nil
+	}
+
+	var covering []models.Session
+	for _, s := range group {
+		if s.EndedAt == nil || !ts.After(*s.EndedAt) {
+			covering = append(covering, s)
+		}
+	}
+	if len(covering) == 1 {
+		return &covering[0], nil
+	}
+	ids := make([]string, len(group))
+	for i, s := range group {
+		ids[i] = s.SessionId
+	}
+	return nil, invalidf("ambiguous transaction %s in boot epoch %d: matches sessions %v", group[0].TransactionId, latest, ids)
+}